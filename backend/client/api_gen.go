@@ -0,0 +1,2009 @@
+// Code generated by go run ./gen; DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+type AddHostRequest struct {
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+}
+
+type Alert struct {
+	Rule       string  `json:"rule"`
+	RuleName   string  `json:"rule_name"`
+	Metric     string  `json:"metric"`
+	Severity   string  `json:"severity"`
+	Host       string  `json:"host"`
+	Object     string  `json:"object,omitempty"`
+	State      string  `json:"state"`
+	Value      float64 `json:"value"`
+	Summary    string  `json:"summary"`
+	Since      int64   `json:"since"`
+	FiredAt    int64   `json:"fired_at,omitempty"`
+	ResolvedAt int64   `json:"resolved_at,omitempty"`
+}
+
+type AlertChannel struct {
+	ID   string   `json:"id"`
+	Name string   `json:"name"`
+	Type string   `json:"type"`
+	URL  string   `json:"url,omitempty"`
+	SMTP string   `json:"smtp,omitempty"`
+	From string   `json:"from,omitempty"`
+	To   []string `json:"to,omitempty"`
+	Path string   `json:"path,omitempty"`
+}
+
+type AlertRule struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Metric    string   `json:"metric"`
+	Op        string   `json:"op,omitempty"`
+	Threshold float64  `json:"threshold,omitempty"`
+	State     string   `json:"state,omitempty"`
+	For       int64    `json:"for"`
+	Host      string   `json:"host,omitempty"`
+	Target    string   `json:"target,omitempty"`
+	Severity  string   `json:"severity"`
+	Channels  []string `json:"channels"`
+	Disabled  bool     `json:"disabled,omitempty"`
+}
+
+type AttachDiskRequest struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Bus    string `json:"bus"`
+}
+
+type AttachISORequest struct {
+	Path string `json:"path"`
+}
+
+type AttachNICRequest struct {
+	Mode    string `json:"mode"`
+	Network string `json:"network"`
+	Bridge  string `json:"bridge"`
+	Dev     string `json:"dev"`
+	Model   string `json:"model"`
+}
+
+type AuditEntry struct {
+	Time       int64           `json:"time"`
+	Actor      string          `json:"actor"`
+	Token      string          `json:"token,omitempty"`
+	IP         string          `json:"ip"`
+	Method     string          `json:"method"`
+	Route      string          `json:"route"`
+	Path       string          `json:"path"`
+	Host       string          `json:"host,omitempty"`
+	ObjectKind string          `json:"object_kind,omitempty"`
+	Object     string          `json:"object,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	Status     int             `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+}
+
+type AuthStatus struct {
+	SetupRequired bool   `json:"setup_required"`
+	User          string `json:"user,omitempty"`
+	Role          string `json:"role,omitempty"`
+}
+
+type Autostart struct {
+	Autostart bool `json:"autostart"`
+}
+
+type BatchActionRequest struct {
+	Names  []string `json:"names"`
+	Action string   `json:"action"`
+}
+
+type BatchResult struct {
+	Message string            `json:"message"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+type Bridge struct {
+	Name   string   `json:"name"`
+	Up     bool     `json:"up"`
+	Slaves []string `json:"slaves"`
+	IP     string   `json:"ip"`
+}
+
+type CloneVMRequest struct {
+	NewName string `json:"new_name"`
+	Linked  bool   `json:"linked"`
+	Pool    string `json:"pool"`
+	Format  string `json:"format"`
+}
+
+type CloudInit struct {
+	Hostname      string          `json:"hostname"`
+	Users         []CloudInitUser `json:"users"`
+	SSHKeys       []string        `json:"ssh_keys"`
+	Packages      []string        `json:"packages"`
+	UserData      string          `json:"user_data"`
+	NetworkConfig string          `json:"network_config"`
+}
+
+type CloudInitUser struct {
+	Name         string   `json:"name"`
+	PasswordHash string   `json:"password_hash"`
+	SSHKeys      []string `json:"ssh_keys"`
+	Sudo         bool     `json:"sudo"`
+	Groups       []string `json:"groups"`
+	Shell        string   `json:"shell"`
+}
+
+type Config struct {
+	Listen         string       `json:"listen"`
+	Driver         string       `json:"driver"`
+	LibvirtURI     string       `json:"libvirt_uri"`
+	DataDir        string       `json:"data_dir"`
+	ImageDir       string       `json:"image_dir"`
+	ISODir         string       `json:"iso_dir"`
+	AllowedRoots   []string     `json:"allowed_roots"`
+	DefaultNetwork string       `json:"default_network"`
+	BridgePrefix   string       `json:"bridge_prefix"`
+	HostName       string       `json:"host_name"`
+	Hosts          []HostConfig `json:"hosts"`
+	StatsInterval  string       `json:"stats_interval"`
+}
+
+type CreateBridgeRequest struct {
+	Name     string `json:"name"`
+	SlaveNIC string `json:"slave_nic"`
+}
+
+type CreateFromTemplateRequest struct {
+	Name       string     `json:"name"`
+	CPU        int        `json:"cpu"`
+	Memory     int        `json:"memory"`
+	Disk       int        `json:"disk"`
+	NetMode    string     `json:"net_mode"`
+	NetModel   string     `json:"net_model"`
+	BridgeName string     `json:"bridge_name"`
+	MacvtapDev string     `json:"macvtap_dev"`
+	CloudInit  *CloudInit `json:"cloud_init,omitempty"`
+	Linked     bool       `json:"linked"`
+	Pool       string     `json:"pool"`
+	Format     string     `json:"format"`
+}
+
+type CreateNetworkRequest struct {
+	Name      string `json:"name"`
+	Bridge    string `json:"bridge"`
+	Subnet    string `json:"subnet"`
+	Netmask   string `json:"netmask"`
+	DHCPStart string `json:"dhcp_start"`
+	DHCPEnd   string `json:"dhcp_end"`
+}
+
+type CreateSnapshotRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type CreateStoragePoolRequest struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type CreateTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+type CreateUserRequest struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Role     string   `json:"role"`
+	Groups   []string `json:"groups"`
+}
+
+type CreateVMRequest struct {
+	Name       string     `json:"name"`
+	CPU        int        `json:"cpu"`
+	Memory     int        `json:"memory"`
+	Disk       int        `json:"disk"`
+	OSType     string     `json:"os_type"`
+	DiskBus    string     `json:"disk_bus"`
+	NetModel   string     `json:"net_model"`
+	Machine    string     `json:"machine"`
+	CPUModel   string     `json:"cpu_model"`
+	Clock      string     `json:"clock"`
+	ISO        string     `json:"iso"`
+	VirtioISO  string     `json:"virtio_iso"`
+	NetMode    string     `json:"net_mode"`
+	BridgeName string     `json:"bridge_name"`
+	MacvtapDev string     `json:"macvtap_dev"`
+	CloudInit  *CloudInit `json:"cloud_init,omitempty"`
+}
+
+type CreateVolumeRequest struct {
+	Pool     string `json:"pool"`
+	Name     string `json:"name"`
+	Capacity int    `json:"capacity"`
+	Format   string `json:"format"`
+}
+
+type DHCPLease struct {
+	IP       string `json:"ip"`
+	MAC      string `json:"mac"`
+	Hostname string `json:"hostname"`
+}
+
+type DiskIO struct {
+	ReadBytes    float64 `json:"read_bytes"`
+	WriteBytes   float64 `json:"write_bytes"`
+	ReadIOPS     float64 `json:"read_iops"`
+	WriteIOPS    float64 `json:"write_iops"`
+	ReadLatency  float64 `json:"read_latency"`
+	WriteLatency float64 `json:"write_latency"`
+}
+
+type DiskInfo struct {
+	Mount     string `json:"mount"`
+	Device    string `json:"device"`
+	Total     uint64 `json:"total"`
+	Used      uint64 `json:"used"`
+	Available uint64 `json:"available"`
+	Percent   int    `json:"percent"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+type Event struct {
+	Time   int64  `json:"time"`
+	Host   string `json:"host"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Owner  string `json:"owner,omitempty"`
+	Group  string `json:"group,omitempty"`
+}
+
+type Host struct {
+	Name       string `json:"name"`
+	URI        string `json:"uri"`
+	Local      bool   `json:"local"`
+	Builtin    bool   `json:"builtin"`
+	Default    bool   `json:"default"`
+	Connected  bool   `json:"connected"`
+	Error      string `json:"error,omitempty"`
+	LastCheck  int64  `json:"last_check"`
+	LatencyMS  int64  `json:"latency_ms"`
+	Reconnects uint64 `json:"reconnects"`
+}
+
+type HostConfig struct {
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+}
+
+type HostInfo struct {
+	Hostname    string     `json:"hostname"`
+	CPUModel    string     `json:"cpu_model"`
+	CPUCount    int        `json:"cpu_count"`
+	CPUUsage    float64    `json:"cpu_usage"`
+	MemoryTotal int        `json:"memory_total"`
+	MemoryFree  int        `json:"memory_free"`
+	VMRunning   int        `json:"vm_running"`
+	VMTotal     int        `json:"vm_total"`
+	Uptime      int64      `json:"uptime"`
+	LoadAvg     []float64  `json:"load_avg"`
+	Disks       []DiskInfo `json:"disks"`
+}
+
+type HostOverview struct {
+	Name       string    `json:"name"`
+	URI        string    `json:"uri"`
+	Local      bool      `json:"local"`
+	Builtin    bool      `json:"builtin"`
+	Default    bool      `json:"default"`
+	Connected  bool      `json:"connected"`
+	Error      string    `json:"error,omitempty"`
+	LastCheck  int64     `json:"last_check"`
+	LatencyMS  int64     `json:"latency_ms"`
+	Reconnects uint64    `json:"reconnects"`
+	Info       *HostInfo `json:"info,omitempty"`
+}
+
+type HostVMs struct {
+	VMs    []VM              `json:"vms"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type ISOFile struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type ImportVMRequest struct {
+	Name     string `json:"name"`
+	DiskPath string `json:"disk_path"`
+	CPU      int    `json:"cpu"`
+	Memory   int    `json:"memory"`
+	DiskBus  string `json:"disk_bus"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type LoginResult struct {
+	Message string `json:"message"`
+	User    string `json:"user"`
+}
+
+type Message struct {
+	Message string `json:"message"`
+}
+
+type NICIO struct {
+	RxBytes   float64 `json:"rx_bytes"`
+	RxPackets float64 `json:"rx_packets"`
+	RxErrors  float64 `json:"rx_errors"`
+	RxDrops   float64 `json:"rx_drops"`
+	TxBytes   float64 `json:"tx_bytes"`
+	TxPackets float64 `json:"tx_packets"`
+	TxErrors  float64 `json:"tx_errors"`
+	TxDrops   float64 `json:"tx_drops"`
+}
+
+type Network struct {
+	Name    string `json:"name"`
+	UUID    string `json:"uuid"`
+	Active  bool   `json:"active"`
+	Forward string `json:"forward"`
+	Bridge  string `json:"bridge"`
+	Subnet  string `json:"subnet"`
+}
+
+type NewToken struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	User       string   `json:"user"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at,omitempty"`
+	LastUsedAt int64    `json:"last_used_at,omitempty"`
+	Token      string   `json:"token"`
+}
+
+type PhysicalNIC struct {
+	Name string `json:"name"`
+	MAC  string `json:"mac"`
+	IP   string `json:"ip"`
+	Up   bool   `json:"up"`
+}
+
+type PortForward struct {
+	ID          string `json:"id"`
+	Protocol    string `json:"protocol"`
+	HostPort    int    `json:"host_port"`
+	HostPortEnd int    `json:"host_port_end,omitempty"`
+	VMIP        string `json:"vm_ip"`
+	VMPort      int    `json:"vm_port"`
+	Comment     string `json:"comment"`
+}
+
+type RenameVMRequest struct {
+	NewName string `json:"new_name"`
+}
+
+type RevertSnapshotToNewRequest struct {
+	NewName string `json:"new_name"`
+	Pool    string `json:"pool"`
+	Format  string `json:"format"`
+}
+
+type SetPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+type Settings struct {
+	ConfigFile string  `json:"config_file"`
+	Settings   *Config `json:"settings"`
+}
+
+type SetupRequest struct {
+	SetupToken string `json:"setup_token"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+}
+
+type Snapshot struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	State       string `json:"state"`
+	CreatedAt   int64  `json:"created_at"`
+	IsCurrent   bool   `json:"is_current"`
+}
+
+type StatsSeries struct {
+	From   int64                `json:"from"`
+	To     int64                `json:"to"`
+	Step   int64                `json:"step"`
+	Time   []int64              `json:"time"`
+	Values map[string][]float64 `json:"values"`
+}
+
+type StoragePool struct {
+	Name       string `json:"name"`
+	UUID       string `json:"uuid"`
+	Active     bool   `json:"active"`
+	Type       string `json:"type"`
+	Path       string `json:"path"`
+	Capacity   uint64 `json:"capacity"`
+	Allocation uint64 `json:"allocation"`
+	Available  uint64 `json:"available"`
+}
+
+type StorageVolume struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Type       string `json:"type"`
+	Capacity   uint64 `json:"capacity"`
+	Allocation uint64 `json:"allocation"`
+}
+
+type Task struct {
+	ID         string  `json:"id"`
+	Kind       string  `json:"kind"`
+	Host       string  `json:"host"`
+	Target     string  `json:"target"`
+	Owner      string  `json:"owner,omitempty"`
+	Group      string  `json:"group,omitempty"`
+	State      string  `json:"state"`
+	Progress   float64 `json:"progress"`
+	Log        string  `json:"log"`
+	Error      string  `json:"error,omitempty"`
+	Code       string  `json:"code,omitempty"`
+	CreatedAt  int64   `json:"created_at"`
+	FinishedAt int64   `json:"finished_at,omitempty"`
+}
+
+type TaskAccepted struct {
+	Message string `json:"message"`
+	TaskID  string `json:"task_id"`
+}
+
+type TokenInfo struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	User       string   `json:"user"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at,omitempty"`
+	LastUsedAt int64    `json:"last_used_at,omitempty"`
+}
+
+type UpdateUserRequest struct {
+	Role   string   `json:"role"`
+	Groups []string `json:"groups"`
+}
+
+type UpdateVMRequest struct {
+	CPU    int `json:"cpu"`
+	Memory int `json:"memory"`
+}
+
+type UpdateVMXMLRequest struct {
+	XML    string `json:"xml"`
+	DryRun bool   `json:"dry_run"`
+}
+
+type UserInfo struct {
+	Name      string   `json:"name"`
+	Role      string   `json:"role"`
+	Groups    []string `json:"groups"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+type VM struct {
+	Name       string  `json:"name"`
+	UUID       string  `json:"uuid"`
+	State      string  `json:"state"`
+	CPU        int     `json:"cpu"`
+	Memory     int     `json:"memory"`
+	CPUUsage   float64 `json:"cpu_usage"`
+	MemUsed    int     `json:"mem_used"`
+	DiskRead   float64 `json:"disk_read"`
+	DiskWrite  float64 `json:"disk_write"`
+	DiskIOPS   float64 `json:"disk_iops"`
+	NetRx      float64 `json:"net_rx"`
+	NetTx      float64 `json:"net_tx"`
+	Host       string  `json:"host,omitempty"`
+	Owner      string  `json:"owner,omitempty"`
+	Group      string  `json:"group,omitempty"`
+	Template   bool    `json:"template,omitempty"`
+	LinkedBase string  `json:"linked_base,omitempty"`
+}
+
+type VMDetail struct {
+	Name   string   `json:"name"`
+	UUID   string   `json:"uuid"`
+	State  string   `json:"state"`
+	CPU    int      `json:"cpu"`
+	Memory int      `json:"memory"`
+	Disks  []VMDisk `json:"disks"`
+	NICs   []VMNIC  `json:"nics"`
+	Boot   string   `json:"boot"`
+	Arch   string   `json:"arch"`
+}
+
+type VMDisk struct {
+	Device string  `json:"device"`
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Bus    string  `json:"bus"`
+	Format string  `json:"format"`
+	IO     *DiskIO `json:"io,omitempty"`
+}
+
+type VMNIC struct {
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	MAC    string `json:"mac"`
+	Model  string `json:"model"`
+	Target string `json:"target,omitempty"`
+	IO     *NICIO `json:"io,omitempty"`
+}
+
+type VMOwner struct {
+	Owner string `json:"owner"`
+	Group string `json:"group"`
+}
+
+type VMTemplate struct {
+	Template bool `json:"template"`
+}
+
+type VMXML struct {
+	XML string `json:"xml"`
+}
+
+type VMXMLResult struct {
+	Diff            string `json:"diff"`
+	Changed         bool   `json:"changed"`
+	Validated       bool   `json:"validated"`
+	Applied         bool   `json:"applied"`
+	RestartRequired bool   `json:"restart_required"`
+}
+
+type VNCPort struct {
+	Port int `json:"port"`
+}
+
+type Webhook struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Secret   string   `json:"secret,omitempty"`
+	Events   []string `json:"events"`
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID         string          `json:"id"`
+	Event      string          `json:"event"`
+	EventID    string          `json:"event_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Status     string          `json:"status"`
+	Attempts   int             `json:"attempts"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+	Created    int64           `json:"created"`
+	NextRetry  int64           `json:"next_retry,omitempty"`
+}
+
+// GetOpenAPI calls GET /api/openapi.json.
+//
+// OpenAPI document of this API.
+func (c *Client) GetOpenAPI(ctx context.Context) (io.ReadCloser, error) {
+	return c.stream(ctx, "GET", "/api/openapi.json", nil)
+}
+
+// AuthStatus calls GET /api/auth/status.
+//
+// Login state and whether the first account must be created.
+func (c *Client) AuthStatus(ctx context.Context) (*AuthStatus, error) {
+	var out AuthStatus
+	if err := c.do(ctx, "GET", "/api/auth/status", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Setup calls POST /api/auth/setup.
+//
+// Create the first account with the setup token.
+func (c *Client) Setup(ctx context.Context, req *SetupRequest) (*LoginResult, error) {
+	var out LoginResult
+	if err := c.do(ctx, "POST", "/api/auth/setup", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Login calls POST /api/auth/login.
+//
+// Log in and receive a session cookie.
+func (c *Client) Login(ctx context.Context, req *LoginRequest) (*LoginResult, error) {
+	var out LoginResult
+	if err := c.do(ctx, "POST", "/api/auth/login", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Logout calls POST /api/auth/logout.
+//
+// End the session.
+func (c *Client) Logout(ctx context.Context) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/auth/logout", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListUsers calls GET /api/users.
+//
+// List accounts.
+func (c *Client) ListUsers(ctx context.Context) ([]UserInfo, error) {
+	var out []UserInfo
+	if err := c.do(ctx, "GET", "/api/users", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateUser calls POST /api/users.
+//
+// Add an account.
+func (c *Client) CreateUser(ctx context.Context, req *CreateUserRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/users", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateUser calls PUT /api/users/:user.
+//
+// Change the role and groups of an account.
+func (c *Client) UpdateUser(ctx context.Context, user string, req *UpdateUserRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "PUT", "/api/users/"+url.PathEscape(user), nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteUser calls DELETE /api/users/:user.
+//
+// Delete an account.
+func (c *Client) DeleteUser(ctx context.Context, user string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/users/"+url.PathEscape(user), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetPassword calls PUT /api/users/:user/password.
+//
+// Change a password.
+func (c *Client) SetPassword(ctx context.Context, user string, req *SetPasswordRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "PUT", "/api/users/"+url.PathEscape(user)+"/password", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListTokensOptions holds the query parameters of ListTokens.
+type ListTokensOptions struct {
+	All bool // every user's tokens (admins)
+}
+
+// ListTokens calls GET /api/tokens.
+//
+// List the caller's API tokens.
+func (c *Client) ListTokens(ctx context.Context, opts *ListTokensOptions) ([]TokenInfo, error) {
+	q := url.Values{}
+	if opts != nil {
+		if opts.All {
+			q.Set("all", "true")
+		}
+	}
+	var out []TokenInfo
+	if err := c.do(ctx, "GET", "/api/tokens", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateToken calls POST /api/tokens.
+//
+// Create an API token; the secret is only in this response.
+func (c *Client) CreateToken(ctx context.Context, req *CreateTokenRequest) (*NewToken, error) {
+	var out NewToken
+	if err := c.do(ctx, "POST", "/api/tokens", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RevokeToken calls DELETE /api/tokens/:id.
+//
+// Revoke an API token.
+func (c *Client) RevokeToken(ctx context.Context, id string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/tokens/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListAuditOptions holds the query parameters of ListAudit.
+type ListAuditOptions struct {
+	Actor  string // user
+	Kind   string // object kind, e.g. vms
+	Object string // object name
+	Since  string // unix seconds or RFC 3339
+	Until  string // unix seconds or RFC 3339
+	Limit  int    // maximum entries, default 200
+}
+
+// ListAudit calls GET /api/audit.
+//
+// Query the audit log, newest first.
+func (c *Client) ListAudit(ctx context.Context, opts *ListAuditOptions) ([]AuditEntry, error) {
+	q := url.Values{}
+	if opts != nil {
+		if opts.Actor != "" {
+			q.Set("actor", opts.Actor)
+		}
+		if opts.Kind != "" {
+			q.Set("kind", opts.Kind)
+		}
+		if opts.Object != "" {
+			q.Set("object", opts.Object)
+		}
+		if opts.Since != "" {
+			q.Set("since", opts.Since)
+		}
+		if opts.Until != "" {
+			q.Set("until", opts.Until)
+		}
+		if opts.Limit != 0 {
+			q.Set("limit", strconv.Itoa(opts.Limit))
+		}
+	}
+	var out []AuditEntry
+	if err := c.do(ctx, "GET", "/api/audit", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExportAuditOptions holds the query parameters of ExportAudit.
+type ExportAuditOptions struct {
+	Actor  string // user
+	Kind   string // object kind, e.g. vms
+	Object string // object name
+	Since  string // unix seconds or RFC 3339
+	Until  string // unix seconds or RFC 3339
+}
+
+// ExportAudit calls GET /api/audit/export.
+//
+// Export the audit log as JSON Lines.
+func (c *Client) ExportAudit(ctx context.Context, opts *ExportAuditOptions) (io.ReadCloser, error) {
+	q := url.Values{}
+	if opts != nil {
+		if opts.Actor != "" {
+			q.Set("actor", opts.Actor)
+		}
+		if opts.Kind != "" {
+			q.Set("kind", opts.Kind)
+		}
+		if opts.Object != "" {
+			q.Set("object", opts.Object)
+		}
+		if opts.Since != "" {
+			q.Set("since", opts.Since)
+		}
+		if opts.Until != "" {
+			q.Set("until", opts.Until)
+		}
+	}
+	return c.stream(ctx, "GET", "/api/audit/export", q)
+}
+
+// ListAlerts calls GET /api/alerts.
+//
+// Pending and firing alerts.
+func (c *Client) ListAlerts(ctx context.Context) ([]Alert, error) {
+	var out []Alert
+	if err := c.do(ctx, "GET", "/api/alerts", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AlertHistoryOptions holds the query parameters of AlertHistory.
+type AlertHistoryOptions struct {
+	Limit int // maximum entries, default 100
+}
+
+// AlertHistory calls GET /api/alerts/history.
+//
+// Resolved alerts, newest first.
+func (c *Client) AlertHistory(ctx context.Context, opts *AlertHistoryOptions) ([]Alert, error) {
+	q := url.Values{}
+	if opts != nil {
+		if opts.Limit != 0 {
+			q.Set("limit", strconv.Itoa(opts.Limit))
+		}
+	}
+	var out []Alert
+	if err := c.do(ctx, "GET", "/api/alerts/history", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListAlertRules calls GET /api/alerts/rules.
+//
+// List alert rules.
+func (c *Client) ListAlertRules(ctx context.Context) ([]AlertRule, error) {
+	var out []AlertRule
+	if err := c.do(ctx, "GET", "/api/alerts/rules", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateAlertRule calls POST /api/alerts/rules.
+//
+// Create an alert rule.
+func (c *Client) CreateAlertRule(ctx context.Context, req *AlertRule) (*AlertRule, error) {
+	var out AlertRule
+	if err := c.do(ctx, "POST", "/api/alerts/rules", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateAlertRule calls PUT /api/alerts/rules/:id.
+//
+// Replace an alert rule.
+func (c *Client) UpdateAlertRule(ctx context.Context, id string, req *AlertRule) (*AlertRule, error) {
+	var out AlertRule
+	if err := c.do(ctx, "PUT", "/api/alerts/rules/"+url.PathEscape(id), nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteAlertRule calls DELETE /api/alerts/rules/:id.
+//
+// Delete an alert rule.
+func (c *Client) DeleteAlertRule(ctx context.Context, id string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/alerts/rules/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListAlertChannels calls GET /api/alerts/channels.
+//
+// List notification channels.
+func (c *Client) ListAlertChannels(ctx context.Context) ([]AlertChannel, error) {
+	var out []AlertChannel
+	if err := c.do(ctx, "GET", "/api/alerts/channels", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateAlertChannel calls POST /api/alerts/channels.
+//
+// Create a notification channel.
+func (c *Client) CreateAlertChannel(ctx context.Context, req *AlertChannel) (*AlertChannel, error) {
+	var out AlertChannel
+	if err := c.do(ctx, "POST", "/api/alerts/channels", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateAlertChannel calls PUT /api/alerts/channels/:id.
+//
+// Replace a notification channel.
+func (c *Client) UpdateAlertChannel(ctx context.Context, id string, req *AlertChannel) (*AlertChannel, error) {
+	var out AlertChannel
+	if err := c.do(ctx, "PUT", "/api/alerts/channels/"+url.PathEscape(id), nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteAlertChannel calls DELETE /api/alerts/channels/:id.
+//
+// Delete a notification channel no rule uses.
+func (c *Client) DeleteAlertChannel(ctx context.Context, id string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/alerts/channels/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// TestAlertChannel calls POST /api/alerts/channels/:id/test.
+//
+// Send a test notification.
+func (c *Client) TestAlertChannel(ctx context.Context, id string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/alerts/channels/"+url.PathEscape(id)+"/test", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListWebhooks calls GET /api/webhooks.
+//
+// List webhooks (secrets omitted).
+func (c *Client) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	var out []Webhook
+	if err := c.do(ctx, "GET", "/api/webhooks", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateWebhook calls POST /api/webhooks.
+//
+// Register a webhook; the secret is only in this response.
+func (c *Client) CreateWebhook(ctx context.Context, req *Webhook) (*Webhook, error) {
+	var out Webhook
+	if err := c.do(ctx, "POST", "/api/webhooks", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateWebhook calls PUT /api/webhooks/:id.
+//
+// Replace a webhook; an empty secret keeps the current one.
+func (c *Client) UpdateWebhook(ctx context.Context, id string, req *Webhook) (*Webhook, error) {
+	var out Webhook
+	if err := c.do(ctx, "PUT", "/api/webhooks/"+url.PathEscape(id), nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteWebhook calls DELETE /api/webhooks/:id.
+//
+// Delete a webhook and its delivery log.
+func (c *Client) DeleteWebhook(ctx context.Context, id string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/webhooks/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListWebhookDeliveries calls GET /api/webhooks/:id/deliveries.
+//
+// Recent deliveries of a webhook, newest first.
+func (c *Client) ListWebhookDeliveries(ctx context.Context, id string) ([]WebhookDelivery, error) {
+	var out []WebhookDelivery
+	if err := c.do(ctx, "GET", "/api/webhooks/"+url.PathEscape(id)+"/deliveries", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TestWebhook calls POST /api/webhooks/:id/test.
+//
+// Send a test event.
+func (c *Client) TestWebhook(ctx context.Context, id string) (*WebhookDelivery, error) {
+	var out WebhookDelivery
+	if err := c.do(ctx, "POST", "/api/webhooks/"+url.PathEscape(id)+"/test", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListHosts calls GET /api/hosts.
+//
+// List libvirt hosts.
+func (c *Client) ListHosts(ctx context.Context) ([]Host, error) {
+	var out []Host
+	if err := c.do(ctx, "GET", "/api/hosts", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AddHost calls POST /api/hosts.
+//
+// Register a libvirt host.
+func (c *Client) AddHost(ctx context.Context, req *AddHostRequest) (*Host, error) {
+	var out Host
+	if err := c.do(ctx, "POST", "/api/hosts", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteHost calls DELETE /api/hosts/:host.
+//
+// Remove a registered host.
+func (c *Client) DeleteHost(ctx context.Context, host string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/hosts/"+url.PathEscape(host), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CheckHost calls POST /api/hosts/:host/check.
+//
+// Check a host's connection now.
+func (c *Client) CheckHost(ctx context.Context, host string) (*Host, error) {
+	var out Host
+	if err := c.do(ctx, "POST", "/api/hosts/"+url.PathEscape(host)+"/check", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// HostsOverview calls GET /api/hosts/overview.
+//
+// Hosts with their resource summary.
+func (c *Client) HostsOverview(ctx context.Context) ([]HostOverview, error) {
+	var out []HostOverview
+	if err := c.do(ctx, "GET", "/api/hosts/overview", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListAllVMs calls GET /api/hosts/vms.
+//
+// VMs of every connected host.
+func (c *Client) ListAllVMs(ctx context.Context) (*HostVMs, error) {
+	var out HostVMs
+	if err := c.do(ctx, "GET", "/api/hosts/vms", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetHostInfo calls GET /api/host/info.
+//
+// Resources of the selected host.
+func (c *Client) GetHostInfo(ctx context.Context) (*HostInfo, error) {
+	var out HostInfo
+	if err := c.do(ctx, "GET", "/api/host/info", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetHostStatsOptions holds the query parameters of GetHostStats.
+type GetHostStatsOptions struct {
+	From string // unix seconds or RFC 3339, default one hour before to
+	To   string // unix seconds or RFC 3339, default now
+	Step string // seconds or a duration such as 5m, default about 300 points
+}
+
+// GetHostStats calls GET /api/host/stats.
+//
+// CPU, memory and load history of the selected host.
+func (c *Client) GetHostStats(ctx context.Context, opts *GetHostStatsOptions) (*StatsSeries, error) {
+	q := url.Values{}
+	if opts != nil {
+		if opts.From != "" {
+			q.Set("from", opts.From)
+		}
+		if opts.To != "" {
+			q.Set("to", opts.To)
+		}
+		if opts.Step != "" {
+			q.Set("step", opts.Step)
+		}
+	}
+	var out StatsSeries
+	if err := c.do(ctx, "GET", "/api/host/stats", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListPhysicalNICs calls GET /api/host/nics.
+//
+// Physical interfaces of the panel's host.
+func (c *Client) ListPhysicalNICs(ctx context.Context) ([]PhysicalNIC, error) {
+	var out []PhysicalNIC
+	if err := c.do(ctx, "GET", "/api/host/nics", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetSettings calls GET /api/settings.
+//
+// Effective backend configuration.
+func (c *Client) GetSettings(ctx context.Context) (*Settings, error) {
+	var out Settings
+	if err := c.do(ctx, "GET", "/api/settings", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListTasks calls GET /api/tasks.
+//
+// List running and recent tasks.
+func (c *Client) ListTasks(ctx context.Context) ([]Task, error) {
+	var out []Task
+	if err := c.do(ctx, "GET", "/api/tasks", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetTask calls GET /api/tasks/:id.
+//
+// Get a task.
+func (c *Client) GetTask(ctx context.Context, id string) (*Task, error) {
+	var out Task
+	if err := c.do(ctx, "GET", "/api/tasks/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CancelTask calls POST /api/tasks/:id/cancel.
+//
+// Cancel a running task.
+func (c *Client) CancelTask(ctx context.Context, id string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/tasks/"+url.PathEscape(id)+"/cancel", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// EventsOptions holds the query parameters of Events.
+type EventsOptions struct {
+	Kind string // comma-separated: domain, agent, network, pool, host
+	Name string // object name
+}
+
+// Events calls GET /api/events.
+//
+// Lifecycle events as server-sent events (model.Event).
+func (c *Client) Events(ctx context.Context, opts *EventsOptions) (io.ReadCloser, error) {
+	q := url.Values{}
+	if opts != nil {
+		if opts.Kind != "" {
+			q.Set("kind", opts.Kind)
+		}
+		if opts.Name != "" {
+			q.Set("name", opts.Name)
+		}
+	}
+	return c.stream(ctx, "GET", "/api/events", q)
+}
+
+// ListVMs calls GET /api/vms.
+//
+// List VMs.
+func (c *Client) ListVMs(ctx context.Context) ([]VM, error) {
+	var out []VM
+	if err := c.do(ctx, "GET", "/api/vms", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetVM calls GET /api/vms/:name.
+//
+// Get a VM.
+func (c *Client) GetVM(ctx context.Context, name string) (*VM, error) {
+	var out VM
+	if err := c.do(ctx, "GET", "/api/vms/"+url.PathEscape(name), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetVMDetail calls GET /api/vms/:name/detail.
+//
+// Disks, NICs and settings of a VM.
+func (c *Client) GetVMDetail(ctx context.Context, name string) (*VMDetail, error) {
+	var out VMDetail
+	if err := c.do(ctx, "GET", "/api/vms/"+url.PathEscape(name)+"/detail", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetVMStatsOptions holds the query parameters of GetVMStats.
+type GetVMStatsOptions struct {
+	From string // unix seconds or RFC 3339, default one hour before to
+	To   string // unix seconds or RFC 3339, default now
+	Step string // seconds or a duration such as 5m, default about 300 points
+}
+
+// GetVMStats calls GET /api/vms/:name/stats.
+//
+// CPU, memory, disk and network history of a VM.
+func (c *Client) GetVMStats(ctx context.Context, name string, opts *GetVMStatsOptions) (*StatsSeries, error) {
+	q := url.Values{}
+	if opts != nil {
+		if opts.From != "" {
+			q.Set("from", opts.From)
+		}
+		if opts.To != "" {
+			q.Set("to", opts.To)
+		}
+		if opts.Step != "" {
+			q.Set("step", opts.Step)
+		}
+	}
+	var out StatsSeries
+	if err := c.do(ctx, "GET", "/api/vms/"+url.PathEscape(name)+"/stats", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateVM calls POST /api/vms.
+//
+// Create a VM (task).
+func (c *Client) CreateVM(ctx context.Context, req *CreateVMRequest) (*TaskAccepted, error) {
+	var out TaskAccepted
+	if err := c.do(ctx, "POST", "/api/vms", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateVM calls PUT /api/vms/:name.
+//
+// Change CPU, memory and boot settings.
+func (c *Client) UpdateVM(ctx context.Context, name string, req *UpdateVMRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "PUT", "/api/vms/"+url.PathEscape(name), nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetVMXMLOptions holds the query parameters of GetVMXML.
+type GetVMXMLOptions struct {
+	Inactive bool // persistent configuration instead of the live one
+}
+
+// GetVMXML calls GET /api/vms/:name/xml.
+//
+// Domain XML.
+func (c *Client) GetVMXML(ctx context.Context, name string, opts *GetVMXMLOptions) (*VMXML, error) {
+	q := url.Values{}
+	if opts != nil {
+		if opts.Inactive {
+			q.Set("inactive", "true")
+		}
+	}
+	var out VMXML
+	if err := c.do(ctx, "GET", "/api/vms/"+url.PathEscape(name)+"/xml", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateVMXMLOptions holds the query parameters of UpdateVMXML.
+type UpdateVMXMLOptions struct {
+	DryRun bool // only validate and return the diff
+}
+
+// UpdateVMXML calls PUT /api/vms/:name/xml.
+//
+// Replace the domain XML.
+func (c *Client) UpdateVMXML(ctx context.Context, name string, req *UpdateVMXMLRequest, opts *UpdateVMXMLOptions) (*VMXMLResult, error) {
+	q := url.Values{}
+	if opts != nil {
+		if opts.DryRun {
+			q.Set("dry_run", "true")
+		}
+	}
+	var out VMXMLResult
+	if err := c.do(ctx, "PUT", "/api/vms/"+url.PathEscape(name)+"/xml", q, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetVMOwner calls PUT /api/vms/:name/owner.
+//
+// Hand a VM to another user or group.
+func (c *Client) SetVMOwner(ctx context.Context, name string, req *VMOwner) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "PUT", "/api/vms/"+url.PathEscape(name)+"/owner", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteVM calls DELETE /api/vms/:name.
+//
+// Delete a VM.
+func (c *Client) DeleteVM(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/vms/"+url.PathEscape(name), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// StartVM calls POST /api/vms/:name/start.
+//
+// Start a VM.
+func (c *Client) StartVM(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/start", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ShutdownVM calls POST /api/vms/:name/shutdown.
+//
+// Shut a VM down gracefully.
+func (c *Client) ShutdownVM(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/shutdown", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DestroyVM calls POST /api/vms/:name/destroy.
+//
+// Force a VM off.
+func (c *Client) DestroyVM(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/destroy", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RebootVM calls POST /api/vms/:name/reboot.
+//
+// Reboot a VM.
+func (c *Client) RebootVM(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/reboot", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SuspendVM calls POST /api/vms/:name/suspend.
+//
+// Pause a VM.
+func (c *Client) SuspendVM(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/suspend", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ResumeVM calls POST /api/vms/:name/resume.
+//
+// Resume a paused VM.
+func (c *Client) ResumeVM(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/resume", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CloneVM calls POST /api/vms/:name/clone.
+//
+// Clone a VM (task).
+func (c *Client) CloneVM(ctx context.Context, name string, req *CloneVMRequest) (*TaskAccepted, error) {
+	var out TaskAccepted
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/clone", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// FlattenVM calls POST /api/vms/:name/flatten.
+//
+// Copy the template data into a linked clone's disks (task).
+func (c *Client) FlattenVM(ctx context.Context, name string) (*TaskAccepted, error) {
+	var out TaskAccepted
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/flatten", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAutostart calls GET /api/vms/:name/autostart.
+//
+// Whether the VM starts with the host.
+func (c *Client) GetAutostart(ctx context.Context, name string) (*Autostart, error) {
+	var out Autostart
+	if err := c.do(ctx, "GET", "/api/vms/"+url.PathEscape(name)+"/autostart", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetAutostart calls PUT /api/vms/:name/autostart.
+//
+// Set autostart.
+func (c *Client) SetAutostart(ctx context.Context, name string, req *Autostart) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "PUT", "/api/vms/"+url.PathEscape(name)+"/autostart", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RenameVM calls POST /api/vms/:name/rename.
+//
+// Rename a VM.
+func (c *Client) RenameVM(ctx context.Context, name string, req *RenameVMRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/rename", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ImportVM calls POST /api/vms/import.
+//
+// Define a VM from existing disks.
+func (c *Client) ImportVM(ctx context.Context, req *ImportVMRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/import", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ExportVMOptions holds the query parameters of ExportVM.
+type ExportVMOptions struct {
+	Format string // ova, the default and only format
+}
+
+// ExportVM calls GET /api/vms/:name/export.
+//
+// Export a shut-off VM as an OVA (local host only).
+func (c *Client) ExportVM(ctx context.Context, name string, opts *ExportVMOptions) (io.ReadCloser, error) {
+	q := url.Values{}
+	if opts != nil {
+		if opts.Format != "" {
+			q.Set("format", opts.Format)
+		}
+	}
+	return c.stream(ctx, "GET", "/api/vms/"+url.PathEscape(name)+"/export", q)
+}
+
+// ImportOVAOptions holds the query parameters of ImportOVA.
+type ImportOVAOptions struct {
+	Name     string // name of the new VM, required
+	Pool     string // directory pool for the disks, default the one of image_dir
+	Format   string // qcow2 (default) or raw
+	DiskBus  string // virtio, sata, scsi or ide; default keeps IDE disks on IDE and puts the others on SATA
+	NetModel string // virtio, e1000 or rtl8139; default keeps virtio and makes the others e1000
+}
+
+// ImportOVA calls POST /api/vms/import-ova.
+//
+// Create a VM from an uploaded OVA (task, local host only).
+func (c *Client) ImportOVA(ctx context.Context, filename string, file io.Reader, opts *ImportOVAOptions) (*TaskAccepted, error) {
+	q := url.Values{}
+	if opts != nil {
+		if opts.Name != "" {
+			q.Set("name", opts.Name)
+		}
+		if opts.Pool != "" {
+			q.Set("pool", opts.Pool)
+		}
+		if opts.Format != "" {
+			q.Set("format", opts.Format)
+		}
+		if opts.DiskBus != "" {
+			q.Set("disk_bus", opts.DiskBus)
+		}
+		if opts.NetModel != "" {
+			q.Set("net_model", opts.NetModel)
+		}
+	}
+	var out TaskAccepted
+	if err := c.upload(ctx, "/api/vms/import-ova", q, "file", filename, file, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// BatchAction calls POST /api/vms/batch.
+//
+// Start, shut down, destroy or delete several VMs.
+func (c *Client) BatchAction(ctx context.Context, req *BatchActionRequest) (*BatchResult, error) {
+	var out BatchResult
+	if err := c.do(ctx, "POST", "/api/vms/batch", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetTemplate calls PUT /api/vms/:name/template.
+//
+// Mark a shut-off VM as a template or unmark it.
+func (c *Client) SetTemplate(ctx context.Context, name string, req *VMTemplate) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "PUT", "/api/vms/"+url.PathEscape(name)+"/template", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListTemplates calls GET /api/templates.
+//
+// List templates.
+func (c *Client) ListTemplates(ctx context.Context) ([]VM, error) {
+	var out []VM
+	if err := c.do(ctx, "GET", "/api/templates", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateFromTemplate calls POST /api/templates/:name/vms.
+//
+// Create a VM from a template (task).
+func (c *Client) CreateFromTemplate(ctx context.Context, name string, req *CreateFromTemplateRequest) (*TaskAccepted, error) {
+	var out TaskAccepted
+	if err := c.do(ctx, "POST", "/api/templates/"+url.PathEscape(name)+"/vms", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AttachDisk calls POST /api/vms/:name/disks.
+//
+// Attach a disk.
+func (c *Client) AttachDisk(ctx context.Context, name string, req *AttachDiskRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/disks", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DetachDisk calls DELETE /api/vms/:name/disks/:target.
+//
+// Detach a disk.
+func (c *Client) DetachDisk(ctx context.Context, name string, target string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/vms/"+url.PathEscape(name)+"/disks/"+url.PathEscape(target), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AttachNIC calls POST /api/vms/:name/nics.
+//
+// Attach a network interface.
+func (c *Client) AttachNIC(ctx context.Context, name string, req *AttachNICRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/nics", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DetachNIC calls DELETE /api/vms/:name/nics/:mac.
+//
+// Detach a network interface.
+func (c *Client) DetachNIC(ctx context.Context, name string, mac string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/vms/"+url.PathEscape(name)+"/nics/"+url.PathEscape(mac), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AttachISO calls POST /api/vms/:name/iso.
+//
+// Insert an ISO into the CD-ROM.
+func (c *Client) AttachISO(ctx context.Context, name string, req *AttachISORequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/iso", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DetachISO calls DELETE /api/vms/:name/iso.
+//
+// Eject the CD-ROM.
+func (c *Client) DetachISO(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/vms/"+url.PathEscape(name)+"/iso", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// FinishInstall calls POST /api/vms/:name/finish-install.
+//
+// Eject the install media and boot from disk.
+func (c *Client) FinishInstall(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/finish-install", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetVNCPort calls GET /api/vms/:name/vnc.
+//
+// VNC port of a running VM.
+func (c *Client) GetVNCPort(ctx context.Context, name string) (*VNCPort, error) {
+	var out VNCPort
+	if err := c.do(ctx, "GET", "/api/vms/"+url.PathEscape(name)+"/vnc", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListSnapshots calls GET /api/vms/:name/snapshots.
+//
+// List snapshots.
+func (c *Client) ListSnapshots(ctx context.Context, name string) ([]Snapshot, error) {
+	var out []Snapshot
+	if err := c.do(ctx, "GET", "/api/vms/"+url.PathEscape(name)+"/snapshots", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateSnapshot calls POST /api/vms/:name/snapshots.
+//
+// Create a snapshot.
+func (c *Client) CreateSnapshot(ctx context.Context, name string, req *CreateSnapshotRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/snapshots", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteSnapshot calls DELETE /api/vms/:name/snapshots/:snap.
+//
+// Delete a snapshot.
+func (c *Client) DeleteSnapshot(ctx context.Context, name string, snap string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/vms/"+url.PathEscape(name)+"/snapshots/"+url.PathEscape(snap), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RevertSnapshot calls POST /api/vms/:name/snapshots/:snap/revert.
+//
+// Revert a VM to a snapshot.
+func (c *Client) RevertSnapshot(ctx context.Context, name string, snap string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/snapshots/"+url.PathEscape(snap)+"/revert", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RevertSnapshotToNew calls POST /api/vms/:name/snapshots/:snap/revert-to-new.
+//
+// Create a new VM from a snapshot (task).
+func (c *Client) RevertSnapshotToNew(ctx context.Context, name string, snap string, req *RevertSnapshotToNewRequest) (*TaskAccepted, error) {
+	var out TaskAccepted
+	if err := c.do(ctx, "POST", "/api/vms/"+url.PathEscape(name)+"/snapshots/"+url.PathEscape(snap)+"/revert-to-new", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListNetworks calls GET /api/networks.
+//
+// List virtual networks.
+func (c *Client) ListNetworks(ctx context.Context) ([]Network, error) {
+	var out []Network
+	if err := c.do(ctx, "GET", "/api/networks", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateNetwork calls POST /api/networks.
+//
+// Create a virtual network.
+func (c *Client) CreateNetwork(ctx context.Context, req *CreateNetworkRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/networks", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// StartNetwork calls POST /api/networks/:name/start.
+//
+// Start a network.
+func (c *Client) StartNetwork(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/networks/"+url.PathEscape(name)+"/start", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// StopNetwork calls POST /api/networks/:name/stop.
+//
+// Stop a network.
+func (c *Client) StopNetwork(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/networks/"+url.PathEscape(name)+"/stop", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteNetwork calls DELETE /api/networks/:name.
+//
+// Delete a network.
+func (c *Client) DeleteNetwork(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/networks/"+url.PathEscape(name), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListDHCPLeases calls GET /api/networks/:name/leases.
+//
+// DHCP leases of a network.
+func (c *Client) ListDHCPLeases(ctx context.Context, name string) ([]DHCPLease, error) {
+	var out []DHCPLease
+	if err := c.do(ctx, "GET", "/api/networks/"+url.PathEscape(name)+"/leases", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListStoragePools calls GET /api/storage-pools.
+//
+// List storage pools.
+func (c *Client) ListStoragePools(ctx context.Context) ([]StoragePool, error) {
+	var out []StoragePool
+	if err := c.do(ctx, "GET", "/api/storage-pools", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateStoragePool calls POST /api/storage-pools.
+//
+// Create a directory storage pool.
+func (c *Client) CreateStoragePool(ctx context.Context, req *CreateStoragePoolRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/storage-pools", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// StartStoragePool calls POST /api/storage-pools/:name/start.
+//
+// Start a storage pool.
+func (c *Client) StartStoragePool(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/storage-pools/"+url.PathEscape(name)+"/start", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// StopStoragePool calls POST /api/storage-pools/:name/stop.
+//
+// Stop a storage pool.
+func (c *Client) StopStoragePool(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/storage-pools/"+url.PathEscape(name)+"/stop", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteStoragePool calls DELETE /api/storage-pools/:name.
+//
+// Delete a storage pool.
+func (c *Client) DeleteStoragePool(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/storage-pools/"+url.PathEscape(name), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListVolumes calls GET /api/storage-pools/:name/volumes.
+//
+// List the volumes of a pool.
+func (c *Client) ListVolumes(ctx context.Context, name string) ([]StorageVolume, error) {
+	var out []StorageVolume
+	if err := c.do(ctx, "GET", "/api/storage-pools/"+url.PathEscape(name)+"/volumes", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateVolume calls POST /api/storage-volumes.
+//
+// Create a volume.
+func (c *Client) CreateVolume(ctx context.Context, req *CreateVolumeRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/storage-volumes", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteVolume calls DELETE /api/storage-pools/:name/volumes/:vol.
+//
+// Delete a volume.
+func (c *Client) DeleteVolume(ctx context.Context, name string, vol string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/storage-pools/"+url.PathEscape(name)+"/volumes/"+url.PathEscape(vol), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListISOs calls GET /api/isos.
+//
+// List ISO images.
+func (c *Client) ListISOs(ctx context.Context) ([]ISOFile, error) {
+	var out []ISOFile
+	if err := c.do(ctx, "GET", "/api/isos", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UploadISO calls POST /api/isos/upload.
+//
+// Upload an ISO image (task).
+func (c *Client) UploadISO(ctx context.Context, filename string, file io.Reader) (*TaskAccepted, error) {
+	var out TaskAccepted
+	if err := c.upload(ctx, "/api/isos/upload", nil, "file", filename, file, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteISO calls DELETE /api/isos/:name.
+//
+// Delete an ISO image.
+func (c *Client) DeleteISO(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/isos/"+url.PathEscape(name), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListBridges calls GET /api/bridges.
+//
+// List host bridges.
+func (c *Client) ListBridges(ctx context.Context) ([]Bridge, error) {
+	var out []Bridge
+	if err := c.do(ctx, "GET", "/api/bridges", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateBridge calls POST /api/bridges.
+//
+// Create a host bridge.
+func (c *Client) CreateBridge(ctx context.Context, req *CreateBridgeRequest) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/bridges", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteBridge calls DELETE /api/bridges/:name.
+//
+// Delete a host bridge.
+func (c *Client) DeleteBridge(ctx context.Context, name string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/bridges/"+url.PathEscape(name), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListPortForwards calls GET /api/port-forwards.
+//
+// List port forwards.
+func (c *Client) ListPortForwards(ctx context.Context) ([]PortForward, error) {
+	var out []PortForward
+	if err := c.do(ctx, "GET", "/api/port-forwards", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AddPortForward calls POST /api/port-forwards.
+//
+// Add a port forward.
+func (c *Client) AddPortForward(ctx context.Context, req *PortForward) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "POST", "/api/port-forwards", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeletePortForward calls DELETE /api/port-forwards/:id.
+//
+// Delete a port forward.
+func (c *Client) DeletePortForward(ctx context.Context, id string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, "DELETE", "/api/port-forwards/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Metrics calls GET /metrics.
+//
+// Host, VM and backend metrics in the Prometheus text format.
+func (c *Client) Metrics(ctx context.Context) (io.ReadCloser, error) {
+	return c.stream(ctx, "GET", "/metrics", nil)
+}
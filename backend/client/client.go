@@ -0,0 +1,196 @@
+// Package client is a Go client for the VirtPanel REST API.
+//
+// The request and response types and one method per endpoint are in
+// api_gen.go, generated from the server's route table
+// (internal/handler/apidoc.go) like the OpenAPI document served at
+// /api/openapi.json. Run go generate in this directory after changing
+// routes or model types.
+//
+//	c := client.New("http://panel:8080", os.Getenv("VIRTPANEL_TOKEN"))
+//	vms, err := c.ListVMs(ctx)
+//	task, err := c.CreateVM(ctx, &client.CreateVMRequest{Name: "web1", CPU: 2, Memory: 2048})
+//	t, err := c.WaitTask(ctx, task.TaskID)
+package client
+
+//go:generate go run ./gen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to one panel. Authenticate with an API token, or leave
+// Token empty and call Login: the session cookie is kept in HTTPClient's
+// cookie jar.
+type Client struct {
+	BaseURL    string // e.g. http://panel:8080
+	Token      string // API token sent as "Authorization: Bearer"
+	Host       string // libvirt host for ?host=, the default host when empty
+	HTTPClient *http.Client
+}
+
+// New returns a client for the panel at baseURL.
+func New(baseURL, token string) *Client {
+	jar, _ := cookiejar.New(nil)
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token, HTTPClient: &http.Client{Jar: jar}}
+}
+
+// WithHost returns a copy of the client that targets another libvirt
+// host. Both share the HTTP client and its session.
+func (c *Client) WithHost(host string) *Client {
+	cp := *c
+	cp.Host = host
+	return &cp
+}
+
+// Error is a non-2xx reply. Code is the server's machine-readable error
+// code, e.g. vm_not_found; it is empty when the reply was not JSON.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("virtpanel: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// ErrorCode returns the code of an error reply, or "" if err is not one.
+func ErrorCode(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return ""
+}
+
+// IsNotFound reports whether err is a 404 reply.
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	if c.Host != "" {
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("host", c.Host)
+	}
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		var e ErrorResponse
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(data))
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Code: e.Code, Message: e.Error}
+	}
+	return resp, nil
+}
+
+// do sends in as JSON and decodes the reply into out; either may be nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body io.Reader
+	var contentType string
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(data), "application/json"
+	}
+	resp, err := c.send(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// stream returns the reply body of endpoints that do not answer with one
+// JSON value. The caller closes it.
+func (c *Client) stream(ctx context.Context, method, path string, query url.Values) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, method, path, query, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// upload posts file as the multipart field, streaming it.
+func (c *Client) upload(ctx context.Context, path string, query url.Values, field, filename string, file io.Reader, out any) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile(field, filename)
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	resp, err := c.send(ctx, http.MethodPost, path, query, pr, mw.FormDataContentType())
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// WaitTask polls a task until it finishes. A failed or canceled task is
+// returned together with an error.
+func (c *Client) WaitTask(ctx context.Context, id string) (*Task, error) {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		t, err := c.GetTask(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		switch t.State {
+		case "succeeded":
+			return t, nil
+		case "failed", "canceled":
+			return t, fmt.Errorf("task %s %s: %s", t.ID, t.State, t.Error)
+		}
+		select {
+		case <-ctx.Done():
+			return t, ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Command gen writes api_gen.go, the typed methods and types of package
+// client, from the server's route table. Run it with go generate in the
+// client directory.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"virtpanel/internal/handler"
+	"virtpanel/internal/openapi"
+)
+
+const output = "api_gen.go"
+
+// initialisms keep Go spelling in names built from snake_case.
+var initialisms = map[string]string{"id": "ID", "ip": "IP", "mac": "MAC", "xml": "XML", "uri": "URI", "url": "URL", "vm": "VM", "iso": "ISO"}
+
+func main() {
+	doc := openapi.Build(handler.APISpec())
+	g := gen{imports: map[string]bool{"context": true}}
+
+	names := make([]string, 0, len(doc.Components.Schemas))
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g.typeDecl(name, doc.Components.Schemas[name])
+	}
+	for _, op := range doc.Operations {
+		if op.Route.WebSocket {
+			continue
+		}
+		g.method(op)
+	}
+
+	var file bytes.Buffer
+	file.WriteString("// Code generated by go run ./gen; DO NOT EDIT.\n\npackage client\n\nimport (\n")
+	imports := make([]string, 0, len(g.imports))
+	for imp := range g.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	for _, imp := range imports {
+		fmt.Fprintf(&file, "%q\n", imp)
+	}
+	file.WriteString(")\n\n")
+	file.Write(g.buf.Bytes())
+
+	src, err := format.Source(file.Bytes())
+	if err != nil {
+		os.WriteFile(output, file.Bytes(), 0644)
+		log.Fatalf("format %s: %v", output, err)
+	}
+	if err := os.WriteFile(output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type gen struct {
+	buf     bytes.Buffer
+	imports map[string]bool
+}
+
+func (g *gen) p(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *gen) typeDecl(name string, s *openapi.Schema) {
+	g.p("type %s struct {\n", name)
+	for _, prop := range s.Order {
+		ps := s.Properties[prop]
+		tag := prop
+		if ps.OmitEmpty {
+			tag += ",omitempty"
+		}
+		g.p("%s %s `json:%q`\n", ps.GoName, g.goType(ps), tag)
+	}
+	g.p("}\n\n")
+}
+
+func (g *gen) goType(s *openapi.Schema) string {
+	var t string
+	switch {
+	case s.Ref != "":
+		t = strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	case s.Raw:
+		g.imports["encoding/json"] = true
+		return "json.RawMessage"
+	case s.Type == "array":
+		return "[]" + g.goType(s.Items)
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		return "map[string]" + g.goType(s.AdditionalProperties)
+	case s.GoType != "":
+		t = s.GoType
+	default:
+		return "any"
+	}
+	if s.Nullable {
+		return "*" + t
+	}
+	return t
+}
+
+// camel turns dry_run into DryRun.
+func camel(s string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(s, "_") {
+		if v, ok := initialisms[part]; ok {
+			b.WriteString(v)
+		} else if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+func jsonSchema(content map[string]openapi.MediaType) *openapi.Schema {
+	if mt, ok := content["application/json"]; ok {
+		return mt.Schema
+	}
+	return nil
+}
+
+func (g *gen) method(op *openapi.Operation) {
+	r := op.Route
+	args := []string{"ctx context.Context"}
+	var pathExpr []string
+	for _, seg := range strings.Split(strings.TrimPrefix(r.Path, "/"), "/") {
+		if strings.HasPrefix(seg, ":") {
+			args = append(args, seg[1:]+" string")
+			g.imports["net/url"] = true
+			pathExpr = append(pathExpr, `"/"+url.PathEscape(`+seg[1:]+`)`)
+		} else {
+			pathExpr = append(pathExpr, fmt.Sprintf("%q", "/"+seg))
+		}
+	}
+	path := strings.ReplaceAll(strings.Join(pathExpr, "+"), `"+"`, "")
+
+	var query []openapi.Param
+	for _, q := range r.Query {
+		if q.Name != "host" {
+			query = append(query, q)
+		}
+	}
+	optsType := op.OperationID + "Options"
+	if len(query) > 0 {
+		g.p("// %s holds the query parameters of %s.\n", optsType, op.OperationID)
+		g.p("type %s struct {\n", optsType)
+		for _, q := range query {
+			t := map[string]string{"string": "string", "boolean": "bool", "integer": "int"}[q.Type]
+			g.p("%s %s // %s\n", camel(q.Name), t, q.Doc)
+		}
+		g.p("}\n\n")
+	}
+
+	var body string
+	switch {
+	case r.Upload != "":
+		g.imports["io"] = true
+		args = append(args, "filename string", "file io.Reader")
+	case op.RequestBody != nil:
+		args = append(args, "req *"+g.goType(jsonSchema(op.RequestBody.Content)))
+		body = "req"
+	}
+	if len(query) > 0 {
+		args = append(args, "opts *"+optsType)
+	}
+
+	var result *openapi.Schema
+	for code, resp := range op.Responses {
+		if code != "default" {
+			result = jsonSchema(resp.Content)
+		}
+	}
+	ret, out, ok := "error", "", ""
+	switch {
+	case r.Stream != "":
+		g.imports["io"] = true
+		ret = "(io.ReadCloser, error)"
+	case result != nil && result.Type == "array":
+		ret, out, ok = "("+g.goType(result)+", error)", g.goType(result), "out"
+	case result != nil:
+		ret, out, ok = "(*"+g.goType(result)+", error)", g.goType(result), "&out"
+	}
+
+	g.p("// %s calls %s %s.\n//\n// %s.\n", op.OperationID, r.Method, r.Path, op.Summary)
+	g.p("func (c *Client) %s(%s) %s {\n", op.OperationID, strings.Join(args, ", "), ret)
+	q := "nil"
+	if len(query) > 0 {
+		q = "q"
+		g.imports["net/url"] = true
+		g.p("q := url.Values{}\nif opts != nil {\n")
+		for _, p := range query {
+			f := "opts." + camel(p.Name)
+			switch p.Type {
+			case "boolean":
+				g.p("if %s {\nq.Set(%q, \"true\")\n}\n", f, p.Name)
+			case "integer":
+				g.imports["strconv"] = true
+				g.p("if %s != 0 {\nq.Set(%q, strconv.Itoa(%s))\n}\n", f, p.Name, f)
+			default:
+				g.p("if %s != \"\" {\nq.Set(%q, %s)\n}\n", f, p.Name, f)
+			}
+		}
+		g.p("}\n")
+	}
+	switch {
+	case r.Stream != "":
+		g.p("return c.stream(ctx, %q, %s, %s)\n", r.Method, path, q)
+	case r.Upload != "":
+		g.p("var out %s\nif err := c.upload(ctx, %s, %s, %q, filename, file, &out); err != nil {\nreturn nil, err\n}\nreturn %s, nil\n",
+			out, path, q, r.Upload, ok)
+	case out != "":
+		if body == "" {
+			body = "nil"
+		}
+		g.p("var out %s\nif err := c.do(ctx, %q, %s, %s, %s, &out); err != nil {\nreturn nil, err\n}\nreturn %s, nil\n",
+			out, r.Method, path, q, body, ok)
+	default:
+		if body == "" {
+			body = "nil"
+		}
+		g.p("return c.do(ctx, %q, %s, %s, %s, nil)\n", r.Method, path, q, body)
+	}
+	g.p("}\n\n")
+}
@@ -1,112 +1,283 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"virtpanel/internal/alert"
+	"virtpanel/internal/audit"
+	"virtpanel/internal/auth"
+	"virtpanel/internal/collector"
+	"virtpanel/internal/config"
+	"virtpanel/internal/event"
 	"virtpanel/internal/handler"
 	"virtpanel/internal/service"
+	"virtpanel/internal/task"
+	"virtpanel/internal/webhook"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	svc, err := service.NewLibvirtService()
+	flags := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := config.Load(*flags.Config)
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+	flags.Apply(cfg)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("配置无效: %v", err)
+	}
+
+	var svc service.Hypervisor
+	switch cfg.Driver {
+	case "libvirt":
+		lv, err := service.NewLibvirtService(cfg, cfg.LibvirtURI)
+		if err != nil {
+			log.Fatalf("连接 libvirt 失败: %v", err)
+		}
+		svc = lv
+	case "sim":
+		log.Println("使用模拟驱动，所有对象仅保存在内存中")
+		svc = service.NewSimService(cfg)
+	}
+	events := event.NewBus()
+	hosts := service.NewHostManager(cfg, svc, events.Publish)
+	defer hosts.Close()
+
+	tasks := task.NewManager(cfg.DataDir)
+	tasks.ErrorCode = handler.ErrorCode
+
+	auditLog, err := audit.Open(cfg.DataDir)
 	if err != nil {
-		log.Fatalf("连接 libvirt 失败: %v", err)
+		log.Fatalf("打开审计日志失败: %v", err)
 	}
-	defer svc.Close()
+	defer auditLog.Close()
 
-	h := handler.NewHandler(svc)
+	users, err := auth.NewManager(cfg.DataDir)
+	if err != nil {
+		log.Fatalf("加载用户失败: %v", err)
+	}
+	if token := users.SetupToken(); token != "" {
+		log.Printf("尚未创建任何账户，请在登录页使用初始化令牌创建管理员: %s", token)
+	}
+
+	var stats *collector.Collector
+	if every := cfg.StatsEvery(); every > 0 {
+		if stats, err = collector.New(hosts, cfg.DataDir, every); err != nil {
+			log.Fatalf("打开监控数据目录失败: %v", err)
+		}
+		stats.Start()
+		defer stats.Close()
+	}
+
+	alerts, err := alert.New(cfg.DataDir, hosts, events)
+	if err != nil {
+		log.Fatalf("加载告警配置失败: %v", err)
+	}
+	alerts.Start()
+	defer alerts.Close()
+
+	hooks, err := webhook.New(cfg.DataDir)
+	if err != nil {
+		log.Fatalf("加载 Webhook 配置失败: %v", err)
+	}
+	defer hooks.Close()
+
+	h := handler.NewHandler(hosts, tasks, events, auditLog, users, stats, alerts, hooks, cfg)
 
 	r := gin.Default()
-	r.Use(cors.Default())
+	r.Use(cors.Default(), h.Instrument)
 	r.MaxMultipartMemory = 8 << 30
 
-	api := r.Group("/api")
+	// Login and first-run setup are the only routes open without a session
+	r.GET("/api/openapi.json", h.OpenAPI)
+
+	authAPI := r.Group("/api/auth", h.Audit)
+	{
+		authAPI.GET("/status", h.AuthStatus)
+		authAPI.POST("/setup", h.Setup)
+		authAPI.POST("/login", h.Login)
+		authAPI.POST("/logout", h.Logout)
+	}
+
+	// Every other /api route needs a session or an API token (see
+	// handler.tokenRoutes for what tokens may call) and accepts ?host=<name> to
+	// pick the libvirt host. Mutating requests are recorded in the audit log.
+	// Host-level objects are admin-only; VM routes check the caller's role
+	// and ownership of the VM.
+	admin := h.RequireRole(auth.RoleAdmin)
+	operator := h.RequireRole(auth.RoleOperator)
+	viewVM := h.VMAccess(auth.RoleViewer)
+	operateVM := h.VMAccess(auth.RoleOperator)
+	api := r.Group("/api", h.Audit, h.Authenticate, h.SelectHost)
 	{
+		// Users (everyone may change their own password)
+		api.GET("/users", admin, h.ListUsers)
+		api.POST("/users", admin, h.CreateUser)
+		api.PUT("/users/:user", admin, h.UpdateUser)
+		api.DELETE("/users/:user", admin, h.DeleteUser)
+		api.PUT("/users/:user/password", h.SetPassword)
+
+		// API tokens of the caller (admins: ?all=true, revoke any)
+		api.GET("/tokens", h.ListTokens)
+		api.POST("/tokens", h.CreateToken)
+		api.DELETE("/tokens/:id", h.RevokeToken)
+
+		// Audit log, filtered by ?actor=, ?kind=, ?object=, ?since=, ?until=
+		api.GET("/audit", admin, h.ListAudit)
+		api.GET("/audit/export", admin, h.ExportAudit)
+
+		// Alerts: active and resolved alerts, rules and notification channels
+		api.GET("/alerts", admin, h.ListAlerts)
+		api.GET("/alerts/history", admin, h.AlertHistory)
+		api.GET("/alerts/rules", admin, h.ListAlertRules)
+		api.POST("/alerts/rules", admin, h.CreateAlertRule)
+		api.PUT("/alerts/rules/:id", admin, h.UpdateAlertRule)
+		api.DELETE("/alerts/rules/:id", admin, h.DeleteAlertRule)
+		api.GET("/alerts/channels", admin, h.ListAlertChannels)
+		api.POST("/alerts/channels", admin, h.CreateAlertChannel)
+		api.PUT("/alerts/channels/:id", admin, h.UpdateAlertChannel)
+		api.DELETE("/alerts/channels/:id", admin, h.DeleteAlertChannel)
+		api.POST("/alerts/channels/:id/test", admin, h.TestAlertChannel)
+
+		// Webhooks: VM lifecycle events posted to external endpoints
+		api.GET("/webhooks", admin, h.ListWebhooks)
+		api.POST("/webhooks", admin, h.CreateWebhook)
+		api.PUT("/webhooks/:id", admin, h.UpdateWebhook)
+		api.DELETE("/webhooks/:id", admin, h.DeleteWebhook)
+		api.GET("/webhooks/:id/deliveries", admin, h.ListWebhookDeliveries)
+		api.POST("/webhooks/:id/test", admin, h.TestWebhook)
+
+		// Hosts
+		api.GET("/hosts", h.ListHosts)
+		api.POST("/hosts", admin, h.AddHost)
+		api.DELETE("/hosts/:host", admin, h.DeleteHost)
+		api.POST("/hosts/:host/check", admin, h.CheckHost)
+		api.GET("/hosts/overview", h.HostsOverview)
+		api.GET("/hosts/vms", h.ListAllVMs)
+
+		// Tasks (create, clone, flatten, create-from-template, revert-to-new, OVA import and ISO upload return a task_id)
+		api.GET("/tasks", h.ListTasks)
+		api.GET("/tasks/:id", h.GetTask)
+		api.POST("/tasks/:id/cancel", operator, h.CancelTask)
+
+		// Lifecycle events (SSE), filtered by ?host=, ?kind= and ?name=
+		api.GET("/events", h.Events)
+
 		api.GET("/host/info", h.GetHostInfo)
 		api.GET("/host/nics", h.ListPhysicalNICs)
+		api.GET("/host/stats", h.GetHostStats)
+		api.GET("/settings", h.GetSettings)
 
 		// VM CRUD + actions
 		api.GET("/vms", h.ListVMs)
-		api.GET("/vms/:name", h.GetVM)
-		api.GET("/vms/:name/detail", h.GetVMDetail)
-		api.POST("/vms", h.CreateVM)
-		api.PUT("/vms/:name", h.UpdateVM)
-		api.DELETE("/vms/:name", h.DeleteVM)
-		api.POST("/vms/:name/start", h.StartVM)
-		api.POST("/vms/:name/shutdown", h.ShutdownVM)
-		api.POST("/vms/:name/destroy", h.DestroyVM)
-		api.POST("/vms/:name/reboot", h.RebootVM)
-		api.POST("/vms/:name/suspend", h.SuspendVM)
-		api.POST("/vms/:name/resume", h.ResumeVM)
-		api.POST("/vms/:name/clone", h.CloneVM)
-		api.GET("/vms/:name/autostart", h.GetAutostart)
-		api.PUT("/vms/:name/autostart", h.SetAutostart)
-		api.POST("/vms/:name/rename", h.RenameVM)
-		api.POST("/vms/import", h.ImportVM)
-		api.POST("/vms/batch", h.BatchAction)
+		api.GET("/vms/:name", viewVM, h.GetVM)
+		api.GET("/vms/:name/detail", viewVM, h.GetVMDetail)
+		api.GET("/vms/:name/stats", viewVM, h.GetVMStats)
+		api.POST("/vms", operator, h.CreateVM)
+		api.PUT("/vms/:name", operateVM, h.UpdateVM)
+		api.GET("/vms/:name/xml", viewVM, h.GetVMXML)
+		api.PUT("/vms/:name/xml", admin, h.UpdateVMXML)
+		api.PUT("/vms/:name/owner", admin, h.SetVMOwner)
+		api.DELETE("/vms/:name", operateVM, h.DeleteVM)
+		api.POST("/vms/:name/start", operateVM, h.StartVM)
+		api.POST("/vms/:name/shutdown", operateVM, h.ShutdownVM)
+		api.POST("/vms/:name/destroy", operateVM, h.DestroyVM)
+		api.POST("/vms/:name/reboot", operateVM, h.RebootVM)
+		api.POST("/vms/:name/suspend", operateVM, h.SuspendVM)
+		api.POST("/vms/:name/resume", operateVM, h.ResumeVM)
+		api.POST("/vms/:name/clone", operateVM, h.CloneVM)
+		api.POST("/vms/:name/flatten", operateVM, h.FlattenVM)
+		api.GET("/vms/:name/autostart", viewVM, h.GetAutostart)
+		api.PUT("/vms/:name/autostart", operateVM, h.SetAutostart)
+		api.POST("/vms/:name/rename", operateVM, h.RenameVM)
+		api.PUT("/vms/:name/template", operateVM, h.SetTemplate)
+		api.POST("/vms/import", operator, h.ImportVM)
+		api.GET("/vms/:name/export", operateVM, h.ExportVM)
+		api.POST("/vms/import-ova", operator, h.ImportOVA)
+		api.POST("/vms/batch", operator, h.BatchAction)
+
+		// Templates: shut-off VMs kept read-only as a base for new VMs
+		api.GET("/templates", h.ListTemplates)
+		api.POST("/templates/:name/vms", operateVM, h.CreateFromTemplate)
 
 		// VM devices
-		api.POST("/vms/:name/disks", h.AttachDisk)
-		api.DELETE("/vms/:name/disks/:target", h.DetachDisk)
-		api.POST("/vms/:name/nics", h.AttachNIC)
-		api.DELETE("/vms/:name/nics/:mac", h.DetachNIC)
-		api.POST("/vms/:name/iso", h.AttachISO)
-		api.DELETE("/vms/:name/iso", h.DetachISO)
-		api.POST("/vms/:name/finish-install", h.FinishInstall)
+		api.POST("/vms/:name/disks", operateVM, h.AttachDisk)
+		api.DELETE("/vms/:name/disks/:target", operateVM, h.DetachDisk)
+		api.POST("/vms/:name/nics", operateVM, h.AttachNIC)
+		api.DELETE("/vms/:name/nics/:mac", operateVM, h.DetachNIC)
+		api.POST("/vms/:name/iso", operateVM, h.AttachISO)
+		api.DELETE("/vms/:name/iso", operateVM, h.DetachISO)
+		api.POST("/vms/:name/finish-install", operateVM, h.FinishInstall)
 
 		// VNC
-		api.GET("/vms/:name/vnc", h.GetVNCPort)
+		api.GET("/vms/:name/vnc", operateVM, h.GetVNCPort)
 
 		// Snapshots
-		api.GET("/vms/:name/snapshots", h.ListSnapshots)
-		api.POST("/vms/:name/snapshots", h.CreateSnapshot)
-		api.DELETE("/vms/:name/snapshots/:snap", h.DeleteSnapshot)
-		api.POST("/vms/:name/snapshots/:snap/revert", h.RevertSnapshot)
-		api.POST("/vms/:name/snapshots/:snap/revert-to-new", h.RevertSnapshotToNew)
+		api.GET("/vms/:name/snapshots", viewVM, h.ListSnapshots)
+		api.POST("/vms/:name/snapshots", operateVM, h.CreateSnapshot)
+		api.DELETE("/vms/:name/snapshots/:snap", operateVM, h.DeleteSnapshot)
+		api.POST("/vms/:name/snapshots/:snap/revert", operateVM, h.RevertSnapshot)
+		api.POST("/vms/:name/snapshots/:snap/revert-to-new", operateVM, h.RevertSnapshotToNew)
 
 		// Networks
 		api.GET("/networks", h.ListNetworks)
-		api.POST("/networks", h.CreateNetwork)
-		api.POST("/networks/:name/start", h.StartNetwork)
-		api.POST("/networks/:name/stop", h.StopNetwork)
-		api.DELETE("/networks/:name", h.DeleteNetwork)
+		api.POST("/networks", admin, h.CreateNetwork)
+		api.POST("/networks/:name/start", admin, h.StartNetwork)
+		api.POST("/networks/:name/stop", admin, h.StopNetwork)
+		api.DELETE("/networks/:name", admin, h.DeleteNetwork)
 		api.GET("/networks/:name/leases", h.ListDHCPLeases)
 
 		// Storage pools
 		api.GET("/storage-pools", h.ListStoragePools)
-		api.POST("/storage-pools", h.CreateStoragePool)
-		api.POST("/storage-pools/:name/start", h.StartStoragePool)
-		api.POST("/storage-pools/:name/stop", h.StopStoragePool)
-		api.DELETE("/storage-pools/:name", h.DeleteStoragePool)
+		api.POST("/storage-pools", admin, h.CreateStoragePool)
+		api.POST("/storage-pools/:name/start", admin, h.StartStoragePool)
+		api.POST("/storage-pools/:name/stop", admin, h.StopStoragePool)
+		api.DELETE("/storage-pools/:name", admin, h.DeleteStoragePool)
 
 		// Storage volumes
 		api.GET("/storage-pools/:name/volumes", h.ListVolumes)
-		api.POST("/storage-volumes", h.CreateVolume)
-		api.DELETE("/storage-pools/:name/volumes/:vol", h.DeleteVolume)
+		api.POST("/storage-volumes", admin, h.CreateVolume)
+		api.DELETE("/storage-pools/:name/volumes/:vol", admin, h.DeleteVolume)
 
 		// ISO
 		api.GET("/isos", h.ListISOs)
-		api.POST("/isos/upload", h.UploadISO)
-		api.DELETE("/isos/:name", h.DeleteISO)
+		api.POST("/isos/upload", admin, h.UploadISO)
+		api.DELETE("/isos/:name", admin, h.DeleteISO)
 
 		// Bridges
 		api.GET("/bridges", h.ListBridges)
-		api.POST("/bridges", h.CreateBridge)
-		api.DELETE("/bridges/:name", h.DeleteBridge)
+		api.POST("/bridges", admin, h.CreateBridge)
+		api.DELETE("/bridges/:name", admin, h.DeleteBridge)
 
 		// Port forwards
 		api.GET("/port-forwards", h.ListPortForwards)
-		api.POST("/port-forwards", h.AddPortForward)
-		api.DELETE("/port-forwards/:id", h.DeletePortForward)
+		api.POST("/port-forwards", admin, h.AddPortForward)
+		api.DELETE("/port-forwards/:id", admin, h.DeletePortForward)
 	}
 
 	// Restore saved port forward rules
 	svc.RestorePortForwards()
 
-	r.GET("/ws/vnc/:name", h.VNCWebSocket)
+	r.GET("/ws/vnc/:name", h.Authenticate, h.SelectHost, operateVM, h.VNCWebSocket)
+	r.GET("/ws/console/:name", h.Authenticate, h.SelectHost, operateVM, h.ConsoleWebSocket)
+	r.GET("/ws/events", h.Authenticate, h.EventsWebSocket)
+
+	// Prometheus scrape endpoint (admin session, or a metrics:read token,
+	// which only admins can issue)
+	r.GET("/metrics", h.Authenticate, admin, h.Metrics)
+
+	missing, stale := handler.UndocumentedRoutes(r.Routes())
+	for _, route := range missing {
+		log.Printf("路由缺少接口文档: %s", route)
+	}
+	for _, route := range stale {
+		log.Printf("接口文档中的路由未注册: %s", route)
+	}
 
-	log.Println("后端启动在 :8080")
-	r.Run(":8080")
+	log.Printf("后端启动在 %s", cfg.Listen)
+	r.Run(cfg.Listen)
 }
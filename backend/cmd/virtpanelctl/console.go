@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/term"
+)
+
+// escapeKey detaches from the console (Ctrl-], as in telnet and virsh).
+const escapeKey = 0x1d
+
+func init() {
+	register(&command{name: "console", args: "VM", help: "attach to the serial console of a running VM (Ctrl-] detaches)", nargs: 1, run: console})
+}
+
+func consoleURL(server, name, host string) (string, error) {
+	u, err := url.Parse(strings.TrimRight(server, "/"))
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("server URL %q: want http or https", server)
+	}
+	u.Path += "/ws/console/" + url.PathEscape(name)
+	if host != "" {
+		u.RawQuery = url.Values{"host": {host}}.Encode()
+	}
+	return u.String(), nil
+}
+
+func console(ctx context.Context, a *app, args []string) error {
+	if _, err := a.client(); err != nil {
+		return err
+	}
+	wsURL, err := consoleURL(a.server, args[0], a.host)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Authorization": {"Bearer " + a.token}}
+	ws, resp, err := websocket.DefaultDialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		if resp != nil {
+			// The server refuses with a JSON error before upgrading.
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			var e struct {
+				Error string `json:"error"`
+			}
+			if json.Unmarshal(body, &e) != nil || e.Error == "" {
+				e.Error = string(bytes.TrimSpace(body))
+			}
+			return fmt.Errorf("%s: %s", resp.Status, e.Error)
+		}
+		return err
+	}
+	defer ws.Close()
+
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		old, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, old)
+	}
+	fmt.Fprintf(os.Stderr, "Connected to %s (Ctrl-] to detach)\r\n", args[0])
+
+	done := make(chan error, 2)
+	go func() {
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				done <- nil
+				return
+			}
+			if _, err := os.Stdout.Write(msg); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				done <- nil
+				return
+			}
+			in := buf[:n]
+			i := bytes.IndexByte(in, escapeKey)
+			if i >= 0 {
+				in = in[:i]
+			}
+			if len(in) > 0 {
+				if err := ws.WriteMessage(websocket.BinaryMessage, in); err != nil {
+					done <- err
+					return
+				}
+			}
+			if i >= 0 {
+				done <- nil
+				return
+			}
+		}
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+	}
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	fmt.Fprint(os.Stderr, "\r\nDisconnected\r\n")
+	return err
+}
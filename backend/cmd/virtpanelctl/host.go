@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"virtpanel/client"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	var netReq client.CreateNetworkRequest
+	var poolPath string
+	var volSize int
+	var volFormat string
+	var noWait bool
+	var pf client.PortForward
+
+	register(
+		&command{name: "net list", help: "list virtual networks", run: netList},
+		&command{name: "net create", args: "NAME", help: "create a NAT network", nargs: 1,
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&netReq.Bridge, "bridge", "", "bridge device (default: generated)")
+				fs.StringVar(&netReq.Subnet, "subnet", "", "gateway address, e.g. 192.168.100.1")
+				fs.StringVar(&netReq.Netmask, "netmask", "255.255.255.0", "netmask")
+				fs.StringVar(&netReq.DHCPStart, "dhcp-start", "", "first DHCP address")
+				fs.StringVar(&netReq.DHCPEnd, "dhcp-end", "", "last DHCP address")
+			},
+			run: func(ctx context.Context, a *app, args []string) error {
+				netReq.Name = args[0]
+				return hostOp(ctx, a, args[0], func(c *client.Client) (*client.Message, error) {
+					return c.CreateNetwork(ctx, &netReq)
+				})
+			}},
+		nameOp("net start", "start a network", (*client.Client).StartNetwork),
+		nameOp("net stop", "stop a network", (*client.Client).StopNetwork),
+		nameOp("net delete", "delete a network", (*client.Client).DeleteNetwork),
+
+		&command{name: "pool list", help: "list storage pools", run: poolList},
+		&command{name: "pool create", args: "NAME", help: "create a directory storage pool", nargs: 1,
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&poolPath, "path", "", "directory on the host (required)")
+			},
+			run: func(ctx context.Context, a *app, args []string) error {
+				if poolPath == "" {
+					return fmt.Errorf("-path is required")
+				}
+				return hostOp(ctx, a, args[0], func(c *client.Client) (*client.Message, error) {
+					return c.CreateStoragePool(ctx, &client.CreateStoragePoolRequest{Name: args[0], Path: poolPath})
+				})
+			}},
+		nameOp("pool start", "start a storage pool", (*client.Client).StartStoragePool),
+		nameOp("pool stop", "stop a storage pool", (*client.Client).StopStoragePool),
+		nameOp("pool delete", "delete a storage pool", (*client.Client).DeleteStoragePool),
+
+		&command{name: "vol list", args: "POOL", help: "list the volumes of a pool", nargs: 1, run: volList},
+		&command{name: "vol create", args: "POOL NAME", help: "create a volume", nargs: 2,
+			flags: func(fs *flag.FlagSet) {
+				fs.IntVar(&volSize, "size", 10, "capacity in GB")
+				fs.StringVar(&volFormat, "format", "qcow2", "qcow2 or raw")
+			},
+			run: func(ctx context.Context, a *app, args []string) error {
+				return hostOp(ctx, a, args[0]+"/"+args[1], func(c *client.Client) (*client.Message, error) {
+					return c.CreateVolume(ctx, &client.CreateVolumeRequest{Pool: args[0], Name: args[1], Capacity: volSize, Format: volFormat})
+				})
+			}},
+		&command{name: "vol delete", args: "POOL NAME", help: "delete a volume", nargs: 2,
+			run: func(ctx context.Context, a *app, args []string) error {
+				return hostOp(ctx, a, args[0]+"/"+args[1], func(c *client.Client) (*client.Message, error) {
+					return c.DeleteVolume(ctx, args[0], args[1])
+				})
+			}},
+
+		&command{name: "iso list", help: "list uploaded ISO images", run: isoList},
+		&command{name: "iso upload", args: "FILE", help: "upload an ISO image", nargs: 1,
+			flags: func(fs *flag.FlagSet) {
+				fs.BoolVar(&noWait, "no-wait", false, "return once the upload is stored, before it is checked")
+			},
+			run: func(ctx context.Context, a *app, args []string) error {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				return runTask(ctx, a, noWait, func(c *client.Client) (*client.TaskAccepted, error) {
+					return c.UploadISO(ctx, filepath.Base(args[0]), f)
+				})
+			}},
+		nameOp("iso delete", "delete an ISO image", (*client.Client).DeleteISO),
+
+		&command{name: "pf list", help: "list port forwards", run: pfList},
+		&command{name: "pf add", help: "forward a host port to a VM", nargs: 0,
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&pf.Protocol, "proto", "tcp", "tcp or udp")
+				fs.IntVar(&pf.HostPort, "host-port", 0, "host port (required)")
+				fs.IntVar(&pf.HostPortEnd, "host-port-end", 0, "last host port of a range")
+				fs.StringVar(&pf.VMIP, "vm-ip", "", "VM address (required)")
+				fs.IntVar(&pf.VMPort, "vm-port", 0, "VM port (default: the host port)")
+				fs.StringVar(&pf.Comment, "comment", "", "comment")
+			},
+			run: func(ctx context.Context, a *app, _ []string) error {
+				if pf.HostPort == 0 || pf.VMIP == "" {
+					return fmt.Errorf("-host-port and -vm-ip are required")
+				}
+				if pf.VMPort == 0 {
+					pf.VMPort = pf.HostPort
+				}
+				return hostOp(ctx, a, strconv.Itoa(pf.HostPort)+"/"+pf.Protocol, func(c *client.Client) (*client.Message, error) {
+					return c.AddPortForward(ctx, &pf)
+				})
+			}},
+		&command{name: "pf rm", args: "ID", help: "remove a port forward", nargs: 1,
+			run: func(ctx context.Context, a *app, args []string) error {
+				return hostOp(ctx, a, args[0], func(c *client.Client) (*client.Message, error) {
+					return c.DeletePortForward(ctx, args[0])
+				})
+			}},
+
+		&command{name: "config show", help: "show the effective settings", run: configShow},
+		&command{name: "config set", help: "save -server, -token, -host and -o to the config file", run: configSet},
+	)
+}
+
+// nameOp is a command that calls op with one name argument.
+func nameOp(name, help string, op func(*client.Client, context.Context, string) (*client.Message, error)) *command {
+	return &command{name: name, args: "NAME", help: help, nargs: 1,
+		run: func(ctx context.Context, a *app, args []string) error {
+			return hostOp(ctx, a, args[0], func(c *client.Client) (*client.Message, error) {
+				return op(c, ctx, args[0])
+			})
+		}}
+}
+
+func hostOp(ctx context.Context, a *app, subject string, op func(*client.Client) (*client.Message, error)) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	res, err := op(c)
+	if err != nil {
+		return err
+	}
+	return a.message(subject, res, res.Message)
+}
+
+func netList(ctx context.Context, a *app, _ []string) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	nets, err := c.ListNetworks(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(nets, func() *table {
+		t := &table{header: []string{"NAME", "ACTIVE", "FORWARD", "BRIDGE", "SUBNET"}}
+		for _, n := range nets {
+			t.add(n.Name, n.Active, orDash(n.Forward), orDash(n.Bridge), orDash(n.Subnet))
+		}
+		return t
+	})
+}
+
+func poolList(ctx context.Context, a *app, _ []string) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	pools, err := c.ListStoragePools(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(pools, func() *table {
+		t := &table{header: []string{"NAME", "ACTIVE", "TYPE", "PATH", "CAPACITY", "AVAILABLE"}}
+		for _, p := range pools {
+			t.add(p.Name, p.Active, p.Type, orDash(p.Path), fmt.Sprintf("%d GB", p.Capacity), fmt.Sprintf("%d GB", p.Available))
+		}
+		return t
+	})
+}
+
+func volList(ctx context.Context, a *app, args []string) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	vols, err := c.ListVolumes(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return a.print(vols, func() *table {
+		t := &table{header: []string{"NAME", "TYPE", "CAPACITY", "PATH"}}
+		for _, v := range vols {
+			t.add(v.Name, v.Type, fmt.Sprintf("%d GB", v.Capacity), v.Path)
+		}
+		return t
+	})
+}
+
+func isoList(ctx context.Context, a *app, _ []string) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	isos, err := c.ListISOs(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(isos, func() *table {
+		t := &table{header: []string{"NAME", "SIZE", "PATH"}}
+		for _, iso := range isos {
+			t.add(iso.Name, fmt.Sprintf("%.1f MB", float64(iso.Size)/(1<<20)), iso.Path)
+		}
+		return t
+	})
+}
+
+func pfList(ctx context.Context, a *app, _ []string) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	rules, err := c.ListPortForwards(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(rules, func() *table {
+		t := &table{header: []string{"ID", "PROTO", "HOST PORT", "VM", "COMMENT"}}
+		for _, r := range rules {
+			hostPort := strconv.Itoa(r.HostPort)
+			if r.HostPortEnd > 0 {
+				hostPort += "-" + strconv.Itoa(r.HostPortEnd)
+			}
+			t.add(r.ID, r.Protocol, hostPort, r.VMIP+":"+strconv.Itoa(r.VMPort), r.Comment)
+		}
+		return t
+	})
+}
+
+func maskToken(tok string) string {
+	if len(tok) <= 8 {
+		return orDash(tok)
+	}
+	return tok[:8] + "..."
+}
+
+func configShow(_ context.Context, a *app, _ []string) error {
+	cfg := ctlConfig{Server: a.server, Token: maskToken(a.token), Host: a.host, Output: a.output}
+	return a.print(struct {
+		File string `json:"file"`
+		ctlConfig
+	}{a.configFile, cfg}, func() *table {
+		t := &table{}
+		t.add("Config file:", a.configFile)
+		t.add("Server:", orDash(cfg.Server))
+		t.add("Token:", cfg.Token)
+		t.add("Host:", orDash(cfg.Host))
+		t.add("Output:", cfg.Output)
+		return t
+	})
+}
+
+// configSet writes the global flags given on the command line into the
+// config file, keeping the settings not given.
+func configSet(_ context.Context, a *app, _ []string) error {
+	cfg, err := loadConfig(a.configFile)
+	if err != nil {
+		return err
+	}
+	set := 0
+	for name, dst := range map[string]*string{"server": &cfg.Server, "token": &cfg.Token, "host": &cfg.Host, "o": &cfg.Output} {
+		if v, ok := a.given[name]; ok {
+			*dst = v
+			set++
+		}
+	}
+	if set == 0 {
+		return fmt.Errorf("nothing to set; give -server, -token, -host or -o")
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.configFile), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(a.configFile, data, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintln(a.out, "saved", a.configFile)
+	return nil
+}
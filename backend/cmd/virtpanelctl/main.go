@@ -0,0 +1,256 @@
+// Command virtpanelctl drives a VirtPanel server through its REST API.
+//
+//	virtpanelctl config set -server http://panel:8080 -token vpt_...
+//	virtpanelctl vm list
+//	virtpanelctl vm create web1 -cpu 2 -memory 2048 -disk 20
+//	virtpanelctl -o yaml snapshot list web1
+//	virtpanelctl console web1
+//
+// The server URL, API token, libvirt host and output format come from the
+// flags, then VIRTPANEL_SERVER / VIRTPANEL_TOKEN / VIRTPANEL_HOST, then
+// the config file (~/.config/virtpanel/ctl.yaml, or VIRTPANEL_CONFIG).
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"virtpanel/client"
+
+	"gopkg.in/yaml.v3"
+)
+
+type command struct {
+	name  string // "vm list"
+	args  string // synopsis of the positional arguments
+	help  string
+	flags func(fs *flag.FlagSet) // command flags, optional
+	run   func(ctx context.Context, a *app, args []string) error
+	nargs int // minimum positional arguments
+}
+
+var commands []*command
+
+func register(cmds ...*command) { commands = append(commands, cmds...) }
+
+// ctlConfig is the config file.
+type ctlConfig struct {
+	Server string `yaml:"server"`
+	Token  string `yaml:"token"`
+	Host   string `yaml:"host,omitempty"`
+	Output string `yaml:"output,omitempty"` // table, json, yaml
+}
+
+// app holds the global flags and the resolved settings.
+type app struct {
+	configFile string
+	server     string
+	token      string
+	host       string
+	output     string
+	given      map[string]string // global flags set on the command line
+	fs         *flag.FlagSet
+	out        io.Writer
+}
+
+func (a *app) globalFlags(fs *flag.FlagSet) {
+	fs.StringVar(&a.configFile, "config", "", "config file")
+	fs.StringVar(&a.server, "server", "", "panel URL, e.g. http://panel:8080")
+	fs.StringVar(&a.token, "token", "", "API token")
+	fs.StringVar(&a.host, "host", "", "libvirt host (default: the panel's default host)")
+	fs.StringVar(&a.output, "o", "", "output format: table, json or yaml")
+}
+
+func defaultConfigFile() string {
+	if f := os.Getenv("VIRTPANEL_CONFIG"); f != "" {
+		return f
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "ctl.yaml"
+	}
+	return filepath.Join(dir, "virtpanel", "ctl.yaml")
+}
+
+func loadConfig(file string) (ctlConfig, error) {
+	var cfg ctlConfig
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// resolve fills the settings not given as flags from the environment and
+// the config file.
+func (a *app) resolve() error {
+	if a.configFile == "" {
+		a.configFile = defaultConfigFile()
+	}
+	cfg, err := loadConfig(a.configFile)
+	if err != nil {
+		return err
+	}
+	pick := func(flagVal, env, fileVal string) string {
+		if flagVal != "" {
+			return flagVal
+		}
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+		return fileVal
+	}
+	a.server = pick(a.server, "VIRTPANEL_SERVER", cfg.Server)
+	a.token = pick(a.token, "VIRTPANEL_TOKEN", cfg.Token)
+	a.host = pick(a.host, "VIRTPANEL_HOST", cfg.Host)
+	a.output = pick(a.output, "VIRTPANEL_OUTPUT", cfg.Output)
+	switch a.output {
+	case "":
+		a.output = "table"
+	case "table", "json", "yaml":
+	default:
+		return fmt.Errorf("unknown output format %q (table, json, yaml)", a.output)
+	}
+	return nil
+}
+
+func (a *app) client() (*client.Client, error) {
+	if a.server == "" {
+		return nil, errors.New("no server configured; run virtpanelctl config set -server URL -token TOKEN")
+	}
+	if a.token == "" {
+		return nil, errors.New("no API token configured; create one with POST /api/tokens and run virtpanelctl config set -token TOKEN")
+	}
+	c := client.New(a.server, a.token)
+	c.Host = a.host
+	return c, nil
+}
+
+// parseInterspersed parses flags given before, between and after the
+// positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return pos, nil
+		}
+		if args[0] == "--" {
+			return append(pos, args[1:]...), nil
+		}
+		pos = append(pos, args[0])
+		args = args[1:]
+	}
+}
+
+// find returns the command named by the leading words of args.
+func find(args []string) (*command, []string) {
+	for _, c := range commands {
+		words := strings.Fields(c.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == c.name {
+			return c, args[len(words):]
+		}
+	}
+	return nil, args
+}
+
+func usage(w io.Writer, prefix string) {
+	fmt.Fprintln(w, "Usage: virtpanelctl [-server URL] [-token TOKEN] [-host HOST] [-o table|json|yaml] COMMAND [ARGS]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	names := make([]*command, 0, len(commands))
+	for _, c := range commands {
+		if strings.HasPrefix(c.name, prefix) {
+			names = append(names, c)
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool { return strings.Fields(names[i].name)[0] < strings.Fields(names[j].name)[0] })
+	for _, c := range names {
+		fmt.Fprintf(w, "  %-40s %s\n", strings.TrimSpace(c.name+" "+c.args), c.help)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run virtpanelctl COMMAND -h for the flags of a command.")
+}
+
+func main() {
+	a := &app{given: map[string]string{}, out: os.Stdout}
+	global := flag.NewFlagSet("virtpanelctl", flag.ContinueOnError)
+	global.Usage = func() { usage(os.Stderr, "") }
+	a.globalFlags(global)
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	global.Visit(func(f *flag.Flag) { a.given[f.Name] = f.Value.String() })
+	cmd, rest := find(global.Args())
+	if cmd == nil {
+		prefix := ""
+		if global.NArg() > 0 {
+			prefix = global.Arg(0)
+		}
+		usage(os.Stderr, prefix)
+		os.Exit(2)
+	}
+
+	// Global flags may also follow the command; registering them again
+	// resets them, so keep the ones given before it.
+	saved := *a
+	fs := flag.NewFlagSet("virtpanelctl "+cmd.name, flag.ContinueOnError)
+	a.globalFlags(fs)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: virtpanelctl %s [flags] %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	a.fs = fs
+	args, err := parseInterspersed(fs, rest)
+	if err != nil {
+		os.Exit(2)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if global.Lookup(f.Name) != nil {
+			a.given[f.Name] = f.Value.String()
+		}
+	})
+	for _, f := range [][2]*string{
+		{&a.configFile, &saved.configFile}, {&a.server, &saved.server}, {&a.token, &saved.token},
+		{&a.host, &saved.host}, {&a.output, &saved.output},
+	} {
+		if *f[0] == "" {
+			*f[0] = *f[1]
+		}
+	}
+	if len(args) < cmd.nargs {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := a.resolve(); err != nil {
+		fmt.Fprintln(os.Stderr, "virtpanelctl:", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := cmd.run(ctx, a, args); err != nil {
+		fmt.Fprintln(os.Stderr, "virtpanelctl:", err)
+		os.Exit(1)
+	}
+}
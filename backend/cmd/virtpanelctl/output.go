@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// table is the -o table rendering of a result.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func (t *table) add(cols ...any) {
+	row := make([]string, len(cols))
+	for i, c := range cols {
+		row[i] = fmt.Sprint(c)
+	}
+	t.rows = append(t.rows, row)
+}
+
+// print writes v in the selected format; tab builds the table view.
+func (a *app) print(v any, tab func() *table) error {
+	switch a.output {
+	case "json":
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(a.out, "%s\n", data)
+		return err
+	case "yaml":
+		return a.printYAML(v)
+	}
+	t := tab()
+	w := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	if len(t.header) > 0 {
+		fmt.Fprintln(w, strings.Join(t.header, "\t"))
+	}
+	for _, row := range t.rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// printYAML renders v with its JSON field names and order: the JSON is
+// read back as a YAML node tree and written in block style.
+func (a *app) printYAML(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	var block func(n *yaml.Node)
+	block = func(n *yaml.Node) {
+		n.Style &^= yaml.FlowStyle | yaml.DoubleQuotedStyle
+		for _, c := range n.Content {
+			block(c)
+		}
+	}
+	block(&doc)
+	enc := yaml.NewEncoder(a.out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// message prints the reply of an action: the text in table mode.
+func (a *app) message(subject string, v any, msg string) error {
+	return a.print(v, func() *table {
+		t := &table{}
+		t.add(subject + ": " + msg)
+		return t
+	})
+}
+
+func unixTime(sec int64) string {
+	if sec == 0 {
+		return "-"
+	}
+	return time.Unix(sec, 0).Format("2006-01-02 15:04:05")
+}
+
+// byteRate formats bytes per second with a binary unit.
+func byteRate(v float64) string {
+	units := []string{"B/s", "KiB/s", "MiB/s", "GiB/s"}
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", v, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
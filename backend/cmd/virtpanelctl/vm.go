@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"virtpanel/client"
+)
+
+func init() {
+	var force bool
+	var create client.CreateVMRequest
+	var snapDesc string
+	var noWait bool
+	var clone client.CloneVMRequest
+	var ova client.ImportOVAOptions
+
+	register(
+		&command{name: "vm list", help: "list virtual machines", run: vmList},
+		&command{name: "vm show", args: "NAME", help: "show a virtual machine", nargs: 1, run: vmShow},
+		&command{name: "vm start", args: "NAME...", help: "start virtual machines", nargs: 1,
+			run: func(ctx context.Context, a *app, args []string) error {
+				return eachVM(ctx, a, args, "started", (*client.Client).StartVM)
+			}},
+		&command{name: "vm stop", args: "NAME...", help: "shut down virtual machines (-force powers them off)", nargs: 1,
+			flags: func(fs *flag.FlagSet) {
+				fs.BoolVar(&force, "force", false, "power off instead of a guest shutdown")
+			},
+			run: func(ctx context.Context, a *app, args []string) error {
+				op := (*client.Client).ShutdownVM
+				if force {
+					op = (*client.Client).DestroyVM
+				}
+				return eachVM(ctx, a, args, "stopped", op)
+			}},
+		&command{name: "vm create", args: "NAME", help: "create a virtual machine", nargs: 1,
+			flags: func(fs *flag.FlagSet) {
+				fs.IntVar(&create.CPU, "cpu", 1, "vCPUs")
+				fs.IntVar(&create.Memory, "memory", 1024, "memory in MB")
+				fs.IntVar(&create.Disk, "disk", 20, "disk size in GB")
+				fs.StringVar(&create.OSType, "os-type", "linux", "linux or windows")
+				fs.StringVar(&create.ISO, "iso", "", "installation ISO path")
+				fs.StringVar(&create.NetMode, "net-mode", "", "nat, bridge or macvtap")
+				fs.StringVar(&create.BridgeName, "bridge", "", "bridge for -net-mode bridge")
+				fs.StringVar(&create.MacvtapDev, "macvtap-dev", "", "host NIC for -net-mode macvtap")
+				fs.BoolVar(&noWait, "no-wait", false, "return once the task is queued")
+			},
+			run: func(ctx context.Context, a *app, args []string) error {
+				create.Name = args[0]
+				return runTask(ctx, a, noWait, func(c *client.Client) (*client.TaskAccepted, error) {
+					return c.CreateVM(ctx, &create)
+				})
+			}},
+		&command{name: "vm clone", args: "SOURCE NEW", help: "clone a stopped virtual machine", nargs: 2,
+			flags: func(fs *flag.FlagSet) {
+				fs.BoolVar(&noWait, "no-wait", false, "return once the task is queued")
+				fs.BoolVar(&clone.Linked, "linked", false, "overlay the disks of SOURCE, which must be a template, instead of copying them")
+				fs.StringVar(&clone.Pool, "pool", "", "storage pool for the copied disks (default: that of each source disk)")
+				fs.StringVar(&clone.Format, "format", "", "qcow2 or raw (default: that of each source disk)")
+			},
+			run: func(ctx context.Context, a *app, args []string) error {
+				return runTask(ctx, a, noWait, func(c *client.Client) (*client.TaskAccepted, error) {
+					clone.NewName = args[1]
+					return c.CloneVM(ctx, args[0], &clone)
+				})
+			}},
+		&command{name: "vm flatten", args: "NAME", help: "copy the template data into a stopped linked clone", nargs: 1,
+			flags: func(fs *flag.FlagSet) {
+				fs.BoolVar(&noWait, "no-wait", false, "return once the task is queued")
+			},
+			run: func(ctx context.Context, a *app, args []string) error {
+				return runTask(ctx, a, noWait, func(c *client.Client) (*client.TaskAccepted, error) {
+					return c.FlattenVM(ctx, args[0])
+				})
+			}},
+		&command{name: "vm export", args: "NAME FILE", help: "export a stopped virtual machine as an OVA", nargs: 2,
+			run: func(ctx context.Context, a *app, args []string) error {
+				return vmExport(ctx, a, args[0], args[1])
+			}},
+		&command{name: "vm import-ova", args: "FILE NAME", help: "create a virtual machine from an OVA", nargs: 2,
+			flags: func(fs *flag.FlagSet) {
+				fs.BoolVar(&noWait, "no-wait", false, "return once the upload is stored, before it is converted")
+				fs.StringVar(&ova.Pool, "pool", "", "directory storage pool for the disks (default: that of image_dir)")
+				fs.StringVar(&ova.Format, "format", "", "qcow2 (default) or raw")
+				fs.StringVar(&ova.DiskBus, "disk-bus", "", "virtio, sata, scsi or ide (default: ide stays ide, others become sata)")
+				fs.StringVar(&ova.NetModel, "net-model", "", "virtio, e1000 or rtl8139 (default: virtio stays virtio, others become e1000)")
+			},
+			run: func(ctx context.Context, a *app, args []string) error {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				return runTask(ctx, a, noWait, func(c *client.Client) (*client.TaskAccepted, error) {
+					ova.Name = args[1]
+					return c.ImportOVA(ctx, filepath.Base(args[0]), f, &ova)
+				})
+			}},
+		&command{name: "vm rename", args: "NAME NEW", help: "rename a stopped virtual machine", nargs: 2,
+			run: func(ctx context.Context, a *app, args []string) error {
+				c, err := a.client()
+				if err != nil {
+					return err
+				}
+				res, err := c.RenameVM(ctx, args[0], &client.RenameVMRequest{NewName: args[1]})
+				if err != nil {
+					return err
+				}
+				return a.message(args[0], res, res.Message)
+			}},
+		&command{name: "vm delete", args: "NAME...", help: "delete virtual machines and their disks", nargs: 1,
+			run: func(ctx context.Context, a *app, args []string) error {
+				return eachVM(ctx, a, args, "deleted", (*client.Client).DeleteVM)
+			}},
+
+		&command{name: "snapshot list", args: "VM", help: "list the snapshots of a virtual machine", nargs: 1, run: snapshotList},
+		&command{name: "snapshot create", args: "VM SNAPSHOT", help: "take a snapshot", nargs: 2,
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&snapDesc, "description", "", "snapshot description")
+			},
+			run: func(ctx context.Context, a *app, args []string) error {
+				c, err := a.client()
+				if err != nil {
+					return err
+				}
+				res, err := c.CreateSnapshot(ctx, args[0], &client.CreateSnapshotRequest{Name: args[1], Description: snapDesc})
+				if err != nil {
+					return err
+				}
+				return a.message(args[0]+"/"+args[1], res, res.Message)
+			}},
+		&command{name: "snapshot revert", args: "VM SNAPSHOT", help: "revert a virtual machine to a snapshot", nargs: 2,
+			run: func(ctx context.Context, a *app, args []string) error {
+				return snapshotOp(ctx, a, args, (*client.Client).RevertSnapshot)
+			}},
+		&command{name: "snapshot delete", args: "VM SNAPSHOT", help: "delete a snapshot", nargs: 2,
+			run: func(ctx context.Context, a *app, args []string) error {
+				return snapshotOp(ctx, a, args, (*client.Client).DeleteSnapshot)
+			}},
+
+		&command{name: "task list", help: "list background tasks", run: taskList},
+		&command{name: "task wait", args: "ID", help: "wait for a task to finish", nargs: 1,
+			run: func(ctx context.Context, a *app, args []string) error {
+				c, err := a.client()
+				if err != nil {
+					return err
+				}
+				return waitTask(ctx, a, c, args[0])
+			}},
+	)
+}
+
+func vmList(ctx context.Context, a *app, _ []string) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	vms, err := c.ListVMs(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(vms, func() *table {
+		t := &table{header: []string{"NAME", "STATE", "CPU", "MEMORY", "CPU%", "DISK R/W", "IOPS", "NET RX/TX", "OWNER"}}
+		for _, vm := range vms {
+			t.add(vm.Name, vm.State, vm.CPU, fmt.Sprintf("%d MB", vm.Memory), fmt.Sprintf("%.1f", vm.CPUUsage),
+				byteRate(vm.DiskRead)+" / "+byteRate(vm.DiskWrite), fmt.Sprintf("%.0f", vm.DiskIOPS),
+				byteRate(vm.NetRx)+" / "+byteRate(vm.NetTx), orDash(vm.Owner))
+		}
+		return t
+	})
+}
+
+func vmShow(ctx context.Context, a *app, args []string) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	d, err := c.GetVMDetail(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return a.print(d, func() *table {
+		t := &table{}
+		t.add("Name:", d.Name)
+		t.add("UUID:", d.UUID)
+		t.add("State:", d.State)
+		t.add("CPU:", d.CPU)
+		t.add("Memory:", fmt.Sprintf("%d MB", d.Memory))
+		for _, disk := range d.Disks {
+			t.add("Disk:", disk.Target+" "+orDash(disk.Source))
+			if io := disk.IO; io != nil {
+				t.add("", fmt.Sprintf("read %s %.0f IOPS %.2f ms, write %s %.0f IOPS %.2f ms",
+					byteRate(io.ReadBytes), io.ReadIOPS, io.ReadLatency, byteRate(io.WriteBytes), io.WriteIOPS, io.WriteLatency))
+			}
+		}
+		for _, nic := range d.NICs {
+			t.add("NIC:", nic.MAC+" "+nic.Type+" "+orDash(nic.Source))
+			if io := nic.IO; io != nil {
+				t.add("", fmt.Sprintf("rx %s %.0f pps, tx %s %.0f pps, errors %.0f/%.0f, drops %.0f/%.0f",
+					byteRate(io.RxBytes), io.RxPackets, byteRate(io.TxBytes), io.TxPackets, io.RxErrors, io.TxErrors, io.RxDrops, io.TxDrops))
+			}
+		}
+		return t
+	})
+}
+
+// eachVM applies op to every named VM and reports each result; it fails
+// if any of them failed.
+func eachVM(ctx context.Context, a *app, names []string, done string,
+	op func(*client.Client, context.Context, string) (*client.Message, error)) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, name := range names {
+		res, err := op(c, ctx, name)
+		if err != nil {
+			fmt.Fprintf(a.fs.Output(), "%s: %v\n", name, err)
+			failed++
+			continue
+		}
+		if err := a.message(name, res, done); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d failed", failed, len(names))
+	}
+	return nil
+}
+
+func snapshotList(ctx context.Context, a *app, args []string) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	snaps, err := c.ListSnapshots(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return a.print(snaps, func() *table {
+		t := &table{header: []string{"NAME", "STATE", "CREATED", "CURRENT", "DESCRIPTION"}}
+		for _, s := range snaps {
+			cur := ""
+			if s.IsCurrent {
+				cur = "*"
+			}
+			t.add(s.Name, s.State, unixTime(s.CreatedAt), cur, s.Description)
+		}
+		return t
+	})
+}
+
+func snapshotOp(ctx context.Context, a *app, args []string,
+	op func(*client.Client, context.Context, string, string) (*client.Message, error)) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	res, err := op(c, ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return a.message(args[0]+"/"+args[1], res, res.Message)
+}
+
+func taskList(ctx context.Context, a *app, _ []string) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	tasks, err := c.ListTasks(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(tasks, func() *table {
+		t := &table{header: []string{"ID", "KIND", "TARGET", "STATE", "PROGRESS", "CREATED", "ERROR"}}
+		for _, task := range tasks {
+			t.add(task.ID, task.Kind, task.Target, task.State, fmt.Sprintf("%.0f%%", task.Progress), unixTime(task.CreatedAt), task.Error)
+		}
+		return t
+	})
+}
+
+// runTask starts a background task and, unless noWait, follows it until
+// it finishes.
+// vmExport downloads the OVA of a VM into file, removing it again if
+// the download fails.
+func vmExport(ctx context.Context, a *app, name, file string) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	body, err := c.ExportVM(ctx, name, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file)
+		return err
+	}
+	return a.message(name, &client.Message{Message: "exported to " + file}, "exported to "+file)
+}
+
+func runTask(ctx context.Context, a *app, noWait bool, start func(*client.Client) (*client.TaskAccepted, error)) error {
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	acc, err := start(c)
+	if err != nil {
+		return err
+	}
+	if noWait {
+		return a.message("task "+acc.TaskID, acc, acc.Message)
+	}
+	return waitTask(ctx, a, c, acc.TaskID)
+}
+
+func waitTask(ctx context.Context, a *app, c *client.Client, id string) error {
+	task, err := c.WaitTask(ctx, id)
+	if task != nil {
+		if perr := a.message("task "+task.ID, task, task.State); perr != nil {
+			return perr
+		}
+	}
+	return err
+}
@@ -0,0 +1,551 @@
+// Package alert evaluates threshold rules against the hosts, VMs and
+// storage pools and notifies channels when an alert fires or resolves.
+//
+// Rules, channels, firing alerts and the recent history are kept in
+// DataDir/alerts.json, so a restart neither loses the configuration nor
+// notifies again about alerts that were already firing.
+package alert
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"virtpanel/internal/event"
+	"virtpanel/internal/model"
+	"virtpanel/internal/service"
+)
+
+const (
+	evalInterval = 30 * time.Second
+	maxHistory   = 500
+)
+
+var (
+	ErrRuleNotFound    = errors.New("alert rule not found")
+	ErrChannelNotFound = errors.New("notification channel not found")
+	ErrInvalidRule     = errors.New("invalid alert rule")
+	ErrInvalidChannel  = errors.New("invalid notification channel")
+	ErrChannelInUse    = errors.New("notification channel is in use")
+)
+
+// Metrics a rule can watch. VM and pool values are per object; host_disk
+// is per mount of the panel's own host.
+const (
+	MetricVMCPU         = "vm_cpu"         // percent of the VM's vCPUs
+	MetricVMMemory      = "vm_memory"      // percent of guest memory in use (balloon driver)
+	MetricVMState       = "vm_state"       // VM is in State, e.g. crashed
+	MetricHostCPU       = "host_cpu"       // percent
+	MetricHostMemory    = "host_memory"    // percent
+	MetricHostDisk      = "host_disk"      // percent used per mount
+	MetricHostDown      = "host_down"      // host failed its health check
+	MetricPoolAvailable = "pool_available" // GB free in an active storage pool
+)
+
+var metrics = []string{MetricVMCPU, MetricVMMemory, MetricVMState, MetricHostCPU, MetricHostMemory,
+	MetricHostDisk, MetricHostDown, MetricPoolAvailable}
+
+// Rule fires an alert for every object whose value compares to Threshold
+// with Op for at least For seconds. vm_state and host_down ignore Op and
+// Threshold.
+type Rule struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Metric    string   `json:"metric"`
+	Op        string   `json:"op,omitempty"` // >, >=, <, <=
+	Threshold float64  `json:"threshold,omitempty"`
+	State     string   `json:"state,omitempty"`  // vm_state: the state to alert on, default crashed
+	For       int64    `json:"for"`              // seconds
+	Host      string   `json:"host,omitempty"`   // only this host; empty for all
+	Target    string   `json:"target,omitempty"` // VM, pool or mount name; glob patterns allowed; empty for all
+	Severity  string   `json:"severity"`         // warning or critical
+	Channels  []string `json:"channels"`         // channel IDs; empty notifies every channel
+	Disabled  bool     `json:"disabled,omitempty"`
+}
+
+// Alert is one rule matching one object. Pending alerts have not held
+// for the rule's For yet and are not notified.
+type Alert struct {
+	Rule       string  `json:"rule"` // rule ID
+	RuleName   string  `json:"rule_name"`
+	Metric     string  `json:"metric"`
+	Severity   string  `json:"severity"`
+	Host       string  `json:"host"`
+	Object     string  `json:"object,omitempty"` // VM, pool or mount; empty for the host itself
+	State      string  `json:"state"`            // pending, firing or resolved
+	Value      float64 `json:"value"`
+	Summary    string  `json:"summary"`
+	Since      int64   `json:"since"` // condition first seen, unix seconds
+	FiredAt    int64   `json:"fired_at,omitempty"`
+	ResolvedAt int64   `json:"resolved_at,omitempty"`
+}
+
+func (a *Alert) key() string { return a.Rule + "|" + a.Host + "|" + a.Object }
+
+// Notification is what channels deliver.
+type Notification struct {
+	Status string `json:"status"` // firing or resolved
+	Alert  Alert  `json:"alert"`
+	Time   int64  `json:"time"`
+}
+
+type saved struct {
+	Rules    []Rule    `json:"rules"`
+	Channels []Channel `json:"channels"`
+	Firing   []Alert   `json:"firing"`
+	History  []Alert   `json:"history"`
+}
+
+// Manager owns the rules and channels and runs the evaluation.
+type Manager struct {
+	file     string
+	dataDir  string
+	hosts    *service.HostManager
+	events   *event.Bus
+	mu       sync.Mutex
+	rules    []Rule
+	channels []Channel
+	active   map[string]*Alert // by key
+	history  []Alert           // oldest first
+	cpu      map[string]cpuSample
+	stopCh   chan struct{}
+}
+
+type cpuSample struct {
+	time uint64
+	ts   time.Time
+}
+
+// New loads the alert configuration from dataDir. On first start the
+// example rules are created, without channels.
+func New(dataDir string, hosts *service.HostManager, events *event.Bus) (*Manager, error) {
+	m := &Manager{
+		file:    filepath.Join(dataDir, "alerts.json"),
+		dataDir: dataDir,
+		hosts:   hosts,
+		events:  events,
+		active:  make(map[string]*Alert),
+		cpu:     make(map[string]cpuSample),
+		stopCh:  make(chan struct{}),
+	}
+	data, err := os.ReadFile(m.file)
+	switch {
+	case err == nil:
+		var s saved
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+		m.rules, m.channels, m.history = s.Rules, s.Channels, s.History
+		for i := range s.Firing {
+			m.active[s.Firing[i].key()] = &s.Firing[i]
+		}
+	case os.IsNotExist(err):
+		m.rules = defaultRules()
+		if err := m.saveLocked(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	return m, nil
+}
+
+func defaultRules() []Rule {
+	return []Rule{
+		{ID: randomID(), Name: "VM CPU high", Metric: MetricVMCPU, Op: ">", Threshold: 90, For: 600, Severity: "warning", Channels: []string{}},
+		{ID: randomID(), Name: "Host disk almost full", Metric: MetricHostDisk, Op: ">", Threshold: 85, Severity: "warning", Channels: []string{}},
+		{ID: randomID(), Name: "Storage pool low", Metric: MetricPoolAvailable, Op: "<", Threshold: 20, Severity: "warning", Channels: []string{}},
+		{ID: randomID(), Name: "VM crashed", Metric: MetricVMState, State: "crashed", Severity: "critical", Channels: []string{}},
+	}
+}
+
+// Start begins evaluating the rules and watching for crashes.
+func (m *Manager) Start() {
+	go m.loop()
+}
+
+func (m *Manager) Close() { close(m.stopCh) }
+
+func (m *Manager) loop() {
+	sub := m.events.Subscribe(event.Filter{Kinds: map[string]bool{"domain": true}})
+	defer sub.Close()
+	tick := time.NewTicker(evalInterval)
+	defer tick.Stop()
+	m.evaluate(time.Now())
+	for {
+		select {
+		case now := <-tick.C:
+			m.evaluate(now)
+		case e := <-sub.C():
+			m.domainEvent(e)
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
+// saveLocked writes the configuration and alert state. Caller must hold
+// m.mu.
+func (m *Manager) saveLocked() error {
+	s := saved{Rules: m.rules, Channels: m.channels, Firing: []Alert{}, History: m.history}
+	for _, a := range m.active {
+		if a.State == "firing" {
+			s.Firing = append(s.Firing, *a)
+		}
+	}
+	sort.Slice(s.Firing, func(i, j int) bool { return s.Firing[i].key() < s.Firing[j].key() })
+	data, _ := json.MarshalIndent(s, "", "  ")
+	os.MkdirAll(filepath.Dir(m.file), 0755)
+	tmp := m.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.file)
+}
+
+func (m *Manager) save() {
+	if err := m.saveLocked(); err != nil {
+		log.Printf("保存告警配置失败: %v", err)
+	}
+}
+
+// Rules returns every rule.
+func (m *Manager) Rules() []Rule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Rule{}, m.rules...)
+}
+
+// AddRule validates r and stores it under a new ID.
+func (m *Manager) AddRule(r Rule) (Rule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.ID = randomID()
+	if err := m.checkRule(&r); err != nil {
+		return Rule{}, err
+	}
+	m.rules = append(m.rules, r)
+	if err := m.saveLocked(); err != nil {
+		m.rules = m.rules[:len(m.rules)-1]
+		return Rule{}, err
+	}
+	return r, nil
+}
+
+// UpdateRule replaces the rule with id. Its alerts are re-evaluated from
+// scratch, resolving the firing ones.
+func (m *Manager) UpdateRule(id string, r Rule) (Rule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.rules, func(r Rule) bool { return r.ID == id })
+	if i < 0 {
+		return Rule{}, ErrRuleNotFound
+	}
+	r.ID = id
+	if err := m.checkRule(&r); err != nil {
+		return Rule{}, err
+	}
+	m.rules[i] = r
+	m.dropAlertsLocked(id, time.Now())
+	m.save()
+	return r, nil
+}
+
+func (m *Manager) DeleteRule(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.rules, func(r Rule) bool { return r.ID == id })
+	if i < 0 {
+		return ErrRuleNotFound
+	}
+	m.dropAlertsLocked(id, time.Now())
+	m.rules = slices.Delete(m.rules, i, i+1)
+	m.save()
+	return nil
+}
+
+// dropAlertsLocked resolves the alerts of a changed or deleted rule.
+func (m *Manager) dropAlertsLocked(rule string, now time.Time) {
+	for k, a := range m.active {
+		if a.Rule == rule {
+			m.resolveLocked(k, a, now)
+		}
+	}
+}
+
+func (m *Manager) checkRule(r *Rule) error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" || utf8.RuneCountInString(r.Name) > 64 {
+		return fmt.Errorf("%w: name must be 1-64 characters", ErrInvalidRule)
+	}
+	if !slices.Contains(metrics, r.Metric) {
+		return fmt.Errorf("%w: metric must be one of %s", ErrInvalidRule, strings.Join(metrics, ", "))
+	}
+	switch r.Metric {
+	case MetricVMState:
+		if r.State == "" {
+			r.State = "crashed"
+		}
+		if !slices.Contains(vmStates, r.State) {
+			return fmt.Errorf("%w: state must be one of %s", ErrInvalidRule, strings.Join(vmStates, ", "))
+		}
+		r.Op, r.Threshold = "", 0
+	case MetricHostDown:
+		r.Op, r.Threshold, r.State = "", 0, ""
+	default:
+		if r.Op == "" {
+			r.Op = ">"
+			if r.Metric == MetricPoolAvailable {
+				r.Op = "<"
+			}
+		}
+		if !slices.Contains([]string{">", ">=", "<", "<="}, r.Op) {
+			return fmt.Errorf("%w: op must be >, >=, < or <=", ErrInvalidRule)
+		}
+		r.State = ""
+	}
+	if r.For < 0 {
+		return fmt.Errorf("%w: for must not be negative", ErrInvalidRule)
+	}
+	if _, err := path.Match(r.Target, ""); err != nil {
+		return fmt.Errorf("%w: target: %v", ErrInvalidRule, err)
+	}
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	if r.Severity != "warning" && r.Severity != "critical" {
+		return fmt.Errorf("%w: severity must be warning or critical", ErrInvalidRule)
+	}
+	if r.Channels == nil {
+		r.Channels = []string{}
+	}
+	for _, id := range r.Channels {
+		if !slices.ContainsFunc(m.channels, func(c Channel) bool { return c.ID == id }) {
+			return fmt.Errorf("%w: unknown channel %s", ErrInvalidRule, id)
+		}
+	}
+	return nil
+}
+
+var vmStates = []string{"crashed", "paused", "shutoff", "blocked", "pmsuspended"}
+
+// Active returns the pending and firing alerts, firing and newest first.
+func (m *Manager) Active() []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Alert, 0, len(m.active))
+	for _, a := range m.active {
+		list = append(list, *a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].State == "firing") != (list[j].State == "firing") {
+			return list[i].State == "firing"
+		}
+		return list[i].Since > list[j].Since
+	})
+	return list
+}
+
+// History returns up to limit resolved alerts, newest first.
+func (m *Manager) History(limit int) []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Alert, 0, min(limit, len(m.history)))
+	for i := len(m.history) - 1; i >= 0 && len(list) < limit; i-- {
+		list = append(list, m.history[i])
+	}
+	return list
+}
+
+// observation is the value of one rule's metric for one object.
+type observation struct {
+	host, object string
+	value        float64
+	match        bool // the rule's condition holds
+	clear        bool // a firing alert may resolve
+}
+
+// evaluate checks every enabled rule against fresh host, VM and pool
+// values.
+func (m *Manager) evaluate(now time.Time) {
+	snap := m.sample(now)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for _, r := range m.rules {
+		if r.Disabled {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, o := range snap.observe(r) {
+			if (r.Host != "" && o.host != r.Host) || !targetMatch(r.Target, o.object) {
+				continue
+			}
+			a := &Alert{Rule: r.ID, Host: o.host, Object: o.object}
+			k := a.key()
+			seen[k] = true
+			cur := m.active[k]
+			switch {
+			case o.match && cur == nil:
+				a.RuleName, a.Metric, a.Severity = r.Name, r.Metric, r.Severity
+				a.State, a.Since, a.Value = "pending", now.Unix(), o.value
+				a.Summary = summary(r, o)
+				m.active[k] = a
+				cur = a
+			case o.match:
+				cur.Value, cur.Summary = o.value, summary(r, o)
+			case cur != nil && (o.clear || cur.State == "pending"):
+				cur.Value = o.value
+				changed = changed || cur.State == "firing"
+				m.resolveLocked(k, cur, now)
+				continue
+			default:
+				continue
+			}
+			if cur.State == "pending" && now.Unix()-cur.Since >= r.For {
+				m.fireLocked(r, cur, now)
+				changed = true
+			}
+		}
+		// Objects that are gone (VM stopped or deleted, pool removed)
+		// resolve their alerts, unless their host is merely unreachable
+		for k, a := range m.active {
+			if a.Rule == r.ID && !seen[k] && !snap.unreachable[a.Host] {
+				changed = changed || a.State == "firing"
+				m.resolveLocked(k, a, now)
+			}
+		}
+	}
+	if changed {
+		m.save()
+	}
+}
+
+// domainEvent fires vm_state rules right away, so a VM that crashed and
+// was destroyed by libvirt before the next evaluation is still reported.
+// The alert resolves once the VM runs again or is gone.
+func (m *Manager) domainEvent(e model.Event) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.rules {
+		if r.Disabled || r.Metric != MetricVMState || (e.Type != r.State && e.Detail != r.State) {
+			continue
+		}
+		if (r.Host != "" && e.Host != r.Host) || !targetMatch(r.Target, e.Name) {
+			continue
+		}
+		o := observation{host: e.Host, object: e.Name, value: 1, match: true}
+		a := &Alert{Rule: r.ID, RuleName: r.Name, Metric: r.Metric, Severity: r.Severity, Host: e.Host, Object: e.Name,
+			Value: 1, Summary: summary(r, o), Since: now.Unix()}
+		if cur := m.active[a.key()]; cur != nil {
+			if cur.State == "firing" {
+				continue
+			}
+			a = cur
+		}
+		m.active[a.key()] = a
+		m.fireLocked(r, a, now)
+		m.save()
+	}
+}
+
+func targetMatch(pattern, object string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, object)
+	return ok
+}
+
+func (m *Manager) fireLocked(r Rule, a *Alert, now time.Time) {
+	a.State, a.FiredAt = "firing", now.Unix()
+	log.Printf("告警触发: %s", a.Summary)
+	m.notifyLocked(r.Channels, Notification{Status: "firing", Alert: *a, Time: now.Unix()})
+}
+
+// resolveLocked ends an alert. Only alerts that fired are notified and
+// kept in the history.
+func (m *Manager) resolveLocked(k string, a *Alert, now time.Time) {
+	delete(m.active, k)
+	if a.State != "firing" {
+		return
+	}
+	a.State, a.ResolvedAt = "resolved", now.Unix()
+	log.Printf("告警恢复: %s", a.Summary)
+	m.history = append(m.history, *a)
+	if n := len(m.history) - maxHistory; n > 0 {
+		m.history = slices.Delete(m.history, 0, n)
+	}
+	var channels []string
+	if i := slices.IndexFunc(m.rules, func(r Rule) bool { return r.ID == a.Rule }); i >= 0 {
+		channels = m.rules[i].Channels
+	}
+	m.notifyLocked(channels, Notification{Status: "resolved", Alert: *a, Time: now.Unix()})
+}
+
+// notifyLocked delivers n in the background to the given channels, or to
+// every channel when ids is empty.
+func (m *Manager) notifyLocked(ids []string, n Notification) {
+	for _, ch := range m.channels {
+		if len(ids) > 0 && !slices.Contains(ids, ch.ID) {
+			continue
+		}
+		go func(ch Channel) {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := ch.send(ctx, m.dataDir, n); err != nil {
+				log.Printf("发送告警通知失败 %s (%s): %v", ch.Name, ch.Type, err)
+			}
+		}(ch)
+	}
+}
+
+// summary describes the alert in one line, e.g. "vm_cpu of web1 on
+// local is 95.3 (> 90)".
+func summary(r Rule, o observation) string {
+	subject := "host " + o.host
+	if o.object != "" {
+		subject = o.object + " on " + o.host
+	}
+	switch r.Metric {
+	case MetricVMState:
+		return fmt.Sprintf("%s: VM %s is %s", r.Name, subject, r.State)
+	case MetricHostDown:
+		return fmt.Sprintf("%s: host %s is unreachable", r.Name, o.host)
+	}
+	return fmt.Sprintf("%s: %s of %s is %.1f (%s %g)", r.Name, r.Metric, subject, o.value, r.Op, r.Threshold)
+}
+
+func compare(v float64, op string, threshold float64) bool {
+	switch op {
+	case ">":
+		return v > threshold
+	case ">=":
+		return v >= threshold
+	case "<":
+		return v < threshold
+	case "<=":
+		return v <= threshold
+	}
+	return false
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
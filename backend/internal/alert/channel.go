@@ -0,0 +1,279 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const notifyTimeout = 10 * time.Second
+
+// Channel is where notifications go. Which fields apply depends on Type.
+type Channel struct {
+	ID   string   `json:"id"`
+	Name string   `json:"name"`
+	Type string   `json:"type"`           // webhook, smtp or file
+	URL  string   `json:"url,omitempty"`  // webhook: receives the Notification as a JSON POST
+	SMTP string   `json:"smtp,omitempty"` // smtp: relay address, default localhost:25; no authentication
+	From string   `json:"from,omitempty"` // smtp
+	To   []string `json:"to,omitempty"`   // smtp
+	Path string   `json:"path,omitempty"` // file: JSON lines are appended; relative to the data directory
+}
+
+// notifier delivers one notification; each channel type has one.
+type notifier interface {
+	notify(ctx context.Context, n Notification) error
+}
+
+// channelTypes builds the notifier of a channel, validating its fields.
+var channelTypes = map[string]func(c *Channel, dataDir string) (notifier, error){
+	"webhook": newWebhook,
+	"smtp":    newSMTP,
+	"file":    newFileSink,
+}
+
+func channelTypeList() string {
+	types := make([]string, 0, len(channelTypes))
+	for t := range channelTypes {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	return strings.Join(types, ", ")
+}
+
+func (c *Channel) notifier(dataDir string) (notifier, error) {
+	newFn, ok := channelTypes[c.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: type must be one of %s", ErrInvalidChannel, channelTypeList())
+	}
+	return newFn(c, dataDir)
+}
+
+func (c Channel) send(ctx context.Context, dataDir string, n Notification) error {
+	nt, err := c.notifier(dataDir)
+	if err != nil {
+		return err
+	}
+	return nt.notify(ctx, n)
+}
+
+type webhook struct{ url string }
+
+func newWebhook(c *Channel, _ string) (notifier, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an http or https URL", ErrInvalidChannel)
+	}
+	c.SMTP, c.From, c.To, c.Path = "", "", nil, ""
+	return webhook{url: c.URL}, nil
+}
+
+func (w webhook) notify(ctx context.Context, n Notification) error {
+	body, _ := json.Marshal(n)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "virtpanel-alerts")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+type smtpRelay struct {
+	addr, from string
+	to         []string
+}
+
+func newSMTP(c *Channel, _ string) (notifier, error) {
+	if c.SMTP == "" {
+		c.SMTP = "localhost:25"
+	}
+	if _, _, err := net.SplitHostPort(c.SMTP); err != nil {
+		return nil, fmt.Errorf("%w: smtp must be host:port", ErrInvalidChannel)
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return nil, fmt.Errorf("%w: invalid from address", ErrInvalidChannel)
+	}
+	if len(c.To) == 0 {
+		return nil, fmt.Errorf("%w: to needs at least one address", ErrInvalidChannel)
+	}
+	for _, to := range c.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return nil, fmt.Errorf("%w: invalid to address %q", ErrInvalidChannel, to)
+		}
+	}
+	c.URL, c.Path = "", ""
+	return smtpRelay{addr: c.SMTP, from: c.From, to: c.To}, nil
+}
+
+func (s smtpRelay) notify(ctx context.Context, n Notification) error {
+	subject := fmt.Sprintf("[%s] %s", strings.ToUpper(n.Status), n.Alert.Summary)
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", s.from, strings.Join(s.to, ", "),
+		mimeHeader(subject), time.Unix(n.Time, 0).Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	a := n.Alert
+	fmt.Fprintf(&msg, "%s\r\n\r\nStatus: %s\r\nSeverity: %s\r\nRule: %s\r\nHost: %s\r\n", a.Summary, n.Status, a.Severity, a.RuleName, a.Host)
+	if a.Object != "" {
+		fmt.Fprintf(&msg, "Object: %s\r\n", a.Object)
+	}
+	fmt.Fprintf(&msg, "Value: %g\r\nSince: %s\r\n", a.Value, time.Unix(a.Since, 0).Format(time.RFC3339))
+
+	// net/smtp has no context; run it so the timeout still applies
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.addr, nil, s.from, s.to, msg.Bytes()) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// mimeHeader encodes non-ASCII subjects (e.g. Chinese rule names).
+func mimeHeader(s string) string {
+	for _, r := range s {
+		if r >= utf8.RuneSelf {
+			return "=?utf-8?b?" + base64.StdEncoding.EncodeToString([]byte(s)) + "?="
+		}
+	}
+	return s
+}
+
+type fileSink struct{ path string }
+
+func newFileSink(c *Channel, dataDir string) (notifier, error) {
+	if c.Path == "" || strings.HasSuffix(c.Path, "/") {
+		return nil, fmt.Errorf("%w: path must name a file", ErrInvalidChannel)
+	}
+	c.URL, c.SMTP, c.From, c.To = "", "", "", nil
+	p := c.Path
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dataDir, p)
+	}
+	return fileSink{path: filepath.Clean(p)}, nil
+}
+
+func (f fileSink) notify(_ context.Context, n Notification) error {
+	line, _ := json.Marshal(n)
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Channels returns every channel.
+func (m *Manager) Channels() []Channel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Channel{}, m.channels...)
+}
+
+// AddChannel validates c and stores it under a new ID.
+func (m *Manager) AddChannel(c Channel) (Channel, error) {
+	c.ID = randomID()
+	if err := m.checkChannel(&c); err != nil {
+		return Channel{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.channels = append(m.channels, c)
+	if err := m.saveLocked(); err != nil {
+		m.channels = m.channels[:len(m.channels)-1]
+		return Channel{}, err
+	}
+	return c, nil
+}
+
+func (m *Manager) UpdateChannel(id string, c Channel) (Channel, error) {
+	c.ID = id
+	if err := m.checkChannel(&c); err != nil {
+		return Channel{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.channels, func(c Channel) bool { return c.ID == id })
+	if i < 0 {
+		return Channel{}, ErrChannelNotFound
+	}
+	m.channels[i] = c
+	m.save()
+	return c, nil
+}
+
+// DeleteChannel removes a channel no rule names.
+func (m *Manager) DeleteChannel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.channels, func(c Channel) bool { return c.ID == id })
+	if i < 0 {
+		return ErrChannelNotFound
+	}
+	for _, r := range m.rules {
+		if slices.Contains(r.Channels, id) {
+			return fmt.Errorf("%w: used by rule %q", ErrChannelInUse, r.Name)
+		}
+	}
+	m.channels = slices.Delete(m.channels, i, i+1)
+	m.save()
+	return nil
+}
+
+// TestChannel sends a made-up firing notification and waits for the
+// result.
+func (m *Manager) TestChannel(ctx context.Context, id string) error {
+	m.mu.Lock()
+	i := slices.IndexFunc(m.channels, func(c Channel) bool { return c.ID == id })
+	var c Channel
+	if i >= 0 {
+		c = m.channels[i]
+	}
+	m.mu.Unlock()
+	if i < 0 {
+		return ErrChannelNotFound
+	}
+	now := time.Now().Unix()
+	n := Notification{Status: "firing", Time: now, Alert: Alert{
+		Rule: "test", RuleName: "Test notification", Metric: MetricHostCPU, Severity: "warning",
+		Host: "test", State: "firing", Summary: "Test notification from virtpanel", Since: now, FiredAt: now,
+	}}
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	return c.send(ctx, m.dataDir, n)
+}
+
+func (m *Manager) checkChannel(c *Channel) error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" || utf8.RuneCountInString(c.Name) > 64 {
+		return fmt.Errorf("%w: name must be 1-64 characters", ErrInvalidChannel)
+	}
+	_, err := c.notifier(m.dataDir)
+	return err
+}
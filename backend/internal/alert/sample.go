@@ -0,0 +1,134 @@
+package alert
+
+import (
+	"time"
+
+	"virtpanel/internal/model"
+)
+
+// snapshot is what one evaluation sees of every host.
+type snapshot struct {
+	hosts       []model.HostStats
+	pools       map[string][]model.StoragePool // by host
+	vmCPU       map[string]float64             // "host/vm" -> percent, running VMs with a previous sample
+	unreachable map[string]bool
+}
+
+// sample reads the hosts, VMs and pools. VM CPU usage is the change of
+// the CPU time since the previous evaluation.
+func (m *Manager) sample(now time.Time) *snapshot {
+	s := &snapshot{
+		hosts:       m.hosts.Stats(),
+		pools:       make(map[string][]model.StoragePool),
+		vmCPU:       make(map[string]float64),
+		unreachable: make(map[string]bool),
+	}
+	cpu := make(map[string]cpuSample)
+	for _, hs := range s.hosts {
+		host := hs.Host.Name
+		// A failed read must not resolve the host's alerts either
+		if !hs.Host.Connected || hs.Guests == nil {
+			s.unreachable[host] = true
+			continue
+		}
+		hv, err := m.hosts.Get(host)
+		if err == nil {
+			s.pools[host], err = hv.ListStoragePools()
+		}
+		if err != nil {
+			s.unreachable[host] = true
+			continue
+		}
+		for _, g := range hs.Guests {
+			if g.State != "running" {
+				continue
+			}
+			key := host + "/" + g.Name
+			cur := cpuSample{time: g.CPUTime, ts: now}
+			cpu[key] = cur
+			prev, ok := m.cpu[key]
+			dt := now.Sub(prev.ts).Seconds()
+			if !ok || cur.time < prev.time || dt <= 0 || g.VCPUs == 0 {
+				continue
+			}
+			s.vmCPU[key] = min(100, float64(cur.time-prev.time)/(dt*1e9*float64(g.VCPUs))*100)
+		}
+	}
+	// Only the evaluation loop samples, so m.cpu needs no lock
+	m.cpu = cpu
+	return s
+}
+
+// observe returns the values of r's metric for every object it applies
+// to.
+func (s *snapshot) observe(r Rule) []observation {
+	var obs []observation
+	num := func(host, object string, v float64) {
+		match := compare(v, r.Op, r.Threshold)
+		obs = append(obs, observation{host: host, object: object, value: v, match: match, clear: !match})
+	}
+	for _, hs := range s.hosts {
+		host := hs.Host.Name
+		if r.Metric == MetricHostDown {
+			down := !hs.Host.Connected
+			obs = append(obs, observation{host: host, value: b2f(down), match: down, clear: !down})
+			continue
+		}
+		if s.unreachable[host] {
+			continue
+		}
+		switch r.Metric {
+		case MetricHostCPU, MetricHostMemory, MetricHostDisk:
+			info := hs.Info
+			if info == nil {
+				continue
+			}
+			switch r.Metric {
+			case MetricHostCPU:
+				num(host, "", info.CPUUsage)
+			case MetricHostMemory:
+				if info.MemoryTotal > 0 {
+					num(host, "", float64(info.MemoryTotal-info.MemoryFree)/float64(info.MemoryTotal)*100)
+				}
+			case MetricHostDisk:
+				for _, d := range info.Disks {
+					num(host, d.Mount, float64(d.Percent))
+				}
+			}
+		case MetricPoolAvailable:
+			for _, p := range s.pools[host] {
+				if p.Active {
+					num(host, p.Name, float64(p.Available))
+				}
+			}
+		case MetricVMCPU:
+			for _, g := range hs.Guests {
+				if v, ok := s.vmCPU[host+"/"+g.Name]; ok {
+					num(host, g.Name, v)
+				}
+			}
+		case MetricVMMemory:
+			for _, g := range hs.Guests {
+				b := g.Balloon
+				if g.State == "running" && b.Available > 0 && b.Unused > 0 && b.Unused < b.Available {
+					num(host, g.Name, float64(b.Available-b.Unused)/float64(b.Available)*100)
+				}
+			}
+		case MetricVMState:
+			// A VM in the state fires; the alert lasts until it runs
+			// again or is deleted
+			for _, g := range hs.Guests {
+				in := g.State == r.State
+				obs = append(obs, observation{host: host, object: g.Name, value: b2f(in), match: in, clear: g.State == "running"})
+			}
+		}
+	}
+	return obs
+}
+
+func b2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+// Package audit records mutating API calls in an append-only JSON lines
+// file and answers queries over it.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Entry is one audited request.
+type Entry struct {
+	Time       int64           `json:"time"` // unix milliseconds
+	Actor      string          `json:"actor"`
+	Token      string          `json:"token,omitempty"` // ID of the API token used, if any
+	IP         string          `json:"ip"`
+	Method     string          `json:"method"`
+	Route      string          `json:"route"` // route pattern, e.g. /api/vms/:name/start
+	Path       string          `json:"path"`
+	Host       string          `json:"host,omitempty"`        // libvirt host the call went to
+	ObjectKind string          `json:"object_kind,omitempty"` // vms, networks, port-forwards, ...
+	Object     string          `json:"object,omitempty"`      // name or ID of the target
+	Body       json.RawMessage `json:"body,omitempty"`        // request body, secrets redacted
+	Status     int             `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+}
+
+// Filter selects entries. Zero fields match everything.
+type Filter struct {
+	Actor      string
+	ObjectKind string
+	Object     string
+	Since      int64 // unix milliseconds, inclusive
+	Until      int64 // unix milliseconds, exclusive
+}
+
+func (f Filter) match(e *Entry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.ObjectKind == "" || e.ObjectKind == f.ObjectKind) &&
+		(f.Object == "" || e.Object == f.Object) &&
+		(f.Since == 0 || e.Time >= f.Since) &&
+		(f.Until == 0 || e.Time < f.Until)
+}
+
+// Log appends entries to DataDir/audit.jsonl. Each entry is synced to
+// disk before Record returns.
+type Log struct {
+	file string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// Open opens (creating if needed) the audit log in dataDir.
+func Open(dataDir string) (*Log, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	file := filepath.Join(dataDir, "audit.jsonl")
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{file: file, f: f}, nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Record appends e to the log.
+func (l *Log) Record(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(line); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// Query returns up to limit matching entries, newest first. limit <= 0
+// means no limit.
+func (l *Log) Query(f Filter, limit int) ([]Entry, error) {
+	var list []Entry
+	err := l.scan(f, func(e Entry) error {
+		list = append(list, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// Export writes the matching entries to w as JSON lines, oldest first.
+func (l *Log) Export(w io.Writer, f Filter) error {
+	enc := json.NewEncoder(w)
+	return l.scan(f, func(e Entry) error { return enc.Encode(e) })
+}
+
+// scan calls fn for each matching entry in file order. Lines that fail to
+// decode (a torn write after a crash) are skipped.
+func (l *Log) scan(f Filter, fn func(Entry) error) error {
+	r, err := os.Open(l.file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		var e Entry
+		if json.Unmarshal(sc.Bytes(), &e) != nil || !f.match(&e) {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// secretKeys are JSON field names whose values are never written to the
+// log. A key matches when it contains one of them.
+var secretKeys = []string{"password", "passwd", "secret", "token", "private_key", "api_key", "user_data"}
+
+// Redact returns body with the values of secret-looking fields replaced.
+// Bodies that are not JSON are dropped.
+func Redact(body []byte) json.RawMessage {
+	var v any
+	if len(body) == 0 || json.Unmarshal(body, &v) != nil {
+		return nil
+	}
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func redactValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			if isSecret(k) {
+				t[k] = "***"
+			} else {
+				t[k] = redactValue(val)
+			}
+		}
+	case []any:
+		for i := range t {
+			t[i] = redactValue(t[i])
+		}
+	}
+	return v
+}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
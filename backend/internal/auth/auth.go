@@ -0,0 +1,415 @@
+// Package auth keeps the panel's local user accounts and login sessions.
+//
+// Accounts are stored in DataDir/users.json with bcrypt password hashes.
+// Sessions live in memory only, so restarting the panel logs everybody
+// out. Until the first account exists a one-time setup token, printed to
+// the log at startup, is needed to create it. Scripts use API tokens
+// instead, kept hashed in DataDir/tokens.json; see Token.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SessionTTL is how long a session stays valid without use.
+const SessionTTL = 12 * time.Hour
+
+const minPasswordLen = 8
+
+var (
+	ErrBadCredentials = errors.New("invalid username or password")
+	ErrSetupDone      = errors.New("setup already completed")
+	ErrBadSetupToken  = errors.New("invalid setup token")
+	ErrUserExists     = errors.New("user already exists")
+	ErrUserNotFound   = errors.New("user not found")
+	ErrLastUser       = errors.New("cannot delete the last user")
+	ErrLastAdmin      = errors.New("at least one admin must remain")
+	ErrInvalidName    = errors.New("invalid username")
+	ErrInvalidRole    = errors.New("role must be admin, operator or viewer")
+	ErrWeakPassword   = errors.New("password must be at least 8 characters")
+)
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+type User struct {
+	Name         string   `json:"name"`
+	PasswordHash string   `json:"password_hash"`
+	Role         Role     `json:"role"`
+	Groups       []string `json:"groups,omitempty"`
+	CreatedAt    int64    `json:"created_at"`
+}
+
+// UserInfo is what the API shows of an account.
+type UserInfo struct {
+	Name      string   `json:"name"`
+	Role      Role     `json:"role"`
+	Groups    []string `json:"groups"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+func (u *User) info() UserInfo {
+	return UserInfo{Name: u.Name, Role: u.Role, Groups: append([]string{}, u.Groups...), CreatedAt: u.CreatedAt}
+}
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// Manager holds the accounts and the active sessions.
+type Manager struct {
+	file       string
+	tokenFile  string
+	mu         sync.Mutex
+	users      map[string]*User
+	sessions   map[string]*session
+	tokens     map[string]*Token // by hash
+	setupToken string
+}
+
+// NewManager loads the accounts from dataDir. Without any account a
+// setup token is generated; see SetupToken.
+func NewManager(dataDir string) (*Manager, error) {
+	m := &Manager{
+		file:      filepath.Join(dataDir, "users.json"),
+		tokenFile: filepath.Join(dataDir, "tokens.json"),
+		users:     make(map[string]*User),
+		sessions:  make(map[string]*session),
+		tokens:    make(map[string]*Token),
+	}
+	data, err := os.ReadFile(m.file)
+	switch {
+	case err == nil:
+		var saved []User
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, err
+		}
+		for i := range saved {
+			// Accounts from before roles existed had full access
+			if saved[i].Role == "" {
+				saved[i].Role = RoleAdmin
+			}
+			m.users[saved[i].Name] = &saved[i]
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	if err := m.loadTokens(); err != nil {
+		return nil, err
+	}
+	if len(m.users) == 0 {
+		m.setupToken = randomHex(16)
+	}
+	return m, nil
+}
+
+// SetupToken returns the token needed to create the first account, or ""
+// once an account exists.
+func (m *Manager) SetupToken() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.setupToken
+}
+
+// NeedsSetup reports whether no account exists yet.
+func (m *Manager) NeedsSetup() bool {
+	return m.SetupToken() != ""
+}
+
+// Setup creates the first account, an admin. token must match
+// SetupToken.
+func (m *Manager) Setup(token, name, password string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.setupToken == "" {
+		return ErrSetupDone
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(m.setupToken)) != 1 {
+		return ErrBadSetupToken
+	}
+	if err := m.addLocked(name, password, RoleAdmin, nil); err != nil {
+		return err
+	}
+	m.setupToken = ""
+	return nil
+}
+
+// dummyHash is compared against when the user does not exist, so a login
+// takes as long for unknown names as for wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("virtpanel"), bcrypt.DefaultCost)
+
+// Login checks the password and starts a session, returning its token.
+func (m *Manager) Login(name, password string) (string, error) {
+	m.mu.Lock()
+	u, ok := m.users[name]
+	hash := dummyHash
+	if ok {
+		hash = []byte(u.PasswordHash)
+	}
+	m.mu.Unlock()
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !ok {
+		return "", ErrBadCredentials
+	}
+
+	token := randomHex(32)
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for t, s := range m.sessions {
+		if now.After(s.expires) {
+			delete(m.sessions, t)
+		}
+	}
+	m.sessions[token] = &session{user: name, expires: now.Add(SessionTTL)}
+	return token, nil
+}
+
+// Logout ends the session.
+func (m *Manager) Logout(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, token)
+}
+
+// Authenticate returns the user of a valid session and extends it. Use
+// Principal for the user's current role and groups.
+func (m *Manager) Authenticate(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[token]
+	if !ok {
+		return "", false
+	}
+	now := time.Now()
+	if now.After(s.expires) {
+		delete(m.sessions, token)
+		return "", false
+	}
+	s.expires = now.Add(SessionTTL)
+	return s.user, true
+}
+
+// Users lists the accounts by name.
+func (m *Manager) Users() []UserInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]UserInfo, 0, len(m.users))
+	for _, u := range m.users {
+		list = append(list, u.info())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Principal returns the account's current role and groups.
+func (m *Manager) Principal(name string) (Principal, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[name]
+	if !ok {
+		return Principal{}, false
+	}
+	return Principal{Name: u.Name, Role: u.Role, Groups: append([]string{}, u.Groups...)}, true
+}
+
+func (m *Manager) AddUser(name, password string, role Role, groups []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.addLocked(name, password, role, groups)
+}
+
+func (m *Manager) addLocked(name, password string, role Role, groups []string) error {
+	if !nameRe.MatchString(name) {
+		return ErrInvalidName
+	}
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+	groups, err := cleanGroups(groups)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.users[name]; ok {
+		return ErrUserExists
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	m.users[name] = &User{Name: name, PasswordHash: hash, Role: role, Groups: groups, CreatedAt: time.Now().Unix()}
+	if err := m.saveLocked(); err != nil {
+		delete(m.users, name)
+		return err
+	}
+	return nil
+}
+
+// DeleteUser removes the account, ends its sessions and revokes its API
+// tokens.
+func (m *Manager) DeleteUser(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[name]; !ok {
+		return ErrUserNotFound
+	}
+	if len(m.users) == 1 {
+		return ErrLastUser
+	}
+	if m.lastAdminLocked(name) {
+		return ErrLastAdmin
+	}
+	delete(m.users, name)
+	m.endSessionsLocked(name)
+	if err := m.saveLocked(); err != nil {
+		return err
+	}
+	return m.revokeUserTokensLocked(name)
+}
+
+// UpdateUser changes the role and groups of an account.
+func (m *Manager) UpdateUser(name string, role Role, groups []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+	groups, err := cleanGroups(groups)
+	if err != nil {
+		return err
+	}
+	if role != RoleAdmin && m.lastAdminLocked(name) {
+		return ErrLastAdmin
+	}
+	oldRole, oldGroups := u.Role, u.Groups
+	u.Role, u.Groups = role, groups
+	if err := m.saveLocked(); err != nil {
+		u.Role, u.Groups = oldRole, oldGroups
+		return err
+	}
+	return nil
+}
+
+// lastAdminLocked reports whether name is the only admin left.
+func (m *Manager) lastAdminLocked(name string) bool {
+	for _, u := range m.users {
+		if u.Role == RoleAdmin && u.Name != name {
+			return false
+		}
+	}
+	return m.users[name].Role == RoleAdmin
+}
+
+func cleanGroups(groups []string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, g := range groups {
+		if !nameRe.MatchString(g) {
+			return nil, fmt.Errorf("%w: group %q", ErrInvalidName, g)
+		}
+		if !seen[g] {
+			seen[g] = true
+			out = append(out, g)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// SetPassword changes the password and ends the user's other sessions.
+// keep is the session that made the change, if any.
+func (m *Manager) SetPassword(name, password, keep string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	old := u.PasswordHash
+	u.PasswordHash = hash
+	if err := m.saveLocked(); err != nil {
+		u.PasswordHash = old
+		return err
+	}
+	for token, s := range m.sessions {
+		if s.user == name && token != keep {
+			delete(m.sessions, token)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) endSessionsLocked(name string) {
+	for token, s := range m.sessions {
+		if s.user == name {
+			delete(m.sessions, token)
+		}
+	}
+}
+
+// saveLocked writes the accounts. Caller must hold m.mu.
+func (m *Manager) saveLocked() error {
+	list := make([]User, 0, len(m.users))
+	for _, u := range m.users {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return writeJSON(m.file, list, "保存用户失败")
+}
+
+// writeJSON replaces file with v, readable by the owner only.
+func writeJSON(file string, v any, what string) error {
+	data, _ := json.MarshalIndent(v, "", "  ")
+	os.MkdirAll(filepath.Dir(file), 0755)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("%s: %v", what, err)
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		log.Printf("%s: %v", what, err)
+		return err
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLen {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
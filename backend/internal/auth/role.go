@@ -0,0 +1,52 @@
+package auth
+
+import "slices"
+
+// Role decides what an account may do:
+//
+//   - admin: everything, including host-level objects (bridges, port
+//     forwards, networks, storage, ISOs, hosts) and accounts.
+//   - operator: create VMs and operate the VMs it owns or that belong to
+//     one of its groups.
+//   - viewer: read-only access to the VMs it owns or that belong to one of
+//     its groups.
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"
+	RoleOperator Role = "operator"
+	RoleViewer   Role = "viewer"
+)
+
+var roleRank = map[Role]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+func (r Role) Valid() bool { return roleRank[r] > 0 }
+
+// Principal is the account behind a request.
+type Principal struct {
+	Name   string
+	Role   Role
+	Groups []string
+}
+
+// Has reports whether the principal's role is r or above.
+func (p Principal) Has(r Role) bool {
+	return roleRank[p.Role] >= roleRank[r]
+}
+
+// Owns reports whether the principal may act on an object owned by owner
+// and group. Admins own everything.
+func (p Principal) Owns(owner, group string) bool {
+	if p.Role == RoleAdmin {
+		return true
+	}
+	return owner == p.Name || group != "" && slices.Contains(p.Groups, group)
+}
+
+// DefaultGroup is the group given to VMs the principal creates.
+func (p Principal) DefaultGroup() string {
+	if len(p.Groups) == 0 {
+		return ""
+	}
+	return p.Groups[0]
+}
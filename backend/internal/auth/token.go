@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Scope limits what an API token may do on top of its user's role.
+type Scope string
+
+const (
+	ScopeVMsRead        Scope = "vms:read"        // list and inspect VMs, follow tasks and events
+	ScopeVMsWrite       Scope = "vms:write"       // create, change, clone and delete VMs
+	ScopeVMsPower       Scope = "vms:power"       // start, stop, reboot, suspend and resume
+	ScopeSnapshotsRead  Scope = "snapshots:read"  // list snapshots
+	ScopeSnapshotsWrite Scope = "snapshots:write" // create, delete and revert snapshots
+	ScopeVMsConsole     Scope = "vms:console"     // serial console and VNC
+	ScopeHostRead       Scope = "host:read"       // list networks, storage, ISOs, bridges, port forwards
+	ScopeHostWrite      Scope = "host:write"      // change networks, storage, ISOs, bridges, port forwards
+	ScopeMetricsRead    Scope = "metrics:read"    // scrape /metrics; admins only
+)
+
+// Scopes lists every scope a token can be given.
+var Scopes = []Scope{ScopeVMsRead, ScopeVMsWrite, ScopeVMsPower, ScopeVMsConsole,
+	ScopeSnapshotsRead, ScopeSnapshotsWrite, ScopeHostRead, ScopeHostWrite, ScopeMetricsRead}
+
+// TokenPrefix starts every API token so leaked ones are easy to find.
+const TokenPrefix = "vpt_"
+
+// lastUsedSaveInterval limits how often using a token rewrites tokens.json.
+const lastUsedSaveInterval = time.Minute
+
+var (
+	ErrTokenNotFound = errors.New("token not found")
+	ErrInvalidScope  = errors.New("scopes must be a non-empty list of " + scopeList())
+	ErrAdminScope    = errors.New("only admins can issue tokens with " + string(ScopeMetricsRead))
+)
+
+// Token is a long-lived credential for scripts and CI. Only the SHA-256
+// of the secret is kept; the secret itself is shown once on creation.
+type Token struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	User       string  `json:"user"`
+	Hash       string  `json:"hash"`
+	Scopes     []Scope `json:"scopes"`
+	CreatedAt  int64   `json:"created_at"`
+	ExpiresAt  int64   `json:"expires_at,omitempty"` // 0: never
+	LastUsedAt int64   `json:"last_used_at,omitempty"`
+}
+
+// TokenInfo is what the API shows of a token.
+type TokenInfo struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	User       string  `json:"user"`
+	Scopes     []Scope `json:"scopes"`
+	CreatedAt  int64   `json:"created_at"`
+	ExpiresAt  int64   `json:"expires_at,omitempty"`
+	LastUsedAt int64   `json:"last_used_at,omitempty"`
+}
+
+// NewToken is returned once, when the token is created.
+type NewToken struct {
+	TokenInfo
+	Token string `json:"token"`
+}
+
+func (t *Token) info() TokenInfo {
+	return TokenInfo{ID: t.ID, Name: t.Name, User: t.User, Scopes: append([]Scope{}, t.Scopes...),
+		CreatedAt: t.CreatedAt, ExpiresAt: t.ExpiresAt, LastUsedAt: t.LastUsedAt}
+}
+
+// Allows reports whether the token was given scope.
+func (t TokenInfo) Allows(scope Scope) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+func scopeList() string {
+	names := make([]string, len(Scopes))
+	for i, s := range Scopes {
+		names[i] = string(s)
+	}
+	return strings.Join(names, ", ")
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *Manager) loadTokens() error {
+	data, err := os.ReadFile(m.tokenFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []Token
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	for i := range saved {
+		m.tokens[saved[i].Hash] = &saved[i]
+	}
+	return nil
+}
+
+// CreateToken issues a token acting as user with the given scopes. A zero
+// expires means the token does not expire.
+func (m *Manager) CreateToken(user, name string, scopes []Scope, expires time.Time) (NewToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > 64 {
+		return NewToken{}, fmt.Errorf("%w: token name must be 1-64 characters", ErrInvalidName)
+	}
+	scopes, err := cleanScopes(scopes)
+	if err != nil {
+		return NewToken{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[user]
+	if !ok {
+		return NewToken{}, ErrUserNotFound
+	}
+	// /metrics needs an admin, and a token never has more than its user
+	if u.Role != RoleAdmin && slices.Contains(scopes, ScopeMetricsRead) {
+		return NewToken{}, ErrAdminScope
+	}
+	secret := TokenPrefix + randomHex(32)
+	t := &Token{
+		ID:        randomHex(8),
+		Name:      name,
+		User:      user,
+		Hash:      hashToken(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now().Unix(),
+	}
+	if !expires.IsZero() {
+		t.ExpiresAt = expires.Unix()
+	}
+	m.tokens[t.Hash] = t
+	if err := m.saveTokensLocked(); err != nil {
+		delete(m.tokens, t.Hash)
+		return NewToken{}, err
+	}
+	return NewToken{TokenInfo: t.info(), Token: secret}, nil
+}
+
+func cleanScopes(scopes []Scope) ([]Scope, error) {
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	var out []Scope
+	for _, s := range scopes {
+		if !slices.Contains(Scopes, s) {
+			return nil, fmt.Errorf("%w (got %q)", ErrInvalidScope, s)
+		}
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// Tokens lists the tokens of user, or of every user when user is "".
+func (m *Manager) Tokens(user string) []TokenInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := []TokenInfo{}
+	for _, t := range m.tokens {
+		if user == "" || t.User == user {
+			list = append(list, t.info())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt > list[j].CreatedAt })
+	return list
+}
+
+// RevokeToken deletes the token with id. When user is not "" the token
+// must belong to that user.
+func (m *Manager) RevokeToken(id, user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, t := range m.tokens {
+		if t.ID == id && (user == "" || t.User == user) {
+			delete(m.tokens, hash)
+			return m.saveTokensLocked()
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// AuthenticateToken checks an API token and records its use.
+func (m *Manager) AuthenticateToken(secret string) (TokenInfo, bool) {
+	if !strings.HasPrefix(secret, TokenPrefix) {
+		return TokenInfo{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[hashToken(secret)]
+	if !ok {
+		return TokenInfo{}, false
+	}
+	now := time.Now()
+	if t.ExpiresAt != 0 && now.Unix() >= t.ExpiresAt {
+		return TokenInfo{}, false
+	}
+	if _, ok := m.users[t.User]; !ok {
+		return TokenInfo{}, false
+	}
+	last := t.LastUsedAt
+	t.LastUsedAt = now.Unix()
+	if now.Sub(time.Unix(last, 0)) >= lastUsedSaveInterval {
+		m.saveTokensLocked()
+	}
+	return t.info(), true
+}
+
+// revokeUserTokensLocked drops the tokens of a deleted user.
+func (m *Manager) revokeUserTokensLocked(user string) error {
+	n := len(m.tokens)
+	for hash, t := range m.tokens {
+		if t.User == user {
+			delete(m.tokens, hash)
+		}
+	}
+	if len(m.tokens) == n {
+		return nil
+	}
+	return m.saveTokensLocked()
+}
+
+func (m *Manager) saveTokensLocked() error {
+	list := make([]Token, 0, len(m.tokens))
+	for _, t := range m.tokens {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return writeJSON(m.tokenFile, list, "保存 API 令牌失败")
+}
@@ -0,0 +1,199 @@
+// Package cloudinit builds NoCloud seed images: an ISO labelled cidata
+// with the user-data, meta-data and optional network-config files
+// cloud-init looks for on first boot.
+package cloudinit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"virtpanel/internal/model"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalid wraps every validation error of Seed.
+var ErrInvalid = errors.New("invalid cloud-init config")
+
+const maxRaw = 64 << 10 // user_data and network_config
+
+var (
+	hostnameRe = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+	userRe     = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	packageRe  = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.+_:=~-]*$`)
+	shellRe    = regexp.MustCompile(`^/[a-zA-Z0-9/._-]+$`)
+)
+
+// cloudConfig is the generated #cloud-config user-data.
+type cloudConfig struct {
+	Hostname          string   `yaml:"hostname"`
+	ManageEtcHosts    bool     `yaml:"manage_etc_hosts"`
+	Users             []any    `yaml:"users,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+	SSHPwauth         bool     `yaml:"ssh_pwauth,omitempty"`
+	PackageUpdate     bool     `yaml:"package_update,omitempty"`
+	Packages          []string `yaml:"packages,omitempty"`
+}
+
+type user struct {
+	Name              string   `yaml:"name"`
+	Shell             string   `yaml:"shell"`
+	Groups            string   `yaml:"groups,omitempty"`
+	Sudo              string   `yaml:"sudo,omitempty"`
+	LockPasswd        bool     `yaml:"lock_passwd"`
+	HashedPasswd      string   `yaml:"hashed_passwd,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+type metaData struct {
+	InstanceID    string `yaml:"instance-id"`
+	LocalHostname string `yaml:"local-hostname"`
+}
+
+// Seed validates c and returns the seed image of the VM called vm.
+func Seed(vm string, c *model.CloudInit) ([]byte, error) {
+	hostname := c.Hostname
+	if hostname == "" {
+		hostname = defaultHostname(vm)
+	}
+	if !hostnameRe.MatchString(hostname) || len(hostname) > 253 {
+		return nil, fmt.Errorf("%w: invalid hostname %q", ErrInvalid, hostname)
+	}
+	userData, err := buildUserData(hostname, c)
+	if err != nil {
+		return nil, err
+	}
+	meta, _ := yaml.Marshal(metaData{InstanceID: vm + "-" + randomID(), LocalHostname: hostname})
+	files := map[string][]byte{"user-data": userData, "meta-data": meta}
+	if c.NetworkConfig != "" {
+		if err := checkYAML("network_config", c.NetworkConfig); err != nil {
+			return nil, err
+		}
+		files["network-config"] = []byte(c.NetworkConfig)
+	}
+	return buildISO("cidata", files), nil
+}
+
+func buildUserData(hostname string, c *model.CloudInit) ([]byte, error) {
+	if c.UserData != "" {
+		if len(c.Users) > 0 || len(c.SSHKeys) > 0 || len(c.Packages) > 0 {
+			return nil, fmt.Errorf("%w: user_data cannot be combined with users, ssh_keys or packages", ErrInvalid)
+		}
+		if strings.HasPrefix(c.UserData, "#cloud-config") {
+			if err := checkYAML("user_data", c.UserData); err != nil {
+				return nil, err
+			}
+		} else if len(c.UserData) > maxRaw {
+			return nil, fmt.Errorf("%w: user_data is larger than %d bytes", ErrInvalid, maxRaw)
+		} else if !strings.HasPrefix(c.UserData, "#") && !strings.HasPrefix(c.UserData, "Content-Type:") {
+			return nil, fmt.Errorf("%w: user_data must start with #cloud-config, #! or another cloud-init header", ErrInvalid)
+		}
+		return []byte(c.UserData), nil
+	}
+
+	cfg := cloudConfig{Hostname: hostname, ManageEtcHosts: true}
+	if err := checkKeys(c.SSHKeys); err != nil {
+		return nil, err
+	}
+	cfg.SSHAuthorizedKeys = c.SSHKeys
+	if len(c.Users) > 0 {
+		cfg.Users = append(cfg.Users, "default")
+	}
+	for _, u := range c.Users {
+		if !userRe.MatchString(u.Name) {
+			return nil, fmt.Errorf("%w: invalid user name %q", ErrInvalid, u.Name)
+		}
+		if err := checkKeys(u.SSHKeys); err != nil {
+			return nil, err
+		}
+		if strings.ContainsAny(u.PasswordHash, " \t\r\n:") {
+			return nil, fmt.Errorf("%w: invalid password_hash of %s", ErrInvalid, u.Name)
+		}
+		for _, g := range u.Groups {
+			if !userRe.MatchString(g) {
+				return nil, fmt.Errorf("%w: invalid group %q", ErrInvalid, g)
+			}
+		}
+		out := user{
+			Name:              u.Name,
+			Shell:             u.Shell,
+			Groups:            strings.Join(u.Groups, ","),
+			LockPasswd:        u.PasswordHash == "",
+			HashedPasswd:      u.PasswordHash,
+			SSHAuthorizedKeys: u.SSHKeys,
+		}
+		if out.Shell == "" {
+			out.Shell = "/bin/bash"
+		} else if !shellRe.MatchString(out.Shell) {
+			return nil, fmt.Errorf("%w: invalid shell %q", ErrInvalid, out.Shell)
+		}
+		if u.Sudo {
+			out.Sudo = "ALL=(ALL) NOPASSWD:ALL"
+		}
+		if u.PasswordHash != "" {
+			cfg.SSHPwauth = true
+		}
+		cfg.Users = append(cfg.Users, out)
+	}
+	for _, p := range c.Packages {
+		if !packageRe.MatchString(p) {
+			return nil, fmt.Errorf("%w: invalid package name %q", ErrInvalid, p)
+		}
+	}
+	cfg.Packages = c.Packages
+	cfg.PackageUpdate = len(c.Packages) > 0
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("#cloud-config\n"), data...), nil
+}
+
+func checkKeys(keys []string) error {
+	for _, k := range keys {
+		if len(strings.Fields(k)) < 2 || strings.ContainsAny(k, "\r\n") {
+			return fmt.Errorf("%w: invalid SSH public key %q", ErrInvalid, k)
+		}
+	}
+	return nil
+}
+
+func checkYAML(field, s string) error {
+	if len(s) > maxRaw {
+		return fmt.Errorf("%w: %s is larger than %d bytes", ErrInvalid, field, maxRaw)
+	}
+	var v map[string]any
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalid, field, err)
+	}
+	if v == nil {
+		return fmt.Errorf("%w: %s is empty", ErrInvalid, field)
+	}
+	return nil
+}
+
+// defaultHostname derives a hostname from a VM name, which may contain
+// underscores and dots.
+func defaultHostname(vm string) string {
+	b := []byte(vm)
+	for i, c := range b {
+		if c == '_' || c == '.' {
+			b[i] = '-'
+		}
+	}
+	if len(b) > 63 {
+		b = b[:63]
+	}
+	return strings.Trim(string(b), "-")
+}
+
+func randomID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
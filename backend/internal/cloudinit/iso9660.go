@@ -0,0 +1,216 @@
+package cloudinit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// The seed is a single-directory ISO 9660 image with Joliet names, the
+// layout cloud-localds and genisoimage -joliet produce. Linux reads the
+// Joliet tree, so the files keep their lower-case names.
+
+const sector = 2048
+
+// Fixed layout: system area, primary and Joliet volume descriptors,
+// terminator, a little- and a big-endian path table for each tree, the
+// two root directories, then the file data.
+const (
+	lbaPrimary      = 16
+	lbaJoliet       = 17
+	lbaTerminator   = 18
+	lbaPathL        = 19
+	lbaPathM        = 20
+	lbaJolietPathL  = 21
+	lbaJolietPathM  = 22
+	lbaRoot         = 23
+	lbaJolietRoot   = 24
+	lbaFiles        = 25
+	pathTableLength = 10 // one entry, for the root
+)
+
+type isoFile struct {
+	name string
+	data []byte
+	lba  uint32
+}
+
+// buildISO returns an image labelled volume holding files in its root
+// directory. The directories must fit in one sector each, which a few
+// short names always do.
+func buildISO(volume string, files map[string][]byte) []byte {
+	list := make([]isoFile, 0, len(files))
+	for name, data := range files {
+		list = append(list, isoFile{name: name, data: data})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	lba := uint32(lbaFiles)
+	for i := range list {
+		list[i].lba = lba
+		lba += sectors(len(list[i].data))
+	}
+	total := lba
+	now := time.Now().UTC()
+
+	img := make([]byte, int(total)*sector)
+	at := func(lba uint32) []byte { return img[int(lba)*sector : int(lba+1)*sector] }
+
+	volumeDescriptor(at(lbaPrimary), 1, total, volume, now, false)
+	volumeDescriptor(at(lbaJoliet), 2, total, volume, now, true)
+	copy(at(lbaTerminator), []byte{255, 'C', 'D', '0', '0', '1', 1})
+	pathTable(at(lbaPathL), lbaRoot, binary.LittleEndian)
+	pathTable(at(lbaPathM), lbaRoot, binary.BigEndian)
+	pathTable(at(lbaJolietPathL), lbaJolietRoot, binary.LittleEndian)
+	pathTable(at(lbaJolietPathM), lbaJolietRoot, binary.BigEndian)
+	directory(at(lbaRoot), lbaRoot, list, now, primaryName)
+	directory(at(lbaJolietRoot), lbaJolietRoot, list, now, ucs2)
+	for _, f := range list {
+		copy(img[int(f.lba)*sector:], f.data)
+	}
+	return img
+}
+
+func sectors(n int) uint32 {
+	return uint32((n + sector - 1) / sector)
+}
+
+// primaryName turns a name into ISO 9660 d-characters with a version.
+func primaryName(name string) []byte {
+	b := []byte(strings.ToUpper(name))
+	for i, c := range b {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			b[i] = '_'
+		}
+	}
+	return append(b, ".;1"...)
+}
+
+// ucs2 encodes s as big-endian UCS-2, the Joliet character set.
+func ucs2(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.BigEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+func both16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func both32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+// fill writes s into b and pads the rest with spaces, in UCS-2 for
+// Joliet descriptors.
+func fill(b []byte, s string, joliet bool) {
+	pad := []byte{' '}
+	enc := []byte(s)
+	if joliet {
+		pad, enc = []byte{0, ' '}, ucs2(s)
+	}
+	n := copy(b, enc)
+	for ; n+len(pad) <= len(b); n += len(pad) {
+		copy(b[n:], pad)
+	}
+}
+
+// longDate is the 17-byte date of volume descriptors; an empty one
+// means not specified.
+func longDate(b []byte, t time.Time) {
+	if t.IsZero() {
+		copy(b, "0000000000000000")
+		b[16] = 0
+		return
+	}
+	copy(b, t.Format("20060102150405")+"00")
+	b[16] = 0 // GMT offset
+}
+
+func volumeDescriptor(b []byte, typ byte, total uint32, volume string, now time.Time, joliet bool) {
+	b[0] = typ
+	copy(b[1:], "CD001")
+	b[6] = 1
+	fill(b[8:40], "", joliet)
+	fill(b[40:72], volume, joliet)
+	both32(b[80:], total)
+	if joliet {
+		copy(b[88:], "%/E") // UCS-2 level 3
+	}
+	both16(b[120:], 1) // volume set size
+	both16(b[124:], 1) // volume sequence number
+	both16(b[128:], sector)
+	both32(b[132:], pathTableLength)
+	root, pathL, pathM := uint32(lbaRoot), uint32(lbaPathL), uint32(lbaPathM)
+	if joliet {
+		root, pathL, pathM = lbaJolietRoot, lbaJolietPathL, lbaJolietPathM
+	}
+	binary.LittleEndian.PutUint32(b[140:], pathL)
+	binary.BigEndian.PutUint32(b[148:], pathM)
+	dirRecord(b[156:190], root, sector, true, []byte{0}, now)
+	fill(b[190:318], "", joliet) // volume set
+	fill(b[318:446], "", joliet) // publisher
+	fill(b[446:574], "", joliet) // data preparer
+	fill(b[574:702], "VIRTPANEL", joliet)
+	fill(b[702:813], "", joliet) // copyright, abstract and bibliographic files
+	longDate(b[813:830], now)
+	longDate(b[830:847], now)
+	longDate(b[847:864], time.Time{})
+	longDate(b[864:881], time.Time{})
+	b[881] = 1 // file structure version
+}
+
+func pathTable(b []byte, root uint32, order binary.ByteOrder) {
+	b[0] = 1 // identifier length
+	order.PutUint32(b[2:], root)
+	order.PutUint16(b[6:], 1) // parent directory number
+}
+
+// dirRecord writes a directory record into b and returns its length.
+func dirRecord(b []byte, lba, size uint32, dir bool, name []byte, t time.Time) int {
+	n := 33 + len(name)
+	if n%2 == 1 {
+		n++
+	}
+	b[0] = byte(n)
+	both32(b[2:], lba)
+	both32(b[10:], size)
+	b[18] = byte(t.Year() - 1900)
+	b[19] = byte(t.Month())
+	b[20] = byte(t.Day())
+	b[21] = byte(t.Hour())
+	b[22] = byte(t.Minute())
+	b[23] = byte(t.Second())
+	if dir {
+		b[25] = 2
+	}
+	both16(b[28:], 1)
+	b[32] = byte(len(name))
+	copy(b[33:], name)
+	return n
+}
+
+// directory writes a root directory: itself, its parent (itself again)
+// and the files sorted by their encoded names.
+func directory(b []byte, self uint32, files []isoFile, t time.Time, encode func(string) []byte) {
+	type entry struct {
+		name []byte
+		file isoFile
+	}
+	entries := make([]entry, len(files))
+	for i, f := range files {
+		entries[i] = entry{encode(f.name), f}
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].name, entries[j].name) < 0 })
+	off := dirRecord(b, self, sector, true, []byte{0}, t)
+	off += dirRecord(b[off:], self, sector, true, []byte{1}, t)
+	for _, e := range entries {
+		off += dirRecord(b[off:], e.file.lba, uint32(len(e.file.data)), false, e.name, t)
+	}
+}
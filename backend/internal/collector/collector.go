@@ -0,0 +1,205 @@
+// Package collector samples every host and VM at a fixed interval into
+// an on-disk time-series store for the history charts.
+package collector
+
+import (
+	"errors"
+	"log"
+	"math"
+	"path/filepath"
+	"time"
+
+	"virtpanel/internal/model"
+	"virtpanel/internal/service"
+	"virtpanel/internal/tsdb"
+)
+
+// The history is kept at the sampling interval for a day and as 5 minute
+// averages for 30 days.
+const (
+	fineRetention   = 24 * time.Hour
+	coarseStep      = 5 * time.Minute
+	coarseRetention = 30 * 24 * time.Hour
+	expireInterval  = time.Hour
+	maxPoints       = 2000
+)
+
+// Fields of the VM and host series, in storage order. CPU is in percent,
+// memory in MiB, I/O in bytes per second.
+var (
+	VMFields   = []string{"cpu_usage", "mem_used", "mem_total", "disk_read", "disk_write", "net_rx", "net_tx"}
+	HostFields = []string{"cpu_usage", "mem_used", "mem_total", "load1", "vm_running"}
+)
+
+var ErrDisabled = errors.New("stats collection is disabled (stats_interval: 0)")
+
+type Collector struct {
+	hosts    *service.HostManager
+	db       *tsdb.DB
+	interval time.Duration
+	prev     map[string]counters // "host/vm" -> last sample, used by the loop only
+	stopCh   chan struct{}
+}
+
+// counters is the part of a VM's stats that is turned into rates.
+type counters struct {
+	at                      time.Time
+	cpuTime, rd, wr, rx, tx uint64
+}
+
+// New opens the store under dataDir/stats. Sampling starts with Start.
+func New(hosts *service.HostManager, dataDir string, interval time.Duration) (*Collector, error) {
+	db, err := tsdb.Open(filepath.Join(dataDir, "stats"), []tsdb.Tier{
+		{Step: interval, Retention: fineRetention},
+		{Step: coarseStep, Retention: coarseRetention},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Collector{hosts: hosts, db: db, interval: interval, prev: make(map[string]counters), stopCh: make(chan struct{})}, nil
+}
+
+func (c *Collector) Start() { go c.loop() }
+
+func (c *Collector) Close() {
+	close(c.stopCh)
+	c.db.Close()
+}
+
+func (c *Collector) loop() {
+	tick := time.NewTicker(c.interval)
+	defer tick.Stop()
+	expire := time.NewTicker(expireInterval)
+	defer expire.Stop()
+	for {
+		select {
+		case now := <-tick.C:
+			c.collect(now)
+		case <-expire.C:
+			if err := c.db.Expire(); err != nil {
+				log.Printf("清理过期监控数据失败: %v", err)
+			}
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+func (c *Collector) collect(now time.Time) {
+	seen := make(map[string]bool)
+	for _, hs := range c.hosts.Stats() {
+		host := hs.Host.Name
+		if info := hs.Info; info != nil {
+			c.write(host+"/host", now, []float64{
+				info.CPUUsage,
+				float64(info.MemoryTotal - info.MemoryFree),
+				float64(info.MemoryTotal),
+				info.LoadAvg[0],
+				float64(info.VMRunning),
+			})
+		}
+		for _, g := range hs.Guests {
+			if g.State != "running" {
+				continue
+			}
+			key := host + "/vm/" + g.Name
+			seen[key] = true
+			cur := counters{at: now, cpuTime: g.CPUTime}
+			for _, b := range g.Blocks {
+				cur.rd += b.ReadBytes
+				cur.wr += b.WriteBytes
+			}
+			for _, n := range g.NICs {
+				cur.rx += n.RxBytes
+				cur.tx += n.TxBytes
+			}
+			prev, ok := c.prev[key]
+			c.prev[key] = cur
+			// Rates need a previous sample of the same boot
+			if !ok || cur.cpuTime < prev.cpuTime || cur.rd < prev.rd || cur.wr < prev.wr || cur.rx < prev.rx || cur.tx < prev.tx {
+				continue
+			}
+			dt := cur.at.Sub(prev.at).Seconds()
+			if dt <= 0 {
+				continue
+			}
+			cpu := 0.0
+			if g.VCPUs > 0 {
+				cpu = math.Min(100, float64(cur.cpuTime-prev.cpuTime)/(dt*1e9*float64(g.VCPUs))*100)
+			}
+			c.write(key, now, []float64{
+				math.Round(cpu*10) / 10,
+				float64(memUsedKiB(g.Balloon)) / 1024,
+				float64(g.Balloon.Current) / 1024,
+				float64(cur.rd-prev.rd) / dt,
+				float64(cur.wr-prev.wr) / dt,
+				float64(cur.rx-prev.rx) / dt,
+				float64(cur.tx-prev.tx) / dt,
+			})
+		}
+	}
+	for key := range c.prev {
+		if !seen[key] {
+			delete(c.prev, key)
+		}
+	}
+}
+
+// memUsedKiB is what the guest uses according to its balloon driver,
+// else the resident memory of the qemu process.
+func memUsedKiB(b model.BalloonStats) uint64 {
+	if b.Available > 0 && b.Unused > 0 && b.Unused < b.Available {
+		return b.Available - b.Unused
+	}
+	return b.RSS
+}
+
+func (c *Collector) write(key string, now time.Time, values []float64) {
+	if err := c.db.Write(key, now, values); err != nil {
+		log.Printf("写入监控数据失败 %s: %v", key, err)
+	}
+}
+
+// VMStats returns the history of one VM between from and to. A step of
+// 0 picks one that gives at most a few hundred points.
+func (c *Collector) VMStats(host, name string, from, to time.Time, step time.Duration) (*model.StatsSeries, error) {
+	return c.query(host+"/vm/"+name, VMFields, from, to, step)
+}
+
+// HostStats returns the history of a host.
+func (c *Collector) HostStats(host string, from, to time.Time, step time.Duration) (*model.StatsSeries, error) {
+	return c.query(host+"/host", HostFields, from, to, step)
+}
+
+func (c *Collector) query(key string, fields []string, from, to time.Time, step time.Duration) (*model.StatsSeries, error) {
+	if c == nil {
+		return nil, ErrDisabled
+	}
+	span := to.Sub(from)
+	if step == 0 {
+		step = span / 300
+	}
+	if min := span / maxPoints; step < min {
+		step = min
+	}
+	out := &model.StatsSeries{From: from.Unix(), To: to.Unix(), Time: []int64{}, Values: make(map[string][]float64, len(fields))}
+	for _, f := range fields {
+		out.Values[f] = []float64{}
+	}
+	s, err := c.db.Query(key, from, to, step)
+	if errors.Is(err, tsdb.ErrNoData) {
+		out.Step = int64((max(step, c.interval)+c.interval-1)/c.interval*c.interval) / int64(time.Second)
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out.Step = int64(s.Step / time.Second)
+	out.Time = s.Time
+	for i, f := range fields {
+		if i < len(s.Values) {
+			out.Values[f] = s.Values[i]
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is where the backend looks for its config file when
+// neither --config nor VIRTPANEL_CONFIG is given.
+const DefaultPath = "/etc/virtpanel/config.yaml"
+
+// Config holds every setting the backend needs at startup. Values are
+// resolved in order: built-in defaults, YAML file, VIRTPANEL_* environment
+// variables, command-line flags.
+type Config struct {
+	Listen         string   `yaml:"listen" json:"listen"`
+	Driver         string   `yaml:"driver" json:"driver"`           // libvirt, sim
+	LibvirtURI     string   `yaml:"libvirt_uri" json:"libvirt_uri"` // e.g. qemu:///system, qemu+tcp://host/system
+	DataDir        string   `yaml:"data_dir" json:"data_dir"`       // panel state (port forwards, ...)
+	ImageDir       string   `yaml:"image_dir" json:"image_dir"`     // new VM disks are created here
+	ISODir         string   `yaml:"iso_dir" json:"iso_dir"`
+	AllowedRoots   []string `yaml:"allowed_roots" json:"allowed_roots"` // disk/pool paths must live under one of these
+	DefaultNetwork string   `yaml:"default_network" json:"default_network"`
+	BridgePrefix   string   `yaml:"bridge_prefix" json:"bridge_prefix"`
+
+	// HostName is how the host behind LibvirtURI is addressed in the
+	// ?host= selector. Hosts lists further libvirt hosts managed by the
+	// same panel; more can be registered at runtime via /api/hosts.
+	HostName string       `yaml:"host_name" json:"host_name"`
+	Hosts    []HostConfig `yaml:"hosts" json:"hosts"`
+
+	// StatsInterval is how often VMs and hosts are sampled for the history
+	// charts, e.g. "10s"; "0" turns sampling off. It must divide 5m, the
+	// step of the 30-day history.
+	StatsInterval string `yaml:"stats_interval" json:"stats_interval"`
+
+	path          string
+	statsInterval time.Duration
+}
+
+// HostConfig is an additional libvirt host, e.g.
+// {name: kvm2, uri: "qemu+tcp://10.0.0.2/system"}.
+type HostConfig struct {
+	Name string `yaml:"name" json:"name"`
+	URI  string `yaml:"uri" json:"uri"`
+}
+
+// Default returns the built-in configuration, matching the paths the
+// panel has always used.
+func Default() *Config {
+	return &Config{
+		Listen:         ":8080",
+		Driver:         "libvirt",
+		LibvirtURI:     "qemu:///system",
+		DataDir:        "/etc/virtpanel",
+		ImageDir:       "/var/lib/libvirt/images",
+		ISODir:         "/var/lib/libvirt/images/iso",
+		AllowedRoots:   []string{"/var/lib/libvirt"},
+		DefaultNetwork: "default",
+		BridgePrefix:   "vp-",
+		HostName:       "local",
+		StatsInterval:  "10s",
+	}
+}
+
+// Load builds a Config from defaults, the YAML file at path and the
+// environment. A missing file is only an error when path is not the
+// default location.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		path = DefaultPath
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		cfg.path = path
+	case errors.Is(err, os.ErrNotExist) && path == DefaultPath:
+	default:
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	cfg.applyEnv()
+	return cfg, nil
+}
+
+// Path returns the config file the settings were read from, or "" when
+// only defaults and overrides are in effect.
+func (c *Config) Path() string { return c.path }
+
+func (c *Config) applyEnv() {
+	str := map[string]*string{
+		"VIRTPANEL_LISTEN":          &c.Listen,
+		"VIRTPANEL_DRIVER":          &c.Driver,
+		"VIRTPANEL_LIBVIRT_URI":     &c.LibvirtURI,
+		"VIRTPANEL_DATA_DIR":        &c.DataDir,
+		"VIRTPANEL_IMAGE_DIR":       &c.ImageDir,
+		"VIRTPANEL_ISO_DIR":         &c.ISODir,
+		"VIRTPANEL_DEFAULT_NETWORK": &c.DefaultNetwork,
+		"VIRTPANEL_BRIDGE_PREFIX":   &c.BridgePrefix,
+		"VIRTPANEL_HOST_NAME":       &c.HostName,
+		"VIRTPANEL_STATS_INTERVAL":  &c.StatsInterval,
+	}
+	for key, dst := range str {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	if v, ok := os.LookupEnv("VIRTPANEL_ALLOWED_ROOTS"); ok {
+		c.AllowedRoots = splitList(v)
+	}
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Flags are command-line overrides. Only flags given a non-empty value
+// replace the loaded settings.
+type Flags struct {
+	Config         *string
+	Listen         *string
+	Driver         *string
+	LibvirtURI     *string
+	DataDir        *string
+	ImageDir       *string
+	ISODir         *string
+	AllowedRoots   *string
+	DefaultNetwork *string
+	BridgePrefix   *string
+	HostName       *string
+	StatsInterval  *string
+}
+
+// RegisterFlags defines the config flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{
+		Config:         fs.String("config", os.Getenv("VIRTPANEL_CONFIG"), "config file (default "+DefaultPath+")"),
+		Listen:         fs.String("listen", "", "HTTP listen address"),
+		Driver:         fs.String("driver", "", "hypervisor driver: libvirt or sim"),
+		LibvirtURI:     fs.String("libvirt-uri", "", "libvirt connection URI"),
+		DataDir:        fs.String("data-dir", "", "directory for panel state"),
+		ImageDir:       fs.String("image-dir", "", "directory for new VM disks"),
+		ISODir:         fs.String("iso-dir", "", "directory for ISO images"),
+		AllowedRoots:   fs.String("allowed-roots", "", "comma-separated path roots for disks and pools"),
+		DefaultNetwork: fs.String("default-network", "", "libvirt network for new NICs"),
+		BridgePrefix:   fs.String("bridge-prefix", "", "name prefix for panel-managed bridges"),
+		HostName:       fs.String("host-name", "", "name of the libvirt-uri host in the host selector"),
+		StatsInterval:  fs.String("stats-interval", "", "sampling interval of the history charts, 0 to disable"),
+	}
+}
+
+// Apply copies the non-empty flag values onto c.
+func (f *Flags) Apply(c *Config) {
+	set := func(dst *string, v *string) {
+		if *v != "" {
+			*dst = *v
+		}
+	}
+	set(&c.Listen, f.Listen)
+	set(&c.Driver, f.Driver)
+	set(&c.LibvirtURI, f.LibvirtURI)
+	set(&c.DataDir, f.DataDir)
+	set(&c.ImageDir, f.ImageDir)
+	set(&c.ISODir, f.ISODir)
+	set(&c.DefaultNetwork, f.DefaultNetwork)
+	set(&c.BridgePrefix, f.BridgePrefix)
+	set(&c.HostName, f.HostName)
+	set(&c.StatsInterval, f.StatsInterval)
+	if *f.AllowedRoots != "" {
+		c.AllowedRoots = splitList(*f.AllowedRoots)
+	}
+}
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// Validate normalizes paths and checks that the settings are usable.
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	switch c.Driver {
+	case "libvirt":
+		if err := CheckHostURI(c.LibvirtURI); err != nil {
+			return fmt.Errorf("libvirt_uri: %w", err)
+		}
+	case "sim":
+	default:
+		return fmt.Errorf("driver: must be libvirt or sim, got %q", c.Driver)
+	}
+
+	if len(c.AllowedRoots) == 0 {
+		return fmt.Errorf("allowed_roots: at least one root is required")
+	}
+	for i, r := range c.AllowedRoots {
+		if !filepath.IsAbs(r) {
+			return fmt.Errorf("allowed_roots: %q is not an absolute path", r)
+		}
+		c.AllowedRoots[i] = filepath.Clean(r)
+	}
+	for name, dir := range map[string]*string{"data_dir": &c.DataDir, "image_dir": &c.ImageDir, "iso_dir": &c.ISODir} {
+		if !filepath.IsAbs(*dir) {
+			return fmt.Errorf("%s: %q is not an absolute path", name, *dir)
+		}
+		*dir = filepath.Clean(*dir)
+	}
+	if !c.PathAllowed(c.ImageDir) {
+		return fmt.Errorf("image_dir: %s is outside allowed_roots", c.ImageDir)
+	}
+	if !c.PathAllowed(c.ISODir) {
+		return fmt.Errorf("iso_dir: %s is outside allowed_roots", c.ISODir)
+	}
+
+	if !nameRe.MatchString(c.DefaultNetwork) {
+		return fmt.Errorf("default_network: invalid name %q", c.DefaultNetwork)
+	}
+	// Linux interface names are limited to 15 characters
+	if !nameRe.MatchString(c.BridgePrefix) || len(c.BridgePrefix) > 8 {
+		return fmt.Errorf("bridge_prefix: must be 1-8 of [a-zA-Z0-9._-], got %q", c.BridgePrefix)
+	}
+
+	if !nameRe.MatchString(c.HostName) {
+		return fmt.Errorf("host_name: invalid name %q", c.HostName)
+	}
+	seen := map[string]bool{c.HostName: true}
+	for _, h := range c.Hosts {
+		if !nameRe.MatchString(h.Name) {
+			return fmt.Errorf("hosts: invalid name %q", h.Name)
+		}
+		if seen[h.Name] {
+			return fmt.Errorf("hosts: duplicate name %q", h.Name)
+		}
+		seen[h.Name] = true
+		if err := CheckHostURI(h.URI); err != nil {
+			return fmt.Errorf("hosts.%s: %w", h.Name, err)
+		}
+	}
+
+	if c.StatsInterval == "0" || c.StatsInterval == "" {
+		c.statsInterval = 0
+	} else {
+		d, err := time.ParseDuration(c.StatsInterval)
+		if err != nil || d < time.Second || d%time.Second != 0 || (5*time.Minute)%d != 0 {
+			return fmt.Errorf("stats_interval: must be 0 or whole seconds dividing 5m, got %q", c.StatsInterval)
+		}
+		c.statsInterval = d
+	}
+	return nil
+}
+
+// StatsEvery returns the validated stats_interval; 0 when sampling is
+// off.
+func (c *Config) StatsEvery() time.Duration { return c.statsInterval }
+
+// CheckHostURI accepts qemu driver URIs over any transport go-libvirt
+// can dial (unix, tcp, tls, ssh, libssh) and sim:// for in-memory hosts.
+func CheckHostURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "sim" {
+		return nil
+	}
+	driver, transport, _ := strings.Cut(u.Scheme, "+")
+	if driver != "qemu" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	switch transport {
+	case "", "unix", "tcp", "tls", "ssh", "libssh":
+	default:
+		return fmt.Errorf("unsupported transport %q", transport)
+	}
+	return nil
+}
+
+// PathAllowed reports whether p is one of the allowed roots or lies
+// beneath one.
+func (c *Config) PathAllowed(p string) bool {
+	p = filepath.Clean(p)
+	for _, r := range c.AllowedRoots {
+		if p == r || strings.HasPrefix(p, strings.TrimSuffix(r, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package domxml
+
+import "encoding/xml"
+
+// CloneNS is the namespace of the linked clone record, kept as
+// <linked base="..."/> in the domain's <metadata>.
+const CloneNS = "http://virtpanel.io/xmlns/clone/1.0"
+
+// ClonePrefix is the namespace prefix libvirt writes the record with.
+const ClonePrefix = "virtpanel-clone"
+
+type linked struct {
+	XMLName xml.Name `xml:"linked"`
+	Base    string   `xml:"base,attr"`
+}
+
+// LinkedBase returns the VM whose disks back this linked clone, or "".
+func (d *Domain) LinkedBase() string {
+	if d.Metadata == nil {
+		return ""
+	}
+	var md struct {
+		Linked *linked `xml:"http://virtpanel.io/xmlns/clone/1.0 linked"`
+	}
+	if xml.Unmarshal([]byte("<metadata>"+d.Metadata.Inner+"</metadata>"), &md) != nil || md.Linked == nil {
+		return ""
+	}
+	return md.Linked.Base
+}
+
+// LinkedElement returns the linked clone record in the form
+// DomainSetMetadata expects.
+func LinkedElement(base string) string {
+	b, _ := xml.Marshal(linked{Base: base})
+	return string(b)
+}
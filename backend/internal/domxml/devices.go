@@ -0,0 +1,280 @@
+package domxml
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+type Devices struct {
+	Emulator    string       `xml:"emulator,omitempty"`
+	Disks       []Disk       `xml:"disk"`
+	Controllers []Controller `xml:"controller"`
+	Interfaces  []Interface  `xml:"interface"`
+	Graphics    []Graphics   `xml:"graphics"`
+	Videos      []Video      `xml:"video"`
+	Inputs      []Input      `xml:"input"`
+	Consoles    []Console    `xml:"console"`
+	Attrs       []xml.Attr   `xml:",any,attr"`
+	Extra       []Node       `xml:",any"` // serial, channel, sound, memballoon, rng, ...
+}
+
+type Disk struct {
+	XMLName  xml.Name    `xml:"disk"`
+	Type     string      `xml:"type,attr,omitempty"`
+	Device   string      `xml:"device,attr,omitempty"`
+	Attrs    []xml.Attr  `xml:",any,attr"`
+	Driver   *DiskDriver `xml:"driver"`
+	Source   *DiskSource `xml:"source"`
+	Target   *DiskTarget `xml:"target"`
+	ReadOnly *Flag       `xml:"readonly"`
+	Extra    []Node      `xml:",any"` // backingStore, alias, address, boot, ...
+}
+
+type DiskDriver struct {
+	Name    string     `xml:"name,attr,omitempty"`
+	Type    string     `xml:"type,attr,omitempty"`
+	Cache   string     `xml:"cache,attr,omitempty"`
+	Discard string     `xml:"discard,attr,omitempty"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+}
+
+type DiskSource struct {
+	File   string     `xml:"file,attr,omitempty"`
+	Dev    string     `xml:"dev,attr,omitempty"`
+	Pool   string     `xml:"pool,attr,omitempty"`
+	Volume string     `xml:"volume,attr,omitempty"`
+	Attrs  []xml.Attr `xml:",any,attr"`
+	Extra  []Node     `xml:",any"`
+}
+
+type DiskTarget struct {
+	Dev   string     `xml:"dev,attr"`
+	Bus   string     `xml:"bus,attr,omitempty"`
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+type Controller struct {
+	XMLName xml.Name   `xml:"controller"`
+	Type    string     `xml:"type,attr"`
+	Index   string     `xml:"index,attr,omitempty"`
+	Model   string     `xml:"model,attr,omitempty"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Extra   []Node     `xml:",any"`
+}
+
+type Interface struct {
+	XMLName xml.Name         `xml:"interface"`
+	Type    string           `xml:"type,attr"`
+	Attrs   []xml.Attr       `xml:",any,attr"`
+	MAC     *MAC             `xml:"mac"`
+	Source  *InterfaceSource `xml:"source"`
+	Model   *Model           `xml:"model"`
+	Extra   []Node           `xml:",any"` // target, alias, address, ...
+}
+
+type MAC struct {
+	Address string     `xml:"address,attr"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+}
+
+type InterfaceSource struct {
+	Network string     `xml:"network,attr,omitempty"`
+	Bridge  string     `xml:"bridge,attr,omitempty"`
+	Dev     string     `xml:"dev,attr,omitempty"`
+	Mode    string     `xml:"mode,attr,omitempty"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Extra   []Node     `xml:",any"`
+}
+
+type Model struct {
+	Type  string     `xml:"type,attr"`
+	Attrs []xml.Attr `xml:",any,attr"`
+	Extra []Node     `xml:",any"`
+}
+
+type Graphics struct {
+	XMLName  xml.Name   `xml:"graphics"`
+	Type     string     `xml:"type,attr"`
+	Port     string     `xml:"port,attr,omitempty"`
+	AutoPort string     `xml:"autoport,attr,omitempty"`
+	Listen   string     `xml:"listen,attr,omitempty"`
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Extra    []Node     `xml:",any"`
+}
+
+type Video struct {
+	XMLName xml.Name    `xml:"video"`
+	Model   *VideoModel `xml:"model"`
+	Attrs   []xml.Attr  `xml:",any,attr"`
+	Extra   []Node      `xml:",any"`
+}
+
+type VideoModel struct {
+	Type    string     `xml:"type,attr"`
+	RAM     string     `xml:"ram,attr,omitempty"`
+	VRAM    string     `xml:"vram,attr,omitempty"`
+	VGAMem  string     `xml:"vgamem,attr,omitempty"`
+	Heads   string     `xml:"heads,attr,omitempty"`
+	Primary string     `xml:"primary,attr,omitempty"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Extra   []Node     `xml:",any"`
+}
+
+type Input struct {
+	XMLName xml.Name   `xml:"input"`
+	Type    string     `xml:"type,attr"`
+	Bus     string     `xml:"bus,attr,omitempty"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Extra   []Node     `xml:",any"`
+}
+
+type Console struct {
+	XMLName xml.Name   `xml:"console"`
+	Type    string     `xml:"type,attr"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Extra   []Node     `xml:",any"`
+}
+
+// PTY returns the host pty libvirt allocated for a pty console of a
+// running domain, or "".
+func (c Console) PTY() string {
+	if c.Type != "pty" {
+		return ""
+	}
+	for _, a := range c.Attrs {
+		if a.Name.Local == "tty" {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// FileDisk returns a disk or cdrom backed by an image file. An empty path
+// gives an empty cdrom drive.
+func FileDisk(device, path, format, dev, bus string) Disk {
+	disk := Disk{
+		Type:   "file",
+		Device: device,
+		Driver: &DiskDriver{Name: "qemu", Type: format},
+		Target: &DiskTarget{Dev: dev, Bus: bus},
+	}
+	if path != "" {
+		disk.Source = &DiskSource{File: path}
+	}
+	if device == "cdrom" {
+		disk.ReadOnly = &Flag{}
+	}
+	return disk
+}
+
+// SourceFile returns the image file behind the disk, if any.
+func (d *Disk) SourceFile() string {
+	if d.Source == nil {
+		return ""
+	}
+	return d.Source.File
+}
+
+// NewInterface returns a NIC attached in mode "network" (libvirt virtual
+// network), "bridge" (host bridge) or "macvtap" (direct to a physical
+// device) to source.
+func NewInterface(mode, source, model string) (Interface, error) {
+	iface := Interface{Model: &Model{Type: model}}
+	switch mode {
+	case "network":
+		iface.Type, iface.Source = "network", &InterfaceSource{Network: source}
+	case "bridge":
+		iface.Type, iface.Source = "bridge", &InterfaceSource{Bridge: source}
+	case "macvtap":
+		iface.Type, iface.Source = "direct", &InterfaceSource{Dev: source, Mode: "bridge"}
+	default:
+		return Interface{}, fmt.Errorf("unsupported mode: %s", mode)
+	}
+	return iface, nil
+}
+
+// SourceName returns the network, bridge or device the NIC is attached to.
+func (i *Interface) SourceName() string {
+	if i.Source == nil {
+		return ""
+	}
+	switch {
+	case i.Source.Network != "":
+		return i.Source.Network
+	case i.Source.Bridge != "":
+		return i.Source.Bridge
+	}
+	return i.Source.Dev
+}
+
+// MACAddress returns the NIC's MAC address, if assigned.
+func (i *Interface) MACAddress() string {
+	if i.MAC == nil {
+		return ""
+	}
+	return i.MAC.Address
+}
+
+// ModelType returns the NIC model, if set.
+func (i *Interface) ModelType() string {
+	if i.Model == nil {
+		return ""
+	}
+	return i.Model.Type
+}
+
+// TargetDev returns the host-side device of a running domain's NIC, e.g.
+// vnet0; libvirt only sets it in the live XML.
+func (i *Interface) TargetDev() string {
+	for _, n := range i.Extra {
+		if n.XMLName.Local != "target" {
+			continue
+		}
+		for _, a := range n.Attrs {
+			if a.Name.Local == "dev" {
+				return a.Value
+			}
+		}
+	}
+	return ""
+}
+
+// FindDisk returns the disk with the given target device.
+func (d *Domain) FindDisk(target string) *Disk {
+	if d.Devices == nil {
+		return nil
+	}
+	for i := range d.Devices.Disks {
+		if t := d.Devices.Disks[i].Target; t != nil && t.Dev == target {
+			return &d.Devices.Disks[i]
+		}
+	}
+	return nil
+}
+
+// FindInterface returns the NIC with the given MAC address.
+func (d *Domain) FindInterface(mac string) *Interface {
+	if d.Devices == nil {
+		return nil
+	}
+	for i := range d.Devices.Interfaces {
+		if d.Devices.Interfaces[i].MACAddress() == mac {
+			return &d.Devices.Interfaces[i]
+		}
+	}
+	return nil
+}
+
+// Cdroms returns the cdrom drives in definition order.
+func (d *Domain) Cdroms() []*Disk {
+	if d.Devices == nil {
+		return nil
+	}
+	var cds []*Disk
+	for i := range d.Devices.Disks {
+		if d.Devices.Disks[i].Device == "cdrom" {
+			cds = append(cds, &d.Devices.Disks[i])
+		}
+	}
+	return cds
+}
@@ -0,0 +1,319 @@
+// Package domxml is a typed model of libvirt domain XML.
+//
+// Only the parts the panel edits are typed. Everything else - unknown
+// elements and attributes, vendor namespaces such as qemu:commandline -
+// is kept verbatim and written back by Marshal, so a definition read from
+// libvirt, changed and redefined loses nothing. Within a parent element,
+// unknown children are written after the typed ones; libvirt does not
+// depend on that order.
+package domxml
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// Node is an element the model does not know about, kept as-is.
+type Node struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   string     `xml:",innerxml"`
+}
+
+// Flag is an element whose presence is what matters, like <acpi/> or
+// <readonly/>.
+type Flag struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+	Extra []Node     `xml:",any"`
+}
+
+type Domain struct {
+	XMLName       xml.Name   `xml:"domain"`
+	Type          string     `xml:"type,attr,omitempty"`
+	ID            string     `xml:"id,attr,omitempty"`
+	Attrs         []xml.Attr `xml:",any,attr"`
+	Name          string     `xml:"name"`
+	UUID          string     `xml:"uuid,omitempty"`
+	Title         string     `xml:"title,omitempty"`
+	Description   string     `xml:"description,omitempty"`
+	Metadata      *Metadata  `xml:"metadata"`
+	Memory        *Memory    `xml:"memory"`
+	CurrentMemory *Memory    `xml:"currentMemory"`
+	VCPU          *VCPU      `xml:"vcpu"`
+	OS            *OS        `xml:"os"`
+	Features      *Features  `xml:"features"`
+	CPU           *CPU       `xml:"cpu"`
+	Clock         *Clock     `xml:"clock"`
+	OnPoweroff    string     `xml:"on_poweroff,omitempty"`
+	OnReboot      string     `xml:"on_reboot,omitempty"`
+	OnCrash       string     `xml:"on_crash,omitempty"`
+	Devices       *Devices   `xml:"devices"`
+	Extra         []Node     `xml:",any"`
+
+	namespaces map[string]string // namespace URI -> prefix declared on <domain>
+}
+
+// Metadata holds the application specific <metadata> children verbatim.
+// Each child carries its own namespace declaration.
+type Metadata struct {
+	Inner string `xml:",innerxml"`
+}
+
+type Memory struct {
+	Unit  string     `xml:"unit,attr,omitempty"`
+	Attrs []xml.Attr `xml:",any,attr"`
+	Value uint64     `xml:",chardata"`
+}
+
+type VCPU struct {
+	Placement string     `xml:"placement,attr,omitempty"`
+	Current   string     `xml:"current,attr,omitempty"`
+	Attrs     []xml.Attr `xml:",any,attr"`
+	Value     int        `xml:",chardata"`
+}
+
+type OS struct {
+	Type     OSType     `xml:"type"`
+	Loader   *Loader    `xml:"loader"`
+	Boot     []Boot     `xml:"boot"`
+	BootMenu *BootMenu  `xml:"bootmenu"`
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Extra    []Node     `xml:",any"`
+}
+
+type OSType struct {
+	Arch    string     `xml:"arch,attr,omitempty"`
+	Machine string     `xml:"machine,attr,omitempty"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Value   string     `xml:",chardata"`
+}
+
+type Loader struct {
+	Readonly string     `xml:"readonly,attr,omitempty"`
+	Type     string     `xml:"type,attr,omitempty"`
+	Secure   string     `xml:"secure,attr,omitempty"`
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Path     string     `xml:",chardata"`
+}
+
+type Boot struct {
+	Dev   string     `xml:"dev,attr"`
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+type BootMenu struct {
+	Enable  string     `xml:"enable,attr,omitempty"`
+	Timeout string     `xml:"timeout,attr,omitempty"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+}
+
+type Features struct {
+	ACPI  *Flag      `xml:"acpi"`
+	APIC  *Flag      `xml:"apic"`
+	PAE   *Flag      `xml:"pae"`
+	Attrs []xml.Attr `xml:",any,attr"`
+	Extra []Node     `xml:",any"` // hyperv, kvm, vmport, smm, ...
+}
+
+type CPU struct {
+	Mode       string     `xml:"mode,attr,omitempty"`
+	Match      string     `xml:"match,attr,omitempty"`
+	Check      string     `xml:"check,attr,omitempty"`
+	Migratable string     `xml:"migratable,attr,omitempty"`
+	Attrs      []xml.Attr `xml:",any,attr"`
+	Model      *CPUModel  `xml:"model"`
+	Vendor     string     `xml:"vendor,omitempty"`
+	Topology   *Topology  `xml:"topology"`
+	Extra      []Node     `xml:",any"` // feature, numa, cache, ...
+}
+
+type CPUModel struct {
+	Fallback string     `xml:"fallback,attr,omitempty"`
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Name     string     `xml:",chardata"`
+}
+
+type Topology struct {
+	Sockets int        `xml:"sockets,attr,omitempty"`
+	Dies    int        `xml:"dies,attr,omitempty"`
+	Cores   int        `xml:"cores,attr,omitempty"`
+	Threads int        `xml:"threads,attr,omitempty"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+}
+
+type Clock struct {
+	Offset string     `xml:"offset,attr,omitempty"`
+	Attrs  []xml.Attr `xml:",any,attr"`
+	Timers []Timer    `xml:"timer"`
+	Extra  []Node     `xml:",any"`
+}
+
+type Timer struct {
+	Name       string     `xml:"name,attr"`
+	TickPolicy string     `xml:"tickpolicy,attr,omitempty"`
+	Present    string     `xml:"present,attr,omitempty"`
+	Attrs      []xml.Attr `xml:",any,attr"`
+	Extra      []Node     `xml:",any"`
+}
+
+// Parse decodes a domain definition.
+func Parse(s string) (*Domain, error) {
+	var d Domain
+	if err := xml.Unmarshal([]byte(s), &d); err != nil {
+		return nil, err
+	}
+	// encoding/xml cannot write prefixed names back, so namespace
+	// declarations are tracked separately and restored by Marshal.
+	attrs := d.Attrs[:0]
+	for _, a := range d.Attrs {
+		if a.Name.Space == "xmlns" {
+			if d.namespaces == nil {
+				d.namespaces = make(map[string]string)
+			}
+			d.namespaces[a.Value] = a.Name.Local
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	d.Attrs = attrs
+	return &d, nil
+}
+
+// Marshal encodes the definition for DomainDefineXML.
+func (d *Domain) Marshal() (string, error) {
+	out := *d
+	out.Attrs = append([]xml.Attr{}, d.Attrs...)
+	for uri, prefix := range d.namespaces {
+		out.Attrs = append(out.Attrs, xml.Attr{Name: xml.Name{Local: "xmlns:" + prefix}, Value: uri})
+	}
+	out.Extra = make([]Node, len(d.Extra))
+	for i, n := range d.Extra {
+		if prefix, ok := d.namespaces[n.XMLName.Space]; ok {
+			n.XMLName = xml.Name{Local: prefix + ":" + n.XMLName.Local}
+		}
+		out.Extra[i] = n
+	}
+	b, err := xml.MarshalIndent(&out, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// MarshalDevice encodes a single device (Disk, Interface, ...) for the
+// attach, detach and update device calls.
+func MarshalDevice(dev any) (string, error) {
+	b, err := xml.MarshalIndent(dev, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// MemoryMiB returns the maximum memory in MiB.
+func (d *Domain) MemoryMiB() int {
+	if d.Memory == nil {
+		return 0
+	}
+	return d.Memory.MiB()
+}
+
+// SetMemoryMiB sets both maximum and current memory.
+func (d *Domain) SetMemoryMiB(mib int) {
+	kib := uint64(mib) * 1024
+	if d.Memory == nil {
+		d.Memory = &Memory{}
+	}
+	if d.CurrentMemory == nil {
+		d.CurrentMemory = &Memory{}
+	}
+	d.Memory.Unit, d.Memory.Value = "KiB", kib
+	d.CurrentMemory.Unit, d.CurrentMemory.Value = "KiB", kib
+}
+
+// MiB converts the value to MiB according to its unit.
+func (m *Memory) MiB() int {
+	v := m.Value
+	switch m.Unit {
+	case "b", "bytes":
+		return int(v / 1024 / 1024)
+	case "KB":
+		return int(v * 1000 / 1024 / 1024)
+	case "k", "KiB", "":
+		return int(v / 1024)
+	case "MB":
+		return int(v * 1000 * 1000 / 1024 / 1024)
+	case "M", "MiB":
+		return int(v)
+	case "GB":
+		return int(v * 1000 * 1000 * 1000 / 1024 / 1024)
+	case "G", "GiB":
+		return int(v * 1024)
+	case "T", "TiB":
+		return int(v * 1024 * 1024)
+	}
+	return int(v / 1024)
+}
+
+// VCPUs returns the maximum vCPU count.
+func (d *Domain) VCPUs() int {
+	if d.VCPU == nil {
+		return 0
+	}
+	return d.VCPU.Value
+}
+
+// SetVCPUs sets the maximum vCPU count. A current count above the new
+// maximum is dropped.
+func (d *Domain) SetVCPUs(n int) {
+	if d.VCPU == nil {
+		d.VCPU = &VCPU{}
+	}
+	d.VCPU.Value = n
+	if cur, err := strconv.Atoi(d.VCPU.Current); err == nil && cur > n {
+		d.VCPU.Current = ""
+	}
+}
+
+// BootOrder returns the <os><boot> devices in order.
+func (d *Domain) BootOrder() []string {
+	if d.OS == nil {
+		return nil
+	}
+	devs := make([]string, 0, len(d.OS.Boot))
+	for _, b := range d.OS.Boot {
+		devs = append(devs, b.Dev)
+	}
+	return devs
+}
+
+// HasBoot reports whether dev is in the boot order.
+func (d *Domain) HasBoot(dev string) bool {
+	for _, b := range d.BootOrder() {
+		if b == dev {
+			return true
+		}
+	}
+	return false
+}
+
+// BootFirst moves dev to the front of the boot order, adding it if it
+// is missing.
+func (d *Domain) BootFirst(dev string) {
+	if d.OS == nil {
+		d.OS = &OS{Type: OSType{Value: "hvm"}}
+	}
+	boots := []Boot{{Dev: dev}}
+	for _, b := range d.OS.Boot {
+		if b.Dev != dev {
+			boots = append(boots, b)
+		}
+	}
+	d.OS.Boot = boots
+}
+
+// IsQ35 reports whether the machine type is q35, which has no IDE bus.
+func (d *Domain) IsQ35() bool {
+	return d.OS != nil && strings.Contains(d.OS.Type.Machine, "q35")
+}
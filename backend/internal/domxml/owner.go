@@ -0,0 +1,37 @@
+package domxml
+
+import "encoding/xml"
+
+// OwnerNS is the namespace of the panel's ownership record, kept as
+// <owner user="..." group="..."/> in the domain's <metadata>.
+const OwnerNS = "http://virtpanel.io/xmlns/owner/1.0"
+
+// OwnerPrefix is the namespace prefix libvirt writes the record with.
+const OwnerPrefix = "virtpanel"
+
+type owner struct {
+	XMLName xml.Name `xml:"owner"`
+	User    string   `xml:"user,attr"`
+	Group   string   `xml:"group,attr"`
+}
+
+// Owner returns the user and group recorded in <metadata>, if any.
+func (d *Domain) Owner() (user, group string) {
+	if d.Metadata == nil {
+		return "", ""
+	}
+	var md struct {
+		Owner *owner `xml:"http://virtpanel.io/xmlns/owner/1.0 owner"`
+	}
+	if xml.Unmarshal([]byte("<metadata>"+d.Metadata.Inner+"</metadata>"), &md) != nil || md.Owner == nil {
+		return "", ""
+	}
+	return md.Owner.User, md.Owner.Group
+}
+
+// OwnerElement returns the ownership record in the form DomainSetMetadata
+// expects: without namespace, which libvirt adds from OwnerNS.
+func OwnerElement(user, group string) string {
+	b, _ := xml.Marshal(owner{User: user, Group: group})
+	return string(b)
+}
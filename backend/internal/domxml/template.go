@@ -0,0 +1,24 @@
+package domxml
+
+import "encoding/xml"
+
+// TemplateNS is the namespace of the template mark, kept as <template/>
+// in the domain's <metadata>.
+const TemplateNS = "http://virtpanel.io/xmlns/template/1.0"
+
+// TemplatePrefix is the namespace prefix libvirt writes the mark with.
+const TemplatePrefix = "virtpanel-template"
+
+// TemplateElement is the mark in the form DomainSetMetadata expects.
+const TemplateElement = "<template/>"
+
+// IsTemplate reports whether the domain is marked as a template.
+func (d *Domain) IsTemplate() bool {
+	if d.Metadata == nil {
+		return false
+	}
+	var md struct {
+		Template *struct{} `xml:"http://virtpanel.io/xmlns/template/1.0 template"`
+	}
+	return xml.Unmarshal([]byte("<metadata>"+d.Metadata.Inner+"</metadata>"), &md) == nil && md.Template != nil
+}
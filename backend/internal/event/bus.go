@@ -0,0 +1,105 @@
+// Package event fans out hypervisor events to any number of subscribers
+// (SSE and WebSocket clients).
+package event
+
+import (
+	"strings"
+	"sync"
+
+	"virtpanel/internal/model"
+)
+
+// subscriberBuffer is how many events a slow subscriber may lag behind
+// before further events are dropped for it.
+const subscriberBuffer = 64
+
+// Filter selects events. Empty fields match everything.
+type Filter struct {
+	Host  string
+	Kinds map[string]bool
+	Names map[string]bool
+}
+
+// ParseFilter builds a filter from comma-separated kind and name lists,
+// as given in the ?kind= and ?name= query parameters.
+func ParseFilter(host, kinds, names string) Filter {
+	return Filter{Host: host, Kinds: splitSet(kinds), Names: splitSet(names)}
+}
+
+func splitSet(v string) map[string]bool {
+	var set map[string]bool
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			if set == nil {
+				set = make(map[string]bool)
+			}
+			set[p] = true
+		}
+	}
+	return set
+}
+
+// Match reports whether e passes the filter.
+func (f Filter) Match(e model.Event) bool {
+	if f.Host != "" && e.Host != f.Host {
+		return false
+	}
+	if f.Kinds != nil && !f.Kinds[e.Kind] {
+		return false
+	}
+	if f.Names != nil && !f.Names[e.Name] {
+		return false
+	}
+	return true
+}
+
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish delivers e to every matching subscriber without blocking.
+func (b *Bus) Publish(e model.Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default: // subscriber is not keeping up
+		}
+	}
+}
+
+// Subscribe registers a new subscriber. Call Close when done.
+func (b *Bus) Subscribe(f Filter) *Subscription {
+	s := &Subscription{bus: b, filter: f, ch: make(chan model.Event, subscriberBuffer)}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+type Subscription struct {
+	bus    *Bus
+	filter Filter
+	ch     chan model.Event
+	once   sync.Once
+}
+
+// C returns the channel events are delivered on.
+func (s *Subscription) C() <-chan model.Event { return s.ch }
+
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+	})
+}
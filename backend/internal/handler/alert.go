@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"virtpanel/internal/alert"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListAlerts returns the pending and firing alerts.
+func (h *Handler) ListAlerts(c *gin.Context) {
+	c.JSON(http.StatusOK, h.alerts.Active())
+}
+
+// AlertHistory returns resolved alerts, newest first (?limit=, default
+// 100).
+func (h *Handler) AlertHistory(c *gin.Context) {
+	limit := 100
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			fail(c, badRequest("invalid limit"))
+			return
+		}
+		limit = n
+	}
+	c.JSON(http.StatusOK, h.alerts.History(limit))
+}
+
+func (h *Handler) ListAlertRules(c *gin.Context) {
+	c.JSON(http.StatusOK, h.alerts.Rules())
+}
+
+func (h *Handler) CreateAlertRule(c *gin.Context) {
+	var req alert.Rule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	r, err := h.alerts.AddRule(req)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, r)
+}
+
+// UpdateAlertRule replaces a rule; its firing alerts resolve and are
+// evaluated again.
+func (h *Handler) UpdateAlertRule(c *gin.Context) {
+	var req alert.Rule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	r, err := h.alerts.UpdateRule(c.Param("id"), req)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+func (h *Handler) DeleteAlertRule(c *gin.Context) {
+	if err := h.alerts.DeleteRule(c.Param("id")); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+func (h *Handler) ListAlertChannels(c *gin.Context) {
+	c.JSON(http.StatusOK, h.alerts.Channels())
+}
+
+func (h *Handler) CreateAlertChannel(c *gin.Context) {
+	var req alert.Channel
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	ch, err := h.alerts.AddChannel(req)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, ch)
+}
+
+func (h *Handler) UpdateAlertChannel(c *gin.Context) {
+	var req alert.Channel
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	ch, err := h.alerts.UpdateChannel(c.Param("id"), req)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ch)
+}
+
+func (h *Handler) DeleteAlertChannel(c *gin.Context) {
+	if err := h.alerts.DeleteChannel(c.Param("id")); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// TestAlertChannel sends a test notification through a channel and
+// reports delivery errors as 502.
+func (h *Handler) TestAlertChannel(c *gin.Context) {
+	err := h.alerts.TestChannel(c.Request.Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, alert.ErrChannelNotFound):
+		fail(c, err)
+	case err != nil:
+		fail(c, deliveryFailed(err))
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "sent"})
+	}
+}
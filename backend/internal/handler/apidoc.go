@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"virtpanel/internal/alert"
+	"virtpanel/internal/audit"
+	"virtpanel/internal/auth"
+	"virtpanel/internal/model"
+	"virtpanel/internal/openapi"
+	"virtpanel/internal/service"
+	"virtpanel/internal/task"
+	"virtpanel/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIRoutes documents every route registered in cmd/main.go; the server
+// logs routes missing here at startup. The OpenAPI document and the Go
+// client in virtpanel/client are generated from it.
+var APIRoutes = []openapi.Route{
+	{Method: "GET", Path: "/api/openapi.json", ID: "GetOpenAPI", Summary: "OpenAPI document of this API", Tag: "meta", Public: true, Stream: "application/json"},
+
+	// Accounts
+	{Method: "GET", Path: "/api/auth/status", ID: "AuthStatus", Summary: "Login state and whether the first account must be created", Tag: "auth", Public: true, Result: model.AuthStatus{}},
+	{Method: "POST", Path: "/api/auth/setup", ID: "Setup", Summary: "Create the first account with the setup token", Tag: "auth", Public: true, Body: model.SetupRequest{}, Result: model.LoginResult{}},
+	{Method: "POST", Path: "/api/auth/login", ID: "Login", Summary: "Log in and receive a session cookie", Tag: "auth", Public: true, Body: model.LoginRequest{}, Result: model.LoginResult{}},
+	{Method: "POST", Path: "/api/auth/logout", ID: "Logout", Summary: "End the session", Tag: "auth", Public: true, Result: model.Message{}},
+	{Method: "GET", Path: "/api/users", ID: "ListUsers", Summary: "List accounts", Tag: "users", Result: []auth.UserInfo{}},
+	{Method: "POST", Path: "/api/users", ID: "CreateUser", Summary: "Add an account", Tag: "users", Body: model.CreateUserRequest{}, Result: model.Message{}, Status: http.StatusCreated},
+	{Method: "PUT", Path: "/api/users/:user", ID: "UpdateUser", Summary: "Change the role and groups of an account", Tag: "users", Body: model.UpdateUserRequest{}, Result: model.Message{}},
+	{Method: "DELETE", Path: "/api/users/:user", ID: "DeleteUser", Summary: "Delete an account", Tag: "users", Result: model.Message{}},
+	{Method: "PUT", Path: "/api/users/:user/password", ID: "SetPassword", Summary: "Change a password", Tag: "users", Body: model.SetPasswordRequest{}, Result: model.Message{}},
+	{Method: "GET", Path: "/api/tokens", ID: "ListTokens", Summary: "List the caller's API tokens", Tag: "tokens", Result: []auth.TokenInfo{},
+		Query: []openapi.Param{{Name: "all", Type: "boolean", Doc: "every user's tokens (admins)"}}},
+	{Method: "POST", Path: "/api/tokens", ID: "CreateToken", Summary: "Create an API token; the secret is only in this response", Tag: "tokens", Body: model.CreateTokenRequest{}, Result: auth.NewToken{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: "/api/tokens/:id", ID: "RevokeToken", Summary: "Revoke an API token", Tag: "tokens", Result: model.Message{}},
+
+	// Audit log
+	{Method: "GET", Path: "/api/audit", ID: "ListAudit", Summary: "Query the audit log, newest first", Tag: "audit", Result: []audit.Entry{},
+		Query: append(auditParams, openapi.Param{Name: "limit", Type: "integer", Doc: "maximum entries, default 200"})},
+	{Method: "GET", Path: "/api/audit/export", ID: "ExportAudit", Summary: "Export the audit log as JSON Lines", Tag: "audit", Stream: "application/x-ndjson", Query: auditParams},
+
+	// Alerts
+	{Method: "GET", Path: "/api/alerts", ID: "ListAlerts", Summary: "Pending and firing alerts", Tag: "alerts", Result: []alert.Alert{}},
+	{Method: "GET", Path: "/api/alerts/history", ID: "AlertHistory", Summary: "Resolved alerts, newest first", Tag: "alerts", Result: []alert.Alert{},
+		Query: []openapi.Param{{Name: "limit", Type: "integer", Doc: "maximum entries, default 100"}}},
+	{Method: "GET", Path: "/api/alerts/rules", ID: "ListAlertRules", Summary: "List alert rules", Tag: "alerts", Result: []alert.Rule{}},
+	{Method: "POST", Path: "/api/alerts/rules", ID: "CreateAlertRule", Summary: "Create an alert rule", Tag: "alerts", Body: alert.Rule{}, Result: alert.Rule{}, Status: http.StatusCreated},
+	{Method: "PUT", Path: "/api/alerts/rules/:id", ID: "UpdateAlertRule", Summary: "Replace an alert rule", Tag: "alerts", Body: alert.Rule{}, Result: alert.Rule{}},
+	{Method: "DELETE", Path: "/api/alerts/rules/:id", ID: "DeleteAlertRule", Summary: "Delete an alert rule", Tag: "alerts", Result: model.Message{}},
+	{Method: "GET", Path: "/api/alerts/channels", ID: "ListAlertChannels", Summary: "List notification channels", Tag: "alerts", Result: []alert.Channel{}},
+	{Method: "POST", Path: "/api/alerts/channels", ID: "CreateAlertChannel", Summary: "Create a notification channel", Tag: "alerts", Body: alert.Channel{}, Result: alert.Channel{}, Status: http.StatusCreated},
+	{Method: "PUT", Path: "/api/alerts/channels/:id", ID: "UpdateAlertChannel", Summary: "Replace a notification channel", Tag: "alerts", Body: alert.Channel{}, Result: alert.Channel{}},
+	{Method: "DELETE", Path: "/api/alerts/channels/:id", ID: "DeleteAlertChannel", Summary: "Delete a notification channel no rule uses", Tag: "alerts", Result: model.Message{}},
+	{Method: "POST", Path: "/api/alerts/channels/:id/test", ID: "TestAlertChannel", Summary: "Send a test notification", Tag: "alerts", Result: model.Message{}},
+
+	// Webhooks
+	{Method: "GET", Path: "/api/webhooks", ID: "ListWebhooks", Summary: "List webhooks (secrets omitted)", Tag: "webhooks", Result: []webhook.Webhook{}},
+	{Method: "POST", Path: "/api/webhooks", ID: "CreateWebhook", Summary: "Register a webhook; the secret is only in this response", Tag: "webhooks", Body: webhook.Webhook{}, Result: webhook.Webhook{}, Status: http.StatusCreated},
+	{Method: "PUT", Path: "/api/webhooks/:id", ID: "UpdateWebhook", Summary: "Replace a webhook; an empty secret keeps the current one", Tag: "webhooks", Body: webhook.Webhook{}, Result: webhook.Webhook{}},
+	{Method: "DELETE", Path: "/api/webhooks/:id", ID: "DeleteWebhook", Summary: "Delete a webhook and its delivery log", Tag: "webhooks", Result: model.Message{}},
+	{Method: "GET", Path: "/api/webhooks/:id/deliveries", ID: "ListWebhookDeliveries", Summary: "Recent deliveries of a webhook, newest first", Tag: "webhooks", Result: []webhook.Delivery{}},
+	{Method: "POST", Path: "/api/webhooks/:id/test", ID: "TestWebhook", Summary: "Send a test event", Tag: "webhooks", Result: webhook.Delivery{}},
+
+	// Hosts
+	{Method: "GET", Path: "/api/hosts", ID: "ListHosts", Summary: "List libvirt hosts", Tag: "hosts", Result: []model.Host{}},
+	{Method: "POST", Path: "/api/hosts", ID: "AddHost", Summary: "Register a libvirt host", Tag: "hosts", Body: model.AddHostRequest{}, Result: model.Host{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: "/api/hosts/:host", ID: "DeleteHost", Summary: "Remove a registered host", Tag: "hosts", Result: model.Message{}},
+	{Method: "POST", Path: "/api/hosts/:host/check", ID: "CheckHost", Summary: "Check a host's connection now", Tag: "hosts", Result: model.Host{}},
+	{Method: "GET", Path: "/api/hosts/overview", ID: "HostsOverview", Summary: "Hosts with their resource summary", Tag: "hosts", Result: []model.HostOverview{}},
+	{Method: "GET", Path: "/api/hosts/vms", ID: "ListAllVMs", Summary: "VMs of every connected host", Tag: "hosts", Result: model.HostVMs{}},
+	{Method: "GET", Path: "/api/host/info", ID: "GetHostInfo", Summary: "Resources of the selected host", Tag: "hosts", Result: model.HostInfo{}},
+	{Method: "GET", Path: "/api/host/stats", ID: "GetHostStats", Summary: "CPU, memory and load history of the selected host", Tag: "hosts", Result: model.StatsSeries{}, Query: statsParams},
+	{Method: "GET", Path: "/api/host/nics", ID: "ListPhysicalNICs", Summary: "Physical interfaces of the panel's host", Tag: "hosts", Result: []model.PhysicalNIC{}},
+	{Method: "GET", Path: "/api/settings", ID: "GetSettings", Summary: "Effective backend configuration", Tag: "meta", Result: settingsInfo{}},
+
+	// Tasks and events
+	{Method: "GET", Path: "/api/tasks", ID: "ListTasks", Summary: "List running and recent tasks", Tag: "tasks", Result: []task.Task{}},
+	{Method: "GET", Path: "/api/tasks/:id", ID: "GetTask", Summary: "Get a task", Tag: "tasks", Result: task.Task{}},
+	{Method: "POST", Path: "/api/tasks/:id/cancel", ID: "CancelTask", Summary: "Cancel a running task", Tag: "tasks", Result: model.Message{}},
+	{Method: "GET", Path: "/api/events", ID: "Events", Summary: "Lifecycle events as server-sent events (model.Event)", Tag: "events", Stream: "text/event-stream", Query: eventParams},
+
+	// VMs
+	{Method: "GET", Path: "/api/vms", ID: "ListVMs", Summary: "List VMs", Tag: "vms", Result: []model.VM{}},
+	{Method: "GET", Path: "/api/vms/:name", ID: "GetVM", Summary: "Get a VM", Tag: "vms", Result: model.VM{}},
+	{Method: "GET", Path: "/api/vms/:name/detail", ID: "GetVMDetail", Summary: "Disks, NICs and settings of a VM", Tag: "vms", Result: model.VMDetail{}},
+	{Method: "GET", Path: "/api/vms/:name/stats", ID: "GetVMStats", Summary: "CPU, memory, disk and network history of a VM", Tag: "vms", Result: model.StatsSeries{}, Query: statsParams},
+	{Method: "POST", Path: "/api/vms", ID: "CreateVM", Summary: "Create a VM (task)", Tag: "vms", Body: model.CreateVMRequest{}, Result: model.TaskAccepted{}, Status: http.StatusAccepted},
+	{Method: "PUT", Path: "/api/vms/:name", ID: "UpdateVM", Summary: "Change CPU, memory and boot settings", Tag: "vms", Body: model.UpdateVMRequest{}, Result: model.Message{}},
+	{Method: "GET", Path: "/api/vms/:name/xml", ID: "GetVMXML", Summary: "Domain XML", Tag: "vms", Result: model.VMXML{},
+		Query: []openapi.Param{{Name: "inactive", Type: "boolean", Doc: "persistent configuration instead of the live one"}}},
+	{Method: "PUT", Path: "/api/vms/:name/xml", ID: "UpdateVMXML", Summary: "Replace the domain XML", Tag: "vms", Body: model.UpdateVMXMLRequest{}, Result: model.VMXMLResult{},
+		Query: []openapi.Param{{Name: "dry_run", Type: "boolean", Doc: "only validate and return the diff"}}},
+	{Method: "PUT", Path: "/api/vms/:name/owner", ID: "SetVMOwner", Summary: "Hand a VM to another user or group", Tag: "vms", Body: model.VMOwner{}, Result: model.Message{}},
+	{Method: "DELETE", Path: "/api/vms/:name", ID: "DeleteVM", Summary: "Delete a VM", Tag: "vms", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/start", ID: "StartVM", Summary: "Start a VM", Tag: "vms", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/shutdown", ID: "ShutdownVM", Summary: "Shut a VM down gracefully", Tag: "vms", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/destroy", ID: "DestroyVM", Summary: "Force a VM off", Tag: "vms", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/reboot", ID: "RebootVM", Summary: "Reboot a VM", Tag: "vms", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/suspend", ID: "SuspendVM", Summary: "Pause a VM", Tag: "vms", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/resume", ID: "ResumeVM", Summary: "Resume a paused VM", Tag: "vms", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/clone", ID: "CloneVM", Summary: "Clone a VM (task)", Tag: "vms", Body: model.CloneVMRequest{}, Result: model.TaskAccepted{}, Status: http.StatusAccepted},
+	{Method: "POST", Path: "/api/vms/:name/flatten", ID: "FlattenVM", Summary: "Copy the template data into a linked clone's disks (task)", Tag: "vms", Result: model.TaskAccepted{}, Status: http.StatusAccepted},
+	{Method: "GET", Path: "/api/vms/:name/autostart", ID: "GetAutostart", Summary: "Whether the VM starts with the host", Tag: "vms", Result: model.Autostart{}},
+	{Method: "PUT", Path: "/api/vms/:name/autostart", ID: "SetAutostart", Summary: "Set autostart", Tag: "vms", Body: model.Autostart{}, Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/rename", ID: "RenameVM", Summary: "Rename a VM", Tag: "vms", Body: model.RenameVMRequest{}, Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/import", ID: "ImportVM", Summary: "Define a VM from existing disks", Tag: "vms", Body: model.ImportVMRequest{}, Result: model.Message{}},
+	{Method: "GET", Path: "/api/vms/:name/export", ID: "ExportVM", Summary: "Export a shut-off VM as an OVA (local host only)", Tag: "vms", Stream: "application/x-tar",
+		Query: []openapi.Param{{Name: "format", Type: "string", Doc: "ova, the default and only format"}}},
+	{Method: "POST", Path: "/api/vms/import-ova", ID: "ImportOVA", Summary: "Create a VM from an uploaded OVA (task, local host only)", Tag: "vms", Upload: "file", Result: model.TaskAccepted{}, Status: http.StatusAccepted,
+		Query: []openapi.Param{
+			{Name: "name", Type: "string", Doc: "name of the new VM, required"},
+			{Name: "pool", Type: "string", Doc: "directory pool for the disks, default the one of image_dir"},
+			{Name: "format", Type: "string", Doc: "qcow2 (default) or raw"},
+			{Name: "disk_bus", Type: "string", Doc: "virtio, sata, scsi or ide; default keeps IDE disks on IDE and puts the others on SATA"},
+			{Name: "net_model", Type: "string", Doc: "virtio, e1000 or rtl8139; default keeps virtio and makes the others e1000"},
+		}},
+	{Method: "POST", Path: "/api/vms/batch", ID: "BatchAction", Summary: "Start, shut down, destroy or delete several VMs", Tag: "vms", Body: model.BatchActionRequest{}, Result: model.BatchResult{}},
+	{Method: "PUT", Path: "/api/vms/:name/template", ID: "SetTemplate", Summary: "Mark a shut-off VM as a template or unmark it", Tag: "vms", Body: model.VMTemplate{}, Result: model.Message{}},
+
+	// Templates
+	{Method: "GET", Path: "/api/templates", ID: "ListTemplates", Summary: "List templates", Tag: "templates", Result: []model.VM{}},
+	{Method: "POST", Path: "/api/templates/:name/vms", ID: "CreateFromTemplate", Summary: "Create a VM from a template (task)", Tag: "templates", Body: model.CreateFromTemplateRequest{}, Result: model.TaskAccepted{}, Status: http.StatusAccepted},
+
+	// VM devices
+	{Method: "POST", Path: "/api/vms/:name/disks", ID: "AttachDisk", Summary: "Attach a disk", Tag: "devices", Body: model.AttachDiskRequest{}, Result: model.Message{}},
+	{Method: "DELETE", Path: "/api/vms/:name/disks/:target", ID: "DetachDisk", Summary: "Detach a disk", Tag: "devices", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/nics", ID: "AttachNIC", Summary: "Attach a network interface", Tag: "devices", Body: model.AttachNICRequest{}, Result: model.Message{}},
+	{Method: "DELETE", Path: "/api/vms/:name/nics/:mac", ID: "DetachNIC", Summary: "Detach a network interface", Tag: "devices", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/iso", ID: "AttachISO", Summary: "Insert an ISO into the CD-ROM", Tag: "devices", Body: model.AttachISORequest{}, Result: model.Message{}},
+	{Method: "DELETE", Path: "/api/vms/:name/iso", ID: "DetachISO", Summary: "Eject the CD-ROM", Tag: "devices", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/finish-install", ID: "FinishInstall", Summary: "Eject the install media and boot from disk", Tag: "devices", Result: model.Message{}},
+	{Method: "GET", Path: "/api/vms/:name/vnc", ID: "GetVNCPort", Summary: "VNC port of a running VM", Tag: "devices", Result: model.VNCPort{}},
+
+	// Snapshots
+	{Method: "GET", Path: "/api/vms/:name/snapshots", ID: "ListSnapshots", Summary: "List snapshots", Tag: "snapshots", Result: []model.Snapshot{}},
+	{Method: "POST", Path: "/api/vms/:name/snapshots", ID: "CreateSnapshot", Summary: "Create a snapshot", Tag: "snapshots", Body: model.CreateSnapshotRequest{}, Result: model.Message{}},
+	{Method: "DELETE", Path: "/api/vms/:name/snapshots/:snap", ID: "DeleteSnapshot", Summary: "Delete a snapshot", Tag: "snapshots", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/snapshots/:snap/revert", ID: "RevertSnapshot", Summary: "Revert a VM to a snapshot", Tag: "snapshots", Result: model.Message{}},
+	{Method: "POST", Path: "/api/vms/:name/snapshots/:snap/revert-to-new", ID: "RevertSnapshotToNew", Summary: "Create a new VM from a snapshot (task)", Tag: "snapshots",
+		Body: model.RevertSnapshotToNewRequest{}, Result: model.TaskAccepted{}, Status: http.StatusAccepted},
+
+	// Networks
+	{Method: "GET", Path: "/api/networks", ID: "ListNetworks", Summary: "List virtual networks", Tag: "networks", Result: []model.Network{}},
+	{Method: "POST", Path: "/api/networks", ID: "CreateNetwork", Summary: "Create a virtual network", Tag: "networks", Body: model.CreateNetworkRequest{}, Result: model.Message{}},
+	{Method: "POST", Path: "/api/networks/:name/start", ID: "StartNetwork", Summary: "Start a network", Tag: "networks", Result: model.Message{}},
+	{Method: "POST", Path: "/api/networks/:name/stop", ID: "StopNetwork", Summary: "Stop a network", Tag: "networks", Result: model.Message{}},
+	{Method: "DELETE", Path: "/api/networks/:name", ID: "DeleteNetwork", Summary: "Delete a network", Tag: "networks", Result: model.Message{}},
+	{Method: "GET", Path: "/api/networks/:name/leases", ID: "ListDHCPLeases", Summary: "DHCP leases of a network", Tag: "networks", Result: []service.DHCPLease{}},
+
+	// Storage
+	{Method: "GET", Path: "/api/storage-pools", ID: "ListStoragePools", Summary: "List storage pools", Tag: "storage", Result: []model.StoragePool{}},
+	{Method: "POST", Path: "/api/storage-pools", ID: "CreateStoragePool", Summary: "Create a directory storage pool", Tag: "storage", Body: model.CreateStoragePoolRequest{}, Result: model.Message{}},
+	{Method: "POST", Path: "/api/storage-pools/:name/start", ID: "StartStoragePool", Summary: "Start a storage pool", Tag: "storage", Result: model.Message{}},
+	{Method: "POST", Path: "/api/storage-pools/:name/stop", ID: "StopStoragePool", Summary: "Stop a storage pool", Tag: "storage", Result: model.Message{}},
+	{Method: "DELETE", Path: "/api/storage-pools/:name", ID: "DeleteStoragePool", Summary: "Delete a storage pool", Tag: "storage", Result: model.Message{}},
+	{Method: "GET", Path: "/api/storage-pools/:name/volumes", ID: "ListVolumes", Summary: "List the volumes of a pool", Tag: "storage", Result: []model.StorageVolume{}},
+	{Method: "POST", Path: "/api/storage-volumes", ID: "CreateVolume", Summary: "Create a volume", Tag: "storage", Body: model.CreateVolumeRequest{}, Result: model.Message{}},
+	{Method: "DELETE", Path: "/api/storage-pools/:name/volumes/:vol", ID: "DeleteVolume", Summary: "Delete a volume", Tag: "storage", Result: model.Message{}},
+
+	// ISOs
+	{Method: "GET", Path: "/api/isos", ID: "ListISOs", Summary: "List ISO images", Tag: "isos", Result: []model.ISOFile{}},
+	{Method: "POST", Path: "/api/isos/upload", ID: "UploadISO", Summary: "Upload an ISO image (task)", Tag: "isos", Upload: "file", Result: model.TaskAccepted{}, Status: http.StatusAccepted},
+	{Method: "DELETE", Path: "/api/isos/:name", ID: "DeleteISO", Summary: "Delete an ISO image", Tag: "isos", Result: model.Message{}},
+
+	// Bridges and port forwards
+	{Method: "GET", Path: "/api/bridges", ID: "ListBridges", Summary: "List host bridges", Tag: "bridges", Result: []model.Bridge{}},
+	{Method: "POST", Path: "/api/bridges", ID: "CreateBridge", Summary: "Create a host bridge", Tag: "bridges", Body: model.CreateBridgeRequest{}, Result: model.Message{}},
+	{Method: "DELETE", Path: "/api/bridges/:name", ID: "DeleteBridge", Summary: "Delete a host bridge", Tag: "bridges", Result: model.Message{}},
+	{Method: "GET", Path: "/api/port-forwards", ID: "ListPortForwards", Summary: "List port forwards", Tag: "port-forwards", Result: []service.PortForward{}},
+	{Method: "POST", Path: "/api/port-forwards", ID: "AddPortForward", Summary: "Add a port forward", Tag: "port-forwards", Body: service.PortForward{}, Result: model.Message{}},
+	{Method: "DELETE", Path: "/api/port-forwards/:id", ID: "DeletePortForward", Summary: "Delete a port forward", Tag: "port-forwards", Result: model.Message{}},
+
+	// WebSockets
+	{Method: "GET", Path: "/ws/vnc/:name", ID: "VNCWebSocket", Summary: "VNC console of a VM (binary WebSocket)", Tag: "devices", WebSocket: true},
+	{Method: "GET", Path: "/ws/console/:name", ID: "ConsoleWebSocket", Summary: "Serial console of a running VM on the panel's host (binary WebSocket)", Tag: "devices", WebSocket: true},
+	{Method: "GET", Path: "/ws/events", ID: "EventsWebSocket", Summary: "Lifecycle events as WebSocket JSON messages (model.Event)", Tag: "events", WebSocket: true, Query: eventParams},
+
+	// Prometheus
+	{Method: "GET", Path: "/metrics", ID: "Metrics", Summary: "Host, VM and backend metrics in the Prometheus text format", Tag: "meta", Stream: "text/plain"},
+}
+
+var auditParams = []openapi.Param{
+	{Name: "actor", Type: "string", Doc: "user"},
+	{Name: "kind", Type: "string", Doc: "object kind, e.g. vms"},
+	{Name: "object", Type: "string", Doc: "object name"},
+	{Name: "since", Type: "string", Doc: "unix seconds or RFC 3339"},
+	{Name: "until", Type: "string", Doc: "unix seconds or RFC 3339"},
+}
+
+var statsParams = []openapi.Param{
+	{Name: "from", Type: "string", Doc: "unix seconds or RFC 3339, default one hour before to"},
+	{Name: "to", Type: "string", Doc: "unix seconds or RFC 3339, default now"},
+	{Name: "step", Type: "string", Doc: "seconds or a duration such as 5m, default about 300 points"},
+}
+
+var eventParams = []openapi.Param{
+	{Name: "kind", Type: "string", Doc: "comma-separated: domain, agent, network, pool, host"},
+	{Name: "name", Type: "string", Doc: "object name"},
+}
+
+// hostParam is accepted by every route behind SelectHost.
+var hostParam = openapi.Param{Name: "host", Type: "string", Doc: "libvirt host, the default host when empty"}
+
+// APISpec returns the input of the OpenAPI document.
+func APISpec() openapi.Spec {
+	routes := make([]openapi.Route, len(APIRoutes))
+	for i, r := range APIRoutes {
+		if strings.HasPrefix(r.Path, "/api/") && !r.Public || strings.HasPrefix(r.Path, "/ws/") && r.Path != "/ws/events" {
+			r.Query = append([]openapi.Param{hostParam}, r.Query...)
+		}
+		routes[i] = r
+	}
+	return openapi.Spec{
+		Info: openapi.Info{
+			Title:       "VirtPanel API",
+			Version:     "1.0",
+			Description: "Authenticate with the session cookie from /api/auth/login or with an API token (Authorization: Bearer).",
+		},
+		Routes: routes,
+		Error:  model.ErrorResponse{},
+		Extra:  []any{model.Event{}},
+		Names:  map[string]string{"audit.Entry": "AuditEntry", "alert.Rule": "AlertRule", "alert.Channel": "AlertChannel", "webhook.Delivery": "WebhookDelivery", "handler.settingsInfo": "Settings", "auth.NewToken": "NewToken"},
+	}
+}
+
+// UndocumentedRoutes compares the registered routes with APIRoutes and
+// returns the ones missing on either side.
+func UndocumentedRoutes(routes gin.RoutesInfo) (missing, stale []string) {
+	documented := make(map[string]bool, len(APIRoutes))
+	for i := range APIRoutes {
+		documented[APIRoutes[i].Key()] = true
+	}
+	registered := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		key := r.Method + " " + r.Path
+		registered[key] = true
+		if !documented[key] {
+			missing = append(missing, key)
+		}
+	}
+	for i := range APIRoutes {
+		if !registered[APIRoutes[i].Key()] {
+			stale = append(stale, APIRoutes[i].Key())
+		}
+	}
+	return missing, stale
+}
+
+var openAPIDoc = sync.OnceValue(func() []byte {
+	data, _ := json.Marshal(openapi.Build(APISpec()))
+	return data
+})
+
+// OpenAPI serves the OpenAPI 3 document of the API.
+func (h *Handler) OpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPIDoc())
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"virtpanel/internal/audit"
+	"virtpanel/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxAuditBody  = 64 * 1024 // larger request bodies are not recorded
+	maxAuditReply = 4 * 1024  // response bytes kept to find the error message
+)
+
+// auditWriter keeps the start of the response so the error message of a
+// failed call can be recorded.
+type auditWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if n := maxAuditReply - w.buf.Len(); n > 0 {
+		w.buf.Write(b[:min(n, len(b))])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Audit records every mutating request under /api once it has been
+// handled. Read-only requests pass through untouched.
+func (h *Handler) Audit(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		c.Next()
+		return
+	}
+	start := time.Now()
+
+	var body []byte
+	if !strings.HasPrefix(c.ContentType(), "multipart/") && c.Request.Body != nil {
+		body, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxAuditBody+1))
+		c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(body), c.Request.Body), c.Request.Body}
+		if len(body) > maxAuditBody {
+			body = nil
+		}
+	}
+	w := &auditWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+
+	c.Next()
+
+	e := audit.Entry{
+		Time:       start.UnixMilli(),
+		Actor:      c.GetString("user"),
+		IP:         c.ClientIP(),
+		Method:     c.Request.Method,
+		Route:      c.FullPath(),
+		Path:       c.Request.URL.Path,
+		Host:       c.GetString("host"),
+		Body:       audit.Redact(body),
+		Status:     w.Status(),
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if t, ok := c.Get("token"); ok {
+		e.Token = t.(auth.TokenInfo).ID
+	}
+	e.ObjectKind, e.Object = auditObject(c, body)
+	if e.Status >= http.StatusBadRequest {
+		var reply struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(w.buf.Bytes(), &reply)
+		e.Error = reply.Error
+	}
+	if err := h.audit.Record(e); err != nil {
+		log.Printf("写入审计日志失败: %v", err)
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// auditObject names what the request acts on: the collection from the
+// route (vms, networks, ...) and the first path parameter, or the name in
+// the body for creates.
+func auditObject(c *gin.Context, body []byte) (kind, object string) {
+	route := strings.TrimPrefix(c.FullPath(), "/api/")
+	kind, _, _ = strings.Cut(route, "/")
+	if len(c.Params) > 0 {
+		return kind, c.Params[0].Value
+	}
+	var named struct {
+		Name string `json:"name"`
+	}
+	json.Unmarshal(body, &named)
+	return kind, named.Name
+}
+
+// auditFilter reads ?actor=, ?kind=, ?object=, ?since= and ?until=. Times
+// are unix seconds or RFC 3339.
+func auditFilter(c *gin.Context) (audit.Filter, error) {
+	f := audit.Filter{Actor: c.Query("actor"), ObjectKind: c.Query("kind"), Object: c.Query("object")}
+	var err error
+	if f.Since, err = parseAuditTime(c.Query("since")); err != nil {
+		return f, fmt.Errorf("invalid since: %w", err)
+	}
+	if f.Until, err = parseAuditTime(c.Query("until")); err != nil {
+		return f, fmt.Errorf("invalid until: %w", err)
+	}
+	return f, nil
+}
+
+func parseAuditTime(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n * 1000, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixMilli(), nil
+}
+
+// ListAudit returns matching audit entries, newest first (?limit=,
+// default 200).
+func (h *Handler) ListAudit(c *gin.Context) {
+	f, err := auditFilter(c)
+	if err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	limit := 200
+	if s := c.Query("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil {
+			fail(c, badRequest("invalid limit"))
+			return
+		}
+	}
+	list, err := h.audit.Query(f, limit)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if list == nil {
+		list = []audit.Entry{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// ExportAudit downloads matching audit entries as JSON lines, oldest
+// first.
+func (h *Handler) ExportAudit(c *gin.Context) {
+	f, err := auditFilter(c)
+	if err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	name := fmt.Sprintf("audit-%s.jsonl", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	if err := h.audit.Export(c.Writer, f); err != nil {
+		log.Printf("导出审计日志失败: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"virtpanel/internal/auth"
+	"virtpanel/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const sessionCookie = "virtpanel_session"
+
+// Authenticate rejects requests without a valid session or API token and
+// records the user for the handlers and the audit log behind it. WebSocket
+// routes are covered too: browsers send the cookie with the upgrade
+// request.
+func (h *Handler) Authenticate(c *gin.Context) {
+	if secret, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		h.authenticateToken(c, secret)
+		return
+	}
+	token, _ := c.Cookie(sessionCookie)
+	user, ok := h.auth.Authenticate(token)
+	var p auth.Principal
+	if ok {
+		p, ok = h.auth.Principal(user)
+	}
+	if !ok {
+		abort(c, errLoginRequired)
+		return
+	}
+	c.Set("user", user)
+	c.Set("principal", p)
+	c.Next()
+}
+
+func (h *Handler) setSession(c *gin.Context, token string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(sessionCookie, token, maxAge, "/", "", secure, true)
+}
+
+// AuthStatus tells the login page whether the first account still has to
+// be created and who is logged in.
+func (h *Handler) AuthStatus(c *gin.Context) {
+	st := model.AuthStatus{SetupRequired: h.auth.NeedsSetup()}
+	if token, err := c.Cookie(sessionCookie); err == nil {
+		if user, ok := h.auth.Authenticate(token); ok {
+			p, _ := h.auth.Principal(user)
+			st.User, st.Role = p.Name, string(p.Role)
+		}
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// Setup creates the first account with the setup token from the log and
+// logs it in.
+func (h *Handler) Setup(c *gin.Context) {
+	var req model.SetupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	c.Set("user", req.Username)
+	if err := h.auth.Setup(req.SetupToken, req.Username, req.Password); err != nil {
+		fail(c, err)
+		return
+	}
+	h.login(c, req.Username, req.Password)
+}
+
+func (h *Handler) Login(c *gin.Context) {
+	var req model.LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	c.Set("user", req.Username)
+	h.login(c, req.Username, req.Password)
+}
+
+func (h *Handler) login(c *gin.Context, user, password string) {
+	token, err := h.auth.Login(user, password)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	h.setSession(c, token, int(auth.SessionTTL.Seconds()))
+	c.JSON(http.StatusOK, model.LoginResult{Message: "logged in", User: user})
+}
+
+func (h *Handler) Logout(c *gin.Context) {
+	if token, err := c.Cookie(sessionCookie); err == nil {
+		if user, ok := h.auth.Authenticate(token); ok {
+			c.Set("user", user)
+		}
+		h.auth.Logout(token)
+	}
+	h.setSession(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+func (h *Handler) ListUsers(c *gin.Context) {
+	c.JSON(http.StatusOK, h.auth.Users())
+}
+
+func (h *Handler) CreateUser(c *gin.Context) {
+	var req model.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	if req.Role == "" {
+		req.Role = string(auth.RoleViewer)
+	}
+	if err := h.auth.AddUser(req.Username, req.Password, auth.Role(req.Role), req.Groups); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "created"})
+}
+
+func (h *Handler) DeleteUser(c *gin.Context) {
+	if err := h.auth.DeleteUser(c.Param("user")); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// UpdateUser changes the role and groups of an account.
+func (h *Handler) UpdateUser(c *gin.Context) {
+	var req model.UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	if err := h.auth.UpdateUser(c.Param("user"), auth.Role(req.Role), req.Groups); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+}
+
+// SetPassword changes a password: any account's for admins, otherwise
+// only the caller's own. The caller's session stays valid, every other
+// session of that user is ended.
+func (h *Handler) SetPassword(c *gin.Context) {
+	var req model.SetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	if p := principal(c); p.Role != auth.RoleAdmin && p.Name != c.Param("user") {
+		fail(c, errForbidden)
+		return
+	}
+	token, _ := c.Cookie(sessionCookie)
+	if err := h.auth.SetPassword(c.Param("user"), req.Password, token); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+}
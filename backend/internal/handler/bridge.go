@@ -8,9 +8,9 @@ import (
 )
 
 func (h *Handler) ListBridges(c *gin.Context) {
-	bridges, err := h.svc.ListBridges()
+	bridges, err := h.svc(c).ListBridges()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, bridges)
@@ -19,19 +19,19 @@ func (h *Handler) ListBridges(c *gin.Context) {
 func (h *Handler) CreateBridge(c *gin.Context) {
 	var req model.CreateBridgeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.CreateBridge(req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).CreateBridge(req); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "created"})
 }
 
 func (h *Handler) DeleteBridge(c *gin.Context) {
-	if err := h.svc.DeleteBridge(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DeleteBridge(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ConsoleWebSocket attaches a WebSocket to the VM's serial console.
+// Messages from the client are typed into the console; console output is
+// sent as binary messages.
+func (h *Handler) ConsoleWebSocket(c *gin.Context) {
+	console, err := h.svc(c).OpenConsole(c.Param("name"))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		console.Close()
+		return
+	}
+
+	var once sync.Once
+	closeAll := func() {
+		once.Do(func() {
+			console.Close()
+			ws.Close()
+		})
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer closeAll()
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if _, err := console.Write(msg); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		defer closeAll()
+		buf := make([]byte, 4096)
+		for {
+			n, err := console.Read(buf)
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"virtpanel/internal/alert"
+	"virtpanel/internal/auth"
+	"virtpanel/internal/collector"
+	"virtpanel/internal/model"
+	"virtpanel/internal/service"
+	"virtpanel/internal/task"
+	"virtpanel/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every error reply is a model.ErrorResponse. Its status and code come
+// from errorStatus, so handlers pass errors to fail instead of picking a
+// status themselves.
+
+var kindStatus = map[service.Kind]int{
+	service.Internal:     http.StatusInternalServerError,
+	service.NotFound:     http.StatusNotFound,
+	service.Conflict:     http.StatusConflict,
+	service.Invalid:      http.StatusBadRequest,
+	service.Precondition: http.StatusPreconditionFailed,
+	service.Unavailable:  http.StatusServiceUnavailable,
+}
+
+// sentinels classifies the errors of the packages other than service.
+var sentinels = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{auth.ErrBadCredentials, http.StatusUnauthorized, "bad_credentials"},
+	{auth.ErrBadSetupToken, http.StatusForbidden, "bad_setup_token"},
+	{auth.ErrUserNotFound, http.StatusNotFound, "user_not_found"},
+	{auth.ErrTokenNotFound, http.StatusNotFound, "token_not_found"},
+	{auth.ErrSetupDone, http.StatusConflict, "setup_done"},
+	{auth.ErrUserExists, http.StatusConflict, service.CodeAlreadyExists},
+	{auth.ErrLastUser, http.StatusConflict, "last_user"},
+	{auth.ErrLastAdmin, http.StatusConflict, "last_admin"},
+	{auth.ErrInvalidName, http.StatusBadRequest, service.CodeInvalidName},
+	{auth.ErrInvalidRole, http.StatusBadRequest, service.CodeInvalidArgument},
+	{auth.ErrInvalidScope, http.StatusBadRequest, service.CodeInvalidArgument},
+	{auth.ErrAdminScope, http.StatusForbidden, "forbidden"},
+	{auth.ErrWeakPassword, http.StatusBadRequest, "weak_password"},
+	{task.ErrNotFound, http.StatusNotFound, "task_not_found"},
+	{task.ErrFinished, http.StatusConflict, service.CodeInvalidState},
+	{alert.ErrRuleNotFound, http.StatusNotFound, "alert_rule_not_found"},
+	{alert.ErrChannelNotFound, http.StatusNotFound, "alert_channel_not_found"},
+	{alert.ErrInvalidRule, http.StatusBadRequest, service.CodeInvalidArgument},
+	{alert.ErrInvalidChannel, http.StatusBadRequest, service.CodeInvalidArgument},
+	{alert.ErrChannelInUse, http.StatusConflict, "in_use"},
+	{webhook.ErrNotFound, http.StatusNotFound, "webhook_not_found"},
+	{webhook.ErrInvalid, http.StatusBadRequest, service.CodeInvalidArgument},
+	{collector.ErrDisabled, http.StatusPreconditionFailed, "stats_disabled"},
+}
+
+// httpError is an error the handlers raise themselves, such as a
+// malformed request body or a missing login.
+type httpError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+var (
+	errLoginRequired = &httpError{http.StatusUnauthorized, "login_required", "login required"}
+	errBadToken      = &httpError{http.StatusUnauthorized, "invalid_token", "invalid or expired token"}
+	errForbidden     = &httpError{http.StatusForbidden, "forbidden", "permission denied"}
+	errSessionOnly   = &httpError{http.StatusForbidden, "forbidden", "not available to API tokens"}
+)
+
+// badRequest rejects a request body or query the handler cannot use.
+func badRequest(format string, a ...any) error {
+	return &httpError{http.StatusBadRequest, "invalid_request", fmt.Sprintf(format, a...)}
+}
+
+// errScope rejects an API token without the scope a route needs.
+func errScope(scope auth.Scope) error {
+	return &httpError{http.StatusForbidden, "insufficient_scope", "token lacks scope " + string(scope)}
+}
+
+// errVMNotFound hides a VM the caller cannot see.
+func errVMNotFound(name string) error {
+	return &httpError{http.StatusNotFound, service.CodeVMNotFound, "vm not found: " + name}
+}
+
+// deliveryFailed reports a test notification or event the receiving end
+// did not accept.
+func deliveryFailed(err error) error {
+	return &httpError{http.StatusBadGateway, "delivery_failed", err.Error()}
+}
+
+// errorStatus returns the HTTP status and code of err.
+func errorStatus(err error) (int, string) {
+	var he *httpError
+	if errors.As(err, &he) {
+		return he.status, he.code
+	}
+	for _, s := range sentinels {
+		if errors.Is(err, s.err) {
+			return s.status, s.code
+		}
+	}
+	kind, code := service.Classify(err)
+	return kindStatus[kind], code
+}
+
+// ErrorCode returns the code API replies give for err.
+func ErrorCode(err error) string {
+	_, code := errorStatus(err)
+	return code
+}
+
+func errorBody(err error) (int, model.ErrorResponse) {
+	status, code := errorStatus(err)
+	return status, model.ErrorResponse{Error: err.Error(), Code: code}
+}
+
+// fail answers the request with err.
+func fail(c *gin.Context, err error) {
+	c.JSON(errorBody(err))
+}
+
+// abort answers with err and stops the handler chain.
+func abort(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(errorBody(err))
+}
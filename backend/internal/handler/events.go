@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"io"
+	"time"
+
+	"virtpanel/internal/auth"
+	"virtpanel/internal/event"
+	"virtpanel/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// eventKeepalive keeps idle event streams from being closed by proxies.
+const eventKeepalive = 15 * time.Second
+
+// visible reports whether the caller may see the event: domain and agent
+// events only reach the VM's owners. The owner comes with the event, so
+// owners also see the undefine of a VM that is already gone.
+func (h *Handler) visible(p auth.Principal, e model.Event) bool {
+	if p.Role == auth.RoleAdmin || (e.Kind != "domain" && e.Kind != "agent") {
+		return true
+	}
+	owner, group := e.Owner, e.Group
+	if owner == "" && group == "" {
+		// The define of a new VM comes before its owner is recorded
+		if svc, err := h.hosts.Get(e.Host); err == nil {
+			if vm, err := svc.GetVM(e.Name); err == nil {
+				owner, group = vm.Owner, vm.Group
+			}
+		}
+	}
+	return p.Owns(owner, group)
+}
+
+// subscribe registers an event subscriber for the request's ?host=, ?kind=
+// and ?name= filters. Without ?host= events of every host are delivered.
+func (h *Handler) subscribe(c *gin.Context) *event.Subscription {
+	return h.events.Subscribe(event.ParseFilter(c.Query("host"), c.Query("kind"), c.Query("name")))
+}
+
+// Events streams lifecycle events as Server-Sent Events. The SSE event
+// name is the event kind (domain, agent, network, pool, host).
+func (h *Handler) Events(c *gin.Context) {
+	sub, p := h.subscribe(c), principal(c)
+	defer sub.Close()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	ticker := time.NewTicker(eventKeepalive)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case e := <-sub.C():
+			if h.visible(p, e) {
+				c.SSEvent(e.Kind, e)
+			}
+		case <-ticker.C:
+			io.WriteString(w, ": keepalive\n\n")
+		}
+		return true
+	})
+}
+
+// EventsWebSocket streams the same events as Events, one JSON text frame
+// per event.
+func (h *Handler) EventsWebSocket(c *gin.Context) {
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+	sub, p := h.subscribe(c), principal(c)
+	defer sub.Close()
+
+	// Drain client frames so close and ping control messages are handled
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(eventKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case e := <-sub.C():
+			if !h.visible(p, e) {
+				continue
+			}
+			if err := ws.WriteJSON(e); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
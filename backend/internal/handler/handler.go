@@ -1,31 +1,82 @@
 package handler
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"sync"
+	"time"
 
+	"virtpanel/internal/alert"
+	"virtpanel/internal/audit"
+	"virtpanel/internal/auth"
+	"virtpanel/internal/collector"
+	"virtpanel/internal/config"
+	"virtpanel/internal/event"
+	"virtpanel/internal/metrics"
 	"virtpanel/internal/model"
 	"virtpanel/internal/service"
+	"virtpanel/internal/task"
+	"virtpanel/internal/webhook"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
-	svc *service.LibvirtService
+	hosts      *service.HostManager
+	tasks      *task.Manager
+	events     *event.Bus
+	audit      *audit.Log
+	auth       *auth.Manager
+	cfg        *config.Config
+	collector  *collector.Collector // nil when stats_interval is 0
+	alerts     *alert.Manager
+	webhooks   *webhook.Manager
+	apiLatency *metrics.Histogram
+	started    time.Time
 }
 
-func NewHandler(svc *service.LibvirtService) *Handler {
-	return &Handler{svc: svc}
+func NewHandler(hosts *service.HostManager, tasks *task.Manager, events *event.Bus, auditLog *audit.Log, users *auth.Manager, stats *collector.Collector, alerts *alert.Manager, hooks *webhook.Manager, cfg *config.Config) *Handler {
+	return &Handler{hosts: hosts, tasks: tasks, events: events, audit: auditLog, auth: users, collector: stats, alerts: alerts, webhooks: hooks, cfg: cfg,
+		apiLatency: metrics.NewHistogram("virtpanel_http_request_duration_seconds", "Latency of HTTP requests by route and status.",
+			metrics.DefBuckets, "method", "route", "status"),
+		started: time.Now(),
+	}
+}
+
+// SelectHost resolves the ?host= query parameter (default host when
+// absent) for the handlers behind it.
+func (h *Handler) SelectHost(c *gin.Context) {
+	name := c.Query("host")
+	if name == "" {
+		name = h.cfg.HostName
+	}
+	svc, err := h.hosts.Get(name)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.Set("host", name)
+	c.Set("hypervisor", svc)
+	c.Next()
+}
+
+// svc returns the hypervisor chosen by SelectHost.
+func (h *Handler) svc(c *gin.Context) service.Hypervisor {
+	return c.MustGet("hypervisor").(service.Hypervisor)
 }
 
 func (h *Handler) ListPhysicalNICs(c *gin.Context) {
+	if host, _ := h.hosts.Host(c.Query("host")); !host.Local {
+		fail(c, service.ErrLocalOnly)
+		return
+	}
 	nics, err := net.Interfaces()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
-	var result []gin.H
+	var result []model.PhysicalNIC
 	for _, n := range nics {
 		if n.Flags&net.FlagLoopback != 0 || n.Flags&net.FlagBroadcast == 0 {
 			continue
@@ -50,33 +101,33 @@ func (h *Handler) ListPhysicalNICs(c *gin.Context) {
 				break
 			}
 		}
-		result = append(result, gin.H{"name": n.Name, "mac": n.HardwareAddr.String(), "ip": ip, "up": n.Flags&net.FlagUp != 0})
+		result = append(result, model.PhysicalNIC{Name: n.Name, MAC: n.HardwareAddr.String(), IP: ip, Up: n.Flags&net.FlagUp != 0})
 	}
 	c.JSON(http.StatusOK, result)
 }
 
 func (h *Handler) GetHostInfo(c *gin.Context) {
-	info, err := h.svc.GetHostInfo()
+	info, err := h.svc(c).GetHostInfo()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, info)
 }
 
 func (h *Handler) ListVMs(c *gin.Context) {
-	vms, err := h.svc.ListVMs()
+	vms, err := h.svc(c).ListVMs()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, vms)
+	c.JSON(http.StatusOK, visibleVMs(principal(c), vms))
 }
 
 func (h *Handler) GetVM(c *gin.Context) {
-	vm, err := h.svc.GetVM(c.Param("name"))
+	vm, err := h.svc(c).GetVM(c.Param("name"))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, vm)
@@ -85,67 +136,70 @@ func (h *Handler) GetVM(c *gin.Context) {
 func (h *Handler) CreateVM(c *gin.Context) {
 	var req model.CreateVMRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.CreateVM(req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "created"})
+	svc := h.svc(c)
+	h.startTask(c, "create_vm", req.Name, h.emitAfter(c, webhook.VMCreated, req.Name, nil, ownedBy(principal(c), svc, req.Name, func(ctx context.Context, r *task.Reporter) error {
+		return svc.CreateVM(ctx, req, r)
+	})))
 }
 
 func (h *Handler) DeleteVM(c *gin.Context) {
-	if err := h.svc.DeleteVM(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DeleteVM(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
+	h.emit(c, webhook.VMDeleted, c.Param("name"), nil)
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
 }
 
 func (h *Handler) StartVM(c *gin.Context) {
-	if err := h.svc.StartVM(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).StartVM(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
+	h.emit(c, webhook.VMStarted, c.Param("name"), nil)
 	c.JSON(http.StatusOK, gin.H{"message": "started"})
 }
 
 func (h *Handler) ShutdownVM(c *gin.Context) {
-	if err := h.svc.ShutdownVM(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).ShutdownVM(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
+	h.emit(c, webhook.VMStopped, c.Param("name"), map[string]any{"mode": "shutdown"})
 	c.JSON(http.StatusOK, gin.H{"message": "shutdown"})
 }
 
 func (h *Handler) DestroyVM(c *gin.Context) {
-	if err := h.svc.DestroyVM(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DestroyVM(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
+	h.emit(c, webhook.VMStopped, c.Param("name"), map[string]any{"mode": "destroy"})
 	c.JSON(http.StatusOK, gin.H{"message": "destroyed"})
 }
 
 func (h *Handler) RebootVM(c *gin.Context) {
-	if err := h.svc.RebootVM(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).RebootVM(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "rebooted"})
 }
 
 func (h *Handler) SuspendVM(c *gin.Context) {
-	if err := h.svc.SuspendVM(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).SuspendVM(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "suspended"})
 }
 
 func (h *Handler) ResumeVM(c *gin.Context) {
-	if err := h.svc.ResumeVM(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).ResumeVM(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "resumed"})
@@ -154,35 +208,33 @@ func (h *Handler) ResumeVM(c *gin.Context) {
 func (h *Handler) UpdateVM(c *gin.Context) {
 	var req model.UpdateVMRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.UpdateVM(c.Param("name"), req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).UpdateVM(c.Param("name"), req); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "updated"})
 }
 
 func (h *Handler) GetAutostart(c *gin.Context) {
-	v, err := h.svc.GetAutostart(c.Param("name"))
+	v, err := h.svc(c).GetAutostart(c.Param("name"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"autostart": v})
+	c.JSON(http.StatusOK, model.Autostart{Autostart: v})
 }
 
 func (h *Handler) SetAutostart(c *gin.Context) {
-	var req struct {
-		Autostart bool `json:"autostart"`
-	}
+	var req model.Autostart
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.SetAutostart(c.Param("name"), req.Autostart); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).SetAutostart(c.Param("name"), req.Autostart); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "ok"})
@@ -191,59 +243,81 @@ func (h *Handler) SetAutostart(c *gin.Context) {
 func (h *Handler) RenameVM(c *gin.Context) {
 	var req model.RenameVMRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.RenameVM(c.Param("name"), req.NewName); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).RenameVM(c.Param("name"), req.NewName); err != nil {
+		fail(c, err)
 		return
 	}
+	h.emit(c, webhook.VMRenamed, req.NewName, map[string]any{"old_name": c.Param("name")})
 	c.JSON(http.StatusOK, gin.H{"message": "renamed"})
 }
 
 func (h *Handler) ImportVM(c *gin.Context) {
 	var req model.ImportVMRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
+		return
+	}
+	svc := h.svc(c)
+	if err := svc.ImportVM(req); err != nil {
+		fail(c, err)
 		return
 	}
-	if err := h.svc.ImportVM(req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := setOwner(principal(c), svc, req.Name); err != nil {
+		fail(c, err)
 		return
 	}
+	h.emit(c, webhook.VMCreated, req.Name, map[string]any{"imported": true})
 	c.JSON(http.StatusOK, gin.H{"message": "imported"})
 }
 
 func (h *Handler) BatchAction(c *gin.Context) {
 	var req model.BatchActionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
 	valid := map[string]bool{"start": true, "shutdown": true, "destroy": true, "delete": true}
 	if !valid[req.Action] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid action"})
+		fail(c, badRequest("invalid action"))
 		return
 	}
+	if req.Action == "delete" && !tokenAllows(c, auth.ScopeVMsWrite) {
+		fail(c, errScope(auth.ScopeVMsWrite))
+		return
+	}
+	svc, p := h.svc(c), principal(c)
 	errors := map[string]string{}
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 	for _, name := range req.Names {
+		if !canOperate(p, svc, name) {
+			mu.Lock()
+			errors[name] = "vm not found or permission denied"
+			mu.Unlock()
+			continue
+		}
 		wg.Add(1)
 		go func(n string) {
 			defer wg.Done()
 			var err error
+			var ev string
+			var data map[string]any
 			switch req.Action {
 			case "start":
-				err = h.svc.StartVM(n)
+				err, ev = svc.StartVM(n), webhook.VMStarted
 			case "shutdown":
-				err = h.svc.ShutdownVM(n)
+				err, ev, data = svc.ShutdownVM(n), webhook.VMStopped, map[string]any{"mode": "shutdown"}
 			case "destroy":
-				err = h.svc.DestroyVM(n)
+				err, ev, data = svc.DestroyVM(n), webhook.VMStopped, map[string]any{"mode": "destroy"}
 			case "delete":
-				err = h.svc.DeleteVM(n)
+				err, ev = svc.DeleteVM(n), webhook.VMDeleted
 			}
-			if err != nil {
+			if err == nil {
+				h.emit(c, ev, n, data)
+			} else {
 				mu.Lock()
 				errors[n] = err.Error()
 				mu.Unlock()
@@ -252,8 +326,8 @@ func (h *Handler) BatchAction(c *gin.Context) {
 	}
 	wg.Wait()
 	if len(errors) > 0 {
-		c.JSON(http.StatusOK, gin.H{"message": "partial", "errors": errors})
+		c.JSON(http.StatusOK, model.BatchResult{Message: "partial", Errors: errors})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "ok"})
+	c.JSON(http.StatusOK, model.BatchResult{Message: "ok"})
 }
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"net/http"
+
+	"virtpanel/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) ListHosts(c *gin.Context) {
+	c.JSON(http.StatusOK, h.hosts.List())
+}
+
+func (h *Handler) AddHost(c *gin.Context) {
+	var req model.AddHostRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	host, err := h.hosts.Add(req)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, host)
+}
+
+func (h *Handler) DeleteHost(c *gin.Context) {
+	if err := h.hosts.Remove(c.Param("host")); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// CheckHost runs a health check (and reconnect attempt) immediately.
+func (h *Handler) CheckHost(c *gin.Context) {
+	host, err := h.hosts.Check(c.Param("host"))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, host)
+}
+
+// ListAllVMs lists VMs across every connected host.
+func (h *Handler) ListAllVMs(c *gin.Context) {
+	all := h.hosts.ListVMs()
+	all.VMs = visibleVMs(principal(c), all.VMs)
+	c.JSON(http.StatusOK, all)
+}
+
+// HostsOverview returns health plus host info for every host.
+func (h *Handler) HostsOverview(c *gin.Context) {
+	c.JSON(http.StatusOK, h.hosts.Overview())
+}
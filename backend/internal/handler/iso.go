@@ -1,16 +1,19 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
+	"virtpanel/internal/task"
+
 	"github.com/gin-gonic/gin"
 )
 
 func (h *Handler) ListISOs(c *gin.Context) {
-	isos, err := h.svc.ListISOs()
+	isos, err := h.svc(c).ListISOs()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, isos)
@@ -19,27 +22,30 @@ func (h *Handler) ListISOs(c *gin.Context) {
 func (h *Handler) UploadISO(c *gin.Context) {
 	file, header, err := c.Request.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no file uploaded"})
+		fail(c, badRequest("no file uploaded"))
 		return
 	}
-	defer file.Close()
 
 	filename := header.Filename
 	if !strings.HasSuffix(strings.ToLower(filename), ".iso") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "only .iso files allowed"})
+		file.Close()
+		fail(c, badRequest("only .iso files allowed"))
 		return
 	}
 
-	if err := h.svc.UploadISO(filename, file); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "uploaded"})
+	// The request body has been received at this point; copying it into
+	// the ISO directory runs as a task. The spooled upload stays readable
+	// through the open handle after the request finishes.
+	svc := h.svc(c)
+	h.startTask(c, "upload_iso", filename, func(ctx context.Context, r *task.Reporter) error {
+		defer file.Close()
+		return svc.UploadISO(ctx, filename, file, header.Size, r)
+	})
 }
 
 func (h *Handler) DeleteISO(c *gin.Context) {
-	if err := h.svc.DeleteISO(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DeleteISO(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"virtpanel/internal/metrics"
+	"virtpanel/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Instrument records the latency of every request by route and status.
+// WebSockets and event streams are left out: they last as long as the
+// client stays.
+func (h *Handler) Instrument(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	if c.IsWebsocket() || strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	h.apiLatency.Observe(time.Since(start).Seconds(), c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+}
+
+// family is one metric written for every host.
+type family struct {
+	name, typ, help string
+	each            func(s *model.HostStats, emit func(v float64, labels ...string))
+}
+
+// guestFamily is a per-VM metric; only running and paused VMs have
+// counters.
+func guestFamily(name, typ, help string, value func(g *model.GuestStats) float64) family {
+	return family{name, typ, help, func(s *model.HostStats, emit func(float64, ...string)) {
+		for i := range s.Guests {
+			if g := &s.Guests[i]; g.State == "running" || g.State == "paused" {
+				emit(value(g), "vm", g.Name)
+			}
+		}
+	}}
+}
+
+// balloonFamily is a memory metric reported by the guest's balloon
+// driver; guests without one are left out.
+func balloonFamily(name, help string, value func(b *model.BalloonStats) uint64) family {
+	return family{name, "gauge", help, func(s *model.HostStats, emit func(float64, ...string)) {
+		for i := range s.Guests {
+			if v := value(&s.Guests[i].Balloon); v > 0 {
+				emit(float64(v)*1024, "vm", s.Guests[i].Name)
+			}
+		}
+	}}
+}
+
+func blockFamily(name, typ, help string, value func(b *model.BlockStats) uint64) family {
+	return family{name, typ, help, func(s *model.HostStats, emit func(float64, ...string)) {
+		for _, g := range s.Guests {
+			for i := range g.Blocks {
+				b := &g.Blocks[i]
+				emit(float64(value(b)), "vm", g.Name, "device", b.Device, "path", b.Path)
+			}
+		}
+	}}
+}
+
+func nicFamily(name, help string, value func(n *model.NICStats) uint64) family {
+	return family{name, "counter", help, func(s *model.HostStats, emit func(float64, ...string)) {
+		for _, g := range s.Guests {
+			for i := range g.NICs {
+				emit(float64(value(&g.NICs[i])), "vm", g.Name, "device", g.NICs[i].Device)
+			}
+		}
+	}}
+}
+
+// hostFamily is a host-level gauge, written for connected hosts.
+func hostFamily(name, help string, value func(info *model.HostInfo) float64) family {
+	return family{name, "gauge", help, func(s *model.HostStats, emit func(float64, ...string)) {
+		if s.Info != nil {
+			emit(value(s.Info))
+		}
+	}}
+}
+
+const gib = 1 << 30
+
+var hostFamilies = []family{
+	{"virtpanel_host_up", "gauge", "Whether the last health check of the libvirt connection succeeded.",
+		func(s *model.HostStats, emit func(float64, ...string)) {
+			up := 0.0
+			if s.Host.Connected {
+				up = 1
+			}
+			emit(up)
+		}},
+	{"virtpanel_host_check_latency_seconds", "gauge", "Round trip of the last health check.",
+		func(s *model.HostStats, emit func(float64, ...string)) {
+			emit(float64(s.Host.LatencyMS) / 1000)
+		}},
+	{"virtpanel_libvirt_reconnects_total", "counter", "Lost libvirt connections that were re-established.",
+		func(s *model.HostStats, emit func(float64, ...string)) {
+			emit(float64(s.Host.Reconnects))
+		}},
+	hostFamily("virtpanel_host_cpus", "Logical CPUs of the host.",
+		func(info *model.HostInfo) float64 { return float64(info.CPUCount) }),
+	hostFamily("virtpanel_host_cpu_usage_ratio", "Host CPU usage over the last sampling interval (0-1).",
+		func(info *model.HostInfo) float64 { return info.CPUUsage / 100 }),
+	hostFamily("virtpanel_host_memory_total_bytes", "Host memory.",
+		func(info *model.HostInfo) float64 { return float64(info.MemoryTotal) * (1 << 20) }),
+	hostFamily("virtpanel_host_memory_available_bytes", "Host memory available for new allocations.",
+		func(info *model.HostInfo) float64 { return float64(info.MemoryFree) * (1 << 20) }),
+	{"virtpanel_host_load", "gauge", "Host load average (local hosts only).",
+		func(s *model.HostStats, emit func(float64, ...string)) {
+			if s.Info != nil && s.Host.Local {
+				for i, period := range []string{"1m", "5m", "15m"} {
+					emit(s.Info.LoadAvg[i], "period", period)
+				}
+			}
+		}},
+	{"virtpanel_host_uptime_seconds", "gauge", "Host uptime (local hosts only).",
+		func(s *model.HostStats, emit func(float64, ...string)) {
+			if s.Info != nil && s.Host.Local {
+				emit(float64(s.Info.Uptime))
+			}
+		}},
+	{"virtpanel_host_filesystem_size_bytes", "gauge", "Size of a host filesystem (local hosts only, GiB precision).",
+		func(s *model.HostStats, emit func(float64, ...string)) {
+			if s.Info != nil {
+				for _, d := range s.Info.Disks {
+					emit(float64(d.Total)*gib, "mount", d.Mount, "device", d.Device)
+				}
+			}
+		}},
+	{"virtpanel_host_filesystem_avail_bytes", "gauge", "Free space of a host filesystem (local hosts only, GiB precision).",
+		func(s *model.HostStats, emit func(float64, ...string)) {
+			if s.Info != nil {
+				for _, d := range s.Info.Disks {
+					emit(float64(d.Available)*gib, "mount", d.Mount, "device", d.Device)
+				}
+			}
+		}},
+	{"virtpanel_vms", "gauge", "VMs by state.",
+		func(s *model.HostStats, emit func(float64, ...string)) {
+			if s.Guests == nil {
+				return
+			}
+			count := map[string]int{}
+			for _, g := range s.Guests {
+				count[g.State]++
+			}
+			for _, st := range []string{"running", "paused", "shutoff", "shutdown", "crashed", "blocked", "unknown"} {
+				emit(float64(count[st]), "state", st)
+			}
+		}},
+}
+
+var guestFamilies = []family{
+	{"virtpanel_vm_info", "gauge", "Always 1; labels carry the VM's UUID and state.",
+		func(s *model.HostStats, emit func(float64, ...string)) {
+			for _, g := range s.Guests {
+				emit(1, "vm", g.Name, "uuid", g.UUID, "state", g.State)
+			}
+		}},
+	{"virtpanel_vm_running", "gauge", "Whether the VM is running (paused counts as not running).",
+		func(s *model.HostStats, emit func(float64, ...string)) {
+			for _, g := range s.Guests {
+				running := 0.0
+				if g.State == "running" {
+					running = 1
+				}
+				emit(running, "vm", g.Name)
+			}
+		}},
+	guestFamily("virtpanel_vm_vcpus", "gauge", "vCPUs of the VM.",
+		func(g *model.GuestStats) float64 { return float64(g.VCPUs) }),
+	guestFamily("virtpanel_vm_cpu_seconds_total", "counter", "CPU time used by all vCPUs of the VM.",
+		func(g *model.GuestStats) float64 { return float64(g.CPUTime) / 1e9 }),
+	balloonFamily("virtpanel_vm_memory_current_bytes", "Memory currently given to the VM.",
+		func(b *model.BalloonStats) uint64 { return b.Current }),
+	balloonFamily("virtpanel_vm_memory_maximum_bytes", "Maximum memory of the VM.",
+		func(b *model.BalloonStats) uint64 { return b.Maximum }),
+	balloonFamily("virtpanel_vm_memory_available_bytes", "Memory seen by the guest (balloon driver).",
+		func(b *model.BalloonStats) uint64 { return b.Available }),
+	balloonFamily("virtpanel_vm_memory_unused_bytes", "Memory left unused by the guest (balloon driver).",
+		func(b *model.BalloonStats) uint64 { return b.Unused }),
+	balloonFamily("virtpanel_vm_memory_usable_bytes", "Memory the guest can use without swapping (balloon driver).",
+		func(b *model.BalloonStats) uint64 { return b.Usable }),
+	balloonFamily("virtpanel_vm_memory_rss_bytes", "Resident memory of the VM's qemu process.",
+		func(b *model.BalloonStats) uint64 { return b.RSS }),
+	guestFamily("virtpanel_vm_swap_in_bytes_total", "counter", "Memory swapped in by the guest (balloon driver).",
+		func(g *model.GuestStats) float64 { return float64(g.Balloon.SwapIn) * 1024 }),
+	guestFamily("virtpanel_vm_swap_out_bytes_total", "counter", "Memory swapped out by the guest (balloon driver).",
+		func(g *model.GuestStats) float64 { return float64(g.Balloon.SwapOut) * 1024 }),
+	guestFamily("virtpanel_vm_major_page_faults_total", "counter", "Major page faults in the guest (balloon driver).",
+		func(g *model.GuestStats) float64 { return float64(g.Balloon.MajorFaults) }),
+	guestFamily("virtpanel_vm_minor_page_faults_total", "counter", "Minor page faults in the guest (balloon driver).",
+		func(g *model.GuestStats) float64 { return float64(g.Balloon.MinorFaults) }),
+	blockFamily("virtpanel_vm_block_read_bytes_total", "counter", "Bytes read from a VM disk.",
+		func(b *model.BlockStats) uint64 { return b.ReadBytes }),
+	blockFamily("virtpanel_vm_block_read_requests_total", "counter", "Read requests on a VM disk.",
+		func(b *model.BlockStats) uint64 { return b.ReadReqs }),
+	blockFamily("virtpanel_vm_block_write_bytes_total", "counter", "Bytes written to a VM disk.",
+		func(b *model.BlockStats) uint64 { return b.WriteBytes }),
+	blockFamily("virtpanel_vm_block_write_requests_total", "counter", "Write requests on a VM disk.",
+		func(b *model.BlockStats) uint64 { return b.WriteReqs }),
+	blockFamily("virtpanel_vm_block_capacity_bytes", "gauge", "Virtual size of a VM disk.",
+		func(b *model.BlockStats) uint64 { return b.Capacity }),
+	blockFamily("virtpanel_vm_block_allocation_bytes", "gauge", "Host storage allocated to a VM disk.",
+		func(b *model.BlockStats) uint64 { return b.Allocation }),
+	nicFamily("virtpanel_vm_interface_receive_bytes_total", "Bytes received by a VM interface.",
+		func(n *model.NICStats) uint64 { return n.RxBytes }),
+	nicFamily("virtpanel_vm_interface_receive_packets_total", "Packets received by a VM interface.",
+		func(n *model.NICStats) uint64 { return n.RxPackets }),
+	nicFamily("virtpanel_vm_interface_receive_errors_total", "Receive errors of a VM interface.",
+		func(n *model.NICStats) uint64 { return n.RxErrors }),
+	nicFamily("virtpanel_vm_interface_receive_drops_total", "Received packets dropped on a VM interface.",
+		func(n *model.NICStats) uint64 { return n.RxDrops }),
+	nicFamily("virtpanel_vm_interface_transmit_bytes_total", "Bytes sent by a VM interface.",
+		func(n *model.NICStats) uint64 { return n.TxBytes }),
+	nicFamily("virtpanel_vm_interface_transmit_packets_total", "Packets sent by a VM interface.",
+		func(n *model.NICStats) uint64 { return n.TxPackets }),
+	nicFamily("virtpanel_vm_interface_transmit_errors_total", "Transmit errors of a VM interface.",
+		func(n *model.NICStats) uint64 { return n.TxErrors }),
+	nicFamily("virtpanel_vm_interface_transmit_drops_total", "Sent packets dropped on a VM interface.",
+		func(n *model.NICStats) uint64 { return n.TxDrops }),
+}
+
+// Metrics serves host, VM and backend metrics in the Prometheus text
+// format. Every sample of the host and VM families has a host label.
+func (h *Handler) Metrics(c *gin.Context) {
+	stats := h.hosts.Stats()
+	c.Header("Content-Type", metrics.ContentType)
+	c.Status(http.StatusOK)
+	w := metrics.NewWriter(c.Writer)
+	for _, list := range [][]family{hostFamilies, guestFamilies} {
+		for _, f := range list {
+			for i := range stats {
+				s := &stats[i]
+				f.each(s, func(v float64, labels ...string) {
+					w.Header(f.name, f.typ, f.help)
+					w.Sample(f.name, v, append([]string{"host", s.Host.Name}, labels...)...)
+				})
+			}
+		}
+	}
+
+	h.apiLatency.Write(w)
+	w.Header("virtpanel_tasks", "gauge", "Background tasks by state.")
+	count := map[string]int{}
+	for _, t := range h.tasks.List() {
+		count[string(t.State)]++
+	}
+	for _, st := range []string{"running", "succeeded", "failed", "canceled"} {
+		w.Sample("virtpanel_tasks", float64(count[st]), "state", st)
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	w.Gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	w.Gauge("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", float64(mem.HeapAlloc))
+	w.Gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(mem.Sys))
+	w.Gauge("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", float64(h.started.Unix()))
+	w.Flush()
+}
@@ -9,9 +9,9 @@ import (
 )
 
 func (h *Handler) ListNetworks(c *gin.Context) {
-	nets, err := h.svc.ListNetworks()
+	nets, err := h.svc(c).ListNetworks()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, nets)
@@ -20,44 +20,44 @@ func (h *Handler) ListNetworks(c *gin.Context) {
 func (h *Handler) CreateNetwork(c *gin.Context) {
 	var req model.CreateNetworkRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.CreateNetwork(req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).CreateNetwork(req); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "created"})
 }
 
 func (h *Handler) StartNetwork(c *gin.Context) {
-	if err := h.svc.StartNetwork(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).StartNetwork(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "started"})
 }
 
 func (h *Handler) StopNetwork(c *gin.Context) {
-	if err := h.svc.StopNetwork(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).StopNetwork(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "stopped"})
 }
 
 func (h *Handler) DeleteNetwork(c *gin.Context) {
-	if err := h.svc.DeleteNetwork(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DeleteNetwork(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
 }
 
 func (h *Handler) ListDHCPLeases(c *gin.Context) {
-	leases, err := h.svc.ListDHCPLeases(c.Param("name"))
+	leases, err := h.svc(c).ListDHCPLeases(c.Param("name"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, leases)
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"virtpanel/internal/model"
+	"virtpanel/internal/task"
+	"virtpanel/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportVM streams a shut-off VM as an OVA. The disks are converted
+// before the first byte is sent, so errors up to then get a JSON reply.
+func (h *Handler) ExportVM(c *gin.Context) {
+	if format := c.DefaultQuery("format", "ova"); format != "ova" {
+		fail(c, badRequest("unsupported export format: %s", format))
+		return
+	}
+	name := c.Param("name")
+	exp, err := h.svc(c).ExportOVA(c.Request.Context(), name)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	defer exp.Close()
+	c.Header("Content-Type", "application/x-tar")
+	c.Header("Content-Disposition", `attachment; filename="`+name+`.ova"`)
+	if err := exp.Stream(c.Writer); err != nil {
+		log.Printf("导出 %s 失败: %v", name, err)
+	}
+}
+
+// ImportOVA defines a VM from an uploaded OVA; the settings come in the
+// query string next to the multipart file.
+func (h *Handler) ImportOVA(c *gin.Context) {
+	req := model.ImportOVARequest{
+		Name:     c.Query("name"),
+		Pool:     c.Query("pool"),
+		Format:   c.Query("format"),
+		DiskBus:  c.Query("disk_bus"),
+		NetModel: c.Query("net_model"),
+	}
+	if req.Name == "" {
+		fail(c, badRequest("name is required"))
+		return
+	}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		fail(c, badRequest("no file uploaded"))
+		return
+	}
+	if !strings.HasSuffix(strings.ToLower(header.Filename), ".ova") {
+		file.Close()
+		fail(c, badRequest("only .ova files allowed"))
+		return
+	}
+
+	svc := h.svc(c)
+	h.startTask(c, "import_ova", req.Name, h.emitAfter(c, webhook.VMCreated, req.Name, map[string]any{"imported": true}, ownedBy(principal(c), svc, req.Name, func(ctx context.Context, r *task.Reporter) error {
+		defer file.Close()
+		return svc.ImportOVA(ctx, req, file, header.Size, r)
+	})))
+}
@@ -9,9 +9,9 @@ import (
 )
 
 func (h *Handler) ListPortForwards(c *gin.Context) {
-	rules, err := h.svc.ListPortForwards()
+	rules, err := h.svc(c).ListPortForwards()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, rules)
@@ -20,11 +20,11 @@ func (h *Handler) ListPortForwards(c *gin.Context) {
 func (h *Handler) AddPortForward(c *gin.Context) {
 	var req service.PortForward
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.AddPortForward(req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).AddPortForward(req); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "ok"})
@@ -32,8 +32,8 @@ func (h *Handler) AddPortForward(c *gin.Context) {
 
 func (h *Handler) DeletePortForward(c *gin.Context) {
 	id := c.Param("id")
-	if err := h.svc.DeletePortForward(id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DeletePortForward(id); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "ok"})
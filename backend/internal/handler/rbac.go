@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"virtpanel/internal/auth"
+	"virtpanel/internal/model"
+	"virtpanel/internal/service"
+	"virtpanel/internal/task"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principal returns the account set by Authenticate.
+func principal(c *gin.Context) auth.Principal {
+	return c.MustGet("principal").(auth.Principal)
+}
+
+// RequireRole rejects callers below role.
+func (h *Handler) RequireRole(role auth.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !principal(c).Has(role) {
+			abort(c, errForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// VMAccess guards routes on /vms/:name: the caller needs role and must
+// own the VM, directly or through its group. VMs the caller cannot see
+// answer 404 like missing ones.
+func (h *Handler) VMAccess(role auth.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := principal(c)
+		if !p.Has(role) {
+			abort(c, errForbidden)
+			return
+		}
+		if p.Role == auth.RoleAdmin {
+			c.Next()
+			return
+		}
+		name := c.Param("name")
+		vm, err := h.svc(c).GetVM(name)
+		if err != nil || !p.Owns(vm.Owner, vm.Group) {
+			abort(c, errVMNotFound(name))
+			return
+		}
+		c.Next()
+	}
+}
+
+// visibleVMs drops the VMs the caller does not own.
+func visibleVMs(p auth.Principal, vms []model.VM) []model.VM {
+	if p.Role == auth.RoleAdmin {
+		return vms
+	}
+	out := make([]model.VM, 0, len(vms))
+	for _, vm := range vms {
+		if p.Owns(vm.Owner, vm.Group) {
+			out = append(out, vm)
+		}
+	}
+	return out
+}
+
+// canOperate reports whether the caller may change the named VM.
+func canOperate(p auth.Principal, svc service.Hypervisor, name string) bool {
+	if !p.Has(auth.RoleOperator) {
+		return false
+	}
+	if p.Role == auth.RoleAdmin {
+		return true
+	}
+	vm, err := svc.GetVM(name)
+	return err == nil && p.Owns(vm.Owner, vm.Group)
+}
+
+// ownedBy wraps a task that creates a VM so the new VM is owned by the
+// caller and the caller's first group.
+func ownedBy(p auth.Principal, svc service.Hypervisor, name string, fn task.Func) task.Func {
+	return func(ctx context.Context, r *task.Reporter) error {
+		if err := fn(ctx, r); err != nil {
+			return err
+		}
+		return setOwner(p, svc, name)
+	}
+}
+
+func setOwner(p auth.Principal, svc service.Hypervisor, name string) error {
+	return svc.SetVMOwner(name, model.VMOwner{Owner: p.Name, Group: p.DefaultGroup()})
+}
+
+// SetVMOwner hands a VM to another user or group (admin only).
+func (h *Handler) SetVMOwner(c *gin.Context) {
+	var req model.VMOwner
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	if err := h.svc(c).SetVMOwner(c.Param("name"), req); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+}
@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"net/http"
+
+	"virtpanel/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+type settingsInfo struct {
+	ConfigFile string         `json:"config_file"`
+	Settings   *config.Config `json:"settings"`
+}
+
+// GetSettings returns the effective backend configuration (read-only).
+func (h *Handler) GetSettings(c *gin.Context) {
+	c.JSON(http.StatusOK, settingsInfo{ConfigFile: h.cfg.Path(), Settings: h.cfg})
+}
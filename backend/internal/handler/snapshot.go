@@ -1,17 +1,20 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 
 	"virtpanel/internal/model"
+	"virtpanel/internal/task"
+	"virtpanel/internal/webhook"
 
 	"github.com/gin-gonic/gin"
 )
 
 func (h *Handler) ListSnapshots(c *gin.Context) {
-	snaps, err := h.svc.ListSnapshots(c.Param("name"))
+	snaps, err := h.svc(c).ListSnapshots(c.Param("name"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, snaps)
@@ -20,41 +23,44 @@ func (h *Handler) ListSnapshots(c *gin.Context) {
 func (h *Handler) CreateSnapshot(c *gin.Context) {
 	var req model.CreateSnapshotRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.CreateSnapshot(c.Param("name"), req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).CreateSnapshot(c.Param("name"), req); err != nil {
+		fail(c, err)
 		return
 	}
+	h.emit(c, webhook.SnapshotCreated, c.Param("name"), map[string]any{"snapshot": req.Name})
 	c.JSON(http.StatusOK, gin.H{"message": "created"})
 }
 
 func (h *Handler) DeleteSnapshot(c *gin.Context) {
-	if err := h.svc.DeleteSnapshot(c.Param("name"), c.Param("snap")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DeleteSnapshot(c.Param("name"), c.Param("snap")); err != nil {
+		fail(c, err)
 		return
 	}
+	h.emit(c, webhook.SnapshotDeleted, c.Param("name"), map[string]any{"snapshot": c.Param("snap")})
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
 }
 
 func (h *Handler) RevertSnapshot(c *gin.Context) {
-	if err := h.svc.RevertSnapshot(c.Param("name"), c.Param("snap")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).RevertSnapshot(c.Param("name"), c.Param("snap")); err != nil {
+		fail(c, err)
 		return
 	}
+	h.emit(c, webhook.SnapshotReverted, c.Param("name"), map[string]any{"snapshot": c.Param("snap")})
 	c.JSON(http.StatusOK, gin.H{"message": "reverted"})
 }
 
 func (h *Handler) RevertSnapshotToNew(c *gin.Context) {
 	var req model.RevertSnapshotToNewRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.RevertSnapshotToNew(c.Param("name"), c.Param("snap"), req.NewName); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "reverted to new vm"})
+	svc, vm, snap := h.svc(c), c.Param("name"), c.Param("snap")
+	data := map[string]any{"source": vm, "snapshot": snap}
+	h.startTask(c, "revert_snapshot_to_new", req.NewName, h.emitAfter(c, webhook.VMCreated, req.NewName, data, ownedBy(principal(c), svc, req.NewName, func(ctx context.Context, r *task.Reporter) error {
+		return svc.RevertSnapshotToNew(ctx, vm, snap, req, r)
+	})))
 }
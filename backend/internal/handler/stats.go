@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"virtpanel/internal/collector"
+	"virtpanel/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultStatsWindow = time.Hour
+	maxStatsWindow     = 31 * 24 * time.Hour // a little over the longest retention
+)
+
+// statsRange reads ?from=, ?to= (unix seconds or RFC 3339, default the
+// last hour up to now, at most 31 days apart) and ?step= (seconds or a
+// duration such as 5m, default about 300 points).
+func statsRange(c *gin.Context) (from, to time.Time, step time.Duration, err error) {
+	to = time.Now()
+	if s := c.Query("to"); s != "" {
+		if to, err = parseStatsTime(s); err != nil {
+			return from, to, 0, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	from = to.Add(-defaultStatsWindow)
+	if s := c.Query("from"); s != "" {
+		if from, err = parseStatsTime(s); err != nil {
+			return from, to, 0, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, 0, fmt.Errorf("from must be before to")
+	}
+	if to.Sub(from) > maxStatsWindow {
+		return from, to, 0, fmt.Errorf("time range must not exceed %d days", maxStatsWindow/(24*time.Hour))
+	}
+	if s := c.Query("step"); s != "" {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			step = time.Duration(n) * time.Second
+		} else if step, err = time.ParseDuration(s); err != nil {
+			return from, to, 0, fmt.Errorf("invalid step: %w", err)
+		}
+		if step <= 0 {
+			return from, to, 0, fmt.Errorf("step must be positive")
+		}
+	}
+	return from, to, step, nil
+}
+
+func parseStatsTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// GetVMStats returns the CPU, memory, disk and network history of a VM
+// for charts.
+func (h *Handler) GetVMStats(c *gin.Context) {
+	h.stats(c, func(from, to time.Time, step time.Duration) (*model.StatsSeries, error) {
+		return h.collector.VMStats(c.GetString("host"), c.Param("name"), from, to, step)
+	})
+}
+
+// GetHostStats returns the CPU, memory and load history of the selected
+// host.
+func (h *Handler) GetHostStats(c *gin.Context) {
+	h.stats(c, func(from, to time.Time, step time.Duration) (*model.StatsSeries, error) {
+		return h.collector.HostStats(c.GetString("host"), from, to, step)
+	})
+}
+
+func (h *Handler) stats(c *gin.Context, query func(from, to time.Time, step time.Duration) (*model.StatsSeries, error)) {
+	if h.collector == nil {
+		fail(c, collector.ErrDisabled)
+		return
+	}
+	from, to, step, err := statsRange(c)
+	if err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	series, err := query(from, to, step)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, series)
+}
@@ -9,9 +9,9 @@ import (
 )
 
 func (h *Handler) ListStoragePools(c *gin.Context) {
-	pools, err := h.svc.ListStoragePools()
+	pools, err := h.svc(c).ListStoragePools()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, pools)
@@ -20,35 +20,35 @@ func (h *Handler) ListStoragePools(c *gin.Context) {
 func (h *Handler) CreateStoragePool(c *gin.Context) {
 	var req model.CreateStoragePoolRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.CreateStoragePool(req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).CreateStoragePool(req); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "created"})
 }
 
 func (h *Handler) StartStoragePool(c *gin.Context) {
-	if err := h.svc.StartStoragePool(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).StartStoragePool(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "started"})
 }
 
 func (h *Handler) StopStoragePool(c *gin.Context) {
-	if err := h.svc.StopStoragePool(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).StopStoragePool(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "stopped"})
 }
 
 func (h *Handler) DeleteStoragePool(c *gin.Context) {
-	if err := h.svc.DeleteStoragePool(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DeleteStoragePool(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http"
+
+	"virtpanel/internal/auth"
+	"virtpanel/internal/model"
+	"virtpanel/internal/task"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startTask runs fn as a background task on the selected host and
+// answers 202 with the task ID. The task belongs to the caller.
+func (h *Handler) startTask(c *gin.Context, kind, target string, fn task.Func) {
+	p := principal(c)
+	t := h.tasks.Run(kind, c.GetString("host"), target, p.Name, p.DefaultGroup(), fn)
+	c.JSON(http.StatusAccepted, model.TaskAccepted{Message: "accepted", TaskID: t.ID})
+}
+
+// ListTasks lists the tasks the caller may see: their own and their
+// groups' for non-admins, like VMs.
+func (h *Handler) ListTasks(c *gin.Context) {
+	p := principal(c)
+	tasks := h.tasks.List()
+	if p.Role != auth.RoleAdmin {
+		own := make([]task.Task, 0, len(tasks))
+		for _, t := range tasks {
+			if p.Owns(t.Owner, t.Group) {
+				own = append(own, t)
+			}
+		}
+		tasks = own
+	}
+	c.JSON(http.StatusOK, tasks)
+}
+
+// visibleTask returns the task with the ID in the path. Tasks the caller
+// does not own answer 404 like missing ones.
+func (h *Handler) visibleTask(c *gin.Context) (task.Task, bool) {
+	t, ok := h.tasks.Get(c.Param("id"))
+	if !ok || !principal(c).Owns(t.Owner, t.Group) {
+		fail(c, task.ErrNotFound)
+		return task.Task{}, false
+	}
+	return t, true
+}
+
+func (h *Handler) GetTask(c *gin.Context) {
+	if t, ok := h.visibleTask(c); ok {
+		c.JSON(http.StatusOK, t)
+	}
+}
+
+func (h *Handler) CancelTask(c *gin.Context) {
+	t, ok := h.visibleTask(c)
+	if !ok {
+		return
+	}
+	if err := h.tasks.Cancel(t.ID); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "canceling"})
+}
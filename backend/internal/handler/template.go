@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"virtpanel/internal/model"
+	"virtpanel/internal/task"
+	"virtpanel/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) ListTemplates(c *gin.Context) {
+	vms, err := h.svc(c).ListVMs()
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	templates := make([]model.VM, 0)
+	for _, vm := range visibleVMs(principal(c), vms) {
+		if vm.Template {
+			templates = append(templates, vm)
+		}
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+func (h *Handler) SetTemplate(c *gin.Context) {
+	var req model.VMTemplate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	if err := h.svc(c).SetTemplate(c.Param("name"), req.Template); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+func (h *Handler) CreateFromTemplate(c *gin.Context) {
+	var req model.CreateFromTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	svc, tpl := h.svc(c), c.Param("name")
+	h.startTask(c, "create_from_template", req.Name, h.emitAfter(c, webhook.VMCreated, req.Name, map[string]any{"template": tpl}, ownedBy(principal(c), svc, req.Name, func(ctx context.Context, r *task.Reporter) error {
+		return svc.CreateFromTemplate(ctx, tpl, req, r)
+	})))
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"virtpanel/internal/auth"
+	"virtpanel/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenRoutes lists the routes API tokens may call and the scope each
+// needs ("" for any valid token). Everything else, including accounts,
+// tokens themselves, hosts and the audit log, needs a login session.
+var tokenRoutes = map[string]auth.Scope{
+	"GET /api/tasks":     "",
+	"GET /api/tasks/:id": "",
+	"GET /api/events":    auth.ScopeVMsRead,
+	"GET /metrics":       auth.ScopeMetricsRead,
+
+	"GET /api/vms":                        auth.ScopeVMsRead,
+	"GET /api/vms/:name":                  auth.ScopeVMsRead,
+	"GET /api/vms/:name/detail":           auth.ScopeVMsRead,
+	"GET /api/vms/:name/stats":            auth.ScopeVMsRead,
+	"GET /api/vms/:name/xml":              auth.ScopeVMsRead,
+	"GET /api/vms/:name/autostart":        auth.ScopeVMsRead,
+	"POST /api/vms":                       auth.ScopeVMsWrite,
+	"PUT /api/vms/:name":                  auth.ScopeVMsWrite,
+	"DELETE /api/vms/:name":               auth.ScopeVMsWrite,
+	"POST /api/vms/:name/clone":           auth.ScopeVMsWrite,
+	"POST /api/vms/:name/flatten":         auth.ScopeVMsWrite,
+	"PUT /api/vms/:name/autostart":        auth.ScopeVMsWrite,
+	"POST /api/vms/:name/rename":          auth.ScopeVMsWrite,
+	"POST /api/vms/import":                auth.ScopeVMsWrite,
+	"GET /api/vms/:name/export":           auth.ScopeVMsWrite,
+	"POST /api/vms/import-ova":            auth.ScopeVMsWrite,
+	"PUT /api/vms/:name/template":         auth.ScopeVMsWrite,
+	"GET /api/templates":                  auth.ScopeVMsRead,
+	"POST /api/templates/:name/vms":       auth.ScopeVMsWrite,
+	"POST /api/vms/:name/start":           auth.ScopeVMsPower,
+	"POST /api/vms/:name/shutdown":        auth.ScopeVMsPower,
+	"POST /api/vms/:name/destroy":         auth.ScopeVMsPower,
+	"POST /api/vms/:name/reboot":          auth.ScopeVMsPower,
+	"POST /api/vms/:name/suspend":         auth.ScopeVMsPower,
+	"POST /api/vms/:name/resume":          auth.ScopeVMsPower,
+	"POST /api/vms/batch":                 auth.ScopeVMsPower, // delete also needs vms:write
+	"POST /api/vms/:name/disks":           auth.ScopeVMsWrite,
+	"DELETE /api/vms/:name/disks/:target": auth.ScopeVMsWrite,
+	"POST /api/vms/:name/nics":            auth.ScopeVMsWrite,
+	"DELETE /api/vms/:name/nics/:mac":     auth.ScopeVMsWrite,
+	"POST /api/vms/:name/iso":             auth.ScopeVMsWrite,
+	"DELETE /api/vms/:name/iso":           auth.ScopeVMsWrite,
+
+	"GET /api/vms/:name/snapshots":                      auth.ScopeSnapshotsRead,
+	"POST /api/vms/:name/snapshots":                     auth.ScopeSnapshotsWrite,
+	"DELETE /api/vms/:name/snapshots/:snap":             auth.ScopeSnapshotsWrite,
+	"POST /api/vms/:name/snapshots/:snap/revert":        auth.ScopeSnapshotsWrite,
+	"POST /api/vms/:name/snapshots/:snap/revert-to-new": auth.ScopeSnapshotsWrite,
+
+	"GET /api/vms/:name/vnc": auth.ScopeVMsConsole,
+	"GET /ws/vnc/:name":      auth.ScopeVMsConsole,
+	"GET /ws/console/:name":  auth.ScopeVMsConsole,
+
+	"GET /api/hosts":                               auth.ScopeHostRead,
+	"GET /api/hosts/overview":                      auth.ScopeHostRead,
+	"GET /api/hosts/vms":                           auth.ScopeVMsRead,
+	"GET /api/host/info":                           auth.ScopeHostRead,
+	"GET /api/host/stats":                          auth.ScopeHostRead,
+	"GET /api/host/nics":                           auth.ScopeHostRead,
+	"GET /api/networks":                            auth.ScopeHostRead,
+	"GET /api/networks/:name/leases":               auth.ScopeHostRead,
+	"POST /api/networks":                           auth.ScopeHostWrite,
+	"POST /api/networks/:name/start":               auth.ScopeHostWrite,
+	"POST /api/networks/:name/stop":                auth.ScopeHostWrite,
+	"DELETE /api/networks/:name":                   auth.ScopeHostWrite,
+	"GET /api/storage-pools":                       auth.ScopeHostRead,
+	"POST /api/storage-pools":                      auth.ScopeHostWrite,
+	"POST /api/storage-pools/:name/start":          auth.ScopeHostWrite,
+	"POST /api/storage-pools/:name/stop":           auth.ScopeHostWrite,
+	"DELETE /api/storage-pools/:name":              auth.ScopeHostWrite,
+	"GET /api/storage-pools/:name/volumes":         auth.ScopeHostRead,
+	"POST /api/storage-volumes":                    auth.ScopeHostWrite,
+	"DELETE /api/storage-pools/:name/volumes/:vol": auth.ScopeHostWrite,
+	"GET /api/isos":                                auth.ScopeHostRead,
+	"POST /api/isos/upload":                        auth.ScopeHostWrite,
+	"DELETE /api/isos/:name":                       auth.ScopeHostWrite,
+	"GET /api/bridges":                             auth.ScopeHostRead,
+	"POST /api/bridges":                            auth.ScopeHostWrite,
+	"DELETE /api/bridges/:name":                    auth.ScopeHostWrite,
+	"GET /api/port-forwards":                       auth.ScopeHostRead,
+	"POST /api/port-forwards":                      auth.ScopeHostWrite,
+	"DELETE /api/port-forwards/:id":                auth.ScopeHostWrite,
+}
+
+// authenticateToken is Authenticate for "Authorization: Bearer" requests.
+// The token acts as its user, so the user's role and VM ownership still
+// apply; the scopes can only narrow that.
+func (h *Handler) authenticateToken(c *gin.Context, secret string) {
+	t, ok := h.auth.AuthenticateToken(secret)
+	var p auth.Principal
+	if ok {
+		p, ok = h.auth.Principal(t.User)
+	}
+	if !ok {
+		abort(c, errBadToken)
+		return
+	}
+	c.Set("user", t.User)
+	c.Set("token", t)
+	scope, listed := tokenRoutes[c.Request.Method+" "+c.FullPath()]
+	if !listed {
+		abort(c, errSessionOnly)
+		return
+	}
+	if scope != "" && !t.Allows(scope) {
+		abort(c, errScope(scope))
+		return
+	}
+	c.Set("principal", p)
+	c.Next()
+}
+
+// tokenAllows reports whether the request may use scope: always for
+// sessions, for API tokens only when they were given it.
+func tokenAllows(c *gin.Context, scope auth.Scope) bool {
+	t, ok := c.Get("token")
+	return !ok || t.(auth.TokenInfo).Allows(scope)
+}
+
+// ListTokens lists the caller's tokens; admins see everyone's with ?all=true.
+func (h *Handler) ListTokens(c *gin.Context) {
+	p, user := principal(c), principal(c).Name
+	if p.Role == auth.RoleAdmin && c.Query("all") == "true" {
+		user = ""
+	}
+	c.JSON(http.StatusOK, h.auth.Tokens(user))
+}
+
+// CreateToken issues a token for the caller. The secret is only in this
+// response.
+func (h *Handler) CreateToken(c *gin.Context) {
+	var req model.CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		fail(c, badRequest("expires_in_days must not be negative"))
+		return
+	}
+	var expires time.Time
+	if req.ExpiresInDays > 0 {
+		expires = time.Now().AddDate(0, 0, req.ExpiresInDays)
+	}
+	scopes := make([]auth.Scope, len(req.Scopes))
+	for i, s := range req.Scopes {
+		scopes[i] = auth.Scope(s)
+	}
+	t, err := h.auth.CreateToken(principal(c).Name, req.Name, scopes, expires)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, t)
+}
+
+// RevokeToken deletes one of the caller's tokens, or any token for admins.
+func (h *Handler) RevokeToken(c *gin.Context) {
+	p, user := principal(c), principal(c).Name
+	if p.Role == auth.RoleAdmin {
+		user = ""
+	}
+	if err := h.auth.RevokeToken(c.Param("id"), user); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "revoked"})
+}
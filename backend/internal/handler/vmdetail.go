@@ -1,39 +1,74 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"virtpanel/internal/model"
+	"virtpanel/internal/task"
+	"virtpanel/internal/webhook"
 
 	"github.com/gin-gonic/gin"
 )
 
 func (h *Handler) GetVMDetail(c *gin.Context) {
-	detail, err := h.svc.GetVMDetail(c.Param("name"))
+	detail, err := h.svc(c).GetVMDetail(c.Param("name"))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, detail)
 }
 
+// GetVMXML returns the domain definition, the persistent one with
+// ?inactive=true.
+func (h *Handler) GetVMXML(c *gin.Context) {
+	inactive, _ := strconv.ParseBool(c.Query("inactive"))
+	doc, err := h.svc(c).GetVMXML(c.Param("name"), inactive)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, model.VMXML{XML: doc})
+}
+
+// UpdateVMXML replaces the domain definition. ?dry_run=true (or dry_run in
+// the body) only validates and returns the diff.
+func (h *Handler) UpdateVMXML(c *gin.Context) {
+	var req model.UpdateVMXMLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	if dry, _ := strconv.ParseBool(c.Query("dry_run")); dry {
+		req.DryRun = true
+	}
+	res, err := h.svc(c).UpdateVMXML(c.Param("name"), req)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
 func (h *Handler) AttachDisk(c *gin.Context) {
 	var req model.AttachDiskRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.AttachDisk(c.Param("name"), req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).AttachDisk(c.Param("name"), req); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "attached"})
 }
 
 func (h *Handler) DetachDisk(c *gin.Context) {
-	if err := h.svc.DetachDisk(c.Param("name"), c.Param("target")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DetachDisk(c.Param("name"), c.Param("target")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "detached"})
@@ -42,11 +77,11 @@ func (h *Handler) DetachDisk(c *gin.Context) {
 func (h *Handler) AttachNIC(c *gin.Context) {
 	var req model.AttachNICRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.AttachNIC(c.Param("name"), req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).AttachNIC(c.Param("name"), req); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "attached"})
@@ -58,8 +93,8 @@ func (h *Handler) DetachNIC(c *gin.Context) {
 	if decoded, err := url.PathUnescape(mac); err == nil {
 		mac = decoded
 	}
-	if err := h.svc.DetachNIC(c.Param("name"), mac); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DetachNIC(c.Param("name"), mac); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "detached"})
@@ -68,19 +103,19 @@ func (h *Handler) DetachNIC(c *gin.Context) {
 func (h *Handler) AttachISO(c *gin.Context) {
 	var req model.AttachISORequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.AttachISO(c.Param("name"), req.Path); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).AttachISO(c.Param("name"), req.Path); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "attached"})
 }
 
 func (h *Handler) DetachISO(c *gin.Context) {
-	if err := h.svc.DetachISO(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DetachISO(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "detached"})
@@ -89,19 +124,25 @@ func (h *Handler) DetachISO(c *gin.Context) {
 func (h *Handler) CloneVM(c *gin.Context) {
 	var req model.CloneVMRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.CloneVM(c.Param("name"), req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "cloned"})
+	svc, src := h.svc(c), c.Param("name")
+	h.startTask(c, "clone_vm", req.NewName, h.emitAfter(c, webhook.VMCreated, req.NewName, map[string]any{"source": src}, ownedBy(principal(c), svc, req.NewName, func(ctx context.Context, r *task.Reporter) error {
+		return svc.CloneVM(ctx, src, req, r)
+	})))
+}
+
+func (h *Handler) FlattenVM(c *gin.Context) {
+	svc, name := h.svc(c), c.Param("name")
+	h.startTask(c, "flatten_vm", name, func(ctx context.Context, r *task.Reporter) error {
+		return svc.FlattenVM(ctx, name, r)
+	})
 }
 
 func (h *Handler) FinishInstall(c *gin.Context) {
-	if err := h.svc.FinishInstall(c.Param("name")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).FinishInstall(c.Param("name")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "ok"})
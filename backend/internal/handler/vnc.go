@@ -1,11 +1,14 @@
 package handler
 
 import (
-	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
 
+	"virtpanel/internal/model"
+	"virtpanel/internal/service"
+
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
@@ -17,17 +20,17 @@ var upgrader = websocket.Upgrader{
 // VNCWebSocket proxies a WebSocket connection to the VM's VNC port
 func (h *Handler) VNCWebSocket(c *gin.Context) {
 	name := c.Param("name")
-	port, err := h.svc.GetVNCPort(name)
+	port, err := h.svc(c).GetVNCPort(name)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 
 	// Connect to VNC server
-	vncAddr := fmt.Sprintf("127.0.0.1:%d", port)
+	vncAddr := net.JoinHostPort(h.hosts.Address(c.Query("host")), strconv.Itoa(port))
 	vncConn, err := net.Dial("tcp", vncAddr)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot connect to vnc: " + err.Error()})
+		fail(c, &service.Error{Kind: service.Unavailable, Code: service.CodeHostUnavailable, Msg: "cannot connect to vnc", Err: err})
 		return
 	}
 
@@ -90,10 +93,10 @@ func (h *Handler) VNCWebSocket(c *gin.Context) {
 
 // GetVNCPort returns the VNC port info for a VM
 func (h *Handler) GetVNCPort(c *gin.Context) {
-	port, err := h.svc.GetVNCPort(c.Param("name"))
+	port, err := h.svc(c).GetVNCPort(c.Param("name"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"port": port})
+	c.JSON(http.StatusOK, model.VNCPort{Port: port})
 }
@@ -9,9 +9,9 @@ import (
 )
 
 func (h *Handler) ListVolumes(c *gin.Context) {
-	vols, err := h.svc.ListVolumes(c.Param("name"))
+	vols, err := h.svc(c).ListVolumes(c.Param("name"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, vols)
@@ -20,19 +20,19 @@ func (h *Handler) ListVolumes(c *gin.Context) {
 func (h *Handler) CreateVolume(c *gin.Context) {
 	var req model.CreateVolumeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, badRequest("%v", err))
 		return
 	}
-	if err := h.svc.CreateVolume(req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).CreateVolume(req); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "created"})
 }
 
 func (h *Handler) DeleteVolume(c *gin.Context) {
-	if err := h.svc.DeleteVolume(c.Param("name"), c.Param("vol")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc(c).DeleteVolume(c.Param("name"), c.Param("vol")); err != nil {
+		fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"virtpanel/internal/task"
+	"virtpanel/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// vmEvent describes a VM operation the caller made on the selected host.
+// It is built before a task starts, as the gin context must not be used
+// once the handler has returned.
+func vmEvent(c *gin.Context, typ, vm string, data map[string]any) webhook.Event {
+	return webhook.Event{Type: typ, Host: c.GetString("host"), VM: vm, Actor: principal(c).Name, Data: data}
+}
+
+// emit sends a VM lifecycle event to the webhooks.
+func (h *Handler) emit(c *gin.Context, typ, vm string, data map[string]any) {
+	h.webhooks.Emit(vmEvent(c, typ, vm, data))
+}
+
+// emitAfter wraps a task so the event is sent once fn succeeds.
+func (h *Handler) emitAfter(c *gin.Context, typ, vm string, data map[string]any, fn task.Func) task.Func {
+	e := vmEvent(c, typ, vm, data)
+	return func(ctx context.Context, r *task.Reporter) error {
+		if err := fn(ctx, r); err != nil {
+			return err
+		}
+		h.webhooks.Emit(e)
+		return nil
+	}
+}
+
+func (h *Handler) ListWebhooks(c *gin.Context) {
+	c.JSON(http.StatusOK, h.webhooks.List())
+}
+
+// CreateWebhook registers an endpoint. The response is the only one that
+// shows the secret.
+func (h *Handler) CreateWebhook(c *gin.Context) {
+	var req webhook.Webhook
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	w, err := h.webhooks.Add(req)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, w)
+}
+
+// UpdateWebhook replaces a webhook; an empty secret keeps the current
+// one.
+func (h *Handler) UpdateWebhook(c *gin.Context) {
+	var req webhook.Webhook
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, badRequest("%v", err))
+		return
+	}
+	w, err := h.webhooks.Update(c.Param("id"), req)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, w)
+}
+
+func (h *Handler) DeleteWebhook(c *gin.Context) {
+	if err := h.webhooks.Delete(c.Param("id")); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// ListWebhookDeliveries returns the recent deliveries of a webhook,
+// newest first.
+func (h *Handler) ListWebhookDeliveries(c *gin.Context) {
+	list, err := h.webhooks.Deliveries(c.Param("id"))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// TestWebhook sends a test event and returns the delivery. A failed
+// delivery is reported as 502.
+func (h *Handler) TestWebhook(c *gin.Context) {
+	d, err := h.webhooks.Test(c.Param("id"), principal(c).Name)
+	switch {
+	case errors.Is(err, webhook.ErrNotFound):
+		fail(c, err)
+	case err != nil:
+		fail(c, deliveryFailed(err))
+	default:
+		c.JSON(http.StatusOK, d)
+	}
+}
@@ -0,0 +1,155 @@
+// Package metrics writes the Prometheus text exposition format (version
+// 0.0.4) and keeps the histograms the backend records about itself.
+// Everything else is read from the hosts at scrape time.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Writer writes metric families. Samples of one family must follow its
+// Header; Header of an already written family is ignored, so a family can
+// be continued for each host.
+type Writer struct {
+	w    *bufio.Writer
+	seen map[string]bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w), seen: make(map[string]bool)}
+}
+
+// Header starts the family name of type typ (counter, gauge, histogram).
+func (w *Writer) Header(name, typ, help string) {
+	if w.seen[name] {
+		return
+	}
+	w.seen[name] = true
+	w.w.WriteString("# HELP " + name + " " + escape(help, false) + "\n")
+	w.w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample writes one sample; labels are name, value pairs.
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.w.WriteByte(',')
+			}
+			w.w.WriteString(labels[i] + `="` + escape(labels[i+1], true) + `"`)
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteByte(' ')
+	w.w.WriteString(formatFloat(value))
+	w.w.WriteByte('\n')
+}
+
+// Gauge writes a family with a single unlabeled sample.
+func (w *Writer) Gauge(name, help string, value float64) {
+	w.Header(name, "gauge", help)
+	w.Sample(name, value)
+}
+
+func (w *Writer) Flush() error { return w.w.Flush() }
+
+func escape(s string, quote bool) string {
+	r := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	if quote {
+		r = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	}
+	return r.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// DefBuckets suit request latencies in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into cumulative buckets, per combination
+// of label values.
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histSeries
+}
+
+type histSeries struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histSeries)}
+}
+
+// Observe records v; values are given in the order of the label names.
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histSeries{values: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Write writes the family in a stable order.
+func (h *Histogram) Write(w *Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w.Header(h.name, "histogram", h.help)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		labels := make([]string, 0, 2*len(h.labels)+2)
+		for i, name := range h.labels {
+			labels = append(labels, name, s.values[i])
+		}
+		var cum uint64
+		for i, b := range h.buckets {
+			cum += s.counts[i]
+			w.Sample(h.name+"_bucket", float64(cum), append(labels, "le", formatFloat(b))...)
+		}
+		w.Sample(h.name+"_bucket", float64(s.count), append(labels, "le", "+Inf")...)
+		w.Sample(h.name+"_sum", s.sum, labels...)
+		w.Sample(h.name+"_count", float64(s.count), labels...)
+	}
+}
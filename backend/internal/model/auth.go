@@ -0,0 +1,48 @@
+package model
+
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// SetupRequest creates the first account. The setup token is printed to
+// the backend log at startup.
+type SetupRequest struct {
+	SetupToken string `json:"setup_token" binding:"required"`
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+}
+
+type CreateUserRequest struct {
+	Username string   `json:"username" binding:"required"`
+	Password string   `json:"password" binding:"required"`
+	Role     string   `json:"role"` // admin, operator, viewer (default)
+	Groups   []string `json:"groups"`
+}
+
+type UpdateUserRequest struct {
+	Role   string   `json:"role" binding:"required"`
+	Groups []string `json:"groups"`
+}
+
+type SetPasswordRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type AuthStatus struct {
+	SetupRequired bool   `json:"setup_required"`
+	User          string `json:"user,omitempty"` // empty when not logged in
+	Role          string `json:"role,omitempty"`
+}
+
+// CreateTokenRequest issues an API token for the caller.
+type CreateTokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0: never expires
+}
+
+type LoginResult struct {
+	Message string `json:"message"`
+	User    string `json:"user"`
+}
@@ -8,6 +8,23 @@ type VM struct {
 	Memory    int     `json:"memory"`     // MB (allocated)
 	CPUUsage  float64 `json:"cpu_usage"`  // percent 0-100
 	MemUsed   int     `json:"mem_used"`   // MB (actually used inside guest)
+	DiskRead  float64 `json:"disk_read"`  // bytes/s, all disks
+	DiskWrite float64 `json:"disk_write"` // bytes/s, all disks
+	DiskIOPS  float64 `json:"disk_iops"`  // read + write requests/s
+	NetRx     float64 `json:"net_rx"`     // bytes/s, all NICs
+	NetTx     float64 `json:"net_tx"`     // bytes/s, all NICs
+	Host      string  `json:"host,omitempty"` // set in cross-host listings
+	Owner     string  `json:"owner,omitempty"`
+	Group     string  `json:"group,omitempty"`
+	Template  bool    `json:"template,omitempty"` // read-only golden image, see /api/templates
+	LinkedBase string `json:"linked_base,omitempty"` // template whose disks back this linked clone
+}
+
+// VMOwner is who may operate a VM besides admins: the owning user and
+// the members of the owning group.
+type VMOwner struct {
+	Owner string `json:"owner"`
+	Group string `json:"group"`
 }
 
 type CreateVMRequest struct {
@@ -26,6 +43,28 @@ type CreateVMRequest struct {
 	NetMode   string `json:"net_mode"`   // nat, bridge, macvtap
 	BridgeName string `json:"bridge_name"` // bridge name for bridge mode
 	MacvtapDev string `json:"macvtap_dev"` // physical device for macvtap
+	CloudInit *CloudInit `json:"cloud_init,omitempty"` // attach a NoCloud seed ISO
+}
+
+// CloudInit is turned into the user-data, meta-data and network-config
+// files of a NoCloud seed. UserData, when set, is used as is and cannot
+// be combined with Users, SSHKeys or Packages.
+type CloudInit struct {
+	Hostname      string          `json:"hostname"`       // default: the VM name
+	Users         []CloudInitUser `json:"users"`          // created besides the image's default user
+	SSHKeys       []string        `json:"ssh_keys"`       // authorized for the default user
+	Packages      []string        `json:"packages"`       // installed on first boot
+	UserData      string          `json:"user_data"`      // raw user-data (#cloud-config, script, ...)
+	NetworkConfig string          `json:"network_config"` // raw network-config, version 1 or 2 YAML
+}
+
+type CloudInitUser struct {
+	Name         string   `json:"name"`
+	PasswordHash string   `json:"password_hash"` // crypt(3) hash, e.g. from mkpasswd -m sha-512; empty locks the password
+	SSHKeys      []string `json:"ssh_keys"`
+	Sudo         bool     `json:"sudo"` // passwordless sudo
+	Groups       []string `json:"groups"`
+	Shell        string   `json:"shell"` // default /bin/bash
 }
 
 type HostInfo struct {
@@ -124,6 +163,20 @@ type UpdateVMRequest struct {
 	Memory int `json:"memory"` // MB
 }
 
+// UpdateVMXMLRequest replaces the persistent domain definition.
+type UpdateVMXMLRequest struct {
+	XML    string `json:"xml" binding:"required"`
+	DryRun bool   `json:"dry_run"` // validate and diff only
+}
+
+type VMXMLResult struct {
+	Diff            string `json:"diff"` // unified diff against the current definition
+	Changed         bool   `json:"changed"`
+	Validated       bool   `json:"validated"` // checked against the libvirt schema
+	Applied         bool   `json:"applied"`
+	RestartRequired bool   `json:"restart_required"` // running VM picks it up on next boot
+}
+
 type VMDetail struct {
 	Name   string       `json:"name"`
 	UUID   string       `json:"uuid"`
@@ -137,11 +190,12 @@ type VMDetail struct {
 }
 
 type VMDisk struct {
-	Device string `json:"device"`
-	Source string `json:"source"`
-	Target string `json:"target"`
-	Bus    string `json:"bus"`
-	Format string `json:"format"`
+	Device string  `json:"device"`
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Bus    string  `json:"bus"`
+	Format string  `json:"format"`
+	IO     *DiskIO `json:"io,omitempty"` // running VMs, from the second sample on
 }
 
 type VMNIC struct {
@@ -149,6 +203,8 @@ type VMNIC struct {
 	Source  string `json:"source"`
 	MAC     string `json:"mac"`
 	Model   string `json:"model"`
+	Target  string `json:"target,omitempty"` // host-side device, e.g. vnet0
+	IO      *NICIO `json:"io,omitempty"`
 }
 
 type AttachDiskRequest struct {
@@ -171,10 +227,15 @@ type AttachISORequest struct {
 
 type CloneVMRequest struct {
 	NewName string `json:"new_name" binding:"required"`
+	Linked  bool   `json:"linked"` // qcow2 overlays on the source's disks instead of full copies; the source must be a template
+	Pool    string `json:"pool"`   // storage pool for the copied disks; default: the pool of each source disk
+	Format  string `json:"format"` // qcow2 or raw; default: the format of each source disk
 }
 
 type RevertSnapshotToNewRequest struct {
 	NewName string `json:"new_name" binding:"required"`
+	Pool    string `json:"pool"`   // as in CloneVMRequest
+	Format  string `json:"format"` // as in CloneVMRequest
 }
 
 type RenameVMRequest struct {
@@ -189,6 +250,15 @@ type ImportVMRequest struct {
 	DiskBus  string `json:"disk_bus"`
 }
 
+// ImportOVARequest holds the query parameters of an OVA upload.
+type ImportOVARequest struct {
+	Name     string `json:"name"`
+	Pool     string `json:"pool"`      // storage pool for the disks; default: the one of image_dir
+	Format   string `json:"format"`    // qcow2 (default) or raw
+	DiskBus  string `json:"disk_bus"`  // default: ide stays ide, other controllers become sata
+	NetModel string `json:"net_model"` // default: virtio stays virtio, other adapters become e1000
+}
+
 type BatchActionRequest struct {
 	Names  []string `json:"names" binding:"required"`
 	Action string   `json:"action" binding:"required"`
@@ -205,3 +275,110 @@ type CreateBridgeRequest struct {
 	Name    string `json:"name" binding:"required"`
 	SlaveNIC string `json:"slave_nic"` // optional: physical NIC to attach
 }
+
+type Host struct {
+	Name       string `json:"name"`
+	URI        string `json:"uri"`
+	Local      bool   `json:"local"`      // same machine as the panel (bridges, port forwards, ISO uploads)
+	Builtin    bool   `json:"builtin"`    // from the config file, cannot be removed via API
+	Default    bool   `json:"default"`    // used when no ?host= is given
+	Connected  bool   `json:"connected"`
+	Error      string `json:"error,omitempty"`
+	LastCheck  int64  `json:"last_check"` // unix seconds
+	LatencyMS  int64  `json:"latency_ms"`
+	Reconnects uint64 `json:"reconnects"`
+}
+
+type AddHostRequest struct {
+	Name string `json:"name" binding:"required"`
+	URI  string `json:"uri" binding:"required"` // qemu+tcp://, qemu+tls://, qemu+ssh://, sim://
+}
+
+type HostOverview struct {
+	Host
+	Info *HostInfo `json:"info,omitempty"`
+}
+
+type HostVMs struct {
+	VMs    []VM              `json:"vms"`
+	Errors map[string]string `json:"errors,omitempty"` // host -> error for unreachable hosts
+}
+
+// Event is a lifecycle change on a host, streamed on /api/events.
+type Event struct {
+	Time   int64  `json:"time"`             // unix seconds
+	Host   string `json:"host"`
+	Kind   string `json:"kind"`             // domain, agent, network, pool, host
+	Name   string `json:"name"`             // object name (VM, network, pool or host)
+	Type   string `json:"type"`             // e.g. started, stopped, defined, undefined, connected
+	Detail string `json:"detail,omitempty"` // e.g. booted, destroyed, crashed
+	Owner  string `json:"owner,omitempty"`  // domain and agent events: the VM's owner and group,
+	Group  string `json:"group,omitempty"`  // as they were when the event happened
+}
+
+// Message is the reply of actions that return nothing else.
+type Message struct {
+	Message string `json:"message"`
+}
+
+// ErrorResponse is the body of every error reply. Code is stable and
+// machine-readable, e.g. vm_not_found; Error is for humans.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// TaskAccepted answers requests that run as a task (202).
+type TaskAccepted struct {
+	Message string `json:"message"`
+	TaskID  string `json:"task_id"`
+}
+
+// BatchResult is the reply of /api/vms/batch. Errors holds the VMs the
+// action failed for.
+type BatchResult struct {
+	Message string            `json:"message"` // ok, partial
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+type Autostart struct {
+	Autostart bool `json:"autostart"`
+}
+
+// VMTemplate turns a shut-off VM into a template or back.
+type VMTemplate struct {
+	Template bool `json:"template"`
+}
+
+// CreateFromTemplateRequest creates a VM from a copy of a template's
+// definition and disks. Zero values keep the template's settings.
+type CreateFromTemplateRequest struct {
+	Name       string     `json:"name" binding:"required"`
+	CPU        int        `json:"cpu"`
+	Memory     int        `json:"memory"`      // MB
+	Disk       int        `json:"disk"`        // GB, grows the first disk; it cannot shrink
+	NetMode    string     `json:"net_mode"`    // nat, bridge, macvtap; replaces the template's NICs
+	NetModel   string     `json:"net_model"`   // virtio,e1000,rtl8139
+	BridgeName string     `json:"bridge_name"` // bridge name for bridge mode
+	MacvtapDev string     `json:"macvtap_dev"` // physical device for macvtap
+	CloudInit  *CloudInit `json:"cloud_init,omitempty"` // replaces the template's seed
+	Linked     bool       `json:"linked"`               // qcow2 overlays on the template's disks instead of full copies
+	Pool       string     `json:"pool"`                 // as in CloneVMRequest, for full copies
+	Format     string     `json:"format"`               // as in CloneVMRequest, for full copies
+}
+
+type VMXML struct {
+	XML string `json:"xml"`
+}
+
+type VNCPort struct {
+	Port int `json:"port"`
+}
+
+// PhysicalNIC is a host interface a bridge can be attached to.
+type PhysicalNIC struct {
+	Name string `json:"name"`
+	MAC  string `json:"mac"`
+	IP   string `json:"ip"`
+	Up   bool   `json:"up"`
+}
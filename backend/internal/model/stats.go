@@ -0,0 +1,99 @@
+package model
+
+// GuestStats are the cumulative counters of one domain, read for all
+// domains of a host in one call. Counters restart from zero when the
+// domain is started again; shut off domains only have Name, UUID and
+// State.
+type GuestStats struct {
+	Name    string       `json:"name"`
+	UUID    string       `json:"uuid"`
+	State   string       `json:"state"`
+	VCPUs   int          `json:"vcpus"`
+	CPUTime uint64       `json:"cpu_time"` // ns, all vCPUs
+	Balloon BalloonStats `json:"balloon"`
+	Blocks  []BlockStats `json:"blocks"`
+	NICs    []NICStats   `json:"nics"`
+}
+
+// BalloonStats are in KiB. The guest-side values are zero without a
+// balloon driver in the guest.
+type BalloonStats struct {
+	Current     uint64 `json:"current"` // memory the guest has now
+	Maximum     uint64 `json:"maximum"`
+	Available   uint64 `json:"available"` // total memory seen by the guest
+	Unused      uint64 `json:"unused"`
+	Usable      uint64 `json:"usable"`
+	RSS         uint64 `json:"rss"` // resident memory of the qemu process
+	SwapIn      uint64 `json:"swap_in"`
+	SwapOut     uint64 `json:"swap_out"`
+	MajorFaults uint64 `json:"major_faults"`
+	MinorFaults uint64 `json:"minor_faults"`
+}
+
+type BlockStats struct {
+	Device     string `json:"device"` // target, e.g. vda
+	Path       string `json:"path"`
+	ReadBytes  uint64 `json:"read_bytes"`
+	ReadReqs   uint64 `json:"read_reqs"`
+	WriteBytes uint64 `json:"write_bytes"`
+	WriteReqs  uint64 `json:"write_reqs"`
+	ReadTime   uint64 `json:"read_time"`  // ns spent on reads
+	WriteTime  uint64 `json:"write_time"` // ns spent on writes
+	Capacity   uint64 `json:"capacity"`   // bytes
+	Allocation uint64 `json:"allocation"` // bytes
+}
+
+type NICStats struct {
+	Device    string `json:"device"` // host side, e.g. vnet0
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDrops   uint64 `json:"rx_drops"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDrops   uint64 `json:"tx_drops"`
+}
+
+// DiskIO is the I/O of one disk per second between two samples.
+// Latencies are the average time per request in milliseconds.
+type DiskIO struct {
+	ReadBytes    float64 `json:"read_bytes"`
+	WriteBytes   float64 `json:"write_bytes"`
+	ReadIOPS     float64 `json:"read_iops"`
+	WriteIOPS    float64 `json:"write_iops"`
+	ReadLatency  float64 `json:"read_latency"`
+	WriteLatency float64 `json:"write_latency"`
+}
+
+// NICIO is the traffic of one interface per second between two samples,
+// seen from the guest.
+type NICIO struct {
+	RxBytes   float64 `json:"rx_bytes"`
+	RxPackets float64 `json:"rx_packets"`
+	RxErrors  float64 `json:"rx_errors"`
+	RxDrops   float64 `json:"rx_drops"`
+	TxBytes   float64 `json:"tx_bytes"`
+	TxPackets float64 `json:"tx_packets"`
+	TxErrors  float64 `json:"tx_errors"`
+	TxDrops   float64 `json:"tx_drops"`
+}
+
+// HostStats is one host's status, host info and guest counters. Info and
+// Guests are nil when the host is not connected or the read failed.
+type HostStats struct {
+	Host   Host         `json:"host"`
+	Info   *HostInfo    `json:"info,omitempty"`
+	Guests []GuestStats `json:"guests,omitempty"`
+}
+
+// StatsSeries is the history of a VM or host for charts. Time holds the
+// start of each step (unix seconds) and Values one array per field,
+// averaged over the step; steps without samples are left out.
+type StatsSeries struct {
+	From   int64                `json:"from"`
+	To     int64                `json:"to"`
+	Step   int64                `json:"step"` // seconds
+	Time   []int64              `json:"time"`
+	Values map[string][]float64 `json:"values"`
+}
@@ -0,0 +1,353 @@
+// Package openapi builds an OpenAPI 3 document from a route table and the
+// Go types the routes take and return. Schemas are derived by reflection
+// from the json tags, so the document follows the model package without
+// being maintained by hand. The Go-only fields of Schema (GoName, GoType,
+// ...) let the client generator rebuild equivalent Go types.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+	Security   []Requirement        `json:"security,omitempty"`
+
+	// Operations lists the operations in route table order.
+	Operations []*Operation `json:"-"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps lower-case HTTP methods to operations.
+type PathItem map[string]*Operation
+
+// Requirement names security schemes that together authorize a request.
+type Requirement map[string][]string
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	Security    *[]Requirement       `json:"security,omitempty"` // empty for public routes
+
+	// Route is the entry the operation was built from.
+	Route *Route `json:"-"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // path, query
+	Required    bool    `json:"required,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type   string `json:"type"`             // apiKey, http
+	In     string `json:"in,omitempty"`     // cookie for apiKey
+	Name   string `json:"name,omitempty"`   // cookie name
+	Scheme string `json:"scheme,omitempty"` // bearer for http
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+
+	// Order lists Properties in struct field order.
+	Order []string `json:"-"`
+	// GoName is the struct field a property comes from.
+	GoName string `json:"-"`
+	// GoType is the Go kind of a primitive (int64, float64, ...).
+	GoType string `json:"-"`
+	// OmitEmpty is set for properties tagged omitempty.
+	OmitEmpty bool `json:"-"`
+	// Raw marks json.RawMessage values.
+	Raw bool `json:"-"`
+}
+
+// Param is a query parameter. Type is string, boolean or integer.
+type Param struct {
+	Name string
+	Type string
+	Doc  string
+}
+
+// Route describes one endpoint. Path uses gin's :param syntax.
+type Route struct {
+	Method  string
+	Path    string
+	ID      string // operationId, also the client method name
+	Summary string
+	Tag     string
+	Query   []Param
+	Body    any // request body, a zero value of its type
+	Result  any // response body, a zero value of its type
+	Status  int // success status, 200 when zero
+	Public  bool
+
+	// Stream is the content type of a response that is not one JSON
+	// value, e.g. text/event-stream.
+	Stream string
+	// Upload is the multipart form field of a file upload.
+	Upload string
+	// WebSocket marks upgrade endpoints.
+	WebSocket bool
+}
+
+// Key returns "METHOD /path", the form gin route tables are compared in.
+func (r *Route) Key() string { return r.Method + " " + r.Path }
+
+// Spec is the input of Build.
+type Spec struct {
+	Info   Info
+	Routes []Route
+	// Error is the body of every error response.
+	Error any
+	// Extra adds schemas for types no route takes or returns directly,
+	// e.g. stream messages.
+	Extra []any
+	// Names renames schemas, keyed by "package.Type"; other schemas are
+	// named after their Go type.
+	Names map[string]string
+}
+
+type builder struct {
+	spec    *Spec
+	schemas map[string]*Schema
+	owners  map[string]reflect.Type
+}
+
+// Build turns the spec into a document. It panics on two Go types with
+// the same schema name, which is a mistake in the spec.
+func Build(spec Spec) *Document {
+	b := &builder{spec: &spec, schemas: make(map[string]*Schema), owners: make(map[string]reflect.Type)}
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info:    spec.Info,
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			Schemas: b.schemas,
+			SecuritySchemes: map[string]SecurityScheme{
+				"session": {Type: "apiKey", In: "cookie", Name: "virtpanel_session"},
+				"token":   {Type: "http", Scheme: "bearer"},
+			},
+		},
+		Security: []Requirement{{"session": {}}, {"token": {}}},
+	}
+	var errSchema *Schema
+	if spec.Error != nil {
+		errSchema = b.schemaOf(reflect.TypeOf(spec.Error))
+	}
+	for _, v := range spec.Extra {
+		b.schemaOf(reflect.TypeOf(v))
+	}
+	for i := range spec.Routes {
+		r := &spec.Routes[i]
+		p, params := convertPath(r.Path)
+		item := doc.Paths[p]
+		if item == nil {
+			item = &PathItem{}
+			doc.Paths[p] = item
+		}
+		op := &Operation{OperationID: r.ID, Summary: r.Summary, Parameters: params, Route: r, Responses: map[string]*Response{}}
+		if r.Tag != "" {
+			op.Tags = []string{r.Tag}
+		}
+		if r.Public {
+			op.Security = &[]Requirement{}
+		}
+		for _, q := range r.Query {
+			op.Parameters = append(op.Parameters, Parameter{Name: q.Name, In: "query", Description: q.Doc, Schema: &Schema{Type: q.Type}})
+		}
+		switch {
+		case r.Upload != "":
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{"multipart/form-data": {Schema: &Schema{
+				Type:       "object",
+				Properties: map[string]*Schema{r.Upload: {Type: "string", Format: "binary"}},
+				Required:   []string{r.Upload},
+			}}}}
+		case r.Body != nil:
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: b.schemaOf(reflect.TypeOf(r.Body))}}}
+		}
+		status := r.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ok := &Response{Description: http.StatusText(status)}
+		switch {
+		case r.WebSocket:
+			status = http.StatusSwitchingProtocols
+			ok.Description = "WebSocket upgrade"
+		case r.Stream != "":
+			ok.Content = map[string]MediaType{r.Stream: {Schema: &Schema{Type: "string"}}}
+		case r.Result != nil:
+			ok.Content = map[string]MediaType{"application/json": {Schema: b.schemaOf(reflect.TypeOf(r.Result))}}
+		}
+		op.Responses[fmt.Sprint(status)] = ok
+		if errSchema != nil {
+			op.Responses["default"] = &Response{Description: "Error", Content: map[string]MediaType{"application/json": {Schema: errSchema}}}
+		}
+		(*item)[strings.ToLower(r.Method)] = op
+		doc.Operations = append(doc.Operations, op)
+	}
+	return doc
+}
+
+// convertPath turns /vms/:name into /vms/{name} and returns the path
+// parameters.
+func convertPath(p string) (string, []Parameter) {
+	var params []Parameter
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		if strings.HasPrefix(s, ":") {
+			name := s[1:]
+			segs[i] = "{" + name + "}"
+			params = append(params, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+	}
+	return strings.Join(segs, "/"), params
+}
+
+var rawMessage = reflect.TypeOf(json.RawMessage(nil))
+
+// schemaOf returns the schema of t, a $ref for named structs.
+func (b *builder) schemaOf(t reflect.Type) *Schema {
+	if t == rawMessage {
+		return &Schema{Raw: true}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := b.schemaOf(t.Elem())
+		if s.Ref != "" {
+			return &Schema{Ref: s.Ref, Nullable: true}
+		}
+		s.Nullable = true
+		return s
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := b.name(t)
+		if owner, ok := b.owners[name]; ok {
+			if owner != t {
+				panic(fmt.Sprintf("openapi: schema %s used for %v and %v", name, owner, t))
+			}
+		} else {
+			b.owners[name] = t
+			b.schemas[name] = nil // placeholder against recursion
+			b.schemas[name] = b.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte", GoType: "[]byte"}
+		}
+		return &Schema{Type: "array", Items: b.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schemaOf(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.String:
+		return &Schema{Type: "string", GoType: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean", GoType: "bool"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		format := "int64"
+		if t.Bits() <= 32 {
+			format = "int32"
+		}
+		return &Schema{Type: "integer", Format: format, GoType: t.Kind().String()}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number", Format: "double", GoType: t.Kind().String()}
+	}
+	panic(fmt.Sprintf("openapi: unsupported type %v", t))
+}
+
+func (b *builder) name(t reflect.Type) string {
+	key := path.Base(t.PkgPath()) + "." + t.Name()
+	if n, ok := b.spec.Names[key]; ok {
+		return n
+	}
+	return t.Name()
+}
+
+// structSchema describes the fields encoding/json writes for t, with
+// embedded structs flattened.
+func (b *builder) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	b.addFields(s, t)
+	return s
+}
+
+func (b *builder) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			b.addFields(s, f.Type)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		prop := b.schemaOf(f.Type)
+		prop.GoName = f.Name
+		prop.OmitEmpty = strings.Contains(opts, "omitempty")
+		s.Properties[name] = prop
+		s.Order = append(s.Order, name)
+		if strings.Contains(f.Tag.Get("binding"), "required") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+}
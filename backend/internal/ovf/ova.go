@@ -0,0 +1,193 @@
+package ovf
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// An OVA is a tar archive holding the descriptor first, then an optional
+// manifest of checksums, then the files the descriptor references.
+
+const maxDescriptor = 4 << 20
+
+// Reader reads an OVA as a stream, so it can be imported straight from
+// an upload.
+type Reader struct {
+	System *System
+
+	tr    *tar.Reader
+	files map[string]bool   // referenced files not read yet
+	sums  map[string]string // file -> algorithm:hex from the manifest
+}
+
+// NewReader reads the descriptor at the start of an OVA.
+func NewReader(r io.Reader) (*Reader, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("%w: not a tar archive: %v", ErrInvalid, err)
+	}
+	if !strings.HasSuffix(strings.ToLower(hdr.Name), ".ovf") {
+		return nil, fmt.Errorf("%w: the first file is %s, not the .ovf descriptor", ErrInvalid, hdr.Name)
+	}
+	data, err := io.ReadAll(io.LimitReader(tr, maxDescriptor+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDescriptor {
+		return nil, fmt.Errorf("%w: descriptor larger than %d bytes", ErrInvalid, maxDescriptor)
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	or := &Reader{System: s, tr: tr, files: map[string]bool{}, sums: map[string]string{}}
+	for _, d := range s.Disks {
+		or.files[d.File] = true
+	}
+	return or, nil
+}
+
+var manifestRe = regexp.MustCompile(`^(SHA1|SHA256|SHA512)\((.+)\)\s*=\s*([0-9a-fA-F]+)$`)
+
+// Next returns the next disk image and a reader of its contents,
+// decompressed. Reading it to the end fails if it does not match the
+// manifest. Next returns io.EOF after the last image, or an error when
+// images the descriptor references are missing.
+func (r *Reader) Next() (string, io.Reader, error) {
+	for {
+		hdr, err := r.tr.Next()
+		if err == io.EOF {
+			for name := range r.files {
+				return "", nil, fmt.Errorf("%w: %s is missing", ErrInvalid, name)
+			}
+			return "", nil, io.EOF
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		name := path.Clean(hdr.Name)
+		switch {
+		case strings.HasSuffix(strings.ToLower(name), ".mf"):
+			sc := bufio.NewScanner(io.LimitReader(r.tr, maxDescriptor))
+			for sc.Scan() {
+				if m := manifestRe.FindStringSubmatch(strings.TrimSpace(sc.Text())); m != nil {
+					r.sums[m[2]] = m[1] + ":" + strings.ToLower(m[3])
+				}
+			}
+			continue
+		case !r.files[name]:
+			continue // certificate, or a file no disk uses
+		}
+		delete(r.files, name)
+
+		cr := &checkedReader{name: name, raw: r.tr}
+		if sum, ok := r.sums[name]; ok {
+			algo, want, _ := strings.Cut(sum, ":")
+			cr.h = map[string]func() hash.Hash{"SHA1": sha1.New, "SHA256": sha256.New, "SHA512": sha512.New}[algo]()
+			cr.want = want
+			cr.raw = io.TeeReader(r.tr, cr.h)
+		}
+		cr.r = cr.raw
+		if r.compressed(name) {
+			zr, err := gzip.NewReader(cr.raw)
+			if err != nil {
+				return "", nil, fmt.Errorf("%w: %s: %v", ErrInvalid, name, err)
+			}
+			cr.r = zr
+		}
+		return name, cr, nil
+	}
+}
+
+func (r *Reader) compressed(name string) bool {
+	for _, d := range r.System.Disks {
+		if d.File == name {
+			return d.Compressed
+		}
+	}
+	return false
+}
+
+// checkedReader verifies the checksum of a file once it is read.
+type checkedReader struct {
+	name string
+	r    io.Reader // what the caller reads
+	raw  io.Reader // the file as stored, through h
+	h    hash.Hash
+	want string
+}
+
+func (c *checkedReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	if err == io.EOF && c.h != nil {
+		// gzip may stop before the end of what was stored
+		if _, derr := io.Copy(io.Discard, c.raw); derr != nil {
+			return n, derr
+		}
+		if hex.EncodeToString(c.h.Sum(nil)) != c.want {
+			return n, fmt.Errorf("%w: %s does not match the manifest", ErrInvalid, c.name)
+		}
+		c.h = nil
+	}
+	return n, err
+}
+
+// File is a file to put into an OVA after the descriptor.
+type File struct {
+	Name   string
+	Size   int64
+	SHA256 string // hex, for the manifest
+	Open   func() (io.ReadCloser, error)
+}
+
+// WriteOVA writes an OVA to w: the descriptor as name.ovf, a manifest
+// with the SHA-256 of it and every file as name.mf, then the files.
+func WriteOVA(w io.Writer, name string, descriptor []byte, files []File) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	add := func(file string, size int64, body io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{Name: file, Mode: 0644, Size: size, ModTime: now, Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, body)
+		return err
+	}
+
+	sum := sha256.Sum256(descriptor)
+	manifest := fmt.Sprintf("SHA256(%s.ovf)= %s\n", name, hex.EncodeToString(sum[:]))
+	for _, f := range files {
+		manifest += fmt.Sprintf("SHA256(%s)= %s\n", f.Name, f.SHA256)
+	}
+	if err := add(name+".ovf", int64(len(descriptor)), bytes.NewReader(descriptor)); err != nil {
+		return err
+	}
+	if err := add(name+".mf", int64(len(manifest)), strings.NewReader(manifest)); err != nil {
+		return err
+	}
+	for _, f := range files {
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = add(f.Name, f.Size, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,451 @@
+// Package ovf reads and writes OVF 1.x descriptors and OVA packages, the
+// format VMware and VirtualBox exchange appliances in. Only the parts the
+// panel maps onto a domain are modelled: CPUs, memory, disks, CD drives,
+// NICs and the firmware.
+package ovf
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalid wraps every error caused by the package itself rather than
+// by reading it.
+var ErrInvalid = errors.New("invalid ovf package")
+
+// System is one virtual system of a package.
+type System struct {
+	Name      string
+	CPUs      int
+	MemoryMiB int
+	Windows   bool
+	EFI       bool
+	Disks     []Disk
+	Cdroms    []string // bus of each CD/DVD drive
+	NICs      []NIC
+}
+
+// Disk is a hard disk and the image file backing it.
+type Disk struct {
+	File       string // name in the package
+	Size       int64  // of the file, when building
+	Capacity   uint64 // virtual size in bytes
+	Bus        string // ide, sata or scsi
+	Compressed bool   // the file is gzipped
+}
+
+// NIC is a network adapter.
+type NIC struct {
+	Model   string // e1000, e1000e, vmxnet3, pcnet or virtio
+	Network string
+}
+
+// CIM resource types of the hardware items.
+const (
+	rtProcessor = 3
+	rtMemory    = 4
+	rtIDE       = 5
+	rtSCSI      = 6
+	rtEthernet  = 10
+	rtCD        = 15
+	rtDVD       = 16
+	rtDisk      = 17
+	rtSATA      = 20
+)
+
+const (
+	nsOVF  = "http://schemas.dmtf.org/ovf/envelope/1"
+	nsRASD = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData"
+	nsVSSD = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_VirtualSystemSettingData"
+	nsVMW  = "http://www.vmware.com/schema/ovf"
+
+	// FormatStreamOptimized is the disk format of the VMDK images in an OVA.
+	FormatStreamOptimized = "http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"
+)
+
+// The reading side matches elements and attributes by local name, so it
+// accepts whatever prefixes the producer chose.
+
+type envelope struct {
+	References []struct {
+		ID          string `xml:"id,attr"`
+		Href        string `xml:"href,attr"`
+		Compression string `xml:"compression,attr"`
+		ChunkSize   string `xml:"chunkSize,attr"`
+	} `xml:"References>File"`
+	Disks []struct {
+		ID       string `xml:"diskId,attr"`
+		FileRef  string `xml:"fileRef,attr"`
+		Capacity string `xml:"capacity,attr"`
+		Units    string `xml:"capacityAllocationUnits,attr"`
+	} `xml:"DiskSection>Disk"`
+	Systems    []virtualSystem `xml:"VirtualSystem"`
+	Collection []virtualSystem `xml:"VirtualSystemCollection>VirtualSystem"`
+}
+
+type virtualSystem struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"Name"`
+	OS   struct {
+		OSType      string `xml:"osType,attr"`
+		Description string `xml:"Description"`
+	} `xml:"OperatingSystemSection"`
+	Hardware struct {
+		Items        []item `xml:"Item"`
+		StorageItems []item `xml:"StorageItem"`
+		NICItems     []item `xml:"EthernetPortItem"`
+		Config       []struct {
+			Key   string `xml:"key,attr"`
+			Value string `xml:"value,attr"`
+		} `xml:"Config"`
+	} `xml:"VirtualHardwareSection"`
+}
+
+type item struct {
+	InstanceID      string `xml:"InstanceID"`
+	ResourceType    int    `xml:"ResourceType"`
+	ResourceSubType string `xml:"ResourceSubType"`
+	Parent          string `xml:"Parent"`
+	HostResource    string `xml:"HostResource"`
+	Connection      string `xml:"Connection"`
+	VirtualQuantity uint64 `xml:"VirtualQuantity"`
+	AllocationUnits string `xml:"AllocationUnits"`
+}
+
+// Parse reads the virtual system of an OVF descriptor. Packages with
+// more than one virtual system are rejected.
+func Parse(data []byte) (*System, error) {
+	var env envelope
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	systems := append(env.Systems, env.Collection...)
+	if len(systems) != 1 {
+		return nil, fmt.Errorf("%w: expected one virtual system, found %d", ErrInvalid, len(systems))
+	}
+	vs := systems[0]
+	s := &System{Name: vs.Name}
+	if s.Name == "" {
+		s.Name = vs.ID
+	}
+	osName := strings.ToLower(vs.OS.OSType + " " + vs.OS.Description)
+	s.Windows = strings.Contains(osName, "windows")
+	for _, c := range vs.Hardware.Config {
+		if c.Key == "firmware" && c.Value == "efi" {
+			s.EFI = true
+		}
+	}
+
+	files := map[string]int{} // file id -> index in References
+	for i, f := range env.References {
+		if f.ChunkSize != "" {
+			return nil, fmt.Errorf("%w: chunked file %s is not supported", ErrInvalid, f.Href)
+		}
+		if f.Compression != "" && f.Compression != "gzip" {
+			return nil, fmt.Errorf("%w: unsupported compression %s", ErrInvalid, f.Compression)
+		}
+		files[f.ID] = i
+	}
+	disks := map[string]Disk{}
+	for _, d := range env.Disks {
+		i, ok := files[d.FileRef]
+		if !ok {
+			return nil, fmt.Errorf("%w: disk %s has no file", ErrInvalid, d.ID)
+		}
+		unit, err := allocationUnits(d.Units)
+		if err != nil {
+			return nil, err
+		}
+		capacity, err := strconv.ParseUint(d.Capacity, 10, 64)
+		if err == nil && capacity > maxBytes/unit {
+			err = errors.New("too large")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: capacity of disk %s: %v", ErrInvalid, d.ID, err)
+		}
+		disks[d.ID] = Disk{
+			File:       env.References[i].Href,
+			Capacity:   capacity * unit,
+			Compressed: env.References[i].Compression == "gzip",
+		}
+	}
+
+	items := append(append(vs.Hardware.Items, vs.Hardware.StorageItems...), vs.Hardware.NICItems...)
+	buses := map[string]string{} // controller InstanceID -> bus
+	for _, it := range items {
+		switch it.ResourceType {
+		case rtIDE:
+			buses[it.InstanceID] = "ide"
+		case rtSCSI:
+			buses[it.InstanceID] = "scsi"
+		case rtSATA:
+			buses[it.InstanceID] = "sata"
+		}
+	}
+	for _, it := range items {
+		switch it.ResourceType {
+		case rtProcessor:
+			s.CPUs = int(it.VirtualQuantity)
+		case rtMemory:
+			unit, err := allocationUnits(it.AllocationUnits)
+			if err != nil {
+				return nil, err
+			}
+			if it.VirtualQuantity > maxBytes/unit {
+				return nil, fmt.Errorf("%w: memory size too large", ErrInvalid)
+			}
+			s.MemoryMiB = int(it.VirtualQuantity * unit >> 20)
+		case rtDisk:
+			ref := strings.TrimPrefix(it.HostResource, "ovf:")
+			id, ok := strings.CutPrefix(ref, "/disk/")
+			d, found := disks[id]
+			if !ok || !found {
+				return nil, fmt.Errorf("%w: disk item %s refers to unknown disk %q", ErrInvalid, it.InstanceID, it.HostResource)
+			}
+			d.Bus = buses[it.Parent]
+			s.Disks = append(s.Disks, d)
+		case rtCD, rtDVD:
+			s.Cdroms = append(s.Cdroms, buses[it.Parent])
+		case rtEthernet:
+			s.NICs = append(s.NICs, NIC{Model: nicModel(it.ResourceSubType), Network: it.Connection})
+		}
+	}
+	return s, nil
+}
+
+// maxBytes bounds sizes so they cannot overflow.
+const maxBytes = 1 << 50
+
+var (
+	bytePowerRe = regexp.MustCompile(`^byte(?:\*(\d+)(?:\^(\d+))?)?$`)
+	namedUnits  = map[string]uint64{
+		"": 1, "bytes": 1, "kilobytes": 1 << 10, "kb": 1 << 10, "megabytes": 1 << 20, "mb": 1 << 20,
+		"gigabytes": 1 << 30, "gb": 1 << 30, "terabytes": 1 << 40, "tb": 1 << 40,
+	}
+)
+
+// allocationUnits returns the bytes of one unit written in the DMTF
+// programmatic form ("byte * 2^20") or by name ("MegaBytes").
+func allocationUnits(s string) (uint64, error) {
+	s = strings.ToLower(strings.ReplaceAll(s, " ", ""))
+	if n, ok := namedUnits[s]; ok {
+		return n, nil
+	}
+	m := bytePowerRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("%w: unknown allocation units %q", ErrInvalid, s)
+	}
+	unit := uint64(1)
+	if m[1] != "" {
+		base, _ := strconv.ParseUint(m[1], 10, 64)
+		exp := uint64(1)
+		if m[2] != "" {
+			exp, _ = strconv.ParseUint(m[2], 10, 64)
+		}
+		if base > 1024 || exp > 40 {
+			return 0, fmt.Errorf("%w: unsupported allocation units %q", ErrInvalid, s)
+		}
+		for range exp {
+			if unit *= base; unit > 1<<40 {
+				break
+			}
+		}
+	}
+	if unit == 0 || unit > 1<<40 {
+		return 0, fmt.Errorf("%w: unsupported allocation units %q", ErrInvalid, s)
+	}
+	return unit, nil
+}
+
+// nicModel normalises the adapter names VMware and VirtualBox use.
+func nicModel(subType string) string {
+	switch t := strings.ToLower(subType); {
+	case strings.HasPrefix(t, "virtio"):
+		return "virtio"
+	case t == "e1000e":
+		return "e1000e"
+	case strings.HasPrefix(t, "vmxnet"):
+		return "vmxnet3"
+	case strings.HasPrefix(t, "pcnet"), strings.HasPrefix(t, "am79c97"):
+		return "pcnet"
+	}
+	return "e1000"
+}
+
+// The writing side spells the prefixes out; encoding/xml would otherwise
+// declare a namespace on every element.
+
+type envelopeOut struct {
+	XMLName    xml.Name         `xml:"Envelope"`
+	XMLNS      string           `xml:"xmlns,attr"`
+	OVF        string           `xml:"xmlns:ovf,attr"`
+	RASD       string           `xml:"xmlns:rasd,attr"`
+	VSSD       string           `xml:"xmlns:vssd,attr"`
+	VMW        string           `xml:"xmlns:vmw,attr"`
+	Version    string           `xml:"ovf:version,attr"`
+	References []fileOut        `xml:"References>File"`
+	Disks      diskSectionOut   `xml:"DiskSection"`
+	Networks   networkSection   `xml:"NetworkSection"`
+	System     virtualSystemOut `xml:"VirtualSystem"`
+}
+
+type fileOut struct {
+	ID   string `xml:"ovf:id,attr"`
+	Href string `xml:"ovf:href,attr"`
+	Size int64  `xml:"ovf:size,attr"`
+}
+
+type diskSectionOut struct {
+	Info  string    `xml:"Info"`
+	Disks []diskOut `xml:"Disk"`
+}
+
+type diskOut struct {
+	Capacity uint64 `xml:"ovf:capacity,attr"`
+	Units    string `xml:"ovf:capacityAllocationUnits,attr"`
+	ID       string `xml:"ovf:diskId,attr"`
+	FileRef  string `xml:"ovf:fileRef,attr"`
+	Format   string `xml:"ovf:format,attr"`
+}
+
+type networkSection struct {
+	Info     string       `xml:"Info"`
+	Networks []networkOut `xml:"Network"`
+}
+
+type networkOut struct {
+	Name        string `xml:"ovf:name,attr"`
+	Description string `xml:"Description"`
+}
+
+// configOut is a VMware extra setting such as the firmware.
+type configOut struct {
+	Required string `xml:"ovf:required,attr"`
+	Key      string `xml:"vmw:key,attr"`
+	Value    string `xml:"vmw:value,attr"`
+}
+
+type virtualSystemOut struct {
+	ID   string `xml:"ovf:id,attr"`
+	Info string `xml:"Info"`
+	Name string `xml:"Name"`
+	OS   struct {
+		ID          int    `xml:"ovf:id,attr"`
+		Info        string `xml:"Info"`
+		Description string `xml:"Description"`
+	} `xml:"OperatingSystemSection"`
+	Hardware struct {
+		Info   string `xml:"Info"`
+		System struct {
+			ElementName string `xml:"vssd:ElementName"`
+			InstanceID  int    `xml:"vssd:InstanceID"`
+			Identifier  string `xml:"vssd:VirtualSystemIdentifier"`
+			Type        string `xml:"vssd:VirtualSystemType"`
+		} `xml:"System"`
+		Items  []itemOut   `xml:"Item"`
+		Config []configOut `xml:"vmw:Config"`
+	} `xml:"VirtualHardwareSection"`
+}
+
+// itemOut lists the RASD elements in schema (alphabetical) order, which
+// strict readers check.
+type itemOut struct {
+	AddressOnParent     string `xml:"rasd:AddressOnParent,omitempty"`
+	AllocationUnits     string `xml:"rasd:AllocationUnits,omitempty"`
+	AutomaticAllocation string `xml:"rasd:AutomaticAllocation,omitempty"`
+	Connection          string `xml:"rasd:Connection,omitempty"`
+	Description         string `xml:"rasd:Description,omitempty"`
+	ElementName         string `xml:"rasd:ElementName"`
+	HostResource        string `xml:"rasd:HostResource,omitempty"`
+	InstanceID          int    `xml:"rasd:InstanceID"`
+	Parent              int    `xml:"rasd:Parent,omitempty"`
+	ResourceSubType     string `xml:"rasd:ResourceSubType,omitempty"`
+	ResourceType        int    `xml:"rasd:ResourceType"`
+	VirtualQuantity     uint64 `xml:"rasd:VirtualQuantity,omitempty"`
+}
+
+// Build returns the OVF descriptor of s, whose disks are streamOptimized
+// VMDK files. Disks on virtio or other buses VMware lacks are put on a
+// SATA controller, NICs become E1000 adapters.
+func Build(s *System) ([]byte, error) {
+	env := envelopeOut{XMLNS: nsOVF, OVF: nsOVF, RASD: nsRASD, VSSD: nsVSSD, VMW: nsVMW, Version: "1.0"}
+	env.Disks.Info = "Virtual disk information"
+	env.Networks.Info = "The list of logical networks"
+	vs := &env.System
+	vs.ID, vs.Name, vs.Info = s.Name, s.Name, "A virtual machine"
+	vs.OS.ID, vs.OS.Info, vs.OS.Description = 1, "The operating system installed", "Other"
+	if s.Windows {
+		vs.OS.Description = "Microsoft Windows"
+	}
+	hw := &vs.Hardware
+	hw.Info = "Virtual hardware requirements"
+	hw.System.ElementName, hw.System.Identifier, hw.System.Type = "Virtual Hardware Family", s.Name, "vmx-10"
+	if s.EFI {
+		hw.Config = append(hw.Config, configOut{Required: "false", Key: "firmware", Value: "efi"})
+	}
+
+	id := 0
+	add := func(it itemOut) int {
+		id++
+		it.InstanceID = id
+		hw.Items = append(hw.Items, it)
+		return id
+	}
+	add(itemOut{AllocationUnits: "hertz * 10^6", Description: "Number of Virtual CPUs", ElementName: fmt.Sprintf("%d virtual CPU(s)", s.CPUs),
+		ResourceType: rtProcessor, VirtualQuantity: uint64(s.CPUs)})
+	add(itemOut{AllocationUnits: "byte * 2^20", Description: "Memory Size", ElementName: fmt.Sprintf("%dMB of memory", s.MemoryMiB),
+		ResourceType: rtMemory, VirtualQuantity: uint64(s.MemoryMiB)})
+
+	// One controller per bus, created when first used
+	controllers := map[string]*struct{ id, used int }{}
+	attach := func(bus string) (parent int, addr string) {
+		if bus != "ide" && bus != "scsi" {
+			bus = "sata"
+		}
+		c := controllers[bus]
+		if c == nil {
+			it := map[string]itemOut{
+				"ide":  {Description: "IDE Controller", ElementName: "ideController0", ResourceSubType: "PIIX4", ResourceType: rtIDE},
+				"scsi": {Description: "SCSI Controller", ElementName: "scsiController0", ResourceSubType: "lsilogic", ResourceType: rtSCSI},
+				"sata": {Description: "SATA Controller", ElementName: "sataController0", ResourceSubType: "vmware.sata.ahci", ResourceType: rtSATA},
+			}[bus]
+			c = &struct{ id, used int }{id: add(it)}
+			controllers[bus] = c
+		}
+		c.used++
+		return c.id, strconv.Itoa(c.used - 1)
+	}
+	for i, d := range s.Disks {
+		fileID, diskID := fmt.Sprintf("file%d", i+1), fmt.Sprintf("vmdisk%d", i+1)
+		env.References = append(env.References, fileOut{ID: fileID, Href: d.File, Size: d.Size})
+		env.Disks.Disks = append(env.Disks.Disks, diskOut{Capacity: d.Capacity, Units: "byte", ID: diskID, FileRef: fileID, Format: FormatStreamOptimized})
+		parent, addr := attach(d.Bus)
+		add(itemOut{AddressOnParent: addr, ElementName: fmt.Sprintf("disk%d", i), HostResource: "ovf:/disk/" + diskID,
+			Parent: parent, ResourceType: rtDisk})
+	}
+	for i, bus := range s.Cdroms {
+		parent, addr := attach(bus)
+		add(itemOut{AddressOnParent: addr, AutomaticAllocation: "false", ElementName: fmt.Sprintf("cdrom%d", i),
+			Parent: parent, ResourceType: rtCD})
+	}
+	networks := map[string]bool{}
+	for i, n := range s.NICs {
+		if !networks[n.Network] {
+			networks[n.Network] = true
+			env.Networks.Networks = append(env.Networks.Networks, networkOut{Name: n.Network, Description: "The " + n.Network + " network"})
+		}
+		add(itemOut{AutomaticAllocation: "true", Connection: n.Network,
+			Description: "E1000 ethernet adapter on " + n.Network, ElementName: fmt.Sprintf("ethernet%d", i),
+			ResourceSubType: "E1000", ResourceType: rtEthernet})
+	}
+
+	out, err := xml.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
@@ -25,16 +25,17 @@ func getDefaultGateway() string {
 	return ""
 }
 
-const bridgePrefix = "vp-"
-
 func (s *LibvirtService) ListBridges() ([]model.Bridge, error) {
+	if !s.local {
+		return nil, ErrLocalOnly
+	}
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return nil, err
 	}
 	result := make([]model.Bridge, 0)
 	for _, iface := range ifaces {
-		if !strings.HasPrefix(iface.Name, bridgePrefix) {
+		if !strings.HasPrefix(iface.Name, s.cfg.BridgePrefix) {
 			continue
 		}
 		// Verify it's actually a bridge
@@ -64,12 +65,15 @@ func (s *LibvirtService) ListBridges() ([]model.Bridge, error) {
 }
 
 func (s *LibvirtService) CreateBridge(req model.CreateBridgeRequest) error {
-	name := bridgePrefix + req.Name
+	if !s.local {
+		return ErrLocalOnly
+	}
+	name := s.cfg.BridgePrefix + req.Name
 	if !safeNameRe.MatchString(req.Name) {
-		return fmt.Errorf("invalid bridge name: %s", req.Name)
+		return invalid(CodeInvalidName, "invalid bridge name: %s", req.Name)
 	}
 	if _, err := net.InterfaceByName(name); err == nil {
-		return fmt.Errorf("网桥 %s 已存在", name)
+		return conflict(CodeAlreadyExists, "bridge %s already exists", name)
 	}
 
 	var script strings.Builder
@@ -77,11 +81,11 @@ func (s *LibvirtService) CreateBridge(req model.CreateBridgeRequest) error {
 
 	if req.SlaveNIC != "" {
 		if !safeNameRe.MatchString(req.SlaveNIC) {
-			return fmt.Errorf("invalid NIC name: %s", req.SlaveNIC)
+			return invalid(CodeInvalidName, "invalid NIC name: %s", req.SlaveNIC)
 		}
 		iface, err := net.InterfaceByName(req.SlaveNIC)
 		if err != nil {
-			return fmt.Errorf("网卡 %s 不存在", req.SlaveNIC)
+			return notFound(CodeDeviceNotFound, "network interface %s not found", req.SlaveNIC)
 		}
 		script.WriteString(fmt.Sprintf(" && ip link set %s master %s", req.SlaveNIC, name))
 		gw := getDefaultGateway()
@@ -100,9 +104,12 @@ func (s *LibvirtService) CreateBridge(req model.CreateBridgeRequest) error {
 }
 
 func (s *LibvirtService) DeleteBridge(name string) error {
-	full := bridgePrefix + name
+	if !s.local {
+		return ErrLocalOnly
+	}
+	full := s.cfg.BridgePrefix + name
 	if _, err := net.InterfaceByName(full); err != nil {
-		return fmt.Errorf("网桥 %s 不存在", full)
+		return notFound(CodeBridgeNotFound, "bridge %s not found", full)
 	}
 	// Move IPs back to slave before deleting
 	entries, _ := os.ReadDir("/sys/class/net/" + full + "/brif")
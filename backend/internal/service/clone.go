@@ -0,0 +1,321 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"virtpanel/internal/domxml"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// Full clones copy each writable disk with StorageVolCreateXMLFrom, so
+// they work the same on remote hosts, and define the copy from the
+// source's XML with a new name, UUID and MAC addresses. Read-only disks
+// and cdroms are shared with the source, except the cloud-init seed.
+
+// cloneOptions picks where the copies go. Empty fields keep the pool and
+// format of each source disk.
+type cloneOptions struct {
+	pool   string
+	format string
+}
+
+// fullCloneOptions returns the options of a full clone; linked clones
+// always put qcow2 overlays next to the template's disks.
+func fullCloneOptions(linked bool, pool, format string) (cloneOptions, error) {
+	o := cloneOptions{pool: pool, format: format}
+	if linked && (pool != "" || format != "") {
+		return o, invalid(CodeInvalidArgument, "pool and format only apply to full clones")
+	}
+	return o, o.validate()
+}
+
+func (o cloneOptions) validate() error {
+	if o.pool != "" && !safeNameRe.MatchString(o.pool) {
+		return invalid(CodeInvalidName, "invalid pool name: %s", o.pool)
+	}
+	if o.format != "" && o.format != "qcow2" && o.format != "raw" {
+		return invalid(CodeInvalidArgument, "unsupported disk format: %s", o.format)
+	}
+	return nil
+}
+
+// cloneDisk is one disk to copy: its volume and where the copy goes.
+type cloneDisk struct {
+	disk   *domxml.Disk
+	src    libvirt.StorageVol
+	used   uint64 // allocation of the source, what the copy has to write
+	pool   libvirt.StoragePool
+	name   string
+	format string
+	path   string // set once copied
+}
+
+// diskPath returns the image behind a file or block disk.
+func diskPath(disk *domxml.Disk) string {
+	if disk.Source == nil {
+		return ""
+	}
+	if disk.Type == "block" {
+		return disk.Source.Dev
+	}
+	return disk.Source.File
+}
+
+// cloneDomain defines newName as a full copy of the shut-off domain
+// described by xmlStr. Copies made before a failure are deleted again.
+// The caller holds the op locks of both names.
+func (s *LibvirtService) cloneDomain(ctx context.Context, l *libvirt.Libvirt, xmlStr, newName string, opts cloneOptions, p Progress) error {
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return fmt.Errorf("parse domain xml: %w", err)
+	}
+	var target *libvirt.StoragePool
+	if opts.pool != "" {
+		pool, err := l.StoragePoolLookupByName(opts.pool)
+		if err != nil {
+			return err
+		}
+		target = &pool
+	}
+
+	// Resolve every disk before copying any
+	var disks []*cloneDisk
+	var total uint64
+	if dx.Devices != nil {
+		for i := range dx.Devices.Disks {
+			disk := &dx.Devices.Disks[i]
+			if disk.Device != "disk" || disk.ReadOnly != nil {
+				continue
+			}
+			path := diskPath(disk)
+			if disk.Type != "file" && disk.Type != "block" || path == "" {
+				target := ""
+				if disk.Target != nil {
+					target = disk.Target.Dev
+				}
+				return precondition(CodeUnsupported, "cannot clone disk %s: only file and block disks are supported", target)
+			}
+			cd := &cloneDisk{disk: disk, format: opts.format}
+			if cd.src, err = l.StorageVolLookupByPath(path); err != nil {
+				return precondition(CodeNotConfigured, "%s is not in an active storage pool", path)
+			}
+			if _, _, cd.used, err = l.StorageVolGetInfo(cd.src); err != nil {
+				return err
+			}
+			if target != nil {
+				cd.pool = *target
+			} else if cd.pool, err = l.StoragePoolLookupByVolume(cd.src); err != nil {
+				return err
+			}
+			if cd.format == "" {
+				cd.format = "raw"
+				if disk.Driver != nil && disk.Driver.Type != "" {
+					cd.format = disk.Driver.Type
+				}
+			}
+			suffix := ""
+			if len(disks) > 0 {
+				suffix = fmt.Sprintf("-%d", len(disks))
+			}
+			cd.name = newName + suffix + "." + cd.format
+			if _, err := l.StorageVolLookupByName(cd.pool, cd.name); err == nil {
+				return conflict(CodeAlreadyExists, "volume %s already exists in pool %s", cd.name, cd.pool.Name)
+			}
+			disks = append(disks, cd)
+			total += cd.used
+		}
+	}
+
+	rollback := func() {
+		for _, cd := range disks {
+			if cd.path == "" {
+				continue
+			}
+			if vol, err := l.StorageVolLookupByName(cd.pool, cd.name); err == nil {
+				l.StorageVolDelete(vol, 0)
+			}
+		}
+	}
+	var copied uint64
+	for _, cd := range disks {
+		if err := ctx.Err(); err != nil {
+			rollback()
+			return err
+		}
+		fmt.Fprintf(p, "copying %s to %s/%s (%d MiB)\n", diskPath(cd.disk), cd.pool.Name, cd.name, cd.used>>20)
+		vol, err := s.copyVolume(l, cd, copied, total, p)
+		if err != nil {
+			rollback()
+			return err
+		}
+		copied += cd.used
+		typ, _, alloc, err := l.StorageVolGetInfo(vol)
+		if err == nil {
+			cd.path, err = l.StorageVolGetPath(vol)
+		}
+		if err != nil {
+			l.StorageVolDelete(vol, 0)
+			rollback()
+			return err
+		}
+		fmt.Fprintf(p, "copied %s: %d bytes\n", cd.name, alloc)
+		if libvirt.StorageVolType(typ) == libvirt.StorageVolBlock {
+			cd.disk.Type, cd.disk.Source = "block", &domxml.DiskSource{Dev: cd.path}
+		} else {
+			cd.disk.Type, cd.disk.Source = "file", &domxml.DiskSource{File: cd.path}
+		}
+		if cd.disk.Driver == nil {
+			cd.disk.Driver = &domxml.DiskDriver{Name: "qemu"}
+		}
+		cd.disk.Driver.Type = cd.format
+		extra := cd.disk.Extra[:0]
+		for _, n := range cd.disk.Extra {
+			if n.XMLName.Local != "backingStore" {
+				extra = append(extra, n)
+			}
+		}
+		cd.disk.Extra = extra
+	}
+	// The copy is not interrupted by ctx; drop it if canceled meanwhile
+	if err := ctx.Err(); err != nil {
+		rollback()
+		return err
+	}
+
+	copyDefinition(dx, newName)
+	newXML, err := dx.Marshal()
+	if err != nil {
+		rollback()
+		return err
+	}
+	d, err := l.DomainDefineXML(newXML)
+	if err != nil {
+		rollback()
+		return err
+	}
+	// A copy is neither a template nor linked to one
+	err = setTemplateMark(l, d, libvirt.OptString{})
+	if err == nil {
+		err = l.DomainSetMetadata(d, int32(libvirt.DomainMetadataElement), libvirt.OptString{},
+			libvirt.OptString{domxml.ClonePrefix}, libvirt.OptString{domxml.CloneNS}, libvirt.DomainAffectConfig)
+	}
+	if err != nil {
+		s.deleteVM(l, newName)
+		return err
+	}
+	p.SetProgress(100)
+	return nil
+}
+
+// copyVolume copies one disk, reporting the bytes written so far against
+// total while libvirt converts it, and logging them for the disk.
+func (s *LibvirtService) copyVolume(l *libvirt.Libvirt, cd *cloneDisk, copied, total uint64, p Progress) (libvirt.StorageVol, error) {
+	xmlDef := fmt.Sprintf(`<volume>
+  <name>%s</name>
+  <capacity unit='bytes'>0</capacity>
+  <target><format type='%s'/></target>
+</volume>`, cd.name, cd.format)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		var logged uint64
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+			}
+			vol, err := l.StorageVolLookupByName(cd.pool, cd.name)
+			if err != nil {
+				continue
+			}
+			_, _, alloc, err := l.StorageVolGetInfo(vol)
+			if err != nil {
+				continue
+			}
+			alloc = min(alloc, cd.used)
+			if alloc != logged {
+				fmt.Fprintf(p, "%s: %d/%d bytes\n", cd.name, alloc, cd.used)
+				logged = alloc
+			}
+			if total > 0 {
+				p.SetProgress(float64(copied+alloc) * 99 / float64(total))
+			}
+		}
+	}()
+
+	vol, err := l.StorageVolCreateXMLFrom(cd.pool, xmlDef, cd.src, 0)
+	if err != nil {
+		return vol, fmt.Errorf("copy %s failed: %w", cd.name, err)
+	}
+	if total > 0 {
+		p.SetProgress(float64(copied+cd.used) * 99 / float64(total))
+	}
+	return vol, nil
+}
+
+// copyDefinition turns the definition of a source domain into that of
+// its copy newName: a new UUID and MAC addresses, fresh UEFI variables and
+// no cloud-init seed.
+func copyDefinition(dx *domxml.Domain, newName string) {
+	dx.Name, dx.UUID = newName, ""
+	if dx.Devices != nil {
+		for i := range dx.Devices.Interfaces {
+			dx.Devices.Interfaces[i].MAC = nil
+		}
+	}
+	dropNVRAM(dx)
+	detachSeed(dx)
+}
+
+// dropNVRAM removes the UEFI variable store of a copied definition, so
+// libvirt makes a fresh one from the firmware's template.
+func dropNVRAM(dx *domxml.Domain) {
+	if dx.OS == nil {
+		return
+	}
+	extra := dx.OS.Extra[:0]
+	for _, n := range dx.OS.Extra {
+		if n.XMLName.Local != "nvram" {
+			extra = append(extra, n)
+		}
+	}
+	dx.OS.Extra = extra
+}
+
+// detachSeed empties the cdroms holding a cloud-init seed: the seed
+// carries the source's instance-id, which a copy must not boot with.
+func detachSeed(dx *domxml.Domain) {
+	for _, cd := range dx.Cdroms() {
+		if strings.HasSuffix(cd.SourceFile(), seedSuffix) {
+			cd.Source = nil
+		}
+	}
+}
+
+// cloneFrom looks up a shut-off domain and clones it to newName.
+func (s *LibvirtService) cloneFrom(ctx context.Context, l *libvirt.Libvirt, src, newName string, opts cloneOptions, p Progress) error {
+	d, err := l.DomainLookupByName(src)
+	if err != nil {
+		return err
+	}
+	state, _, _, _, _, err := l.DomainGetInfo(d)
+	if err != nil {
+		return err
+	}
+	if libvirt.DomainState(state) != libvirt.DomainShutoff {
+		return errNotShutoff
+	}
+	xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	if err != nil {
+		return err
+	}
+	return s.cloneDomain(ctx, l, xmlStr, newName, opts, p)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"virtpanel/internal/domxml"
+	"virtpanel/internal/model"
+)
+
+const templateXML = `<domain type='kvm'>
+  <name>base</name>
+  <uuid>0b7d3a4e-5f1c-4e8a-9d2b-6c1f0e3a7b55</uuid>
+  <os>
+    <type arch='x86_64' machine='q35'>hvm</type>
+    <nvram>/var/lib/libvirt/qemu/nvram/base_VARS.fd</nvram>
+  </os>
+  <devices>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2'/>
+      <source file='/var/lib/libvirt/images/base.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+    <disk type='file' device='cdrom'>
+      <source file='/var/lib/libvirt/images/ubuntu.iso'/>
+      <target dev='sda' bus='sata'/>
+      <readonly/>
+    </disk>
+    <disk type='file' device='cdrom'>
+      <source file='/var/lib/libvirt/images/base-cidata.iso'/>
+      <target dev='sdb' bus='sata'/>
+      <readonly/>
+    </disk>
+    <interface type='network'>
+      <mac address='52:54:00:12:34:56'/>
+      <source network='default'/>
+    </interface>
+  </devices>
+</domain>`
+
+// Full and linked clones both define their copy with copyDefinition.
+func TestCopyDefinition(t *testing.T) {
+	dx, err := domxml.Parse(templateXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyDefinition(dx, "web1")
+	if dx.Name != "web1" || dx.UUID != "" {
+		t.Errorf("got name %q, uuid %q", dx.Name, dx.UUID)
+	}
+	if mac := dx.Devices.Interfaces[0].MAC; mac != nil {
+		t.Errorf("mac kept: %v", mac)
+	}
+	cds := dx.Cdroms()
+	if len(cds) != 2 || cds[0].SourceFile() != "/var/lib/libvirt/images/ubuntu.iso" || cds[1].Source != nil {
+		t.Errorf("cdroms: want the iso shared and the seed drive empty")
+	}
+	out, err := dx.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, seedSuffix) || strings.Contains(out, "nvram") {
+		t.Errorf("copy still refers to the seed or nvram:\n%s", out)
+	}
+}
+
+func TestLinkedCloneDetachesSeed(t *testing.T) {
+	s := newTestSim(t)
+	ctx := context.Background()
+	err := s.CreateVM(ctx, model.CreateVMRequest{Name: "base", CloudInit: &model.CloudInit{}}, nopProgress{io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetTemplate("base", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CloneVM(ctx, "base", model.CloneVMRequest{NewName: "clone1", Linked: true}, nopProgress{io.Discard}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateFromTemplate(ctx, "base", model.CreateFromTemplateRequest{Name: "copy1", Linked: true}, nopProgress{io.Discard}); err != nil {
+		t.Fatal(err)
+	}
+	if !hasSeed(t, s, "base") {
+		t.Fatal("base has no seed to leave behind")
+	}
+	for _, name := range []string{"clone1", "copy1"} {
+		if vm, err := s.GetVM(name); err != nil || vm.LinkedBase != "base" {
+			t.Fatalf("%s: want a linked clone of base, got %+v, %v", name, vm, err)
+		}
+		if hasSeed(t, s, name) {
+			t.Errorf("%s: the template's seed is still attached", name)
+		}
+	}
+}
+
+func hasSeed(t *testing.T, s *SimService, name string) bool {
+	t.Helper()
+	vm, err := s.GetVMDetail(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, disk := range vm.Disks {
+		if strings.HasSuffix(disk.Source, seedSuffix) {
+			return true
+		}
+	}
+	return false
+}
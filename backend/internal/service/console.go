@@ -0,0 +1,42 @@
+package service
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"virtpanel/internal/domxml"
+)
+
+// OpenConsole attaches to the serial console of a running VM. go-libvirt
+// only streams console output, so the pty libvirt allocated for the
+// console is opened directly, which needs the panel on the VM's host.
+func (s *LibvirtService) OpenConsole(name string) (io.ReadWriteCloser, error) {
+	if !s.local {
+		return nil, ErrLocalOnly
+	}
+	l, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	d, err := l.DomainLookupByName(name)
+	if err != nil {
+		return nil, err
+	}
+	xmlStr, err := l.DomainGetXMLDesc(d, 0)
+	if err != nil {
+		return nil, err
+	}
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return nil, err
+	}
+	if dx.Devices != nil {
+		for _, c := range dx.Devices.Consoles {
+			if tty := c.PTY(); tty != "" {
+				return os.OpenFile(tty, os.O_RDWR|syscall.O_NOCTTY, 0)
+			}
+		}
+	}
+	return nil, conflict(CodeInvalidState, "no serial console (vm may not be running)")
+}
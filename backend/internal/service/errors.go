@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// Kind is the class of a service error. Handlers map each kind to one
+// HTTP status.
+type Kind int
+
+const (
+	Internal     Kind = iota // 500
+	NotFound                 // 404: no such VM, network, pool, ...
+	Conflict                 // 409: already exists, wrong state or busy
+	Invalid                  // 400: bad name, path or argument
+	Precondition             // 412: something else must be set up or done first
+	Unavailable              // 503: libvirt cannot be reached
+)
+
+// Codes are stable and meant for API clients; the messages are not.
+const (
+	CodeInternal           = "internal"
+	CodeVMNotFound         = "vm_not_found"
+	CodeSnapshotNotFound   = "snapshot_not_found"
+	CodeNetworkNotFound    = "network_not_found"
+	CodePoolNotFound       = "pool_not_found"
+	CodeVolumeNotFound     = "volume_not_found"
+	CodeDeviceNotFound     = "device_not_found" // disk, NIC or physical interface
+	CodeFileNotFound       = "file_not_found"   // disk image or ISO
+	CodeBridgeNotFound     = "bridge_not_found"
+	CodePortForwardMissing = "port_forward_not_found"
+	CodeHostNotFound       = "host_not_found"
+	CodeAlreadyExists      = "already_exists"
+	CodeInvalidState       = "invalid_state" // e.g. already running, not running
+	CodeVMNotShutoff       = "vm_not_shutoff"
+	CodeBusy               = "busy"
+	CodePortInUse          = "port_in_use"
+	CodeInvalidName        = "invalid_name"
+	CodeInvalidPath        = "invalid_path"
+	CodeInvalidArgument    = "invalid_argument"
+	CodeInvalidXML         = "invalid_xml"
+	CodeLocalOnly          = "local_only"
+	CodeNotConfigured      = "not_configured" // e.g. no VNC graphics or serial console
+	CodeUnsupported        = "unsupported"
+	CodeRestartRequired    = "restart_required"
+	CodeHostUnavailable    = "host_unavailable"
+	CodeHostBuiltin        = "host_builtin" // defined in the config file, cannot be removed at runtime
+	CodeIsTemplate         = "vm_is_template"
+	CodeNotTemplate        = "not_template"
+	CodeHasLinkedClones    = "has_linked_clones"
+	CodeNotLinkedClone     = "not_linked_clone"
+)
+
+// Error is a classified service error. Err, if set, is the underlying
+// cause and is appended to Msg.
+type Error struct {
+	Kind Kind
+	Code string
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Err == nil:
+		return e.Msg
+	case e.Msg == "":
+		return e.Err.Error()
+	}
+	return e.Msg + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func newError(kind Kind, code, format string, a ...any) error {
+	return &Error{Kind: kind, Code: code, Msg: fmt.Sprintf(format, a...)}
+}
+
+func notFound(code, format string, a ...any) error {
+	return newError(NotFound, code, format, a...)
+}
+
+func conflict(code, format string, a ...any) error {
+	return newError(Conflict, code, format, a...)
+}
+
+func invalid(code, format string, a ...any) error {
+	return newError(Invalid, code, format, a...)
+}
+
+func precondition(code, format string, a ...any) error {
+	return newError(Precondition, code, format, a...)
+}
+
+// errNotShutoff is returned by operations that need the domain's disks
+// to be quiescent.
+var errNotShutoff = &Error{Kind: Conflict, Code: CodeVMNotShutoff, Msg: "the VM must be shut off for this operation"}
+
+// libvirtKinds classifies the libvirt error codes the panel runs into.
+var libvirtKinds = map[libvirt.ErrorNumber]struct {
+	kind Kind
+	code string
+}{
+	libvirt.ErrNoDomain:             {NotFound, CodeVMNotFound},
+	libvirt.ErrNoDomainSnapshot:     {NotFound, CodeSnapshotNotFound},
+	libvirt.ErrNoNetwork:            {NotFound, CodeNetworkNotFound},
+	libvirt.ErrNoStoragePool:        {NotFound, CodePoolNotFound},
+	libvirt.ErrNoStorageVol:         {NotFound, CodeVolumeNotFound},
+	libvirt.ErrNoInterface:          {NotFound, CodeDeviceNotFound},
+	libvirt.ErrNoNodeDevice:         {NotFound, CodeDeviceNotFound},
+	libvirt.ErrDomExist:             {Conflict, CodeAlreadyExists},
+	libvirt.ErrNetworkExist:         {Conflict, CodeAlreadyExists},
+	libvirt.ErrStorageVolExist:      {Conflict, CodeAlreadyExists},
+	libvirt.ErrOperationInvalid:     {Conflict, CodeInvalidState},
+	libvirt.ErrInvalidArg:           {Invalid, CodeInvalidArgument},
+	libvirt.ErrXMLError:             {Invalid, CodeInvalidXML},
+	libvirt.ErrXMLDetail:            {Invalid, CodeInvalidXML},
+	libvirt.ErrXMLInvalidSchema:     {Invalid, CodeInvalidXML},
+	libvirt.ErrNoSupport:            {Precondition, CodeUnsupported},
+	libvirt.ErrOperationUnsupported: {Precondition, CodeUnsupported},
+	libvirt.ErrConfigUnsupported:    {Precondition, CodeUnsupported},
+	libvirt.ErrNoConnect:            {Unavailable, CodeHostUnavailable},
+	libvirt.ErrRPC:                  {Unavailable, CodeHostUnavailable},
+	libvirt.ErrOperationTimeout:     {Unavailable, CodeHostUnavailable},
+}
+
+// Classify returns the kind and code of err: those of the *Error it
+// wraps, else derived from a libvirt error or a broken connection, else
+// Internal.
+func Classify(err error) (Kind, string) {
+	var se *Error
+	if errors.As(err, &se) {
+		return se.Kind, se.Code
+	}
+	var le libvirt.Error
+	if errors.As(err, &le) {
+		if k, ok := libvirtKinds[libvirt.ErrorNumber(le.Code)]; ok {
+			return k.kind, k.code
+		}
+		return Internal, CodeInternal
+	}
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, libvirt.ErrInterrupted) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return Unavailable, CodeHostUnavailable
+	}
+	return Internal, CodeInternal
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"virtpanel/internal/model"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// eventPollInterval is how often network and pool state is diffed, and
+// how long to wait before resubscribing after the connection dropped.
+const eventPollInterval = 5 * time.Second
+
+// Names of virDomainEventType and its per-type detail enums, lowercased
+// as in virsh event output.
+var domainEventTypes = []string{"defined", "undefined", "started", "suspended", "resumed", "stopped", "shutdown", "pmsuspended", "crashed"}
+
+var domainEventDetails = [][]string{
+	{"added", "updated", "renamed", "from_snapshot"},
+	{"removed", "renamed"},
+	{"booted", "migrated", "restored", "from_snapshot", "wakeup", "recovered"},
+	{"paused", "migrated", "ioerror", "watchdog", "restored", "from_snapshot", "api_error", "postcopy", "postcopy_failed"},
+	{"unpaused", "migrated", "from_snapshot", "postcopy", "postcopy_failed"},
+	{"shutdown", "destroyed", "crashed", "migrated", "saved", "failed", "from_snapshot", "recovered"},
+	{"finished", "guest", "host"},
+	{"memory", "disk"},
+	{"panicked", "crashloaded"},
+}
+
+func domainEventName(event, detail int32) (string, string) {
+	if event < 0 || int(event) >= len(domainEventTypes) {
+		return "unknown", ""
+	}
+	var d string
+	if details := domainEventDetails[event]; detail >= 0 && int(detail) < len(details) {
+		d = details[detail]
+	}
+	return domainEventTypes[event], d
+}
+
+var agentStates = map[int32]string{1: "connected", 2: "disconnected"}
+var agentReasons = map[int32]string{1: "domain_started", 2: "channel"}
+
+func newEvent(kind, name, typ, detail string) model.Event {
+	return model.Event{Time: time.Now().Unix(), Kind: kind, Name: name, Type: typ, Detail: detail}
+}
+
+// WatchEvents streams domain lifecycle, reboot and guest agent events to
+// publish, resubscribing whenever the connection is re-established.
+// go-libvirt does not route network and storage pool event callbacks, so
+// those are derived by polling their state every eventPollInterval.
+func (s *LibvirtService) WatchEvents(publish func(model.Event)) {
+	go s.watchEvents(publish)
+}
+
+func (s *LibvirtService) watchEvents(publish func(model.Event)) {
+	var nets, pools map[string]bool
+	poll := func() {
+		if list, err := s.ListNetworks(); err == nil {
+			cur := make(map[string]bool, len(list))
+			for _, n := range list {
+				cur[n.Name] = n.Active
+			}
+			diffStates("network", nets, cur, publish)
+			nets = cur
+		}
+		if list, err := s.ListStoragePools(); err == nil {
+			cur := make(map[string]bool, len(list))
+			for _, p := range list {
+				cur[p.Name] = p.Active
+			}
+			diffStates("pool", pools, cur, publish)
+			pools = cur
+		}
+	}
+
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		lost, err := s.subscribeDomainEvents(ctx, publish)
+		if err != nil {
+			cancel()
+			select {
+			case <-s.stopCh:
+				return
+			case <-time.After(eventPollInterval):
+				continue
+			}
+		}
+		poll()
+		ticker := time.NewTicker(eventPollInterval)
+	watch:
+		for {
+			select {
+			case <-s.stopCh:
+				ticker.Stop()
+				cancel()
+				return
+			case <-lost:
+				break watch
+			case <-ticker.C:
+				poll()
+			}
+		}
+		ticker.Stop()
+		cancel()
+	}
+}
+
+// subscribeDomainEvents registers the domain event callbacks on the
+// current connection. The returned channel is closed when that
+// connection goes away.
+func (s *LibvirtService) subscribeDomainEvents(ctx context.Context, publish func(model.Event)) (<-chan struct{}, error) {
+	l, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []<-chan interface{}
+	for _, id := range []libvirt.DomainEventID{libvirt.DomainEventIDLifecycle, libvirt.DomainEventIDReboot, libvirt.DomainEventIDAgentLifecycle} {
+		ch, err := l.SubscribeEvents(ctx, id, libvirt.OptDomain{})
+		if err != nil {
+			log.Printf("订阅 libvirt 事件失败 (%s): %v", s.uri, err)
+			return nil, err
+		}
+		streams = append(streams, ch)
+	}
+	s.cacheOwners()
+	for _, ch := range streams {
+		go func(ch <-chan interface{}) {
+			for ev := range ch {
+				if e, ok := translateDomainEvent(ev); ok {
+					s.stampOwner(&e)
+					publish(e)
+				}
+			}
+		}(ch)
+	}
+	return l.Disconnected(), nil
+}
+
+// cacheOwners records the owner of every domain, so the undefine of one
+// that has had no event since the subscription still names its owner.
+func (s *LibvirtService) cacheOwners() {
+	vms, err := s.ListVMs()
+	if err != nil {
+		return
+	}
+	s.ownerMu.Lock()
+	defer s.ownerMu.Unlock()
+	for _, vm := range vms {
+		s.owners[vm.Name] = model.VMOwner{Owner: vm.Owner, Group: vm.Group}
+	}
+}
+
+// stampOwner sets the owner of the domain an event is about. The domain
+// is gone once undefined, so that event takes the owner cached before.
+func (s *LibvirtService) stampOwner(e *model.Event) {
+	if e.Type != "undefined" {
+		if owner, err := s.domainOwner(e.Name); err == nil {
+			s.ownerMu.Lock()
+			s.owners[e.Name] = owner
+			s.ownerMu.Unlock()
+		}
+	}
+	s.ownerMu.Lock()
+	defer s.ownerMu.Unlock()
+	owner := s.owners[e.Name]
+	e.Owner, e.Group = owner.Owner, owner.Group
+	if e.Type == "undefined" {
+		delete(s.owners, e.Name)
+	}
+}
+
+// domainOwner reads the owner recorded in a domain's metadata.
+func (s *LibvirtService) domainOwner(name string) (model.VMOwner, error) {
+	l, err := s.conn()
+	if err != nil {
+		return model.VMOwner{}, err
+	}
+	d, err := l.DomainLookupByName(name)
+	if err != nil {
+		return model.VMOwner{}, err
+	}
+	xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	if err != nil {
+		return model.VMOwner{}, err
+	}
+	vm := parseDomainInfo(xmlStr)
+	return model.VMOwner{Owner: vm.Owner, Group: vm.Group}, nil
+}
+
+func translateDomainEvent(ev interface{}) (model.Event, bool) {
+	switch m := ev.(type) {
+	case *libvirt.DomainEventCallbackLifecycleMsg:
+		typ, detail := domainEventName(m.Msg.Event, m.Msg.Detail)
+		return newEvent("domain", m.Msg.Dom.Name, typ, detail), true
+	case *libvirt.DomainEventCallbackRebootMsg:
+		return newEvent("domain", m.Msg.Dom.Name, "rebooted", ""), true
+	case *libvirt.DomainEventCallbackAgentLifecycleMsg:
+		return newEvent("agent", m.Dom.Name, agentStates[m.State], agentReasons[m.Reason]), true
+	}
+	return model.Event{}, false
+}
+
+// diffStates publishes defined/undefined/started/stopped events for the
+// objects whose presence or active flag changed. A nil prev is the first
+// sample and produces no events.
+func diffStates(kind string, prev, cur map[string]bool, publish func(model.Event)) {
+	if prev == nil {
+		return
+	}
+	for name, active := range cur {
+		was, existed := prev[name]
+		if !existed {
+			publish(newEvent(kind, name, "defined", ""))
+		}
+		if active && !was {
+			publish(newEvent(kind, name, "started", ""))
+		} else if !active && was {
+			publish(newEvent(kind, name, "stopped", ""))
+		}
+	}
+	for name, was := range prev {
+		if _, ok := cur[name]; ok {
+			continue
+		}
+		if was {
+			publish(newEvent(kind, name, "stopped", ""))
+		}
+		publish(newEvent(kind, name, "undefined", ""))
+	}
+}
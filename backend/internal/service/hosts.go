@@ -0,0 +1,400 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"virtpanel/internal/config"
+	"virtpanel/internal/model"
+)
+
+const hostCheckInterval = 15 * time.Second
+
+// HostManager holds one Hypervisor per managed host. The host behind
+// cfg.LibvirtURI (or the sim driver) is always present under
+// cfg.HostName; others come from the config file or are registered at
+// runtime and persisted in DataDir/hosts.json.
+type HostManager struct {
+	cfg     *config.Config
+	publish func(model.Event)
+	mu      sync.RWMutex
+	hosts   map[string]*managedHost
+	stopCh  chan struct{}
+}
+
+type managedHost struct {
+	hv      Hypervisor
+	publish func(model.Event)
+
+	mu     sync.Mutex
+	status model.Host
+}
+
+// NewHostManager registers primary plus every configured and saved host,
+// then starts the background health checks. Unreachable hosts are kept
+// and retried. Events from all hosts, tagged with the host name, and
+// host connect/disconnect events go to publish.
+func NewHostManager(cfg *config.Config, primary Hypervisor, publish func(model.Event)) *HostManager {
+	m := &HostManager{cfg: cfg, publish: publish, hosts: make(map[string]*managedHost), stopCh: make(chan struct{})}
+	uri := cfg.LibvirtURI
+	if cfg.Driver == "sim" {
+		uri = "sim://"
+	}
+	m.hosts[cfg.HostName] = &managedHost{hv: primary, status: model.Host{
+		Name: cfg.HostName, URI: uri, Local: true, Builtin: true, Default: true,
+	}}
+	for _, hc := range cfg.Hosts {
+		m.hosts[hc.Name] = newManagedHost(m.cfg, hc, true)
+	}
+	saved, err := m.load()
+	if err != nil {
+		log.Printf("读取主机列表失败: %v", err)
+	}
+	for _, hc := range saved {
+		if _, ok := m.hosts[hc.Name]; ok {
+			log.Printf("忽略重复的主机 %s", hc.Name)
+			continue
+		}
+		m.hosts[hc.Name] = newManagedHost(m.cfg, hc, false)
+	}
+	for _, h := range m.hosts {
+		m.watch(h)
+	}
+	m.checkAll()
+	go m.healthLoop()
+	return m
+}
+
+func newManagedHost(cfg *config.Config, hc config.HostConfig, builtin bool) *managedHost {
+	var hv Hypervisor
+	local := true // simulated hosts fake bridges and port forwards too
+	if u, err := url.Parse(hc.URI); err == nil && u.Scheme == "sim" {
+		hv = NewSimService(cfg)
+	} else {
+		lv := newLibvirtService(cfg, hc.URI)
+		lv.start()
+		hv, local = lv, lv.local
+	}
+	return &managedHost{hv: hv, status: model.Host{
+		Name: hc.Name, URI: hc.URI, Local: local, Builtin: builtin,
+	}}
+}
+
+// watch forwards the host's events, tagged with its name.
+func (m *HostManager) watch(h *managedHost) {
+	name := h.status.Name
+	h.publish = func(e model.Event) {
+		e.Host = name
+		m.publish(e)
+	}
+	h.hv.WatchEvents(h.publish)
+}
+
+func (m *HostManager) hostsFile() string {
+	return filepath.Join(m.cfg.DataDir, "hosts.json")
+}
+
+func (m *HostManager) load() ([]config.HostConfig, error) {
+	data, err := os.ReadFile(m.hostsFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var hosts []config.HostConfig
+	return hosts, json.Unmarshal(data, &hosts)
+}
+
+// save writes the runtime-registered hosts. Caller must hold m.mu.
+func (m *HostManager) save() error {
+	hosts := make([]config.HostConfig, 0)
+	for _, h := range m.hosts {
+		if !h.status.Builtin {
+			hosts = append(hosts, config.HostConfig{Name: h.status.Name, URI: h.status.URI})
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	os.MkdirAll(m.cfg.DataDir, 0755)
+	data, _ := json.MarshalIndent(hosts, "", "  ")
+	return os.WriteFile(m.hostsFile(), data, 0644)
+}
+
+func (m *HostManager) healthLoop() {
+	ticker := time.NewTicker(hostCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.checkAll()
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
+// checkAll pings every host in parallel so one slow host does not delay
+// the others.
+func (m *HostManager) checkAll() {
+	var wg sync.WaitGroup
+	for _, h := range m.snapshot() {
+		wg.Add(1)
+		go func(h *managedHost) {
+			defer wg.Done()
+			h.check()
+		}(h)
+	}
+	wg.Wait()
+}
+
+func (h *managedHost) check() model.Host {
+	start := time.Now()
+	err := h.hv.Ping()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil && h.status.Connected {
+		log.Printf("主机 %s 连接断开: %v", h.status.Name, err)
+		h.publish(newEvent("host", h.status.Name, "disconnected", err.Error()))
+	} else if err == nil && !h.status.Connected && h.status.LastCheck != 0 {
+		log.Printf("主机 %s 已重新连接", h.status.Name)
+		h.publish(newEvent("host", h.status.Name, "connected", ""))
+	}
+	h.status.Connected = err == nil
+	h.status.Error = ""
+	if err != nil {
+		h.status.Error = err.Error()
+	}
+	h.status.LastCheck = start.Unix()
+	h.status.LatencyMS = time.Since(start).Milliseconds()
+	h.status.Reconnects = h.hv.Reconnects()
+	return h.status
+}
+
+func (h *managedHost) info() model.Host {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+// snapshot returns the hosts sorted by name, default host first.
+func (m *HostManager) snapshot() []*managedHost {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	hosts := make([]*managedHost, 0, len(m.hosts))
+	for _, h := range m.hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		// Name and Default never change, so they can be read unlocked
+		a, b := hosts[i], hosts[j]
+		if a.status.Default != b.status.Default {
+			return a.status.Default
+		}
+		return a.status.Name < b.status.Name
+	})
+	return hosts
+}
+
+// Get returns the hypervisor for name; "" selects the default host.
+func (m *HostManager) Get(name string) (Hypervisor, error) {
+	if name == "" {
+		name = m.cfg.HostName
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	h, ok := m.hosts[name]
+	if !ok {
+		return nil, notFound(CodeHostNotFound, "host not found: %s", name)
+	}
+	return h.hv, nil
+}
+
+// Host returns the status of one host; "" selects the default host.
+func (m *HostManager) Host(name string) (model.Host, error) {
+	if name == "" {
+		name = m.cfg.HostName
+	}
+	m.mu.RLock()
+	h, ok := m.hosts[name]
+	m.mu.RUnlock()
+	if !ok {
+		return model.Host{}, notFound(CodeHostNotFound, "host not found: %s", name)
+	}
+	return h.info(), nil
+}
+
+// List returns every host with its last health check result.
+func (m *HostManager) List() []model.Host {
+	hosts := m.snapshot()
+	result := make([]model.Host, 0, len(hosts))
+	for _, h := range hosts {
+		result = append(result, h.info())
+	}
+	return result
+}
+
+// Add registers a new host and persists it. The host is kept even if it
+// cannot be reached yet; the returned status tells whether it could.
+func (m *HostManager) Add(req model.AddHostRequest) (model.Host, error) {
+	if !safeNameRe.MatchString(req.Name) {
+		return model.Host{}, invalid(CodeInvalidName, "invalid host name: %s", req.Name)
+	}
+	if err := config.CheckHostURI(req.URI); err != nil {
+		return model.Host{}, &Error{Kind: Invalid, Code: CodeInvalidArgument, Msg: "invalid uri", Err: err}
+	}
+	h := newManagedHost(m.cfg, config.HostConfig{Name: req.Name, URI: req.URI}, false)
+	m.mu.Lock()
+	if _, ok := m.hosts[req.Name]; ok {
+		m.mu.Unlock()
+		h.hv.Close()
+		return model.Host{}, conflict(CodeAlreadyExists, "host already exists: %s", req.Name)
+	}
+	m.hosts[req.Name] = h
+	m.watch(h)
+	err := m.save()
+	m.mu.Unlock()
+	if err != nil {
+		return model.Host{}, err
+	}
+	return h.check(), nil
+}
+
+// Remove unregisters a host added at runtime and closes its connection.
+func (m *HostManager) Remove(name string) error {
+	m.mu.Lock()
+	h, ok := m.hosts[name]
+	if !ok {
+		m.mu.Unlock()
+		return notFound(CodeHostNotFound, "host not found: %s", name)
+	}
+	if h.status.Builtin {
+		m.mu.Unlock()
+		return precondition(CodeHostBuiltin, "host %s is defined in the config file", name)
+	}
+	delete(m.hosts, name)
+	err := m.save()
+	m.mu.Unlock()
+	h.hv.Close()
+	return err
+}
+
+// Check runs a health check on one host right away.
+func (m *HostManager) Check(name string) (model.Host, error) {
+	m.mu.RLock()
+	h, ok := m.hosts[name]
+	m.mu.RUnlock()
+	if !ok {
+		return model.Host{}, notFound(CodeHostNotFound, "host not found: %s", name)
+	}
+	return h.check(), nil
+}
+
+// ListVMs lists the VMs of every connected host, tagged with the host
+// name. Hosts that fail are reported in Errors instead of failing the
+// whole listing.
+func (m *HostManager) ListVMs() model.HostVMs {
+	hosts := m.snapshot()
+	lists := make([][]model.VM, len(hosts))
+	errs := make([]error, len(hosts))
+	for i, h := range hosts {
+		if st := h.info(); !st.Connected {
+			errs[i] = &Error{Kind: Unavailable, Code: CodeHostUnavailable, Msg: "not connected: " + st.Error}
+		}
+	}
+	m.each(hosts, func(i int, h *managedHost) {
+		vms, err := h.hv.ListVMs()
+		for j := range vms {
+			vms[j].Host = h.status.Name
+		}
+		lists[i], errs[i] = vms, err
+	})
+	result := model.HostVMs{VMs: make([]model.VM, 0)}
+	for i, h := range hosts {
+		if errs[i] != nil {
+			if result.Errors == nil {
+				result.Errors = make(map[string]string)
+			}
+			result.Errors[h.status.Name] = errs[i].Error()
+			continue
+		}
+		result.VMs = append(result.VMs, lists[i]...)
+	}
+	return result
+}
+
+// Overview returns every host's status together with its HostInfo when
+// the host is reachable.
+func (m *HostManager) Overview() []model.HostOverview {
+	hosts := m.snapshot()
+	result := make([]model.HostOverview, len(hosts))
+	for i, h := range hosts {
+		result[i].Host = h.info()
+	}
+	m.each(hosts, func(i int, h *managedHost) {
+		result[i].Info, _ = h.hv.GetHostInfo()
+	})
+	return result
+}
+
+// Stats returns every host's status with its host info and guest
+// counters, read concurrently from the connected hosts.
+func (m *HostManager) Stats() []model.HostStats {
+	hosts := m.snapshot()
+	result := make([]model.HostStats, len(hosts))
+	for i, h := range hosts {
+		result[i].Host = h.info()
+	}
+	m.each(hosts, func(i int, h *managedHost) {
+		if info, err := h.hv.GetHostInfo(); err == nil {
+			result[i].Info = info
+		}
+		if guests, err := h.hv.GuestStats(); err == nil {
+			result[i].Guests = guests
+		}
+	})
+	return result
+}
+
+// each runs fn for every connected host concurrently. Hosts that failed
+// their last health check are skipped rather than waited on.
+func (m *HostManager) each(hosts []*managedHost, fn func(i int, h *managedHost)) {
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		st := h.info()
+		if !st.Connected {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, h *managedHost) {
+			defer wg.Done()
+			fn(i, h)
+		}(i, h)
+	}
+	wg.Wait()
+}
+
+// Address returns the address VNC consoles of the host are reachable at.
+func (m *HostManager) Address(name string) string {
+	st, err := m.Host(name)
+	if err != nil || st.Local {
+		return "127.0.0.1"
+	}
+	if u, err := url.Parse(st.URI); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "127.0.0.1"
+}
+
+// Close stops the health checks and closes every host connection.
+func (m *HostManager) Close() {
+	close(m.stopCh)
+	for _, h := range m.snapshot() {
+		h.hv.Close()
+	}
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"io"
+
+	"virtpanel/internal/model"
+)
+
+// Hypervisor is the service surface used by the HTTP handlers. It is
+// implemented by LibvirtService for real hosts and by SimService for the
+// in-memory demo/test driver.
+type Hypervisor interface {
+	Close()
+	// Ping checks the connection to the host, reconnecting if it was lost.
+	Ping() error
+	// Reconnects returns how often a lost connection was re-established.
+	Reconnects() uint64
+	// WatchEvents starts delivering lifecycle events of domains, guest
+	// agents, networks and pools to publish until Close.
+	WatchEvents(publish func(model.Event))
+
+	// Host
+	GetHostInfo() (*model.HostInfo, error)
+	// GuestStats returns the cumulative counters of every domain.
+	GuestStats() ([]model.GuestStats, error)
+
+	// VM lifecycle
+	ListVMs() ([]model.VM, error)
+	GetVM(name string) (*model.VM, error)
+	GetVMDetail(name string) (*model.VMDetail, error)
+	CreateVM(ctx context.Context, req model.CreateVMRequest, p Progress) error
+	ImportVM(req model.ImportVMRequest) error
+	UpdateVM(name string, req model.UpdateVMRequest) error
+	GetVMXML(name string, inactive bool) (string, error)
+	UpdateVMXML(name string, req model.UpdateVMXMLRequest) (*model.VMXMLResult, error)
+	DeleteVM(name string) error
+	StartVM(name string) error
+	ShutdownVM(name string) error
+	DestroyVM(name string) error
+	RebootVM(name string) error
+	SuspendVM(name string) error
+	ResumeVM(name string) error
+	CloneVM(ctx context.Context, srcName string, req model.CloneVMRequest, p Progress) error
+	// FlattenVM turns a linked clone into a VM with standalone disks.
+	FlattenVM(ctx context.Context, name string, p Progress) error
+	RenameVM(oldName, newName string) error
+	GetAutostart(name string) (bool, error)
+	SetAutostart(name string, enabled bool) error
+	// SetVMOwner records who owns the VM, stored with the domain.
+	SetVMOwner(name string, owner model.VMOwner) error
+	// SetTemplate marks a shut-off VM as a template, or clears the mark.
+	SetTemplate(name string, template bool) error
+	// CreateFromTemplate copies a template's definition and disks into a
+	// new VM and applies the overrides in req.
+	CreateFromTemplate(ctx context.Context, template string, req model.CreateFromTemplateRequest, p Progress) error
+	// ExportOVA converts a shut-off VM's disks for an OVA; the caller
+	// streams it and closes it.
+	ExportOVA(ctx context.Context, name string) (*OVAExport, error)
+	// ImportOVA defines a VM from an OVA read from r, converting its
+	// disks into a storage pool.
+	ImportOVA(ctx context.Context, req model.ImportOVARequest, r io.Reader, size int64, p Progress) error
+
+	// VM devices
+	AttachDisk(vmName string, req model.AttachDiskRequest) error
+	DetachDisk(vmName, target string) error
+	AttachNIC(vmName string, req model.AttachNICRequest) error
+	DetachNIC(vmName, mac string) error
+	AttachISO(vmName string, isoPath string) error
+	DetachISO(vmName string) error
+	FinishInstall(vmName string) error
+	GetVNCPort(name string) (int, error)
+	OpenConsole(name string) (io.ReadWriteCloser, error)
+
+	// Snapshots
+	ListSnapshots(vmName string) ([]model.Snapshot, error)
+	CreateSnapshot(vmName string, req model.CreateSnapshotRequest) error
+	DeleteSnapshot(vmName, snapName string) error
+	RevertSnapshot(vmName, snapName string) error
+	RevertSnapshotToNew(ctx context.Context, vmName, snapName string, req model.RevertSnapshotToNewRequest, p Progress) error
+
+	// Networks
+	ListNetworks() ([]model.Network, error)
+	CreateNetwork(req model.CreateNetworkRequest) error
+	StartNetwork(name string) error
+	StopNetwork(name string) error
+	DeleteNetwork(name string) error
+	ListDHCPLeases(networkName string) ([]DHCPLease, error)
+
+	// Storage pools and volumes
+	ListStoragePools() ([]model.StoragePool, error)
+	CreateStoragePool(req model.CreateStoragePoolRequest) error
+	StartStoragePool(name string) error
+	StopStoragePool(name string) error
+	DeleteStoragePool(name string) error
+	ListVolumes(poolName string) ([]model.StorageVolume, error)
+	CreateVolume(req model.CreateVolumeRequest) error
+	DeleteVolume(poolName, volName string) error
+
+	// ISO images
+	ListISOs() ([]model.ISOFile, error)
+	UploadISO(ctx context.Context, filename string, reader io.Reader, size int64, p Progress) error
+	DeleteISO(filename string) error
+
+	// Host bridges
+	ListBridges() ([]model.Bridge, error)
+	CreateBridge(req model.CreateBridgeRequest) error
+	DeleteBridge(name string) error
+
+	// Port forwards
+	ListPortForwards() ([]PortForward, error)
+	AddPortForward(pf PortForward) error
+	DeletePortForward(id string) error
+	RestorePortForwards()
+}
+
+// Progress receives status from long-running operations (they run as
+// tasks): the completed percentage, and log output written as-is.
+type Progress interface {
+	io.Writer
+	SetProgress(percent float64)
+}
+
+// progressReader reports how much of a known-size stream has been read
+// and stops once ctx is canceled.
+type progressReader struct {
+	ctx   context.Context
+	r     io.Reader
+	p     Progress
+	total int64
+	done  int64
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	if err := pr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := pr.r.Read(b)
+	pr.done += int64(n)
+	if pr.total > 0 {
+		pr.p.SetProgress(float64(pr.done) * 100 / float64(pr.total))
+	}
+	return n, err
+}
+
+var (
+	_ Hypervisor = (*LibvirtService)(nil)
+	_ Hypervisor = (*SimService)(nil)
+)
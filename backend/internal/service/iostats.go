@@ -0,0 +1,135 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"virtpanel/internal/model"
+)
+
+// ioSample is the last block and interface counters of a running domain.
+// Like cpuSample, rates are taken against the previous call, so the first
+// listing after a start has none.
+type ioSample struct {
+	blocks map[string]model.BlockStats // by target, e.g. vda
+	nics   map[string]model.NICStats   // by host-side device, e.g. vnet0
+	ts     time.Time
+}
+
+// ioCache keeps the last sample per domain name.
+type ioCache struct {
+	mu      sync.Mutex
+	samples map[string]ioSample
+}
+
+// domainIO is the rate of each disk and NIC of one domain.
+type domainIO struct {
+	disks map[string]*model.DiskIO
+	nics  map[string]*model.NICIO
+}
+
+// rates records the counters of g and returns the rates since the
+// previous sample. Devices that are new or whose counters went back (the
+// domain was restarted in between) are left out.
+func (c *ioCache) rates(g model.GuestStats, now time.Time) domainIO {
+	cur := ioSample{blocks: make(map[string]model.BlockStats), nics: make(map[string]model.NICStats), ts: now}
+	for _, b := range g.Blocks {
+		cur.blocks[b.Device] = b
+	}
+	for _, n := range g.NICs {
+		cur.nics[n.Device] = n
+	}
+	c.mu.Lock()
+	if c.samples == nil {
+		c.samples = make(map[string]ioSample)
+	}
+	prev, ok := c.samples[g.Name]
+	c.samples[g.Name] = cur
+	c.mu.Unlock()
+
+	io := domainIO{disks: make(map[string]*model.DiskIO), nics: make(map[string]*model.NICIO)}
+	dt := now.Sub(prev.ts).Seconds()
+	if !ok || dt <= 0 {
+		return io
+	}
+	for dev, b := range cur.blocks {
+		p, ok := prev.blocks[dev]
+		if !ok || b.ReadBytes < p.ReadBytes || b.WriteBytes < p.WriteBytes || b.ReadReqs < p.ReadReqs ||
+			b.WriteReqs < p.WriteReqs || b.ReadTime < p.ReadTime || b.WriteTime < p.WriteTime {
+			continue
+		}
+		io.disks[dev] = &model.DiskIO{
+			ReadBytes:    rate(b.ReadBytes-p.ReadBytes, dt),
+			WriteBytes:   rate(b.WriteBytes-p.WriteBytes, dt),
+			ReadIOPS:     rate(b.ReadReqs-p.ReadReqs, dt),
+			WriteIOPS:    rate(b.WriteReqs-p.WriteReqs, dt),
+			ReadLatency:  latency(b.ReadTime-p.ReadTime, b.ReadReqs-p.ReadReqs),
+			WriteLatency: latency(b.WriteTime-p.WriteTime, b.WriteReqs-p.WriteReqs),
+		}
+	}
+	for dev, n := range cur.nics {
+		p, ok := prev.nics[dev]
+		if !ok || n.RxBytes < p.RxBytes || n.TxBytes < p.TxBytes || n.RxPackets < p.RxPackets || n.TxPackets < p.TxPackets ||
+			n.RxErrors < p.RxErrors || n.TxErrors < p.TxErrors || n.RxDrops < p.RxDrops || n.TxDrops < p.TxDrops {
+			continue
+		}
+		io.nics[dev] = &model.NICIO{
+			RxBytes:   rate(n.RxBytes-p.RxBytes, dt),
+			RxPackets: rate(n.RxPackets-p.RxPackets, dt),
+			RxErrors:  rate(n.RxErrors-p.RxErrors, dt),
+			RxDrops:   rate(n.RxDrops-p.RxDrops, dt),
+			TxBytes:   rate(n.TxBytes-p.TxBytes, dt),
+			TxPackets: rate(n.TxPackets-p.TxPackets, dt),
+			TxErrors:  rate(n.TxErrors-p.TxErrors, dt),
+			TxDrops:   rate(n.TxDrops-p.TxDrops, dt),
+		}
+	}
+	return io
+}
+
+// forget drops the samples of domains that are no longer running.
+func (c *ioCache) forget(running map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name := range c.samples {
+		if !running[name] {
+			delete(c.samples, name)
+		}
+	}
+}
+
+func rate(delta uint64, dt float64) float64 {
+	return math.Round(float64(delta)/dt*10) / 10
+}
+
+// latency is the average time per request in ms.
+func latency(ns, reqs uint64) float64 {
+	if reqs == 0 {
+		return 0
+	}
+	return math.Round(float64(ns)/float64(reqs)/1e3) / 1e3
+}
+
+// apply sets the totals of the list view.
+func (io domainIO) apply(vm *model.VM) {
+	for _, d := range io.disks {
+		vm.DiskRead += d.ReadBytes
+		vm.DiskWrite += d.WriteBytes
+		vm.DiskIOPS += d.ReadIOPS + d.WriteIOPS
+	}
+	for _, n := range io.nics {
+		vm.NetRx += n.RxBytes
+		vm.NetTx += n.TxBytes
+	}
+}
+
+// attach sets the rates on the disks and NICs of a detail view.
+func (io domainIO) attach(detail *model.VMDetail) {
+	for i := range detail.Disks {
+		detail.Disks[i].IO = io.disks[detail.Disks[i].Target]
+	}
+	for i := range detail.NICs {
+		detail.NICs[i].IO = io.nics[detail.NICs[i].Target]
+	}
+}
@@ -1,7 +1,7 @@
 package service
 
 import (
-	"fmt"
+	"context"
 	"io"
 	"virtpanel/internal/model"
 	"os"
@@ -9,9 +9,11 @@ import (
 	"strings"
 )
 
-const isoDir = "/var/lib/libvirt/images/iso"
-
 func (s *LibvirtService) ListISOs() ([]model.ISOFile, error) {
+	if !s.local {
+		return s.listRemoteISOs()
+	}
+	isoDir := s.cfg.ISODir
 	os.MkdirAll(isoDir, 0755)
 	entries, err := os.ReadDir(isoDir)
 	if err != nil {
@@ -35,25 +37,29 @@ func (s *LibvirtService) ListISOs() ([]model.ISOFile, error) {
 	return result, nil
 }
 
-func (s *LibvirtService) UploadISO(filename string, reader io.Reader) error {
+func (s *LibvirtService) UploadISO(ctx context.Context, filename string, reader io.Reader, size int64, p Progress) error {
+	if !s.local {
+		return ErrLocalOnly
+	}
 	filename = filepath.Base(filename)
 	if !strings.HasSuffix(strings.ToLower(filename), ".iso") {
-		return fmt.Errorf("only .iso files allowed")
+		return invalid(CodeInvalidName, "only .iso files allowed")
 	}
 	nameWithoutExt := strings.TrimSuffix(filename, filepath.Ext(filename))
 	if !safeNameRe.MatchString(nameWithoutExt) {
-		return fmt.Errorf("invalid filename: %s", filename)
+		return invalid(CodeInvalidName, "invalid filename: %s", filename)
 	}
+	isoDir := s.cfg.ISODir
 	os.MkdirAll(isoDir, 0755)
 	dstPath := filepath.Join(isoDir, filename)
 	if _, err := os.Stat(dstPath); err == nil {
-		return fmt.Errorf("文件已存在: %s", filename)
+		return conflict(CodeAlreadyExists, "file already exists: %s", filename)
 	}
 	dst, err := os.Create(dstPath)
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(dst, reader)
+	_, err = io.Copy(dst, &progressReader{ctx: ctx, r: reader, p: p, total: size})
 	dst.Close()
 	if err != nil {
 		os.Remove(dstPath)
@@ -64,12 +70,63 @@ func (s *LibvirtService) UploadISO(filename string, reader io.Reader) error {
 func (s *LibvirtService) DeleteISO(filename string) error {
 	base := filepath.Base(filename)
 	if !strings.HasSuffix(strings.ToLower(base), ".iso") {
-		return fmt.Errorf("not an iso file: %s", base)
+		return invalid(CodeInvalidName, "not an iso file: %s", base)
 	}
+	isoDir := s.cfg.ISODir
 	path := filepath.Join(isoDir, base)
 	// Ensure resolved path is still under isoDir
 	if !strings.HasPrefix(filepath.Clean(path), filepath.Clean(isoDir)+string(filepath.Separator)) {
-		return fmt.Errorf("invalid filename")
+		return invalid(CodeInvalidName, "invalid filename")
+	}
+	if !s.local {
+		l, err := s.conn()
+		if err != nil {
+			return err
+		}
+		vol, err := l.StorageVolLookupByPath(path)
+		if err != nil {
+			return err
+		}
+		return l.StorageVolDelete(vol, 0)
+	}
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return notFound(CodeFileNotFound, "iso not found: %s", base)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// listRemoteISOs lists the .iso volumes of the pool backing iso_dir on a
+// remote host. Hosts without such a pool simply have no ISOs.
+func (s *LibvirtService) listRemoteISOs() ([]model.ISOFile, error) {
+	l, err := s.conn()
+	if err != nil {
+		return nil, err
 	}
-	return os.Remove(path)
+	result := make([]model.ISOFile, 0)
+	pool, err := poolForDir(l, s.cfg.ISODir)
+	if err != nil {
+		return result, nil
+	}
+	_ = l.StoragePoolRefresh(pool, 0)
+	vols, _, err := l.StoragePoolListAllVolumes(pool, -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range vols {
+		if !strings.HasSuffix(strings.ToLower(v.Name), ".iso") {
+			continue
+		}
+		_, capacity, _, err := l.StorageVolGetInfo(v)
+		if err != nil {
+			continue
+		}
+		result = append(result, model.ISOFile{
+			Name: v.Name,
+			Path: filepath.Join(s.cfg.ISODir, v.Name),
+			Size: int64(capacity),
+		})
+	}
+	return result, nil
 }
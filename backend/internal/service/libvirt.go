@@ -1,18 +1,22 @@
 package service
 
 import (
-	"encoding/xml"
+	"context"
 	"fmt"
 	"math"
-	"net"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"virtpanel/internal/cloudinit"
+	"virtpanel/internal/config"
+	"virtpanel/internal/domxml"
 	"virtpanel/internal/model"
 
 	libvirt "github.com/digitalocean/go-libvirt"
@@ -20,30 +24,70 @@ import (
 
 var safeNameRe = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
 
+// seedSuffix names the cloud-init seed image of a VM, kept next to its
+// disk as <image_dir>/<name>-cidata.iso and removed with it.
+const seedSuffix = "-cidata.iso"
+
 type cpuSample struct {
 	time    uint64
 	ts      time.Time
 }
 
 type LibvirtService struct {
+	cfg        *config.Config
+	uri        string
+	local      bool         // uri points at this machine, so /proc, qemu-img, ip etc. apply to it
+	mu         sync.RWMutex // guards l only; the client itself is safe for concurrent calls
 	l          *libvirt.Libvirt
-	mu         sync.Mutex
+	ops        opLocks // domains with a mutating operation in progress
+	cpuMu      sync.Mutex
 	cpuCache   map[string]cpuSample // domain name -> last cpu sample
+	ioCache    ioCache              // domain name -> last block and interface counters
 	hostCPU    float64              // cached host CPU usage
 	hostCPUMu  sync.RWMutex
+	nodeCPU    [2]uint64 // remote hosts: last idle/total sample
+	ownerMu    sync.Mutex
+	owners     map[string]model.VMOwner // domain name -> owner, for events after undefine
+	reconnects atomic.Uint64
 	stopCh     chan struct{}
 }
 
-func NewLibvirtService() (*LibvirtService, error) {
-	svc := &LibvirtService{cpuCache: make(map[string]cpuSample), stopCh: make(chan struct{})}
+// NewLibvirtService connects to the libvirt daemon at uri and fails if it
+// is unreachable.
+func NewLibvirtService(cfg *config.Config, uri string) (*LibvirtService, error) {
+	svc := newLibvirtService(cfg, uri)
 	if err := svc.connect(); err != nil {
 		return nil, err
 	}
-	svc.hostCPU = readCPUUsage() // initial sample
-	go svc.cpuSampleLoop()
+	svc.start()
 	return svc, nil
 }
 
+// newLibvirtService returns a service that connects on first use, so a
+// host that is down at startup can still be registered.
+func newLibvirtService(cfg *config.Config, uri string) *LibvirtService {
+	return &LibvirtService{
+		cfg:      cfg,
+		uri:      uri,
+		local:    isLocalURI(uri),
+		cpuCache: make(map[string]cpuSample),
+		owners:   make(map[string]model.VMOwner),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+func isLocalURI(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && u.Host == ""
+}
+
+func (s *LibvirtService) start() {
+	if s.local {
+		s.hostCPU = readCPUUsage() // initial sample
+	}
+	go s.cpuSampleLoop()
+}
+
 // cpuSampleLoop samples host CPU usage in background every 2s
 func (s *LibvirtService) cpuSampleLoop() {
 	ticker := time.NewTicker(2 * time.Second)
@@ -51,7 +95,12 @@ func (s *LibvirtService) cpuSampleLoop() {
 	for {
 		select {
 		case <-ticker.C:
-			v := readCPUUsage()
+			var v float64
+			if s.local {
+				v = readCPUUsage()
+			} else {
+				v = s.readNodeCPUUsage()
+			}
 			s.hostCPUMu.Lock()
 			s.hostCPU = v
 			s.hostCPUMu.Unlock()
@@ -62,32 +111,75 @@ func (s *LibvirtService) cpuSampleLoop() {
 }
 
 func (s *LibvirtService) connect() error {
-	c, err := net.DialTimeout("unix", "/var/run/libvirt/libvirt-sock", 2*time.Second)
+	u, err := url.Parse(s.uri)
 	if err != nil {
-		return fmt.Errorf("dial libvirt: %w", err)
+		return fmt.Errorf("parse libvirt uri: %w", err)
 	}
-	l := libvirt.New(c)
-	if err := l.Connect(); err != nil {
-		return fmt.Errorf("connect libvirt: %w", err)
+	l, err := libvirt.ConnectToURI(u)
+	if err != nil {
+		return &Error{Kind: Unavailable, Code: CodeHostUnavailable, Msg: "connect libvirt", Err: err}
 	}
 	s.l = l
 	return nil
 }
 
-// ensureConnected checks and reconnects if needed. Caller must hold s.mu.
-func (s *LibvirtService) ensureConnected() error {
-	if _, err := s.l.ConnectGetLibVersion(); err == nil {
-		return nil
+// conn returns the shared connection, reconnecting first if it was lost.
+// Callers use it without holding any lock, so a slow call on one domain
+// does not hold up calls on others.
+func (s *LibvirtService) conn() (*libvirt.Libvirt, error) {
+	s.mu.RLock()
+	l := s.l
+	s.mu.RUnlock()
+	if l != nil && l.IsConnected() {
+		return l, nil
+	}
+	return s.reconnect(l)
+}
+
+// reconnect replaces the broken connection stale. When several callers
+// notice the same broken connection, only the first one reconnects.
+func (s *LibvirtService) reconnect(stale *libvirt.Libvirt) (*libvirt.Libvirt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.l != nil && s.l != stale && s.l.IsConnected() {
+		return s.l, nil
+	}
+	if s.l != nil {
+		_ = s.l.Disconnect()
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
 	}
-	_ = s.l.Disconnect()
-	return s.connect()
+	if stale != nil {
+		s.reconnects.Add(1)
+	}
+	return s.l, nil
+}
+
+// Ping checks the connection with a round trip, reconnecting if it was
+// lost.
+func (s *LibvirtService) Ping() error {
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	if _, err := l.ConnectGetLibVersion(); err != nil {
+		_, err = s.reconnect(l)
+		return err
+	}
+	return nil
 }
 
+// Reconnects returns how often a lost connection has been re-established.
+func (s *LibvirtService) Reconnects() uint64 { return s.reconnects.Load() }
+
 func (s *LibvirtService) Close() {
 	close(s.stopCh)
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.l.Disconnect()
+	if s.l != nil {
+		s.l.Disconnect()
+	}
 }
 
 var stateMap = map[libvirt.DomainState]string{
@@ -106,63 +198,47 @@ func stateName(s libvirt.DomainState) string {
 	return "unknown"
 }
 
-// domainXML is a minimal struct to extract CPU/memory from domain XML
-type domainXML struct {
-	XMLName xml.Name `xml:"domain"`
-	VCPU    int      `xml:"vcpu"`
-	Memory  struct {
-		Value int    `xml:",chardata"`
-		Unit  string `xml:"unit,attr"`
-	} `xml:"memory"`
-}
-
-func parseDomainInfo(xmlStr string) (cpu int, memMB int) {
-	var d domainXML
-	if xml.Unmarshal([]byte(xmlStr), &d) == nil {
-		cpu = d.VCPU
-		switch d.Memory.Unit {
-		case "GiB":
-			memMB = d.Memory.Value * 1024
-		case "MiB":
-			memMB = d.Memory.Value
-		case "KiB", "":
-			memMB = d.Memory.Value / 1024
-		case "bytes", "b":
-			memMB = d.Memory.Value / 1024 / 1024
-		default:
-			memMB = d.Memory.Value / 1024
-		}
+// parseDomainInfo extracts the vCPU count, memory in MiB, owner, template
+// mark and linked clone base from domain XML.
+func parseDomainInfo(xmlStr string) (vm model.VM) {
+	if d, err := domxml.Parse(xmlStr); err == nil {
+		vm.CPU, vm.Memory = d.VCPUs(), d.MemoryMiB()
+		vm.Owner, vm.Group = d.Owner()
+		vm.Template = d.IsTemplate()
+		vm.LinkedBase = d.LinkedBase()
 	}
-	return
+	return vm
 }
 
 func (s *LibvirtService) ListVMs() ([]model.VM, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return nil, err
 	}
-	return s.listVMsLocked()
-}
-
-// listVMsLocked requires s.mu to be held.
-func (s *LibvirtService) listVMsLocked() ([]model.VM, error) {
-	domains, _, err := s.l.ConnectListAllDomains(-1, 0)
+	domains, _, err := l.ConnectListAllDomains(-1, 0)
 	if err != nil {
 		return nil, err
 	}
 	vms := make([]model.VM, 0, len(domains))
 	now := time.Now()
+	guests := make(map[string]model.GuestStats)
+	if stats, err := s.guestStats(nil); err == nil {
+		for _, g := range stats {
+			guests[g.Name] = g
+		}
+	}
+	running := make(map[string]bool)
 	for _, d := range domains {
-		state, _, _, _, _, err := s.l.DomainGetInfo(d)
+		state, _, _, _, _, err := l.DomainGetInfo(d)
 		if err != nil {
 			continue
 		}
-		xmlStr, err := s.l.DomainGetXMLDesc(d, 0)
+		xmlStr, err := l.DomainGetXMLDesc(d, 0)
 		if err != nil {
 			continue
 		}
-		cpu, mem := parseDomainInfo(xmlStr)
+		vm := parseDomainInfo(xmlStr)
+		cpu := vm.CPU
 		uuidStr := fmt.Sprintf("%x", d.UUID)
 		st := stateName(libvirt.DomainState(state))
 
@@ -171,9 +247,12 @@ func (s *LibvirtService) listVMsLocked() ([]model.VM, error) {
 
 		if st == "running" {
 			// CPU usage: compare with cached sample
-			_, _, cpuTimeNs, _, _, err := s.l.DomainGetInfo(d)
+			_, _, cpuTimeNs, _, _, err := l.DomainGetInfo(d)
 			if err == nil {
+				s.cpuMu.Lock()
 				prev, ok := s.cpuCache[d.Name]
+				s.cpuCache[d.Name] = cpuSample{time: cpuTimeNs, ts: now}
+				s.cpuMu.Unlock()
 				if ok {
 					dt := now.Sub(prev.ts).Seconds()
 					if dt > 0 && cpu > 0 {
@@ -187,11 +266,10 @@ func (s *LibvirtService) listVMsLocked() ([]model.VM, error) {
 						cpuUsage = math.Round(cpuUsage*10) / 10
 					}
 				}
-				s.cpuCache[d.Name] = cpuSample{time: cpuTimeNs, ts: now}
 			}
 
 			// Memory: try balloon stats via dommemstat
-			memStats, err := s.l.DomainMemoryStats(d, 11, 0)
+			memStats, err := l.DomainMemoryStats(d, 11, 0)
 			if err == nil {
 				var available, unused uint64
 				for _, ms := range memStats {
@@ -208,145 +286,206 @@ func (s *LibvirtService) listVMsLocked() ([]model.VM, error) {
 			}
 		}
 
-		vms = append(vms, model.VM{
-			Name:     d.Name,
-			UUID:     uuidStr,
-			State:    st,
-			CPU:      cpu,
-			Memory:   mem,
-			CPUUsage: cpuUsage,
-			MemUsed:  memUsed,
-		})
+		vm.Name, vm.UUID, vm.State = d.Name, uuidStr, st
+		vm.CPUUsage, vm.MemUsed = cpuUsage, memUsed
+		if g, ok := guests[d.Name]; ok && st == "running" {
+			running[d.Name] = true
+			s.ioCache.rates(g, now).apply(&vm)
+		}
+		vms = append(vms, vm)
 	}
+	s.ioCache.forget(running)
 	return vms, nil
 }
 
 func (s *LibvirtService) GetVM(name string) (*model.VM, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return nil, err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	d, err := l.DomainLookupByName(name)
 	if err != nil {
 		return nil, err
 	}
-	state, _, _, _, _, err := s.l.DomainGetInfo(d)
+	state, _, _, _, _, err := l.DomainGetInfo(d)
 	if err != nil {
 		return nil, err
 	}
-	xmlStr, err := s.l.DomainGetXMLDesc(d, 0)
+	xmlStr, err := l.DomainGetXMLDesc(d, 0)
 	if err != nil {
 		return nil, err
 	}
-	cpu, mem := parseDomainInfo(xmlStr)
-	return &model.VM{
-		Name:   d.Name,
-		UUID:   fmt.Sprintf("%x", d.UUID),
-		State:  stateName(libvirt.DomainState(state)),
-		CPU:    cpu,
-		Memory: mem,
-	}, nil
+	vm := parseDomainInfo(xmlStr)
+	vm.Name, vm.UUID = d.Name, fmt.Sprintf("%x", d.UUID)
+	vm.State = stateName(libvirt.DomainState(state))
+	return &vm, nil
+}
+
+// SetVMOwner records the owning user and group in the domain metadata.
+// An empty owner removes the record.
+func (s *LibvirtService) SetVMOwner(name string, owner model.VMOwner) error {
+	release, err := s.ops.acquire("set_owner", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	d, err := l.DomainLookupByName(name)
+	if err != nil {
+		return err
+	}
+	var elem libvirt.OptString
+	if owner.Owner != "" || owner.Group != "" {
+		elem = libvirt.OptString{domxml.OwnerElement(owner.Owner, owner.Group)}
+	}
+	flags := libvirt.DomainAffectConfig
+	if active, err := l.DomainIsActive(d); err == nil && active == 1 {
+		flags |= libvirt.DomainAffectLive
+	}
+	if err := l.DomainSetMetadata(d, int32(libvirt.DomainMetadataElement), elem,
+		libvirt.OptString{domxml.OwnerPrefix}, libvirt.OptString{domxml.OwnerNS}, flags); err != nil {
+		return err
+	}
+	s.ownerMu.Lock()
+	s.owners[name] = owner
+	s.ownerMu.Unlock()
+	return nil
 }
 
 func (s *LibvirtService) StartVM(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("start", name)
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	defer release()
+	l, err := s.conn()
 	if err != nil {
 		return err
 	}
-	if err := s.l.DomainCreate(d); err != nil {
+	d, err := lookupNotTemplate(l, name)
+	if err != nil {
+		return err
+	}
+	if err := l.DomainCreate(d); err != nil {
 		return err
 	}
 	// After first boot from cdrom, switch boot order to hd-first
 	// so next reboot (after OS install) boots from disk
-	xmlStr, err := s.l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
 	if err != nil {
 		return nil // VM started, non-fatal
 	}
-	bootRe := regexp.MustCompile(`<boot dev=['"]cdrom['"]/>\s*<boot dev=['"]hd['"]/>`)
-	if bootRe.MatchString(xmlStr) {
-		newXML := bootRe.ReplaceAllString(xmlStr, "<boot dev='hd'/><boot dev='cdrom'/>")
-		s.l.DomainDefineXML(newXML)
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return nil
+	}
+	if boots := dx.BootOrder(); len(boots) >= 2 && boots[0] == "cdrom" && boots[1] == "hd" {
+		dx.BootFirst("hd")
+		if newXML, err := dx.Marshal(); err == nil {
+			l.DomainDefineXML(newXML)
+		}
 	}
 	return nil
 }
 
 func (s *LibvirtService) ShutdownVM(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("shutdown", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	d, err := lookupNotTemplate(l, name)
 	if err != nil {
 		return err
 	}
-	return s.l.DomainShutdown(d)
+	return l.DomainShutdown(d)
 }
 
 func (s *LibvirtService) DestroyVM(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("destroy", name)
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	defer release()
+	l, err := s.conn()
 	if err != nil {
 		return err
 	}
-	return s.l.DomainDestroy(d)
+	d, err := lookupNotTemplate(l, name)
+	if err != nil {
+		return err
+	}
+	return l.DomainDestroy(d)
 }
 
 func (s *LibvirtService) RebootVM(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("reboot", name)
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	defer release()
+	l, err := s.conn()
 	if err != nil {
 		return err
 	}
-	return s.l.DomainReboot(d, 0)
+	d, err := lookupNotTemplate(l, name)
+	if err != nil {
+		return err
+	}
+	return l.DomainReboot(d, 0)
 }
 
 func (s *LibvirtService) DeleteVM(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("delete", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	if err := checkNoLinkedClones(l, name); err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	return s.deleteVM(l, name)
+}
+
+// deleteVM powers off and undefines a domain and removes the images under
+// image_dir no other domain uses. The caller holds the domain's op lock.
+func (s *LibvirtService) deleteVM(l *libvirt.Libvirt, name string) error {
+	d, err := l.DomainLookupByName(name)
 	if err != nil {
 		return err
 	}
 
 	// Collect disk paths before undefining
-	xmlStr, xmlErr := s.l.DomainGetXMLDesc(d, 0)
+	xmlStr, xmlErr := l.DomainGetXMLDesc(d, 0)
 	var diskPaths []string
 	if xmlErr == nil && xmlStr != "" {
-		var dx detailDomainXML
-		if xml.Unmarshal([]byte(xmlStr), &dx) == nil {
+		if dx, err := domxml.Parse(xmlStr); err == nil && dx.Devices != nil {
 			for _, disk := range dx.Devices.Disks {
-				if disk.Device == "disk" && disk.Source.File != "" {
-					diskPaths = append(diskPaths, disk.Source.File)
+				if disk.Device == "disk" && disk.SourceFile() != "" || disk.Device == "cdrom" && strings.HasSuffix(disk.SourceFile(), seedSuffix) {
+					diskPaths = append(diskPaths, disk.SourceFile())
 				}
 			}
 		}
 	}
 
 	// 先尝试强制关闭
-	_ = s.l.DomainDestroy(d)
+	_ = l.DomainDestroy(d)
 	// Undefine with snapshots metadata cleanup
-	err = s.l.DomainUndefineFlags(d, libvirt.DomainUndefineSnapshotsMetadata)
+	err = l.DomainUndefineFlags(d, libvirt.DomainUndefineSnapshotsMetadata)
 	if err != nil {
 		// Fallback to simple undefine if flags not supported
-		err = s.l.DomainUndefine(d)
+		err = l.DomainUndefine(d)
 		if err != nil {
 			return err
 		}
@@ -355,26 +494,25 @@ func (s *LibvirtService) DeleteVM(name string) error {
 	// Clean up disk files (only if not used by other VMs)
 	if len(diskPaths) > 0 {
 		usedPaths := make(map[string]bool)
-		domains, _, listErr := s.l.ConnectListAllDomains(-1, 0)
+		domains, _, listErr := l.ConnectListAllDomains(-1, 0)
 		if listErr == nil {
 			for _, od := range domains {
-				ox, err := s.l.DomainGetXMLDesc(od, libvirt.DomainXMLInactive)
+				ox, err := l.DomainGetXMLDesc(od, libvirt.DomainXMLInactive)
 				if err != nil {
 					continue
 				}
-				var odx detailDomainXML
-				if xml.Unmarshal([]byte(ox), &odx) == nil {
+				if odx, err := domxml.Parse(ox); err == nil && odx.Devices != nil {
 					for _, disk := range odx.Devices.Disks {
-						if disk.Source.File != "" {
-							usedPaths[disk.Source.File] = true
+						if disk.SourceFile() != "" {
+							usedPaths[disk.SourceFile()] = true
 						}
 					}
 				}
 			}
 		}
 		for _, p := range diskPaths {
-			if !usedPaths[p] && strings.HasPrefix(p, "/var/lib/libvirt/images/") {
-				os.Remove(p)
+			if !usedPaths[p] && strings.HasPrefix(p, s.cfg.ImageDir+"/") {
+				s.removeDisk(p)
 			}
 		}
 	}
@@ -382,52 +520,63 @@ func (s *LibvirtService) DeleteVM(name string) error {
 }
 
 func (s *LibvirtService) SuspendVM(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("suspend", name)
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	defer release()
+	l, err := s.conn()
 	if err != nil {
 		return err
 	}
-	return s.l.DomainSuspend(d)
+	d, err := lookupNotTemplate(l, name)
+	if err != nil {
+		return err
+	}
+	return l.DomainSuspend(d)
 }
 
 func (s *LibvirtService) ResumeVM(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("resume", name)
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	defer release()
+	l, err := s.conn()
 	if err != nil {
 		return err
 	}
-	return s.l.DomainResume(d)
+	d, err := lookupNotTemplate(l, name)
+	if err != nil {
+		return err
+	}
+	return l.DomainResume(d)
 }
 
 func (s *LibvirtService) GetAutostart(name string) (bool, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return false, err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	d, err := l.DomainLookupByName(name)
 	if err != nil {
 		return false, err
 	}
-	v, err := s.l.DomainGetAutostart(d)
+	v, err := l.DomainGetAutostart(d)
 	return v != 0, err
 }
 
 func (s *LibvirtService) SetAutostart(name string, enabled bool) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("set_autostart", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	d, err := lookupNotTemplate(l, name)
 	if err != nil {
 		return err
 	}
@@ -435,37 +584,49 @@ func (s *LibvirtService) SetAutostart(name string, enabled bool) error {
 	if enabled {
 		v = 1
 	}
-	return s.l.DomainSetAutostart(d, v)
+	return l.DomainSetAutostart(d, v)
 }
 
 func (s *LibvirtService) RenameVM(oldName, newName string) error {
+	release, err := s.ops.acquire("rename", oldName, newName)
+	if err != nil {
+		return err
+	}
+	defer release()
 	if !safeNameRe.MatchString(newName) {
-		return fmt.Errorf("invalid vm name: %s", newName)
+		return invalid(CodeInvalidName, "invalid vm name: %s", newName)
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(oldName)
+	d, err := lookupNotTemplate(l, oldName)
 	if err != nil {
 		return err
 	}
 	// VM must be shut off
-	state, _, _, _, _, err := s.l.DomainGetInfo(d)
+	state, _, _, _, _, err := l.DomainGetInfo(d)
 	if err != nil {
 		return err
 	}
 	if libvirt.DomainState(state) != libvirt.DomainShutoff {
-		return fmt.Errorf("vm must be shut off to rename")
+		return errNotShutoff
+	}
+	if err := checkNameFree(l, newName); err != nil {
+		return err
 	}
-	_, err = s.l.DomainRename(d, libvirt.OptString{newName}, 0)
+	_, err = l.DomainRename(d, libvirt.OptString{newName}, 0)
 	return err
 }
 
 func (s *LibvirtService) ImportVM(req model.ImportVMRequest) error {
+	release, err := s.ops.acquire("import", req.Name)
+	if err != nil {
+		return err
+	}
+	defer release()
 	if !safeNameRe.MatchString(req.Name) {
-		return fmt.Errorf("invalid vm name: %s", req.Name)
+		return invalid(CodeInvalidName, "invalid vm name: %s", req.Name)
 	}
 	if req.CPU <= 0 {
 		req.CPU = 2
@@ -480,14 +641,20 @@ func (s *LibvirtService) ImportVM(req model.ImportVMRequest) error {
 	}
 	validBus := map[string]bool{"virtio": true, "sata": true, "scsi": true, "ide": true}
 	if !validBus[diskBus] {
-		return fmt.Errorf("unsupported disk bus: %s", diskBus)
+		return invalid(CodeInvalidArgument, "unsupported disk bus: %s", diskBus)
 	}
 	diskDev := map[string]string{"virtio": "vda", "scsi": "sda", "sata": "sda", "ide": "hdc"}[diskBus]
 
 	// Validate disk path exists
 	cleanPath := filepath.Clean(req.DiskPath)
-	if _, err := os.Stat(cleanPath); err != nil {
-		return fmt.Errorf("磁盘文件不存在: %s", cleanPath)
+	if !s.cfg.PathAllowed(cleanPath) {
+		return invalid(CodeInvalidPath, "disk path must be under %s", strings.Join(s.cfg.AllowedRoots, ", "))
+	}
+	if strings.ContainsAny(cleanPath, `<>&'"`) {
+		return invalid(CodeInvalidPath, "disk path contains invalid characters")
+	}
+	if !s.diskExists(cleanPath) {
+		return notFound(CodeFileNotFound, "disk image not found: %s", cleanPath)
 	}
 
 	format := "qcow2"
@@ -495,128 +662,150 @@ func (s *LibvirtService) ImportVM(req model.ImportVMRequest) error {
 		format = "raw"
 	}
 
-	xmlDef := fmt.Sprintf(`<domain type='kvm'>
-  <name>%s</name>
-  <memory unit='MiB'>%d</memory>
-  <vcpu>%d</vcpu>
-  <os><type arch='x86_64'>hvm</type><boot dev='hd'/></os>
-  <features><acpi/><apic/></features>
-  <devices>
-    <disk type='file' device='disk'>
-      <driver name='qemu' type='%s'/>
-      <source file='%s'/>
-      <target dev='%s' bus='%s'/>
-    </disk>
-    <disk type='file' device='cdrom'>
-      <driver name='qemu' type='raw'/>
-      <target dev='hda' bus='ide'/>
-      <readonly/>
-    </disk>
-    <interface type='network'>
-      <source network='default'/>
-      <model type='virtio'/>
-    </interface>
-    <graphics type='vnc' port='-1' autoport='yes' listen='0.0.0.0'/>
-    <video>
-      <model type='qxl' ram='65536' vram='65536' vgamem='32768' heads='1' primary='yes'/>
-    </video>
-    <input type='tablet' bus='usb'/>
-    <console type='pty'/>
-  </devices>
-</domain>`, req.Name, req.Memory, req.CPU, format, cleanPath, diskDev, diskBus)
+	dom := newDomain(req.Name, req.Memory, req.CPU, "hd")
+	iface, _ := domxml.NewInterface("network", s.cfg.DefaultNetwork, "virtio")
+	dom.Devices.Disks = []domxml.Disk{
+		domxml.FileDisk("disk", cleanPath, format, diskDev, diskBus),
+		domxml.FileDisk("cdrom", "", "raw", "hda", "ide"),
+	}
+	dom.Devices.Interfaces = []domxml.Interface{iface}
+	xmlDef, err := dom.Marshal()
+	if err != nil {
+		return err
+	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	_, err := s.l.DomainDefineXML(xmlDef)
+	if err := checkNameFree(l, req.Name); err != nil {
+		return err
+	}
+	_, err = l.DomainDefineXML(xmlDef)
 	return err
 }
 
+// checkNameFree fails with a conflict when a domain called name exists;
+// libvirt only reports that as a generic failure.
+func checkNameFree(l *libvirt.Libvirt, name string) error {
+	if _, err := l.DomainLookupByName(name); err == nil {
+		return conflict(CodeAlreadyExists, "vm %s already exists", name)
+	}
+	return nil
+}
+
 func (s *LibvirtService) UpdateVM(name string, req model.UpdateVMRequest) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("update", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	d, err := lookupNotTemplate(l, name)
 	if err != nil {
 		return err
 	}
-	xmlStr, err := s.l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
 	if err != nil {
 		return err
 	}
 
-	newXML := xmlStr
+	if req.CPU <= 0 && req.Memory <= 0 {
+		return nil
+	}
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return fmt.Errorf("parse domain xml: %w", err)
+	}
 	if req.CPU > 0 {
-		newXML = replaceXMLTag(newXML, "vcpu", fmt.Sprintf("%d", req.CPU))
+		dx.SetVCPUs(req.CPU)
 	}
 	if req.Memory > 0 {
-		memKiB := req.Memory * 1024
-		newXML = replaceXMLMemory(newXML, memKiB)
+		dx.SetMemoryMiB(req.Memory)
 	}
-	if newXML == xmlStr {
-		return nil
+	newXML, err := dx.Marshal()
+	if err != nil {
+		return err
 	}
 
-	_, err = s.l.DomainDefineXML(newXML)
+	_, err = l.DomainDefineXML(newXML)
 	if err != nil {
 		return err
 	}
 
 	// Hot resize if running, otherwise done
-	state, _, _, _, _, _ := s.l.DomainGetInfo(d)
+	state, _, _, _, _, _ := l.DomainGetInfo(d)
 	if libvirt.DomainState(state) != libvirt.DomainRunning {
 		return nil
 	}
 	var hotFailed bool
 	if req.CPU > 0 {
-		if err := s.l.DomainSetVcpusFlags(d, uint32(req.CPU), uint32(libvirt.DomainAffectLive)); err != nil {
+		if err := l.DomainSetVcpusFlags(d, uint32(req.CPU), uint32(libvirt.DomainAffectLive)); err != nil {
 			hotFailed = true
 		}
 	}
 	if req.Memory > 0 {
-		if err := s.l.DomainSetMemoryFlags(d, uint64(req.Memory)*1024, uint32(libvirt.DomainMemLive)); err != nil {
+		if err := l.DomainSetMemoryFlags(d, uint64(req.Memory)*1024, uint32(libvirt.DomainMemLive)); err != nil {
 			hotFailed = true
 		}
 	}
 	if hotFailed {
-		return fmt.Errorf("已保存配置，但热修改失败，关机后重启生效")
+		return conflict(CodeRestartRequired, "the configuration was saved, but could not be applied live; it takes effect after the VM is restarted")
 	}
 	return nil
 }
 
-func replaceXMLTag(xmlStr, tag, value string) string {
-	re := regexp.MustCompile(fmt.Sprintf(`<%s[^>]*>[^<]*</%s>`, tag, tag))
-	return re.ReplaceAllStringFunc(xmlStr, func(match string) string {
-		// Preserve attributes from opening tag
-		openEnd := strings.Index(match, ">")
-		closeStart := strings.LastIndex(match, "</")
-		return match[:openEnd+1] + value + match[closeStart:]
-	})
-}
-
-func replaceXMLMemory(xmlStr string, kiB int) string {
-	xmlStr = replaceXMLTag(xmlStr, "memory", fmt.Sprintf("%d", kiB))
-	re := regexp.MustCompile(`<memory[^>]*>`)
-	xmlStr = re.ReplaceAllString(xmlStr, `<memory unit='KiB'>`)
-	// Handle currentMemory: replace if exists, insert if not
-	if strings.Contains(xmlStr, "<currentMemory") {
-		xmlStr = replaceXMLTag(xmlStr, "currentMemory", fmt.Sprintf("%d", kiB))
-		re2 := regexp.MustCompile(`<currentMemory[^>]*>`)
-		xmlStr = re2.ReplaceAllString(xmlStr, `<currentMemory unit='KiB'>`)
-	} else {
-		xmlStr = strings.Replace(xmlStr, fmt.Sprintf("<memory unit='KiB'>%d</memory>", kiB),
-			fmt.Sprintf("<memory unit='KiB'>%d</memory>\n  <currentMemory unit='KiB'>%d</currentMemory>", kiB, kiB), 1)
+// newDomain returns a KVM domain with the devices every panel VM gets:
+// VNC console, QXL video, USB tablet and a serial console.
+func newDomain(name string, memMiB, cpu int, boot ...string) *domxml.Domain {
+	d := &domxml.Domain{
+		Type:     "kvm",
+		Name:     name,
+		Memory:   &domxml.Memory{Unit: "MiB", Value: uint64(memMiB)},
+		VCPU:     &domxml.VCPU{Value: cpu},
+		OS:       &domxml.OS{Type: domxml.OSType{Arch: "x86_64", Value: "hvm"}},
+		Features: &domxml.Features{ACPI: &domxml.Flag{}, APIC: &domxml.Flag{}},
+		Devices: &domxml.Devices{
+			Graphics: []domxml.Graphics{{Type: "vnc", Port: "-1", AutoPort: "yes", Listen: "0.0.0.0"}},
+			Videos: []domxml.Video{{Model: &domxml.VideoModel{
+				Type: "qxl", RAM: "65536", VRAM: "65536", VGAMem: "32768", Heads: "1", Primary: "yes",
+			}}},
+			Inputs:   []domxml.Input{{Type: "tablet", Bus: "usb"}},
+			Consoles: []domxml.Console{{Type: "pty"}},
+		},
+	}
+	for _, dev := range boot {
+		d.OS.Boot = append(d.OS.Boot, domxml.Boot{Dev: dev})
+	}
+	return d
+}
+
+// newClock returns a clock with the given offset; localtime, which
+// Windows guests expect, comes with the timers that suit them.
+func newClock(offset string) *domxml.Clock {
+	clock := &domxml.Clock{Offset: offset}
+	if offset == "localtime" {
+		clock.Timers = []domxml.Timer{
+			{Name: "rtc", TickPolicy: "catchup"},
+			{Name: "pit", TickPolicy: "delay"},
+			{Name: "hpet", Present: "no"},
+			{Name: "hypervclock", Present: "yes"},
+		}
 	}
-	return xmlStr
+	return clock
 }
 
-func (s *LibvirtService) CreateVM(req model.CreateVMRequest) error {
+func (s *LibvirtService) CreateVM(ctx context.Context, req model.CreateVMRequest, p Progress) error {
+	release, err := s.ops.acquire("create", req.Name)
+	if err != nil {
+		return err
+	}
+	defer release()
 	if !safeNameRe.MatchString(req.Name) {
-		return fmt.Errorf("invalid vm name: %s", req.Name)
+		return invalid(CodeInvalidName, "invalid vm name: %s", req.Name)
 	}
 	if req.CPU <= 0 {
 		req.CPU = 2
@@ -659,193 +848,162 @@ func (s *LibvirtService) CreateVM(req model.CreateVMRequest) error {
 	validBus := map[string]bool{"virtio": true, "sata": true, "scsi": true, "ide": true}
 	validNet := map[string]bool{"virtio": true, "e1000": true, "rtl8139": true}
 	if !validBus[diskBus] {
-		return fmt.Errorf("unsupported disk bus: %s", diskBus)
+		return invalid(CodeInvalidArgument, "unsupported disk bus: %s", diskBus)
 	}
 	if !validNet[netModel] {
-		return fmt.Errorf("unsupported net model: %s", netModel)
+		return invalid(CodeInvalidArgument, "unsupported net model: %s", netModel)
 	}
 
 	// Determine disk target device name by bus type
 	diskDev := map[string]string{"virtio": "vda", "scsi": "sda", "sata": "sda", "ide": "hdc"}[diskBus]
 
-	// SCSI controller XML (only needed for scsi bus)
-	scsiCtrl := ""
+	dom := newDomain(req.Name, req.Memory, req.CPU, "cdrom", "hd")
+
+	// SCSI controller (only needed for scsi bus)
 	if diskBus == "scsi" {
-		scsiCtrl = "\n    <controller type='scsi' model='virtio-scsi'/>"
+		dom.Devices.Controllers = []domxml.Controller{{Type: "scsi", Model: "virtio-scsi"}}
 	}
 
 	// Machine type
-	machineAttr := ""
 	if machine == "q35" {
-		machineAttr = " machine='pc-q35-7.2'"
+		dom.OS.Type.Machine = "pc-q35-7.2"
 	}
 
 	// CPU model
-	cpuXML := ""
-	if cpuModel == "host-passthrough" {
-		cpuXML = "\n  <cpu mode='host-passthrough'/>"
-	} else if cpuModel == "host-model" {
-		cpuXML = "\n  <cpu mode='host-model'/>"
+	if cpuModel == "host-passthrough" || cpuModel == "host-model" {
+		dom.CPU = &domxml.CPU{Mode: cpuModel}
 	}
 
 	// Clock
-	clockXML := fmt.Sprintf("\n  <clock offset='%s'/>", clock)
-	if clock == "localtime" {
-		clockXML = "\n  <clock offset='localtime'>\n    <timer name='rtc' tickpolicy='catchup'/>\n    <timer name='pit' tickpolicy='delay'/>\n    <timer name='hpet' present='no'/>\n    <timer name='hypervclock' present='yes'/>\n  </clock>"
-	}
-
-	// Check if disk already exists
-	diskPath := fmt.Sprintf("/var/lib/libvirt/images/%s.qcow2", req.Name)
-	if _, err := os.Stat(diskPath); err == nil {
-		return fmt.Errorf("磁盘文件已存在: %s，请使用其他名称", diskPath)
-	}
-
-	// Create qcow2 disk image outside the lock
-	cmd := exec.Command("qemu-img", "create", "-f", "qcow2", diskPath, fmt.Sprintf("%dG", req.Disk))
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("create disk failed: %s", string(output))
-	}
+	dom.Clock = newClock(clock)
 
 	// CDROM bus: q35 has no IDE, use sata
 	cdromBus, cdromDev := "ide", "hda"
-	virtioCdromDev := "hdb"
+	virtioCdromDev, seedCdromDev := "hdb", "hdd"
 	if machine == "q35" {
 		cdromBus, cdromDev = "sata", "sdb"
-		virtioCdromDev = "sdc"
+		virtioCdromDev, seedCdromDev = "sdc", "sdd"
 	}
 
 	// Primary CDROM with optional install ISO
 	cdromSource := ""
 	if req.ISO != "" {
-		cleanISO := filepath.Clean(req.ISO)
-		if !strings.HasPrefix(cleanISO, isoDir+"/") {
-			return fmt.Errorf("iso path must be under %s", isoDir)
+		cdromSource = filepath.Clean(req.ISO)
+		if !strings.HasPrefix(cdromSource, s.cfg.ISODir+"/") {
+			return invalid(CodeInvalidPath, "iso path must be under %s", s.cfg.ISODir)
 		}
-		cdromSource = fmt.Sprintf("\n      <source file='%s'/>", cleanISO)
+	}
+	diskPath := filepath.Join(s.cfg.ImageDir, req.Name+".qcow2")
+	dom.Devices.Disks = []domxml.Disk{
+		domxml.FileDisk("disk", diskPath, "qcow2", diskDev, diskBus),
+		domxml.FileDisk("cdrom", cdromSource, "raw", cdromDev, cdromBus),
 	}
 
 	// Optional second CDROM for VirtIO drivers ISO
-	virtioCD := ""
 	if req.VirtioISO != "" {
 		cleanVirtio := filepath.Clean(req.VirtioISO)
-		if !strings.HasPrefix(cleanVirtio, isoDir+"/") {
-			return fmt.Errorf("virtio iso path must be under %s", isoDir)
-		}
-		virtioCD = fmt.Sprintf(`
-    <disk type='file' device='cdrom'>
-      <driver name='qemu' type='raw'/>
-      <source file='%s'/>
-      <target dev='%s' bus='%s'/>
-      <readonly/>
-    </disk>`, cleanVirtio, virtioCdromDev, cdromBus)
-	}
-
-	// Network interface XML based on mode
-	netXML := fmt.Sprintf(`<interface type='network'>
-      <source network='default'/>
-      <model type='%s'/>
-    </interface>`, netModel)
-	switch req.NetMode {
-	case "bridge":
-		bridgeName := req.BridgeName
-		if bridgeName == "" {
-			bridgeName = "br0"
-		}
-		netXML = fmt.Sprintf(`<interface type='bridge'>
-      <source bridge='%s'/>
-      <model type='%s'/>
-    </interface>`, bridgeName, netModel)
-	case "macvtap":
-		dev := req.MacvtapDev
-		if dev == "" {
-			return fmt.Errorf("macvtap 模式需要指定物理网卡")
-		}
-		netXML = fmt.Sprintf(`<interface type='direct'>
-      <source dev='%s' mode='bridge'/>
-      <model type='%s'/>
-    </interface>`, dev, netModel)
-	}
-
-	xmlDef := fmt.Sprintf(`<domain type='kvm'>
-  <name>%s</name>
-  <memory unit='MiB'>%d</memory>
-  <vcpu>%d</vcpu>%s%s
-  <os><type arch='x86_64'%s>hvm</type><boot dev='cdrom'/><boot dev='hd'/></os>
-  <features><acpi/><apic/></features>
-  <devices>%s
-    <disk type='file' device='disk'>
-      <driver name='qemu' type='qcow2'/>
-      <source file='/var/lib/libvirt/images/%s.qcow2'/>
-      <target dev='%s' bus='%s'/>
-    </disk>
-    <disk type='file' device='cdrom'>
-      <driver name='qemu' type='raw'/>%s
-      <target dev='%s' bus='%s'/>
-      <readonly/>
-    </disk>%s
-    %s
-    <graphics type='vnc' port='-1' autoport='yes' listen='0.0.0.0'/>
-    <video>
-      <model type='qxl' ram='65536' vram='65536' vgamem='32768' heads='1' primary='yes'/>
-    </video>
-    <input type='tablet' bus='usb'/>
-    <console type='pty'/>
-  </devices>
-</domain>`, req.Name, req.Memory, req.CPU, cpuXML, clockXML, machineAttr, scsiCtrl, req.Name, diskDev, diskBus, cdromSource, cdromDev, cdromBus, virtioCD, netXML)
+		if !strings.HasPrefix(cleanVirtio, s.cfg.ISODir+"/") {
+			return invalid(CodeInvalidPath, "virtio iso path must be under %s", s.cfg.ISODir)
+		}
+		dom.Devices.Disks = append(dom.Devices.Disks, domxml.FileDisk("cdrom", cleanVirtio, "raw", virtioCdromDev, cdromBus))
+	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
-		os.Remove(diskPath)
+	// Optional cloud-init NoCloud seed
+	var seed []byte
+	seedPath := filepath.Join(s.cfg.ImageDir, req.Name+seedSuffix)
+	if req.CloudInit != nil {
+		if seed, err = cloudinit.Seed(req.Name, req.CloudInit); err != nil {
+			return &Error{Kind: Invalid, Code: CodeInvalidArgument, Err: err}
+		}
+		dom.Devices.Disks = append(dom.Devices.Disks, domxml.FileDisk("cdrom", seedPath, "raw", seedCdromDev, cdromBus))
+	}
+
+	// Network interface based on mode
+	iface, err := s.newNIC(req.NetMode, req.BridgeName, req.MacvtapDev, netModel)
+	if err != nil {
 		return err
 	}
-	_, err := s.l.DomainDefineXML(xmlDef)
+	dom.Devices.Interfaces = []domxml.Interface{iface}
+
+	xmlDef, err := dom.Marshal()
 	if err != nil {
-		os.Remove(diskPath)
+		return err
+	}
+
+	// Create qcow2 disk image
+	if err := s.createDisk(ctx, diskPath, req.Disk, p); err != nil {
+		return err
+	}
+	if seed != nil {
+		if err := s.writeImage(seedPath, seed); err != nil {
+			s.removeDisk(diskPath)
+			return err
+		}
+		fmt.Fprintf(p, "cloud-init seed: %s\n", seedPath)
+	}
+	p.SetProgress(50)
+
+	l, err := s.conn()
+	if err == nil {
+		_, err = l.DomainDefineXML(xmlDef)
+	}
+	if err != nil {
+		s.removeDisk(diskPath)
+		if seed != nil {
+			s.removeDisk(seedPath)
+		}
 	}
 	return err
 }
 
+// newNIC returns a NIC for the net_mode of a create request: the default
+// network for nat or "", a host bridge, or macvtap on a physical device.
+func (s *LibvirtService) newNIC(mode, bridge, macvtapDev, netModel string) (domxml.Interface, error) {
+	netMode, netSource := "network", s.cfg.DefaultNetwork
+	switch mode {
+	case "", "nat":
+	case "bridge":
+		netMode, netSource = "bridge", bridge
+		if netSource == "" {
+			netSource = "br0"
+		}
+	case "macvtap":
+		if macvtapDev == "" {
+			return domxml.Interface{}, invalid(CodeInvalidArgument, "macvtap mode needs a physical device")
+		}
+		netMode, netSource = "macvtap", macvtapDev
+	default:
+		return domxml.Interface{}, invalid(CodeInvalidArgument, "unsupported net mode: %s", mode)
+	}
+	iface, err := domxml.NewInterface(netMode, netSource, netModel)
+	if err != nil {
+		return domxml.Interface{}, &Error{Kind: Invalid, Code: CodeInvalidArgument, Err: err}
+	}
+	return iface, nil
+}
+
 func (s *LibvirtService) GetHostInfo() (*model.HostInfo, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return nil, err
 	}
-	hostname, err := s.l.ConnectGetHostname()
+	hostname, err := l.ConnectGetHostname()
 	if err != nil {
 		return nil, err
 	}
 
-	_, rMemory, rCpus, _, _, _, _, _, err := s.l.NodeGetInfo()
+	rModel, rMemory, rCpus, _, _, _, _, _, err := l.NodeGetInfo()
 	if err != nil {
 		return nil, err
 	}
 
-	// Read real CPU model from /proc/cpuinfo
-	cpuModel := "unknown"
-	if data, err := os.ReadFile("/proc/cpuinfo"); err == nil {
-		for _, line := range strings.Split(string(data), "\n") {
-			if strings.HasPrefix(line, "model name") {
-				if idx := strings.Index(line, ":"); idx >= 0 {
-					cpuModel = strings.TrimSpace(line[idx+1:])
-				}
-				break
-			}
-		}
-	}
-
-	// Read MemAvailable from /proc/meminfo
-	memAvailMiB := 0
-	if data, err := os.ReadFile("/proc/meminfo"); err == nil {
-		for _, line := range strings.Split(string(data), "\n") {
-			if strings.HasPrefix(line, "MemAvailable:") {
-				var kb int
-				fmt.Sscanf(line, "MemAvailable: %d kB", &kb)
-				memAvailMiB = kb / 1024
-				break
-			}
-		}
+	var cpuModel string
+	var memAvailMiB int
+	if s.local {
+		cpuModel, memAvailMiB = readCPUModel(), readMemAvailable()
+	} else {
+		// /proc belongs to the panel machine; ask libvirt instead
+		cpuModel, memAvailMiB = int8String(rModel[:]), nodeMemAvailable(l)
 	}
 
 	// Read cached CPU usage (sampled in background)
@@ -853,7 +1011,7 @@ func (s *LibvirtService) GetHostInfo() (*model.HostInfo, error) {
 	cpuUsage := s.hostCPU
 	s.hostCPUMu.RUnlock()
 
-	vms, err := s.listVMsLocked()
+	vms, err := s.ListVMs()
 	if err != nil {
 		return nil, err
 	}
@@ -865,23 +1023,14 @@ func (s *LibvirtService) GetHostInfo() (*model.HostInfo, error) {
 		}
 	}
 
-	// Uptime
+	// Uptime, load average and disk usage are only known for the local host
 	var uptime int64
-	if data, err := os.ReadFile("/proc/uptime"); err == nil {
-		var up float64
-		fmt.Sscanf(string(data), "%f", &up)
-		uptime = int64(up)
-	}
-
-	// Load average
 	var loadAvg [3]float64
-	if data, err := os.ReadFile("/proc/loadavg"); err == nil {
-		fmt.Sscanf(string(data), "%f %f %f", &loadAvg[0], &loadAvg[1], &loadAvg[2])
+	var disks []model.DiskInfo
+	if s.local {
+		uptime, loadAvg, disks = readUptime(), readLoadAvg(), readDiskUsage()
 	}
 
-	// Disk usage
-	disks := readDiskUsage()
-
 	return &model.HostInfo{
 		Hostname:    hostname,
 		CPUModel:    cpuModel,
@@ -897,6 +1046,119 @@ func (s *LibvirtService) GetHostInfo() (*model.HostInfo, error) {
 	}, nil
 }
 
+// readCPUModel reads the real CPU model from /proc/cpuinfo
+func readCPUModel() string {
+	if data, err := os.ReadFile("/proc/cpuinfo"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "model name") {
+				if idx := strings.Index(line, ":"); idx >= 0 {
+					return strings.TrimSpace(line[idx+1:])
+				}
+				break
+			}
+		}
+	}
+	return "unknown"
+}
+
+// readMemAvailable reads MemAvailable from /proc/meminfo in MiB
+func readMemAvailable() int {
+	if data, err := os.ReadFile("/proc/meminfo"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "MemAvailable:") {
+				var kb int
+				fmt.Sscanf(line, "MemAvailable: %d kB", &kb)
+				return kb / 1024
+			}
+		}
+	}
+	return 0
+}
+
+func readUptime() int64 {
+	var up float64
+	if data, err := os.ReadFile("/proc/uptime"); err == nil {
+		fmt.Sscanf(string(data), "%f", &up)
+	}
+	return int64(up)
+}
+
+func readLoadAvg() [3]float64 {
+	var loadAvg [3]float64
+	if data, err := os.ReadFile("/proc/loadavg"); err == nil {
+		fmt.Sscanf(string(data), "%f %f %f", &loadAvg[0], &loadAvg[1], &loadAvg[2])
+	}
+	return loadAvg
+}
+
+func int8String(b []int8) string {
+	buf := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == 0 {
+			break
+		}
+		buf = append(buf, byte(c))
+	}
+	return string(buf)
+}
+
+// nodeMemAvailable returns free+buffers+cached in MiB as reported by
+// libvirt.
+func nodeMemAvailable(l *libvirt.Libvirt) int {
+	// -1: all NUMA cells. The first call only asks for the parameter count.
+	_, n, err := l.NodeGetMemoryStats(0, -1, 0)
+	if err != nil {
+		return 0
+	}
+	params, _, err := l.NodeGetMemoryStats(n, -1, 0)
+	if err != nil {
+		return 0
+	}
+	var kb uint64
+	for _, p := range params {
+		switch p.Field {
+		case "free", "buffers", "cached":
+			kb += p.Value
+		}
+	}
+	return int(kb / 1024)
+}
+
+// readNodeCPUUsage computes host CPU usage of a remote host from libvirt
+// counters, relative to the previous call.
+func (s *LibvirtService) readNodeCPUUsage() float64 {
+	s.mu.RLock()
+	l := s.l
+	s.mu.RUnlock()
+	if l == nil || !l.IsConnected() {
+		return 0
+	}
+	_, n, err := l.NodeGetCPUStats(-1, 0, 0)
+	if err != nil {
+		return 0
+	}
+	params, _, err := l.NodeGetCPUStats(-1, n, 0)
+	if err != nil {
+		return 0
+	}
+	var idle, total uint64
+	for _, p := range params {
+		switch p.Field {
+		case "idle":
+			idle = p.Value
+			total += p.Value
+		case "kernel", "user", "iowait":
+			total += p.Value
+		}
+	}
+	prevIdle, prevTotal := s.nodeCPU[0], s.nodeCPU[1]
+	s.nodeCPU = [2]uint64{idle, total}
+	if prevTotal == 0 || total <= prevTotal {
+		return 0
+	}
+	return math.Round((1-float64(idle-prevIdle)/float64(total-prevTotal))*1000) / 10
+}
+
 func readCPUUsage() float64 {
 	read := func() (idle, total uint64) {
 		data, err := os.ReadFile("/proc/stat")
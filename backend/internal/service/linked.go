@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"virtpanel/internal/domxml"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// A linked clone gets a qcow2 overlay per disk with the template's image
+// as backing file, so it is created in seconds and only stores what it
+// changes. The template must never change afterwards, which is why only
+// templates (read-only) can be the base, and a base cannot be deleted or
+// turned back into a VM while clones are linked to it.
+
+// linkedChildren returns the linked clones of base, sorted by name.
+func linkedChildren(l *libvirt.Libvirt, base string) ([]string, error) {
+	domains, _, err := l.ConnectListAllDomains(-1, 0)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, d := range domains {
+		xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+		if err != nil {
+			continue
+		}
+		if dx, err := domxml.Parse(xmlStr); err == nil && dx.LinkedBase() == base {
+			names = append(names, d.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// checkNoLinkedClones refuses to change name while it backs linked clones.
+func checkNoLinkedClones(l *libvirt.Libvirt, name string) error {
+	children, err := linkedChildren(l, name)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return conflict(CodeHasLinkedClones, "%s is the base of linked clones %s; flatten or delete them first", name, strings.Join(children, ", "))
+	}
+	return nil
+}
+
+// linkedClone defines newName from the inactive XML of a shut-off
+// template, with overlays in place of its writable disks and new UUID and
+// MAC addresses. Read-only disks and cdroms but the cloud-init seed are
+// shared, as with full clones. The caller holds the op locks of both names.
+func (s *LibvirtService) linkedClone(ctx context.Context, l *libvirt.Libvirt, xmlStr, newName string, p Progress) error {
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return fmt.Errorf("parse domain xml: %w", err)
+	}
+	if !dx.IsTemplate() {
+		return precondition(CodeNotTemplate, "linked clones need a template as source, %s is not one", dx.Name)
+	}
+	base := dx.Name
+
+	// Check every disk before creating any overlay
+	var disks []*domxml.Disk
+	if dx.Devices != nil {
+		for i := range dx.Devices.Disks {
+			disk := &dx.Devices.Disks[i]
+			if disk.Device != "disk" || disk.ReadOnly != nil {
+				continue
+			}
+			if disk.Type != "file" || disk.SourceFile() == "" {
+				target := ""
+				if disk.Target != nil {
+					target = disk.Target.Dev
+				}
+				return precondition(CodeUnsupported, "linked clones need file-backed disks, %s is not", target)
+			}
+			disks = append(disks, disk)
+		}
+	}
+
+	var overlays []string
+	rollback := func() {
+		for _, path := range overlays {
+			s.removeDisk(path)
+		}
+	}
+	for i, disk := range disks {
+		backing, format := disk.SourceFile(), "raw"
+		if disk.Driver != nil && disk.Driver.Type != "" {
+			format = disk.Driver.Type
+		}
+		suffix := ""
+		if i > 0 {
+			suffix = fmt.Sprintf("-%d", i)
+		}
+		path := filepath.Join(filepath.Dir(backing), newName+suffix+".qcow2")
+		if err := s.createOverlay(ctx, path, backing, format); err != nil {
+			rollback()
+			return err
+		}
+		overlays = append(overlays, path)
+		fmt.Fprintf(p, "overlay %s on %s\n", path, backing)
+		p.SetProgress(float64(i+1) * 80 / float64(len(disks)))
+
+		disk.Source = &domxml.DiskSource{File: path}
+		if disk.Driver == nil {
+			disk.Driver = &domxml.DiskDriver{Name: "qemu"}
+		}
+		disk.Driver.Type = "qcow2"
+		extra := disk.Extra[:0]
+		for _, n := range disk.Extra {
+			if n.XMLName.Local != "backingStore" {
+				extra = append(extra, n)
+			}
+		}
+		disk.Extra = extra
+	}
+
+	copyDefinition(dx, newName)
+	newXML, err := dx.Marshal()
+	if err != nil {
+		rollback()
+		return err
+	}
+	d, err := l.DomainDefineXML(newXML)
+	if err != nil {
+		rollback()
+		return err
+	}
+	err = setTemplateMark(l, d, libvirt.OptString{})
+	if err == nil {
+		err = l.DomainSetMetadata(d, int32(libvirt.DomainMetadataElement), libvirt.OptString{domxml.LinkedElement(base)},
+			libvirt.OptString{domxml.ClonePrefix}, libvirt.OptString{domxml.CloneNS}, libvirt.DomainAffectConfig)
+	}
+	if err != nil {
+		s.deleteVM(l, newName)
+		return err
+	}
+	return nil
+}
+
+// FlattenVM copies the template data into the disks of a shut-off linked
+// clone so it no longer depends on the template.
+func (s *LibvirtService) FlattenVM(ctx context.Context, name string, p Progress) error {
+	release, err := s.ops.acquire("flatten", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	d, err := lookupNotTemplate(l, name)
+	if err != nil {
+		return err
+	}
+	state, _, _, _, _, err := l.DomainGetInfo(d)
+	if err != nil {
+		return err
+	}
+	if libvirt.DomainState(state) != libvirt.DomainShutoff {
+		return errNotShutoff
+	}
+	xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	if err != nil {
+		return err
+	}
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return fmt.Errorf("parse domain xml: %w", err)
+	}
+	if dx.LinkedBase() == "" {
+		return precondition(CodeNotLinkedClone, "vm is not a linked clone: %s", name)
+	}
+	// Internal snapshots would lose the clusters they read from the base
+	if n, err := l.DomainSnapshotNum(d, 0); err == nil && n > 0 {
+		return conflict(CodeInvalidState, "delete the snapshots of %s before flattening it", name)
+	}
+
+	var disks []*domxml.Disk
+	if dx.Devices != nil {
+		for i := range dx.Devices.Disks {
+			disk := &dx.Devices.Disks[i]
+			if disk.Device != "disk" || disk.ReadOnly != nil || disk.SourceFile() == "" {
+				continue
+			}
+			backing, err := s.diskBacking(ctx, disk.SourceFile())
+			if err != nil {
+				return err
+			}
+			if backing != "" {
+				disks = append(disks, disk)
+			}
+		}
+	}
+	replaced := map[string]string{} // overlay -> standalone copy
+	rollback := func() {
+		for _, path := range replaced {
+			s.removeDisk(path)
+		}
+	}
+	for i, disk := range disks {
+		path := disk.SourceFile()
+		fmt.Fprintf(p, "flattening %s\n", path)
+		flat, err := s.flattenDisk(ctx, path)
+		if err != nil {
+			rollback()
+			return err
+		}
+		if flat != path {
+			replaced[path] = flat
+			disk.Source.File = flat
+		}
+		p.SetProgress(float64(i+1) * 90 / float64(len(disks)))
+	}
+	if len(replaced) > 0 {
+		newXML, err := dx.Marshal()
+		if err == nil {
+			_, err = l.DomainDefineXML(newXML)
+		}
+		if err != nil {
+			rollback()
+			return err
+		}
+		for path := range replaced {
+			s.removeDisk(path)
+		}
+	}
+	return l.DomainSetMetadata(d, int32(libvirt.DomainMetadataElement), libvirt.OptString{},
+		libvirt.OptString{domxml.ClonePrefix}, libvirt.OptString{domxml.CloneNS}, libvirt.DomainAffectConfig)
+}
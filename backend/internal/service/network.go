@@ -31,22 +31,21 @@ type networkXML struct {
 }
 
 func (s *LibvirtService) ListNetworks() ([]model.Network, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return nil, err
 	}
-	nets, _, err := s.l.ConnectListAllNetworks(-1, 0)
+	nets, _, err := l.ConnectListAllNetworks(-1, 0)
 	if err != nil {
 		return nil, err
 	}
 	result := make([]model.Network, 0, len(nets))
 	for _, n := range nets {
-		active, err := s.l.NetworkIsActive(n)
+		active, err := l.NetworkIsActive(n)
 		if err != nil {
 			continue
 		}
-		xmlStr, err := s.l.NetworkGetXMLDesc(n, 0)
+		xmlStr, err := l.NetworkGetXMLDesc(n, 0)
 		if err != nil {
 			continue
 		}
@@ -84,59 +83,55 @@ func (s *LibvirtService) ListNetworks() ([]model.Network, error) {
 }
 
 func (s *LibvirtService) StartNetwork(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	n, err := s.l.NetworkLookupByName(name)
+	n, err := l.NetworkLookupByName(name)
 	if err != nil {
 		return err
 	}
-	return s.l.NetworkCreate(n)
+	return l.NetworkCreate(n)
 }
 
 func (s *LibvirtService) StopNetwork(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	n, err := s.l.NetworkLookupByName(name)
+	n, err := l.NetworkLookupByName(name)
 	if err != nil {
 		return err
 	}
-	return s.l.NetworkDestroy(n)
+	return l.NetworkDestroy(n)
 }
 
 func (s *LibvirtService) DeleteNetwork(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	n, err := s.l.NetworkLookupByName(name)
+	n, err := l.NetworkLookupByName(name)
 	if err != nil {
 		return err
 	}
-	_ = s.l.NetworkDestroy(n)
-	return s.l.NetworkUndefine(n)
+	_ = l.NetworkDestroy(n)
+	return l.NetworkUndefine(n)
 }
 
 func (s *LibvirtService) CreateNetwork(req model.CreateNetworkRequest) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
 	if !safeNameRe.MatchString(req.Name) {
-		return fmt.Errorf("invalid network name: %s", req.Name)
+		return invalid(CodeInvalidName, "invalid network name: %s", req.Name)
 	}
 	if req.Bridge == "" {
 		req.Bridge = "virbr-" + req.Name
 	}
 	if !safeNameRe.MatchString(req.Bridge) {
-		return fmt.Errorf("invalid bridge name: %s", req.Bridge)
+		return invalid(CodeInvalidName, "invalid bridge name: %s", req.Bridge)
 	}
 	if req.Subnet == "" {
 		req.Subnet = "192.168.100.1"
@@ -153,7 +148,7 @@ func (s *LibvirtService) CreateNetwork(req model.CreateNetworkRequest) error {
 	// Validate IP addresses
 	for _, ip := range []string{req.Subnet, req.Netmask, req.DHCPStart, req.DHCPEnd} {
 		if net.ParseIP(ip) == nil {
-			return fmt.Errorf("invalid IP address: %s", ip)
+			return invalid(CodeInvalidArgument, "invalid IP address: %s", ip)
 		}
 	}
 
@@ -168,16 +163,16 @@ func (s *LibvirtService) CreateNetwork(req model.CreateNetworkRequest) error {
   </ip>
 </network>`, req.Name, req.Bridge, req.Subnet, req.Netmask, req.DHCPStart, req.DHCPEnd)
 
-	_, err := s.l.NetworkDefineXML(xmlDef)
+	_, err = l.NetworkDefineXML(xmlDef)
 	if err != nil {
 		return err
 	}
 	// Auto-start the newly created network
-	n, err := s.l.NetworkLookupByName(req.Name)
+	n, err := l.NetworkLookupByName(req.Name)
 	if err != nil {
 		return nil // defined but couldn't look up — non-fatal
 	}
-	_ = s.l.NetworkCreate(n)
+	_ = l.NetworkCreate(n)
 	return nil
 }
 
@@ -188,9 +183,9 @@ type DHCPLease struct {
 }
 
 func (s *LibvirtService) ListDHCPLeases(networkName string) ([]DHCPLease, error) {
-	out, err := execCmd("virsh", "net-dhcp-leases", networkName)
+	out, err := execCmd("virsh", "-c", s.uri, "net-dhcp-leases", networkName)
 	if err != nil {
-		return nil, fmt.Errorf("获取 DHCP 租约失败: %v", err)
+		return nil, fmt.Errorf("get DHCP leases: %w", err)
 	}
 	var leases []DHCPLease
 	for _, line := range splitLines(out) {
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ErrBusy is returned when a domain already has a conflicting operation
+// in progress.
+var ErrBusy = &Error{Kind: Conflict, Code: CodeBusy, Msg: "operation in progress"}
+
+// opLocks tracks the domains that have a mutating operation running, so a
+// second operation on the same domain fails fast with ErrBusy instead of
+// queueing behind a slow shutdown, snapshot or clone. Operations on other
+// domains, and reads, are not affected. The zero value is ready to use.
+type opLocks struct {
+	mu  sync.Mutex
+	ops map[string]string // domain name -> running operation
+}
+
+// acquire marks every named domain as busy with op, or none of them if
+// one already is. The returned func releases them again.
+func (o *opLocks) acquire(op string, names ...string) (func(), error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, name := range names {
+		if cur, ok := o.ops[name]; ok {
+			return nil, fmt.Errorf("%w: %s on %s", ErrBusy, cur, name)
+		}
+	}
+	if o.ops == nil {
+		o.ops = make(map[string]string)
+	}
+	for _, name := range names {
+		o.ops[name] = op
+	}
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		for _, name := range names {
+			delete(o.ops, name)
+		}
+	}, nil
+}
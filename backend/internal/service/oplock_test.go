@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"virtpanel/internal/config"
+	"virtpanel/internal/model"
+)
+
+// nopProgress discards the progress of operations run outside a task.
+type nopProgress struct{ io.Writer }
+
+func (nopProgress) SetProgress(float64) {}
+
+func newTestSim(t testing.TB, vms ...string) *SimService {
+	t.Helper()
+	s := NewSimService(config.Default())
+	for _, name := range vms {
+		if err := s.CreateVM(context.Background(), model.CreateVMRequest{Name: name}, nopProgress{io.Discard}); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+	return s
+}
+
+// startOp runs fn in the background and returns once it holds the op
+// lock of name. The returned func waits for it to finish.
+func startOp(t testing.TB, ops *opLocks, name string, fn func() error) func() error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	deadline := time.Now().Add(time.Second)
+	for {
+		ops.mu.Lock()
+		_, held := ops.ops[name]
+		ops.mu.Unlock()
+		if held {
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("operation on %s ended before it was seen: %v", name, err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("operation on %s did not take the op lock", name)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return func() error { return <-done }
+}
+
+func startClone(t testing.TB, s *SimService, ctx context.Context, src, dst string) func() error {
+	return startOp(t, &s.ops, src, func() error {
+		return s.CloneVM(ctx, src, model.CloneVMRequest{NewName: dst}, nopProgress{io.Discard})
+	})
+}
+
+func startSnapshot(t testing.TB, s Hypervisor, ops *opLocks, vm, snap string) func() error {
+	return startOp(t, ops, vm, func() error {
+		return s.CreateSnapshot(vm, model.CreateSnapshotRequest{Name: snap})
+	})
+}
+
+func TestOpLockBusy(t *testing.T) {
+	s := newTestSim(t, "web1", "web2")
+	wait := startClone(t, s, context.Background(), "web1", "web1-copy")
+
+	err := s.CreateSnapshot("web1", model.CreateSnapshotRequest{Name: "snap1"})
+	if !errors.Is(err, ErrBusy) {
+		t.Fatalf("snapshot during clone: got %v, want ErrBusy", err)
+	}
+	if kind, code := Classify(err); kind != Conflict || code != CodeBusy {
+		t.Errorf("classify: got %v %s, want Conflict %s", kind, code, CodeBusy)
+	}
+	if err := s.RenameVM("web1-copy", "other"); !errors.Is(err, ErrBusy) {
+		t.Errorf("rename of the clone target: got %v, want ErrBusy", err)
+	}
+	// Other domains are not affected
+	if err := s.CreateSnapshot("web2", model.CreateSnapshotRequest{Name: "snap1"}); err != nil {
+		t.Errorf("snapshot of another vm: %v", err)
+	}
+
+	if err := wait(); err != nil {
+		t.Fatalf("clone: %v", err)
+	}
+	if err := s.CreateSnapshot("web1", model.CreateSnapshotRequest{Name: "snap1"}); err != nil {
+		t.Errorf("snapshot after clone: %v", err)
+	}
+
+	// A running domain's snapshot takes a while and blocks its changes only
+	if err := s.StartVM("web2"); err != nil {
+		t.Fatal(err)
+	}
+	wait = startSnapshot(t, s, &s.ops, "web2", "snap2")
+	if err := s.ShutdownVM("web2"); !errors.Is(err, ErrBusy) {
+		t.Errorf("shutdown during snapshot: got %v, want ErrBusy", err)
+	}
+	if err := s.StartVM("web1"); err != nil {
+		t.Errorf("start of another vm during snapshot: %v", err)
+	}
+	if err := wait(); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+}
+
+func benchVMs(b *testing.B) (*SimService, []string) {
+	names := make([]string, 50)
+	for i := range names {
+		names[i] = fmt.Sprintf("vm%02d", i)
+	}
+	return newTestSim(b, names...), names
+}
+
+// benchListVMs lists VMs b.N times while an operation holds the op lock
+// of one of them: listing does not wait for the operation.
+func benchListVMs(b *testing.B, s Hypervisor, want int) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vms, err := s.ListVMs()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(vms) < want {
+			b.Fatalf("got %d vms, want at least %d", len(vms), want)
+		}
+	}
+	b.StopTimer()
+	// A list taking as long as the operation would have waited for it
+	if per := b.Elapsed() / time.Duration(b.N); per > 100*time.Millisecond {
+		b.Errorf("ListVMs took %v while the operation ran", per)
+	}
+}
+
+func BenchmarkListVMsDuringClone(b *testing.B) {
+	s, names := benchVMs(b)
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := startClone(b, s, ctx, names[0], "copy")
+	defer func() {
+		cancel()
+		wait()
+	}()
+	benchListVMs(b, s, len(names))
+}
+
+func BenchmarkListVMsDuringSnapshot(b *testing.B) {
+	s, names := benchVMs(b)
+	if err := s.StartVM(names[0]); err != nil {
+		b.Fatal(err)
+	}
+	wait := startSnapshot(b, s, &s.ops, names[0], "bench")
+	defer wait()
+	benchListVMs(b, s, len(names))
+}
+
+// BenchmarkLibvirtListVMsDuringSnapshot is BenchmarkListVMsDuringSnapshot
+// against a real libvirt: VIRTPANEL_TEST_URI names the daemon and
+// VIRTPANEL_TEST_VM a running domain to snapshot. The snapshot is
+// deleted again afterwards.
+func BenchmarkLibvirtListVMsDuringSnapshot(b *testing.B) {
+	uri, vm := os.Getenv("VIRTPANEL_TEST_URI"), os.Getenv("VIRTPANEL_TEST_VM")
+	if uri == "" || vm == "" {
+		b.Skip("VIRTPANEL_TEST_URI and VIRTPANEL_TEST_VM are not set")
+	}
+	s, err := NewLibvirtService(config.Default(), uri)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	snap := fmt.Sprintf("bench-%d", time.Now().Unix())
+	wait := startSnapshot(b, s, &s.ops, vm, snap)
+	defer func() {
+		if err := wait(); err == nil {
+			s.DeleteSnapshot(vm, snap)
+		}
+	}()
+	benchListVMs(b, s, 1)
+}
@@ -0,0 +1,494 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"virtpanel/internal/domxml"
+	"virtpanel/internal/model"
+	"virtpanel/internal/ovf"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// OVAs carry their disks as streamOptimized VMDK images. Both directions
+// convert with qemu-img on the panel machine, so they are local only.
+
+// OVAExport is a VM converted for export, ready to be written as an OVA.
+// Close removes the converted disks.
+type OVAExport struct {
+	Name       string
+	descriptor []byte
+	files      []ovf.File
+	dir        string
+}
+
+// Stream writes the OVA to w.
+func (e *OVAExport) Stream(w io.Writer) error {
+	return ovf.WriteOVA(w, e.Name, e.descriptor, e.files)
+}
+
+func (e *OVAExport) Close() error {
+	if e.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(e.dir)
+}
+
+// ovaDevice is where a disk or cdrom of an imported OVA is attached.
+type ovaDevice struct {
+	bus, dev string
+}
+
+// ovaLayout is how the virtual system of an OVA maps onto a domain.
+type ovaLayout struct {
+	cpu, memory int
+	q35         bool
+	disks       []ovaDevice
+	cdroms      []ovaDevice
+	nics        []string // model of each NIC
+}
+
+// checkImportOVA validates an import request and fills in its defaults.
+func checkImportOVA(req *model.ImportOVARequest) error {
+	if !safeNameRe.MatchString(req.Name) {
+		return invalid(CodeInvalidName, "invalid vm name: %s", req.Name)
+	}
+	if req.Format == "" {
+		req.Format = "qcow2"
+	}
+	if err := (cloneOptions{pool: req.Pool, format: req.Format}).validate(); err != nil {
+		return err
+	}
+	validBus := map[string]bool{"": true, "virtio": true, "sata": true, "scsi": true, "ide": true}
+	validNet := map[string]bool{"": true, "virtio": true, "e1000": true, "rtl8139": true}
+	if !validBus[req.DiskBus] {
+		return invalid(CodeInvalidArgument, "unsupported disk bus: %s", req.DiskBus)
+	}
+	if !validNet[req.NetModel] {
+		return invalid(CodeInvalidArgument, "unsupported net model: %s", req.NetModel)
+	}
+	return nil
+}
+
+// planOVA maps the hardware of sys onto a domain. Disks on IDE stay
+// there and other controllers become SATA, which every guest has a
+// driver for; NICs other than virtio become e1000. Windows guests get
+// a q35 machine, which has no IDE bus.
+func planOVA(req model.ImportOVARequest, sys *ovf.System) (*ovaLayout, error) {
+	lay := &ovaLayout{cpu: sys.CPUs, memory: sys.MemoryMiB, q35: sys.Windows}
+	if lay.cpu <= 0 {
+		lay.cpu = 2
+	}
+	if lay.memory <= 0 {
+		lay.memory = 2048
+	}
+	if lay.cpu > 4096 {
+		return nil, invalid(CodeInvalidArgument, "too many vcpus: %d", lay.cpu)
+	}
+	if len(sys.Disks) == 0 {
+		return nil, invalid(CodeInvalidArgument, "the ova has no disks")
+	}
+
+	used := map[string]int{} // target prefix -> devices so far
+	attach := func(bus string) (ovaDevice, error) {
+		if bus == "ide" && lay.q35 {
+			bus = "sata"
+		}
+		prefix := map[string]string{"ide": "hd", "sata": "sd", "scsi": "sd", "virtio": "vd"}[bus]
+		if used[prefix] >= 26 {
+			return ovaDevice{}, invalid(CodeInvalidArgument, "too many %s devices", bus)
+		}
+		dev := ovaDevice{bus: bus, dev: prefix + string(rune('a'+used[prefix]))}
+		used[prefix]++
+		return dev, nil
+	}
+	for _, d := range sys.Disks {
+		bus := req.DiskBus
+		if bus == "" {
+			bus = "sata"
+			if d.Bus == "ide" {
+				bus = "ide"
+			}
+		}
+		dev, err := attach(bus)
+		if err != nil {
+			return nil, err
+		}
+		lay.disks = append(lay.disks, dev)
+	}
+	for range sys.Cdroms {
+		dev, err := attach("ide")
+		if err != nil {
+			return nil, err
+		}
+		lay.cdroms = append(lay.cdroms, dev)
+	}
+	for _, nic := range sys.NICs {
+		m := req.NetModel
+		if m == "" {
+			m = "e1000"
+			if nic.Model == "virtio" {
+				m = "virtio"
+			}
+		}
+		lay.nics = append(lay.nics, m)
+	}
+	return lay, nil
+}
+
+// diskFiles returns the image name of each disk of an import, refusing
+// packages whose disks share a file.
+func diskFiles(req model.ImportOVARequest, sys *ovf.System) ([]string, error) {
+	seen := map[string]bool{}
+	names := make([]string, len(sys.Disks))
+	for i, d := range sys.Disks {
+		if seen[d.File] {
+			return nil, invalid(CodeInvalidArgument, "%s backs more than one disk", d.File)
+		}
+		seen[d.File] = true
+		suffix := ""
+		if i > 0 {
+			suffix = fmt.Sprintf("-%d", i)
+		}
+		names[i] = req.Name + suffix + "." + req.Format
+	}
+	return names, nil
+}
+
+// ovaError reports a malformed package as invalid input.
+func ovaError(err error) error {
+	if errors.Is(err, ovf.ErrInvalid) {
+		return &Error{Kind: Invalid, Code: CodeInvalidArgument, Err: err}
+	}
+	return err
+}
+
+func (s *LibvirtService) ExportOVA(ctx context.Context, name string) (*OVAExport, error) {
+	if !s.local {
+		return nil, ErrLocalOnly
+	}
+	release, err := s.ops.acquire("export", name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	d, err := l.DomainLookupByName(name)
+	if err != nil {
+		return nil, err
+	}
+	state, _, _, _, _, err := l.DomainGetInfo(d)
+	if err != nil {
+		return nil, err
+	}
+	if libvirt.DomainState(state) != libvirt.DomainShutoff {
+		return nil, errNotShutoff
+	}
+	xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	if err != nil {
+		return nil, err
+	}
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse domain xml: %w", err)
+	}
+
+	sys := &ovf.System{
+		Name:      name,
+		CPUs:      dx.VCPUs(),
+		MemoryMiB: dx.MemoryMiB(),
+		Windows:   dx.Clock != nil && dx.Clock.Offset == "localtime",
+	}
+	if dx.OS != nil {
+		for _, a := range dx.OS.Attrs {
+			if a.Name.Local == "firmware" && a.Value == "efi" {
+				sys.EFI = true
+			}
+		}
+		if dx.OS.Loader != nil && dx.OS.Loader.Type == "pflash" {
+			sys.EFI = true
+		}
+	}
+	var disks []*domxml.Disk
+	if dx.Devices != nil {
+		for i := range dx.Devices.Disks {
+			disk := &dx.Devices.Disks[i]
+			bus, dev := "", ""
+			if disk.Target != nil {
+				bus, dev = disk.Target.Bus, disk.Target.Dev
+			}
+			switch {
+			case disk.Device == "cdrom":
+				sys.Cdroms = append(sys.Cdroms, bus)
+			case disk.Device == "disk" && disk.ReadOnly == nil:
+				if diskPath(disk) == "" {
+					return nil, precondition(CodeUnsupported, "cannot export disk %s: only file and block disks are supported", dev)
+				}
+				disks = append(disks, disk)
+			}
+		}
+		for i := range dx.Devices.Interfaces {
+			iface := &dx.Devices.Interfaces[i]
+			sys.NICs = append(sys.NICs, ovf.NIC{Model: iface.ModelType(), Network: iface.SourceName()})
+		}
+	}
+
+	dir, err := os.MkdirTemp(s.cfg.ImageDir, "."+name+"-export-")
+	if err != nil {
+		return nil, err
+	}
+	exp := &OVAExport{Name: name, dir: dir}
+	for i, disk := range disks {
+		src, format, bus := diskPath(disk), "raw", ""
+		if disk.Target != nil {
+			bus = disk.Target.Bus
+		}
+		if disk.Driver != nil && disk.Driver.Type != "" {
+			format = disk.Driver.Type
+		}
+		capacity, err := s.diskCapacity(ctx, src)
+		if err != nil {
+			exp.Close()
+			return nil, err
+		}
+		file := fmt.Sprintf("%s-disk%d.vmdk", name, i+1)
+		dst := filepath.Join(dir, file)
+		out, err := exec.CommandContext(ctx, "qemu-img", "convert", "-f", format, "-O", "vmdk", "-o", "subformat=streamOptimized", src, dst).CombinedOutput()
+		if err != nil {
+			exp.Close()
+			return nil, fmt.Errorf("convert %s failed: %s", src, bytes.TrimSpace(out))
+		}
+		size, sum, err := fileSHA256(dst)
+		if err != nil {
+			exp.Close()
+			return nil, err
+		}
+		exp.files = append(exp.files, ovf.File{Name: file, Size: size, SHA256: sum, Open: func() (io.ReadCloser, error) {
+			return os.Open(dst)
+		}})
+		sys.Disks = append(sys.Disks, ovf.Disk{File: file, Size: size, Capacity: capacity, Bus: bus})
+	}
+	if exp.descriptor, err = ovf.Build(sys); err != nil {
+		exp.Close()
+		return nil, err
+	}
+	return exp, nil
+}
+
+// fileSHA256 returns the size and SHA-256 of the file at path.
+func fileSHA256(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *LibvirtService) ImportOVA(ctx context.Context, req model.ImportOVARequest, r io.Reader, size int64, p Progress) error {
+	if !s.local {
+		return ErrLocalOnly
+	}
+	if err := checkImportOVA(&req); err != nil {
+		return err
+	}
+	release, err := s.ops.acquire("import", req.Name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	if err := checkNameFree(l, req.Name); err != nil {
+		return err
+	}
+	dir := s.cfg.ImageDir
+	if req.Pool != "" {
+		if dir, err = poolDir(l, req.Pool); err != nil {
+			return err
+		}
+	}
+
+	or, err := ovf.NewReader(&progressReader{ctx: ctx, r: r, p: p, total: size})
+	if err != nil {
+		return ovaError(err)
+	}
+	sys := or.System
+	lay, err := planOVA(req, sys)
+	if err != nil {
+		return err
+	}
+	names, err := diskFiles(req, sys)
+	if err != nil {
+		return err
+	}
+	dest := map[string]string{} // file in the package -> image path
+	for i, d := range sys.Disks {
+		path := filepath.Join(dir, names[i])
+		if s.diskExists(path) {
+			return conflict(CodeAlreadyExists, "disk image %s already exists, choose another name", path)
+		}
+		dest[d.File] = path
+	}
+
+	var created []string
+	rollback := func() {
+		for _, path := range created {
+			os.Remove(path)
+		}
+	}
+	for {
+		file, fr, err := or.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rollback()
+			return ovaError(err)
+		}
+		fmt.Fprintf(p, "converting %s to %s\n", file, dest[file])
+		if err := convertVMDK(ctx, file, fr, dest[file], req.Format); err != nil {
+			rollback()
+			return ovaError(err)
+		}
+		created = append(created, dest[file])
+	}
+
+	dom := newDomain(req.Name, lay.memory, lay.cpu, "hd")
+	if lay.q35 {
+		dom.OS.Type.Machine = "pc-q35-7.2"
+	}
+	if sys.Windows {
+		dom.Clock = newClock("localtime")
+	} else {
+		dom.Clock = newClock("utc")
+	}
+	if sys.EFI {
+		dom.OS.Attrs = append(dom.OS.Attrs, xml.Attr{Name: xml.Name{Local: "firmware"}, Value: "efi"})
+	}
+	for i, d := range sys.Disks {
+		dev := lay.disks[i]
+		if dev.bus == "scsi" && len(dom.Devices.Controllers) == 0 {
+			dom.Devices.Controllers = []domxml.Controller{{Type: "scsi", Model: "virtio-scsi"}}
+		}
+		dom.Devices.Disks = append(dom.Devices.Disks, domxml.FileDisk("disk", dest[d.File], req.Format, dev.dev, dev.bus))
+	}
+	for _, dev := range lay.cdroms {
+		dom.Devices.Disks = append(dom.Devices.Disks, domxml.FileDisk("cdrom", "", "raw", dev.dev, dev.bus))
+	}
+	for _, m := range lay.nics {
+		iface, err := domxml.NewInterface("network", s.cfg.DefaultNetwork, m)
+		if err != nil {
+			rollback()
+			return err
+		}
+		dom.Devices.Interfaces = append(dom.Devices.Interfaces, iface)
+	}
+	xmlDef, err := dom.Marshal()
+	if err == nil {
+		_, err = l.DomainDefineXML(xmlDef)
+	}
+	if err != nil {
+		rollback()
+		return err
+	}
+	p.SetProgress(100)
+	return nil
+}
+
+// poolDir returns the target directory of a directory storage pool.
+func poolDir(l *libvirt.Libvirt, name string) (string, error) {
+	pool, err := l.StoragePoolLookupByName(name)
+	if err != nil {
+		return "", notFound(CodePoolNotFound, "storage pool not found: %s", name)
+	}
+	xmlStr, err := l.StoragePoolGetXMLDesc(pool, 0)
+	if err != nil {
+		return "", err
+	}
+	var px poolXML
+	if err := xml.Unmarshal([]byte(xmlStr), &px); err != nil {
+		return "", err
+	}
+	if px.Type != "dir" && px.Type != "fs" && px.Type != "netfs" || px.Target.Path == "" {
+		return "", precondition(CodeUnsupported, "pool %s is not a directory pool", name)
+	}
+	return filepath.Clean(px.Target.Path), nil
+}
+
+// convertVMDK converts the VMDK image read from r into path. The image
+// comes from an upload, so only a self-contained sparse VMDK is
+// accepted: a text descriptor, or a sparse one with an embedded
+// descriptor or a parent, could make qemu-img read other host files.
+func convertVMDK(ctx context.Context, name string, r io.Reader, path, format string) error {
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: %s is not a sparse VMDK image", ovf.ErrInvalid, name)
+		}
+		return err
+	}
+	// magic, version, flags, then the capacity, which is 0 when the
+	// extents are listed in an embedded descriptor
+	if string(header[:4]) != "KDMV" || binary.LittleEndian.Uint64(header[12:]) == 0 {
+		return fmt.Errorf("%w: %s is not a sparse VMDK image", ovf.ErrInvalid, name)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.vmdk")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(header)
+	if err == nil {
+		_, err = io.Copy(tmp, r)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	out, err := exec.CommandContext(ctx, "qemu-img", "info", "--output=json", "-f", "vmdk", tmp.Name()).Output()
+	if err != nil {
+		return fmt.Errorf("%w: %s is not a valid VMDK image", ovf.ErrInvalid, name)
+	}
+	var info struct {
+		Backing string `json:"backing-filename"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return fmt.Errorf("read %s: %w", name, err)
+	}
+	if info.Backing != "" {
+		return fmt.Errorf("%w: %s has a parent image", ovf.ErrInvalid, name)
+	}
+	out, err = exec.CommandContext(ctx, "qemu-img", "convert", "-f", "vmdk", "-O", format, tmp.Name(), path).CombinedOutput()
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("convert %s failed: %s", name, bytes.TrimSpace(out))
+	}
+	return nil
+}
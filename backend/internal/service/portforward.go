@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
 )
@@ -20,12 +21,14 @@ type PortForward struct {
 	Comment     string `json:"comment"`
 }
 
-const pfFile = "/etc/virtpanel/portforwards.json"
-
 var pfMu sync.Mutex
 
-func loadPF() ([]PortForward, error) {
-	data, err := os.ReadFile(pfFile)
+func (s *LibvirtService) pfFile() string {
+	return filepath.Join(s.cfg.DataDir, "portforwards.json")
+}
+
+func (s *LibvirtService) loadPF() ([]PortForward, error) {
+	data, err := os.ReadFile(s.pfFile())
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
@@ -36,10 +39,10 @@ func loadPF() ([]PortForward, error) {
 	return rules, json.Unmarshal(data, &rules)
 }
 
-func savePF(rules []PortForward) error {
-	os.MkdirAll("/etc/virtpanel", 0755)
+func (s *LibvirtService) savePF(rules []PortForward) error {
+	os.MkdirAll(s.cfg.DataDir, 0755)
 	data, _ := json.MarshalIndent(rules, "", "  ")
-	return os.WriteFile(pfFile, data, 0644)
+	return os.WriteFile(s.pfFile(), data, 0644)
 }
 
 // checkPortConflict checks if any port in the range is used by the host
@@ -49,13 +52,13 @@ func checkPortConflict(protocol string, start, end int) error {
 		if protocol == "tcp" {
 			ln, err := net.Listen("tcp", addr)
 			if err != nil {
-				return fmt.Errorf("宿主机端口 %d/tcp 已被占用", port)
+				return conflict(CodePortInUse, "host port %d/tcp is in use", port)
 			}
 			ln.Close()
 		} else {
 			ln, err := net.ListenPacket("udp", addr)
 			if err != nil {
-				return fmt.Errorf("宿主机端口 %d/udp 已被占用", port)
+				return conflict(CodePortInUse, "host port %d/udp is in use", port)
 			}
 			ln.Close()
 		}
@@ -64,9 +67,12 @@ func checkPortConflict(protocol string, start, end int) error {
 }
 
 func (s *LibvirtService) ListPortForwards() ([]PortForward, error) {
+	if !s.local {
+		return nil, ErrLocalOnly
+	}
 	pfMu.Lock()
 	defer pfMu.Unlock()
-	rules, err := loadPF()
+	rules, err := s.loadPF()
 	if err != nil {
 		return nil, err
 	}
@@ -77,6 +83,9 @@ func (s *LibvirtService) ListPortForwards() ([]PortForward, error) {
 }
 
 func (s *LibvirtService) AddPortForward(pf PortForward) error {
+	if !s.local {
+		return ErrLocalOnly
+	}
 	pfMu.Lock()
 	defer pfMu.Unlock()
 
@@ -85,7 +94,7 @@ func (s *LibvirtService) AddPortForward(pf PortForward) error {
 		end = pf.HostPortEnd
 	}
 
-	rules, _ := loadPF()
+	rules, _ := s.loadPF()
 	// check overlap with existing rules
 	for _, r := range rules {
 		rEnd := r.HostPort
@@ -93,7 +102,7 @@ func (s *LibvirtService) AddPortForward(pf PortForward) error {
 			rEnd = r.HostPortEnd
 		}
 		if r.Protocol == pf.Protocol && pf.HostPort <= rEnd && end >= r.HostPort {
-			return fmt.Errorf("端口范围与已有规则冲突")
+			return conflict(CodePortInUse, "port range overlaps an existing rule")
 		}
 	}
 
@@ -108,14 +117,17 @@ func (s *LibvirtService) AddPortForward(pf PortForward) error {
 
 	pf.ID = fmt.Sprintf("%s-%d-%d-%s-%d", pf.Protocol, pf.HostPort, end, pf.VMIP, pf.VMPort)
 	rules = append(rules, pf)
-	return savePF(rules)
+	return s.savePF(rules)
 }
 
 func (s *LibvirtService) DeletePortForward(id string) error {
+	if !s.local {
+		return ErrLocalOnly
+	}
 	pfMu.Lock()
 	defer pfMu.Unlock()
 
-	rules, _ := loadPF()
+	rules, _ := s.loadPF()
 	var target *PortForward
 	var newRules []PortForward
 	for _, r := range rules {
@@ -127,14 +139,14 @@ func (s *LibvirtService) DeletePortForward(id string) error {
 		}
 	}
 	if target == nil {
-		return fmt.Errorf("规则不存在")
+		return notFound(CodePortForwardMissing, "port forward not found")
 	}
 
 	removeIptablesRule(*target)
 	if newRules == nil {
 		newRules = []PortForward{}
 	}
-	return savePF(newRules)
+	return s.savePF(newRules)
 }
 
 func dportArg(pf PortForward) string {
@@ -164,7 +176,7 @@ func addIptablesRule(pf PortForward) error {
 		"-p", pf.Protocol, "--dport", dportArg(pf),
 		"-j", "DNAT", "--to-destination", destArg(pf))
 	if out, err := dnat.CombinedOutput(); err != nil {
-		return fmt.Errorf("DNAT 规则添加失败: %s", strings.TrimSpace(string(out)))
+		return fmt.Errorf("add DNAT rule: %s", strings.TrimSpace(string(out)))
 	}
 
 	exec.Command("iptables", "-I", "FORWARD",
@@ -186,7 +198,10 @@ func removeIptablesRule(pf PortForward) {
 
 // RestorePortForwards re-applies all saved rules (call on startup)
 func (s *LibvirtService) RestorePortForwards() {
-	rules, _ := loadPF()
+	if !s.local {
+		return
+	}
+	rules, _ := s.loadPF()
 	for _, r := range rules {
 		addIptablesRule(r)
 	}
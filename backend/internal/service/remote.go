@@ -0,0 +1,302 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// ErrLocalOnly is returned for operations that act on the panel machine
+// itself (bridges, iptables, ISO uploads) when a remote host is selected.
+var ErrLocalOnly = &Error{Kind: Precondition, Code: CodeLocalOnly, Msg: "operation is only supported on the local host"}
+
+// Disk images on remote hosts cannot be reached through the filesystem,
+// so the helpers below go through libvirt storage pools instead. The
+// directory (image_dir, iso_dir) must be the target of a pool there.
+
+// diskExists reports whether a disk image exists at path on the host.
+func (s *LibvirtService) diskExists(path string) bool {
+	if s.local {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	l, err := s.conn()
+	if err != nil {
+		return false
+	}
+	_, err = l.StorageVolLookupByPath(path)
+	return err == nil
+}
+
+// createDisk creates an empty qcow2 image of sizeGB at path.
+func (s *LibvirtService) createDisk(ctx context.Context, path string, sizeGB int, p Progress) error {
+	if s.diskExists(path) {
+		return conflict(CodeAlreadyExists, "disk image %s already exists, choose another name", path)
+	}
+	if s.local {
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, "qemu-img", "create", "-f", "qcow2", path, fmt.Sprintf("%dG", sizeGB))
+		cmd.Stdout = io.MultiWriter(&out, p)
+		cmd.Stderr = cmd.Stdout
+		if err := cmd.Run(); err != nil {
+			os.Remove(path)
+			return fmt.Errorf("create disk failed: %s", out.String())
+		}
+		return nil
+	}
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	pool, err := poolForDir(l, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	xmlDef := fmt.Sprintf(`<volume>
+  <name>%s</name>
+  <capacity unit='G'>%d</capacity>
+  <target><format type='qcow2'/></target>
+</volume>`, filepath.Base(path), sizeGB)
+	if _, err := l.StorageVolCreateXML(pool, xmlDef, 0); err != nil {
+		return fmt.Errorf("create disk failed: %w", err)
+	}
+	return nil
+}
+
+// writeImage stores data as a raw image at path.
+func (s *LibvirtService) writeImage(path string, data []byte) error {
+	if s.diskExists(path) {
+		return conflict(CodeAlreadyExists, "image %s already exists", path)
+	}
+	if s.local {
+		return os.WriteFile(path, data, 0644)
+	}
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	pool, err := poolForDir(l, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	xmlDef := fmt.Sprintf(`<volume>
+  <name>%s</name>
+  <capacity unit='bytes'>%d</capacity>
+  <target><format type='raw'/></target>
+</volume>`, filepath.Base(path), len(data))
+	vol, err := l.StorageVolCreateXML(pool, xmlDef, 0)
+	if err != nil {
+		return fmt.Errorf("create volume failed: %w", err)
+	}
+	if err := l.StorageVolUpload(vol, bytes.NewReader(data), 0, uint64(len(data)), 0); err != nil {
+		l.StorageVolDelete(vol, 0)
+		return fmt.Errorf("upload %s failed: %w", path, err)
+	}
+	return nil
+}
+
+// diskCapacity returns the virtual size in bytes of the image at path.
+func (s *LibvirtService) diskCapacity(ctx context.Context, path string) (uint64, error) {
+	if s.local {
+		out, err := exec.CommandContext(ctx, "qemu-img", "info", "--output=json", "-U", path).Output()
+		if err != nil {
+			return 0, fmt.Errorf("read size of %s: %w", path, err)
+		}
+		var info struct {
+			VirtualSize uint64 `json:"virtual-size"`
+		}
+		if err := json.Unmarshal(out, &info); err != nil {
+			return 0, fmt.Errorf("read size of %s: %w", path, err)
+		}
+		return info.VirtualSize, nil
+	}
+	l, err := s.conn()
+	if err != nil {
+		return 0, err
+	}
+	vol, err := l.StorageVolLookupByPath(path)
+	if err != nil {
+		return 0, err
+	}
+	_, capacity, _, err := l.StorageVolGetInfo(vol)
+	return capacity, err
+}
+
+// resizeDisk grows the image at path to size bytes.
+func (s *LibvirtService) resizeDisk(ctx context.Context, path string, size uint64) error {
+	if s.local {
+		out, err := exec.CommandContext(ctx, "qemu-img", "resize", path, strconv.FormatUint(size, 10)).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("resize disk failed: %s", out)
+		}
+		return nil
+	}
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	vol, err := l.StorageVolLookupByPath(path)
+	if err != nil {
+		return err
+	}
+	if err := l.StorageVolResize(vol, size, 0); err != nil {
+		return fmt.Errorf("resize disk failed: %w", err)
+	}
+	return nil
+}
+
+// createOverlay creates a qcow2 image at path that reads through to the
+// backing image until written.
+func (s *LibvirtService) createOverlay(ctx context.Context, path, backing, backingFormat string) error {
+	if s.diskExists(path) {
+		return conflict(CodeAlreadyExists, "disk image %s already exists", path)
+	}
+	if s.local {
+		out, err := exec.CommandContext(ctx, "qemu-img", "create", "-f", "qcow2", "-F", backingFormat, "-b", backing, path).CombinedOutput()
+		if err != nil {
+			os.Remove(path)
+			return fmt.Errorf("create overlay failed: %s", out)
+		}
+		return nil
+	}
+	size, err := s.diskCapacity(ctx, backing)
+	if err != nil {
+		return err
+	}
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	pool, err := poolForDir(l, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<volume>\n  <name>%s</name>\n  <capacity unit='bytes'>%d</capacity>\n", filepath.Base(path), size)
+	fmt.Fprintf(&b, "  <target><format type='qcow2'/></target>\n")
+	fmt.Fprintf(&b, "  <backingStore><path>%s</path><format type='%s'/></backingStore>\n</volume>", html.EscapeString(backing), html.EscapeString(backingFormat))
+	if _, err := l.StorageVolCreateXML(pool, b.String(), 0); err != nil {
+		return fmt.Errorf("create overlay failed: %w", err)
+	}
+	return nil
+}
+
+// diskBacking returns the backing file of the image at path, or "".
+func (s *LibvirtService) diskBacking(ctx context.Context, path string) (string, error) {
+	if s.local {
+		out, err := exec.CommandContext(ctx, "qemu-img", "info", "--output=json", "-U", path).Output()
+		if err != nil {
+			return "", fmt.Errorf("read %s: %w", path, err)
+		}
+		var info struct {
+			BackingFilename string `json:"backing-filename"`
+		}
+		if err := json.Unmarshal(out, &info); err != nil {
+			return "", fmt.Errorf("read %s: %w", path, err)
+		}
+		return info.BackingFilename, nil
+	}
+	l, err := s.conn()
+	if err != nil {
+		return "", err
+	}
+	vol, err := l.StorageVolLookupByPath(path)
+	if err != nil {
+		return "", err
+	}
+	xmlStr, err := l.StorageVolGetXMLDesc(vol, 0)
+	if err != nil {
+		return "", err
+	}
+	var vx struct {
+		BackingStore struct {
+			Path string `xml:"path"`
+		} `xml:"backingStore"`
+	}
+	if err := xml.Unmarshal([]byte(xmlStr), &vx); err != nil {
+		return "", err
+	}
+	return vx.BackingStore.Path, nil
+}
+
+// flattenDisk copies the backing data of the overlay at path into it so
+// it stands alone, and returns where the standalone image is. Locally
+// the image is rebased in place; on remote hosts libvirt writes a full
+// copy next to it, which replaces the overlay.
+func (s *LibvirtService) flattenDisk(ctx context.Context, path string) (string, error) {
+	if s.local {
+		out, err := exec.CommandContext(ctx, "qemu-img", "rebase", "-f", "qcow2", "-b", "", path).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("flatten %s failed: %s", path, out)
+		}
+		return path, nil
+	}
+	l, err := s.conn()
+	if err != nil {
+		return "", err
+	}
+	vol, err := l.StorageVolLookupByPath(path)
+	if err != nil {
+		return "", err
+	}
+	pool, err := poolForDir(l, filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(path)
+	flat := strings.TrimSuffix(name, filepath.Ext(name)) + "-flat.qcow2"
+	xmlDef := fmt.Sprintf(`<volume>
+  <name>%s</name>
+  <capacity unit='bytes'>0</capacity>
+  <target><format type='qcow2'/></target>
+</volume>`, flat)
+	if _, err := l.StorageVolCreateXMLFrom(pool, xmlDef, vol, 0); err != nil {
+		return "", fmt.Errorf("flatten %s failed: %w", path, err)
+	}
+	return filepath.Join(filepath.Dir(path), flat), nil
+}
+
+// removeDisk deletes the image at path, ignoring errors.
+func (s *LibvirtService) removeDisk(path string) {
+	if s.local {
+		os.Remove(path)
+		return
+	}
+	l, err := s.conn()
+	if err != nil {
+		return
+	}
+	if vol, err := l.StorageVolLookupByPath(path); err == nil {
+		l.StorageVolDelete(vol, 0)
+	}
+}
+
+// poolForDir finds the active storage pool whose target is dir.
+func poolForDir(l *libvirt.Libvirt, dir string) (libvirt.StoragePool, error) {
+	pools, _, err := l.ConnectListAllStoragePools(-1, libvirt.ConnectListStoragePoolsActive)
+	if err != nil {
+		return libvirt.StoragePool{}, err
+	}
+	for _, p := range pools {
+		xmlStr, err := l.StoragePoolGetXMLDesc(p, 0)
+		if err != nil {
+			continue
+		}
+		var px poolXML
+		if xml.Unmarshal([]byte(xmlStr), &px) == nil && filepath.Clean(px.Target.Path) == dir {
+			return p, nil
+		}
+	}
+	return libvirt.StoragePool{}, precondition(CodeNotConfigured, "no active storage pool for %s", dir)
+}
@@ -0,0 +1,2302 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	mrand "math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"virtpanel/internal/cloudinit"
+	"virtpanel/internal/config"
+	"virtpanel/internal/domxml"
+	"virtpanel/internal/model"
+	"virtpanel/internal/ovf"
+)
+
+// SimService is an in-memory Hypervisor. It keeps domains, snapshots,
+// networks, pools, volumes, ISOs, bridges and port forwards in process
+// memory and applies the same validation and state rules as libvirt, so
+// the panel can run without a libvirt socket (demo/training mode and
+// hermetic API tests).
+type SimService struct {
+	cfg      *config.Config
+	mu       sync.Mutex
+	domains  map[string]*simDomain
+	networks map[string]*simNetwork
+	pools    map[string]*simPool
+	isos     map[string]int64
+	bridges  map[string]*model.Bridge
+	pfs      []PortForward
+	nextVNC  int
+	started  time.Time
+	publish  func(model.Event) // set by WatchEvents
+	ops      opLocks           // held across the simulated copies too
+	ioCache  ioCache
+}
+
+type simDomain struct {
+	name      string
+	uuid      string
+	state     string
+	cpu       int
+	memory    int // MiB
+	arch      string
+	boot      []string
+	disks     []model.VMDisk
+	nics      []model.VMNIC
+	autostart bool
+	template  bool
+	linkedTo  string // base template of a linked clone
+	owner     string
+	group     string
+	vncPort   int
+	cpuTime   uint64 // ns, advanced while running
+	lastTick  time.Time
+	snapshots []*simSnapshot
+	current   string
+}
+
+type simSnapshot struct {
+	name        string
+	description string
+	state       string
+	createdAt   int64
+	domain      simDomain // config and state at snapshot time
+}
+
+type simNetwork struct {
+	model.Network
+	dhcpStart string
+}
+
+type simPool struct {
+	model.StoragePool
+	volumes map[string]*simVolume
+}
+
+type simVolume struct {
+	model.StorageVolume
+	format string
+}
+
+// NewSimService returns a simulated hypervisor pre-populated with the
+// objects a fresh libvirt install has: the default NAT network and the
+// default directory pool.
+func NewSimService(cfg *config.Config) *SimService {
+	s := &SimService{
+		cfg:      cfg,
+		domains:  make(map[string]*simDomain),
+		networks: make(map[string]*simNetwork),
+		pools:    make(map[string]*simPool),
+		isos:     make(map[string]int64),
+		bridges:  make(map[string]*model.Bridge),
+		nextVNC:  5900,
+		started:  time.Now(),
+	}
+	s.networks[cfg.DefaultNetwork] = &simNetwork{
+		Network: model.Network{
+			Name:    cfg.DefaultNetwork,
+			UUID:    simUUID(),
+			Active:  true,
+			Forward: "nat",
+			Bridge:  "virbr0",
+			Subnet:  "192.168.122.1/24",
+		},
+		dhcpStart: "192.168.122.2",
+	}
+	s.pools["default"] = &simPool{
+		StoragePool: model.StoragePool{
+			Name:     "default",
+			UUID:     simUUID(),
+			Active:   true,
+			Type:     "dir",
+			Path:     cfg.ImageDir,
+			Capacity: 500,
+		},
+		volumes: make(map[string]*simVolume),
+	}
+	return s
+}
+
+func (s *SimService) Close() {}
+
+// simCopy stands in for a disk copy: it reports progress in ten steps
+// over d and stops early when ctx is canceled.
+func simCopy(ctx context.Context, p Progress, what string, d time.Duration) error {
+	fmt.Fprintf(p, "%s\n", what)
+	for i := 1; i <= 10; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d / 10):
+		}
+		p.SetProgress(float64(i * 10))
+	}
+	return nil
+}
+
+// simMemorySave is how long a snapshot of a running domain takes to save
+// its memory.
+const simMemorySave = time.Second
+
+// WatchEvents makes the simulator report its own state changes.
+func (s *SimService) WatchEvents(publish func(model.Event)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publish = publish
+}
+
+// emit publishes an event if someone is watching, with the owner of the
+// domain it is about. Caller must hold s.mu.
+func (s *SimService) emit(kind, name, typ, detail string) {
+	if s.publish == nil {
+		return
+	}
+	e := newEvent(kind, name, typ, detail)
+	if d, ok := s.domains[name]; ok && (kind == "domain" || kind == "agent") {
+		e.Owner, e.Group = d.owner, d.group
+	}
+	s.publish(e)
+}
+
+// Ping always succeeds: there is no connection to lose.
+func (s *SimService) Ping() error { return nil }
+
+func (s *SimService) Reconnects() uint64 { return 0 }
+
+func simUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+func simMAC() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", b[0], b[1], b[2])
+}
+
+func simNoDomain(name string) error {
+	return notFound(CodeVMNotFound, "Domain not found: no domain with matching name '%s'", name)
+}
+
+// lookup returns the named domain. Caller must hold s.mu.
+func (s *SimService) lookup(name string) (*simDomain, error) {
+	d, ok := s.domains[name]
+	if !ok {
+		return nil, simNoDomain(name)
+	}
+	s.tick(d)
+	return d, nil
+}
+
+// lookupNotTemplate is lookup for power actions and edits, which
+// templates refuse. Caller must hold s.mu.
+func (s *SimService) lookupNotTemplate(name string) (*simDomain, error) {
+	d, err := s.lookup(name)
+	if err == nil && d.template {
+		return nil, ErrIsTemplate
+	}
+	return d, err
+}
+
+// tick advances the simulated cpu time of a running domain.
+func (s *SimService) tick(d *simDomain) {
+	now := time.Now()
+	if d.state == "running" && !d.lastTick.IsZero() {
+		load := 0.05 + mrand.Float64()*0.3
+		d.cpuTime += uint64(now.Sub(d.lastTick).Seconds() * load * float64(d.cpu) * 1e9)
+	}
+	d.lastTick = now
+}
+
+func (d *simDomain) clone() simDomain {
+	c := *d
+	c.boot = append([]string(nil), d.boot...)
+	c.disks = append([]model.VMDisk(nil), d.disks...)
+	c.nics = append([]model.VMNIC(nil), d.nics...)
+	c.snapshots = nil
+	return c
+}
+
+func (s *SimService) vmOf(d *simDomain) model.VM {
+	vm := model.VM{
+		Name:       d.name,
+		UUID:       d.uuid,
+		State:      d.state,
+		CPU:        d.cpu,
+		Memory:     d.memory,
+		Owner:      d.owner,
+		Group:      d.group,
+		Template:   d.template,
+		LinkedBase: d.linkedTo,
+	}
+	if d.state == "running" {
+		vm.CPUUsage = math.Round((5+mrand.Float64()*30)*10) / 10
+		vm.MemUsed = d.memory * (30 + mrand.Intn(40)) / 100
+	}
+	return vm
+}
+
+func (s *SimService) GetHostInfo() (*model.HostInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	running := 0
+	usedMem := 0
+	for _, d := range s.domains {
+		if d.state == "running" || d.state == "paused" {
+			running++
+			usedMem += d.memory
+		}
+	}
+	const totalMem = 65536
+	var alloc uint64
+	for _, v := range s.pools["default"].volumes {
+		alloc += v.Allocation
+	}
+	return &model.HostInfo{
+		Hostname:    "virtpanel-sim",
+		CPUModel:    "Simulated CPU @ 3.00GHz",
+		CPUCount:    16,
+		CPUUsage:    math.Round((2+mrand.Float64()*float64(running)*4)*10) / 10,
+		MemoryTotal: totalMem,
+		MemoryFree:  totalMem - 2048 - usedMem,
+		VMRunning:   running,
+		VMTotal:     len(s.domains),
+		Uptime:      int64(time.Since(s.started).Seconds()),
+		LoadAvg:     [3]float64{0.1 * float64(running), 0.08 * float64(running), 0.05 * float64(running)},
+		Disks: []model.DiskInfo{{
+			Mount:     "/",
+			Device:    "/dev/simda1",
+			Total:     500,
+			Used:      20 + alloc,
+			Available: 480 - alloc,
+			Percent:   int((20 + alloc) * 100 / 500),
+		}},
+	}, nil
+}
+
+// GuestStats derives block and interface counters from the simulated cpu
+// time, so they grow while a domain runs.
+func (s *SimService) GuestStats() ([]model.GuestStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]model.GuestStats, 0, len(s.domains))
+	for _, name := range s.domainNames() {
+		d := s.domains[name]
+		s.tick(d)
+		stats = append(stats, s.guestStats(d))
+	}
+	return stats, nil
+}
+
+// guestStats derives the counters of d from its CPU time. Caller must
+// hold s.mu.
+func (s *SimService) guestStats(d *simDomain) model.GuestStats {
+	g := model.GuestStats{Name: d.name, UUID: d.uuid, State: d.state}
+	if d.state != "running" && d.state != "paused" {
+		return g
+	}
+	work := d.cpuTime / 1000 // grows ~1e6 per busy vCPU second
+	memKiB := uint64(d.memory) * 1024
+	g.VCPUs, g.CPUTime = d.cpu, d.cpuTime
+	g.Balloon = model.BalloonStats{
+		Current:     memKiB,
+		Maximum:     memKiB,
+		Available:   memKiB - 65536,
+		Unused:      memKiB / 2,
+		Usable:      memKiB / 2,
+		RSS:         memKiB * 6 / 10,
+		MajorFaults: work / 1e4,
+		MinorFaults: work / 10,
+	}
+	for i, disk := range d.disks {
+		if disk.Device != "disk" {
+			continue
+		}
+		k := uint64(i + 1)
+		b := model.BlockStats{
+			Device:     disk.Target,
+			Path:       disk.Source,
+			ReadBytes:  work * 40 / k,
+			ReadReqs:   work / 100 / k,
+			WriteBytes: work * 25 / k,
+			WriteReqs:  work / 160 / k,
+			ReadTime:   work / 100 / k * 400000,
+			WriteTime:  work / 160 / k * 900000,
+		}
+		if v := s.findVolume(disk.Source); v != nil {
+			b.Capacity, b.Allocation = v.Capacity<<30, v.Allocation<<30
+		}
+		g.Blocks = append(g.Blocks, b)
+	}
+	for i := range d.nics {
+		k := uint64(i + 1)
+		g.NICs = append(g.NICs, model.NICStats{
+			Device:    fmt.Sprintf("vnet%d", i),
+			RxBytes:   work * 12 / k,
+			RxPackets: work / 90 / k,
+			TxBytes:   work * 5 / k,
+			TxPackets: work / 120 / k,
+		})
+	}
+	return g
+}
+
+func (s *SimService) ListVMs() ([]model.VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vms := make([]model.VM, 0, len(s.domains))
+	running := make(map[string]bool)
+	now := time.Now()
+	for _, name := range s.domainNames() {
+		d := s.domains[name]
+		s.tick(d)
+		vm := s.vmOf(d)
+		if d.state == "running" {
+			running[d.name] = true
+			s.ioCache.rates(s.guestStats(d), now).apply(&vm)
+		}
+		vms = append(vms, vm)
+	}
+	s.ioCache.forget(running)
+	return vms, nil
+}
+
+// domainNames returns domain names in a stable order. Caller must hold s.mu.
+func (s *SimService) domainNames() []string {
+	names := make([]string, 0, len(s.domains))
+	for n := range s.domains {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *SimService) GetVM(name string) (*model.VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	vm := s.vmOf(d)
+	vm.CPUUsage, vm.MemUsed = 0, 0
+	return &vm, nil
+}
+
+func (s *SimService) GetVMDetail(name string) (*model.VMDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	detail := &model.VMDetail{
+		Name:   d.name,
+		UUID:   d.uuid,
+		State:  d.state,
+		CPU:    d.cpu,
+		Memory: d.memory,
+		Disks:  append([]model.VMDisk{}, d.disks...),
+		NICs:   append([]model.VMNIC{}, d.nics...),
+		Boot:   strings.Join(d.boot, ", "),
+		Arch:   d.arch,
+	}
+	if d.state == "running" {
+		s.tick(d)
+		for i := range detail.NICs {
+			detail.NICs[i].Target = fmt.Sprintf("vnet%d", i)
+		}
+		s.ioCache.rates(s.guestStats(d), time.Now()).attach(detail)
+	}
+	return detail, nil
+}
+
+func (s *SimService) CreateVM(ctx context.Context, req model.CreateVMRequest, p Progress) error {
+	release, err := s.ops.acquire("create", req.Name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if !safeNameRe.MatchString(req.Name) {
+		return invalid(CodeInvalidName, "invalid vm name: %s", req.Name)
+	}
+	if req.CPU <= 0 {
+		req.CPU = 2
+	}
+	if req.Memory <= 0 {
+		req.Memory = 2048
+	}
+	if req.Disk <= 0 {
+		req.Disk = 20
+	}
+	diskBus, netModel, machine := "virtio", "virtio", "i440fx"
+	switch req.OSType {
+	case "windows":
+		diskBus, netModel, machine = "sata", "e1000", "q35"
+	case "legacy":
+		diskBus, netModel = "ide", "rtl8139"
+	}
+	if req.DiskBus != "" {
+		diskBus = req.DiskBus
+	}
+	if req.NetModel != "" {
+		netModel = req.NetModel
+	}
+	if req.Machine != "" {
+		machine = req.Machine
+	}
+	validBus := map[string]bool{"virtio": true, "sata": true, "scsi": true, "ide": true}
+	validNet := map[string]bool{"virtio": true, "e1000": true, "rtl8139": true}
+	if !validBus[diskBus] {
+		return invalid(CodeInvalidArgument, "unsupported disk bus: %s", diskBus)
+	}
+	if !validNet[netModel] {
+		return invalid(CodeInvalidArgument, "unsupported net model: %s", netModel)
+	}
+	diskDev := map[string]string{"virtio": "vda", "scsi": "sda", "sata": "sda", "ide": "hdc"}[diskBus]
+	cdromBus, cdromDev, seedCdromDev := "ide", "hda", "hdd"
+	if machine == "q35" {
+		cdromBus, cdromDev, seedCdromDev = "sata", "sdb", "sdd"
+	}
+	if req.ISO != "" && !strings.HasPrefix(filepath.Clean(req.ISO), s.cfg.ISODir+"/") {
+		return invalid(CodeInvalidPath, "iso path must be under %s", s.cfg.ISODir)
+	}
+	var seed []byte
+	if req.CloudInit != nil {
+		if seed, err = cloudinit.Seed(req.Name, req.CloudInit); err != nil {
+			return &Error{Kind: Invalid, Code: CodeInvalidArgument, Err: err}
+		}
+	}
+
+	nic, err := s.newNIC(req.NetMode, req.BridgeName, req.MacvtapDev, netModel)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.domains[req.Name]; ok {
+		return conflict(CodeAlreadyExists, "vm %s already exists", req.Name)
+	}
+	pool := s.pools["default"]
+	volName := req.Name + ".qcow2"
+	if _, ok := pool.volumes[volName]; ok {
+		return conflict(CodeAlreadyExists, "disk image %s already exists, choose another name", filepath.Join(s.cfg.ImageDir, volName))
+	}
+	if _, ok := pool.volumes[req.Name+seedSuffix]; ok && seed != nil {
+		return conflict(CodeAlreadyExists, "image %s already exists", filepath.Join(s.cfg.ImageDir, req.Name+seedSuffix))
+	}
+	diskPath := filepath.Join(s.cfg.ImageDir, volName)
+	pool.volumes[volName] = &simVolume{
+		StorageVolume: model.StorageVolume{Name: volName, Path: diskPath, Type: "file", Capacity: uint64(req.Disk), Allocation: 1},
+		format:        "qcow2",
+	}
+	fmt.Fprintf(p, "Formatting '%s', fmt=qcow2 size=%d\n", diskPath, uint64(req.Disk)<<30)
+	d := &simDomain{
+		name:   req.Name,
+		uuid:   simUUID(),
+		state:  "shutoff",
+		cpu:    req.CPU,
+		memory: req.Memory,
+		arch:   "x86_64",
+		boot:   []string{"cdrom", "hd"},
+		disks: []model.VMDisk{
+			{Device: "disk", Source: diskPath, Target: diskDev, Bus: diskBus, Format: "qcow2"},
+			{Device: "cdrom", Source: filepath.Clean(req.ISO), Target: cdromDev, Bus: cdromBus, Format: "raw"},
+		},
+		nics: []model.VMNIC{nic},
+	}
+	if req.ISO == "" {
+		d.disks[1].Source = ""
+	}
+	if seed != nil {
+		seedName := req.Name + seedSuffix
+		seedPath := filepath.Join(s.cfg.ImageDir, seedName)
+		pool.volumes[seedName] = &simVolume{
+			StorageVolume: model.StorageVolume{Name: seedName, Path: seedPath, Type: "file"},
+			format:        "raw",
+		}
+		d.disks = append(d.disks, model.VMDisk{Device: "cdrom", Source: seedPath, Target: seedCdromDev, Bus: cdromBus, Format: "raw"})
+		fmt.Fprintf(p, "cloud-init seed: %s (%d bytes)\n", seedPath, len(seed))
+	}
+	s.domains[req.Name] = d
+	s.emit("domain", req.Name, "defined", "added")
+	return nil
+}
+
+func (s *SimService) ImportVM(req model.ImportVMRequest) error {
+	if !safeNameRe.MatchString(req.Name) {
+		return invalid(CodeInvalidName, "invalid vm name: %s", req.Name)
+	}
+	if req.CPU <= 0 {
+		req.CPU = 2
+	}
+	if req.Memory <= 0 {
+		req.Memory = 2048
+	}
+	diskBus := req.DiskBus
+	if diskBus == "" {
+		diskBus = "virtio"
+	}
+	diskDev, ok := map[string]string{"virtio": "vda", "scsi": "sda", "sata": "sda", "ide": "hdc"}[diskBus]
+	if !ok {
+		return invalid(CodeInvalidArgument, "unsupported disk bus: %s", diskBus)
+	}
+	cleanPath := filepath.Clean(req.DiskPath)
+	if !s.cfg.PathAllowed(cleanPath) {
+		return invalid(CodeInvalidPath, "disk path must be under %s", strings.Join(s.cfg.AllowedRoots, ", "))
+	}
+	format := "qcow2"
+	if strings.HasSuffix(cleanPath, ".raw") || strings.HasSuffix(cleanPath, ".img") {
+		format = "raw"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findVolume(cleanPath) == nil {
+		return notFound(CodeFileNotFound, "disk image not found: %s", cleanPath)
+	}
+	if _, ok := s.domains[req.Name]; ok {
+		return conflict(CodeAlreadyExists, "vm %s already exists", req.Name)
+	}
+	s.domains[req.Name] = &simDomain{
+		name:   req.Name,
+		uuid:   simUUID(),
+		state:  "shutoff",
+		cpu:    req.CPU,
+		memory: req.Memory,
+		arch:   "x86_64",
+		boot:   []string{"hd"},
+		disks: []model.VMDisk{
+			{Device: "disk", Source: cleanPath, Target: diskDev, Bus: diskBus, Format: format},
+			{Device: "cdrom", Target: "hda", Bus: "ide", Format: "raw"},
+		},
+		nics: []model.VMNIC{{Type: "network", Source: s.cfg.DefaultNetwork, MAC: simMAC(), Model: "virtio"}},
+	}
+	s.emit("domain", req.Name, "defined", "added")
+	return nil
+}
+
+// findVolume returns the volume with the given path in any pool. Caller must hold s.mu.
+func (s *SimService) findVolume(path string) *simVolume {
+	for _, p := range s.pools {
+		for _, v := range p.volumes {
+			if v.Path == path {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SimService) UpdateVM(name string, req model.UpdateVMRequest) error {
+	release, err := s.ops.acquire("update", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(name)
+	if err != nil {
+		return err
+	}
+	if req.CPU > 0 {
+		d.cpu = req.CPU
+	}
+	if req.Memory > 0 {
+		d.memory = req.Memory
+	}
+	s.emit("domain", name, "defined", "updated")
+	return nil
+}
+
+// domainXML renders the simulated domain the way libvirt would describe
+// it. Caller must hold s.mu.
+func (s *SimService) domainXML(d *simDomain, inactive bool) (string, error) {
+	dx := newDomain(d.name, d.memory, d.cpu, d.boot...)
+	dx.UUID = d.uuid
+	dx.OS.Type.Arch = d.arch
+	for _, vd := range d.disks {
+		dx.Devices.Disks = append(dx.Devices.Disks, domxml.FileDisk(vd.Device, vd.Source, vd.Format, vd.Target, vd.Bus))
+	}
+	for _, nic := range d.nics {
+		mode := nic.Type
+		if mode == "direct" {
+			mode = "macvtap"
+		}
+		iface, err := domxml.NewInterface(mode, nic.Source, nic.Model)
+		if err != nil {
+			return "", err
+		}
+		iface.MAC = &domxml.MAC{Address: nic.MAC}
+		dx.Devices.Interfaces = append(dx.Devices.Interfaces, iface)
+	}
+	if !inactive && d.vncPort != 0 {
+		dx.Devices.Graphics[0].Port = fmt.Sprint(d.vncPort)
+	}
+	return dx.Marshal()
+}
+
+func (s *SimService) GetVMXML(name string, inactive bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return s.domainXML(d, inactive)
+}
+
+// UpdateVMXML applies the parts of the definition the simulator models:
+// vCPUs, memory, boot order, disks and NICs. There is no schema to
+// validate against.
+func (s *SimService) UpdateVMXML(name string, req model.UpdateVMXMLRequest) (*model.VMXMLResult, error) {
+	release, err := s.ops.acquire("edit_xml", name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := s.domainXML(d, true)
+	if err != nil {
+		return nil, err
+	}
+	dx, err := parseEditedXML(name, d.uuid, req.XML)
+	if err != nil {
+		return nil, err
+	}
+	if dx.VCPUs() <= 0 || dx.MemoryMiB() <= 0 {
+		return nil, fmt.Errorf("%w: vcpu and memory must be positive", ErrInvalidXML)
+	}
+
+	res := &model.VMXMLResult{Diff: xmlDiff(name, cur, req.XML)}
+	res.Changed = res.Diff != ""
+	if req.DryRun || !res.Changed {
+		return res, nil
+	}
+
+	d.cpu, d.memory, d.boot = dx.VCPUs(), dx.MemoryMiB(), dx.BootOrder()
+	if dx.OS != nil && dx.OS.Type.Arch != "" {
+		d.arch = dx.OS.Type.Arch
+	}
+	d.disks, d.nics = []model.VMDisk{}, []model.VMNIC{}
+	if dx.Devices != nil {
+		for _, disk := range dx.Devices.Disks {
+			vd := model.VMDisk{Device: disk.Device, Source: disk.SourceFile()}
+			if disk.Target != nil {
+				vd.Target, vd.Bus = disk.Target.Dev, disk.Target.Bus
+			}
+			if disk.Driver != nil {
+				vd.Format = disk.Driver.Type
+			}
+			d.disks = append(d.disks, vd)
+		}
+		for _, iface := range dx.Devices.Interfaces {
+			nic := model.VMNIC{Type: iface.Type, Source: iface.SourceName(), MAC: iface.MACAddress(), Model: iface.ModelType()}
+			if nic.MAC == "" {
+				nic.MAC = simMAC()
+			}
+			d.nics = append(d.nics, nic)
+		}
+	}
+	res.Applied = true
+	if next, err := s.domainXML(d, true); err == nil {
+		res.Diff = xmlDiff(name, cur, next)
+		res.Changed = res.Diff != ""
+	}
+	res.RestartRequired = res.Changed && d.state != "shutoff"
+	s.emit("domain", name, "defined", "updated")
+	return res, nil
+}
+
+func (s *SimService) DeleteVM(name string) error {
+	release, err := s.ops.acquire("delete", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	if err := s.checkNoLinkedClones(name); err != nil {
+		return err
+	}
+	if d.state != "shutoff" {
+		s.emit("domain", name, "stopped", "destroyed")
+	}
+	s.emit("domain", name, "undefined", "removed")
+	delete(s.domains, name)
+
+	// Remove disk volumes no other domain references
+	used := make(map[string]bool)
+	for _, od := range s.domains {
+		for _, disk := range od.disks {
+			used[disk.Source] = true
+		}
+	}
+	for _, disk := range d.disks {
+		seed := disk.Device == "cdrom" && strings.HasSuffix(disk.Source, seedSuffix)
+		if disk.Device != "disk" && !seed || used[disk.Source] || !strings.HasPrefix(disk.Source, s.cfg.ImageDir+"/") {
+			continue
+		}
+		for _, p := range s.pools {
+			for vn, v := range p.volumes {
+				if v.Path == disk.Source {
+					delete(p.volumes, vn)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// transition moves a domain between states, rejecting invalid
+// transitions with libvirt's wording, and emits the matching event.
+func (s *SimService) transition(name string, from []string, to, event, detail string) error {
+	release, err := s.ops.acquire(event, name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(name)
+	if err != nil {
+		return err
+	}
+	for _, f := range from {
+		if d.state == f {
+			if to == "running" && d.state == "shutoff" {
+				d.vncPort = s.nextVNC
+				s.nextVNC++
+			}
+			if to == "shutoff" {
+				d.vncPort = 0
+			}
+			d.state = to
+			s.emit("domain", name, event, detail)
+			return nil
+		}
+	}
+	switch d.state {
+	case "running":
+		return conflict(CodeInvalidState, "Requested operation is not valid: domain is already running")
+	case "shutoff":
+		return conflict(CodeInvalidState, "Requested operation is not valid: domain is not running")
+	default:
+		return conflict(CodeInvalidState, "Requested operation is not valid: domain is %s", d.state)
+	}
+}
+
+func (s *SimService) StartVM(name string) error {
+	if err := s.transition(name, []string{"shutoff"}, "running", "started", "booted"); err != nil {
+		return err
+	}
+	// Mirror the libvirt driver: after first boot switch to hd-first
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.domains[name]
+	if len(d.boot) == 2 && d.boot[0] == "cdrom" && d.boot[1] == "hd" {
+		d.boot = []string{"hd", "cdrom"}
+	}
+	return nil
+}
+
+func (s *SimService) ShutdownVM(name string) error {
+	return s.transition(name, []string{"running"}, "shutoff", "stopped", "shutdown")
+}
+
+func (s *SimService) DestroyVM(name string) error {
+	return s.transition(name, []string{"running", "paused", "crashed", "blocked"}, "shutoff", "stopped", "destroyed")
+}
+
+func (s *SimService) RebootVM(name string) error {
+	return s.transition(name, []string{"running"}, "running", "rebooted", "")
+}
+
+func (s *SimService) SuspendVM(name string) error {
+	return s.transition(name, []string{"running"}, "paused", "suspended", "paused")
+}
+
+func (s *SimService) ResumeVM(name string) error {
+	return s.transition(name, []string{"paused"}, "running", "resumed", "unpaused")
+}
+
+func (s *SimService) CloneVM(ctx context.Context, srcName string, req model.CloneVMRequest, p Progress) error {
+	release, err := s.ops.acquire("clone", srcName, req.NewName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if !safeNameRe.MatchString(req.NewName) {
+		return invalid(CodeInvalidName, "invalid vm name: %s", req.NewName)
+	}
+	opts, err := fullCloneOptions(req.Linked, req.Pool, req.Format)
+	if err != nil {
+		return err
+	}
+	if _, err := s.GetVM(srcName); err != nil {
+		return err
+	}
+	if !req.Linked {
+		if err := simCopy(ctx, p, "Cloning "+srcName, time.Second); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, err := s.lookup(srcName)
+	if err != nil {
+		return err
+	}
+	if req.Linked {
+		return s.linkedCloneLocked(src.clone(), req.NewName, p)
+	}
+	return s.cloneLocked(src.clone(), req.NewName, opts)
+}
+
+// cloneLocked defines newName as a copy of src with fresh disks, UUID and
+// MAC addresses, like LibvirtService.cloneDomain. Caller must hold s.mu.
+func (s *SimService) cloneLocked(src simDomain, newName string, opts cloneOptions) error {
+	if src.state != "shutoff" {
+		return errNotShutoff
+	}
+	if _, ok := s.domains[newName]; ok {
+		return conflict(CodeAlreadyExists, "vm %s already exists", newName)
+	}
+	var target *simPool
+	if opts.pool != "" {
+		if target = s.pools[opts.pool]; target == nil {
+			return notFound(CodePoolNotFound, "storage pool not found: %s", opts.pool)
+		}
+	}
+
+	// Name every copy before adding any
+	type diskCopy struct {
+		disk int
+		pool *simPool
+		vol  simVolume
+	}
+	var copies []diskCopy
+	for i, disk := range src.disks {
+		if disk.Device != "disk" || disk.Source == "" {
+			continue
+		}
+		v := s.findVolume(disk.Source)
+		if v == nil {
+			return precondition(CodeNotConfigured, "%s is not in an active storage pool", disk.Source)
+		}
+		pool := target
+		if pool == nil {
+			for _, p := range s.pools {
+				if p.volumes[v.Name] == v {
+					pool = p
+				}
+			}
+		}
+		nv := *v
+		if opts.format != "" {
+			nv.format = opts.format
+		}
+		suffix := ""
+		if len(copies) > 0 {
+			suffix = fmt.Sprintf("-%d", len(copies))
+		}
+		nv.Name = newName + suffix + "." + nv.format
+		nv.Path = filepath.Join(pool.Path, nv.Name)
+		if _, ok := pool.volumes[nv.Name]; ok {
+			return conflict(CodeAlreadyExists, "volume %s already exists in pool %s", nv.Name, pool.Name)
+		}
+		copies = append(copies, diskCopy{disk: i, pool: pool, vol: nv})
+	}
+
+	nd := src
+	nd.name = newName
+	nd.uuid = simUUID()
+	nd.cpuTime = 0
+	nd.current = ""
+	nd.template, nd.linkedTo = false, ""
+	nd.disks = append([]model.VMDisk(nil), src.disks...)
+	for _, c := range copies {
+		nv := c.vol
+		c.pool.volumes[nv.Name] = &nv
+		nd.disks[c.disk].Source, nd.disks[c.disk].Format = nv.Path, nv.format
+	}
+	simDetachSeed(nd.disks)
+	nd.nics = make([]model.VMNIC, len(src.nics))
+	for i, nic := range src.nics {
+		nic.MAC = simMAC()
+		nd.nics[i] = nic
+	}
+	s.domains[newName] = &nd
+	s.emit("domain", newName, "defined", "added")
+	return nil
+}
+
+// simDetachSeed mirrors detachSeed.
+func simDetachSeed(disks []model.VMDisk) {
+	for i, disk := range disks {
+		if disk.Device == "cdrom" && strings.HasSuffix(disk.Source, seedSuffix) {
+			disks[i].Source = ""
+		}
+	}
+}
+
+// newNIC mirrors LibvirtService.newNIC.
+func (s *SimService) newNIC(mode, bridge, macvtapDev, netModel string) (model.VMNIC, error) {
+	nic := model.VMNIC{Type: "network", Source: s.cfg.DefaultNetwork, MAC: simMAC(), Model: netModel}
+	switch mode {
+	case "", "nat":
+	case "bridge":
+		nic.Type, nic.Source = "bridge", bridge
+		if nic.Source == "" {
+			nic.Source = "br0"
+		}
+	case "macvtap":
+		if macvtapDev == "" {
+			return nic, invalid(CodeInvalidArgument, "macvtap mode needs a physical device")
+		}
+		nic.Type, nic.Source = "direct", macvtapDev
+	default:
+		return nic, invalid(CodeInvalidArgument, "unsupported net mode: %s", mode)
+	}
+	return nic, nil
+}
+
+func (s *SimService) SetTemplate(name string, template bool) error {
+	release, err := s.ops.acquire("set_template", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	if !template {
+		if err := s.checkNoLinkedClones(name); err != nil {
+			return err
+		}
+	} else {
+		if d.state != "shutoff" {
+			return errNotShutoff
+		}
+		d.autostart = false
+	}
+	d.template = template
+	return nil
+}
+
+func (s *SimService) CreateFromTemplate(ctx context.Context, template string, req model.CreateFromTemplateRequest, p Progress) error {
+	if !safeNameRe.MatchString(req.Name) {
+		return invalid(CodeInvalidName, "invalid vm name: %s", req.Name)
+	}
+	if req.CPU < 0 || req.Memory < 0 || req.Disk < 0 {
+		return invalid(CodeInvalidArgument, "cpu, memory and disk cannot be negative")
+	}
+	opts, err := fullCloneOptions(req.Linked, req.Pool, req.Format)
+	if err != nil {
+		return err
+	}
+	release, err := s.ops.acquire("provision", template, req.Name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s.mu.Lock()
+	src, err := s.lookup(template)
+	if err == nil && !src.template {
+		err = precondition(CodeNotTemplate, "vm is not a template: %s", template)
+	}
+	var tpl simDomain
+	if err == nil {
+		tpl = src.clone()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var nic *model.VMNIC
+	if req.NetMode != "" {
+		netModel := req.NetModel
+		if netModel == "" {
+			netModel = "virtio"
+			if len(tpl.nics) > 0 && tpl.nics[0].Model != "" {
+				netModel = tpl.nics[0].Model
+			}
+		}
+		n, err := s.newNIC(req.NetMode, req.BridgeName, req.MacvtapDev, netModel)
+		if err != nil {
+			return err
+		}
+		nic = &n
+	}
+	if req.NetModel != "" && !map[string]bool{"virtio": true, "e1000": true, "rtl8139": true}[req.NetModel] {
+		return invalid(CodeInvalidArgument, "unsupported net model: %s", req.NetModel)
+	}
+	var seed []byte
+	if req.CloudInit != nil {
+		if seed, err = cloudinit.Seed(req.Name, req.CloudInit); err != nil {
+			return &Error{Kind: Invalid, Code: CodeInvalidArgument, Err: err}
+		}
+	}
+	if req.Disk > 0 {
+		disk := -1
+		for i, d := range tpl.disks {
+			if d.Device == "disk" && d.Source != "" {
+				disk = i
+				break
+			}
+		}
+		if disk < 0 {
+			return invalid(CodeInvalidArgument, "template has no disk to resize")
+		}
+		s.mu.Lock()
+		v := s.findVolume(tpl.disks[disk].Source)
+		s.mu.Unlock()
+		if v != nil && uint64(req.Disk) < v.Capacity {
+			return invalid(CodeInvalidArgument, "disk cannot shrink below %d GB", v.Capacity)
+		}
+	}
+
+	if !req.Linked {
+		if err := simCopy(ctx, p, "Cloning "+template, time.Second); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Linked {
+		err = s.linkedCloneLocked(tpl, req.Name, p)
+	} else {
+		err = s.cloneLocked(tpl, req.Name, opts)
+	}
+	if err != nil {
+		return err
+	}
+	d := s.domains[req.Name]
+	d.template = false
+	if req.CPU > 0 {
+		d.cpu = req.CPU
+	}
+	if req.Memory > 0 {
+		d.memory = req.Memory
+	}
+	if nic != nil {
+		d.nics = []model.VMNIC{*nic}
+	} else if req.NetModel != "" {
+		for i := range d.nics {
+			d.nics[i].Model = req.NetModel
+		}
+	}
+	seedPath := filepath.Join(s.cfg.ImageDir, req.Name+seedSuffix)
+	simDetachSeed(d.disks)
+	seedDrive := -1
+	for i, disk := range d.disks {
+		if disk.Device == "cdrom" && disk.Source == "" {
+			seedDrive = i
+			break
+		}
+	}
+	if seed != nil {
+		seedName := req.Name + seedSuffix
+		s.pools["default"].volumes[seedName] = &simVolume{
+			StorageVolume: model.StorageVolume{Name: seedName, Path: seedPath, Type: "file"},
+			format:        "raw",
+		}
+		if seedDrive >= 0 {
+			d.disks[seedDrive].Source = seedPath
+		} else {
+			cd := model.VMDisk{Device: "cdrom", Source: seedPath, Target: "hdd", Bus: "ide", Format: "raw"}
+			for _, disk := range d.disks {
+				if disk.Device == "cdrom" && disk.Bus == "sata" {
+					cd.Target, cd.Bus = "sdd", "sata"
+				}
+			}
+			d.disks = append(d.disks, cd)
+		}
+		fmt.Fprintf(p, "cloud-init seed: %s (%d bytes)\n", seedPath, len(seed))
+	}
+	if req.Disk > 0 {
+		for _, disk := range d.disks {
+			if disk.Device == "disk" && disk.Source != "" {
+				if v := s.findVolume(disk.Source); v != nil {
+					v.Capacity = uint64(req.Disk)
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// linkedCloneLocked mirrors LibvirtService.linkedClone: the new disks are
+// empty overlays on the template's. Caller must hold s.mu.
+func (s *SimService) linkedCloneLocked(src simDomain, newName string, p Progress) error {
+	if !src.template {
+		return precondition(CodeNotTemplate, "linked clones need a template as source, %s is not one", src.name)
+	}
+	if err := s.cloneLocked(src, newName, cloneOptions{format: "qcow2"}); err != nil {
+		return err
+	}
+	d := s.domains[newName]
+	d.linkedTo = src.name
+	for _, disk := range d.disks {
+		if v := s.findVolume(disk.Source); v != nil && disk.Device == "disk" {
+			v.Allocation, v.format = 0, "qcow2"
+			fmt.Fprintf(p, "overlay %s\n", v.Path)
+		}
+	}
+	return nil
+}
+
+// checkNoLinkedClones mirrors the libvirt check. Caller must hold s.mu.
+func (s *SimService) checkNoLinkedClones(name string) error {
+	var children []string
+	for _, d := range s.domains {
+		if d.linkedTo == name {
+			children = append(children, d.name)
+		}
+	}
+	if len(children) > 0 {
+		sort.Strings(children)
+		return conflict(CodeHasLinkedClones, "%s is the base of linked clones %s; flatten or delete them first", name, strings.Join(children, ", "))
+	}
+	return nil
+}
+
+func (s *SimService) FlattenVM(ctx context.Context, name string, p Progress) error {
+	release, err := s.ops.acquire("flatten", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s.mu.Lock()
+	d, err := s.lookupNotTemplate(name)
+	if err == nil && d.state != "shutoff" {
+		err = errNotShutoff
+	} else if err == nil && d.linkedTo == "" {
+		err = precondition(CodeNotLinkedClone, "vm is not a linked clone: %s", name)
+	} else if err == nil && len(d.snapshots) > 0 {
+		err = conflict(CodeInvalidState, "delete the snapshots of %s before flattening it", name)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := simCopy(ctx, p, "flattening "+name, time.Second); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, err = s.lookup(name); err != nil {
+		return err
+	}
+	d.linkedTo = ""
+	for _, disk := range d.disks {
+		if v := s.findVolume(disk.Source); v != nil && disk.Device == "disk" {
+			v.Allocation = 1
+		}
+	}
+	return nil
+}
+
+func (s *SimService) ExportOVA(ctx context.Context, name string) (*OVAExport, error) {
+	release, err := s.ops.acquire("export", name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if d.state != "shutoff" {
+		return nil, errNotShutoff
+	}
+	sys := &ovf.System{Name: name, CPUs: d.cpu, MemoryMiB: d.memory}
+	exp := &OVAExport{Name: name}
+	for _, disk := range d.disks {
+		if disk.Device == "cdrom" {
+			sys.Cdroms = append(sys.Cdroms, disk.Bus)
+			continue
+		}
+		var capacity uint64
+		if v := s.findVolume(disk.Source); v != nil {
+			capacity = v.Capacity << 30
+		}
+		// A stand-in for the converted image: just the VMDK header
+		data := make([]byte, 512)
+		copy(data, "KDMV")
+		binary.LittleEndian.PutUint64(data[12:], capacity>>9)
+		sum := sha256.Sum256(data)
+		file := fmt.Sprintf("%s-disk%d.vmdk", name, len(sys.Disks)+1)
+		exp.files = append(exp.files, ovf.File{Name: file, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:]), Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}})
+		sys.Disks = append(sys.Disks, ovf.Disk{File: file, Size: int64(len(data)), Capacity: capacity, Bus: disk.Bus})
+	}
+	for _, nic := range d.nics {
+		sys.NICs = append(sys.NICs, ovf.NIC{Model: nic.Model, Network: nic.Source})
+	}
+	if exp.descriptor, err = ovf.Build(sys); err != nil {
+		return nil, err
+	}
+	return exp, nil
+}
+
+func (s *SimService) ImportOVA(ctx context.Context, req model.ImportOVARequest, r io.Reader, size int64, p Progress) error {
+	if err := checkImportOVA(&req); err != nil {
+		return err
+	}
+	release, err := s.ops.acquire("import", req.Name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	poolName := req.Pool
+	if poolName == "" {
+		poolName = "default"
+	}
+	s.mu.Lock()
+	_, ok := s.pools[poolName]
+	s.mu.Unlock()
+	if !ok {
+		return notFound(CodePoolNotFound, "storage pool not found: %s", poolName)
+	}
+
+	or, err := ovf.NewReader(&progressReader{ctx: ctx, r: r, p: p, total: size})
+	if err != nil {
+		return ovaError(err)
+	}
+	sys := or.System
+	lay, err := planOVA(req, sys)
+	if err != nil {
+		return err
+	}
+	names, err := diskFiles(req, sys)
+	if err != nil {
+		return err
+	}
+	// Read every image to the end, which checks it against the manifest
+	for {
+		file, fr, err := or.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ovaError(err)
+		}
+		fmt.Fprintf(p, "converting %s\n", file)
+		if _, err := io.Copy(io.Discard, fr); err != nil {
+			return ovaError(err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.domains[req.Name]; ok {
+		return conflict(CodeAlreadyExists, "vm %s already exists", req.Name)
+	}
+	pool := s.pools[poolName]
+	if pool == nil {
+		return notFound(CodePoolNotFound, "storage pool not found: %s", poolName)
+	}
+	for _, name := range names {
+		if _, ok := pool.volumes[name]; ok {
+			return conflict(CodeAlreadyExists, "volume %s already exists in pool %s", name, pool.Name)
+		}
+	}
+	d := &simDomain{
+		name:   req.Name,
+		uuid:   simUUID(),
+		state:  "shutoff",
+		cpu:    lay.cpu,
+		memory: lay.memory,
+		arch:   "x86_64",
+		boot:   []string{"hd"},
+	}
+	for i, disk := range sys.Disks {
+		path := filepath.Join(pool.Path, names[i])
+		pool.volumes[names[i]] = &simVolume{
+			StorageVolume: model.StorageVolume{Name: names[i], Path: path, Type: "file", Capacity: max(disk.Capacity>>30, 1), Allocation: 1},
+			format:        req.Format,
+		}
+		d.disks = append(d.disks, model.VMDisk{Device: "disk", Source: path, Target: lay.disks[i].dev, Bus: lay.disks[i].bus, Format: req.Format})
+	}
+	for _, dev := range lay.cdroms {
+		d.disks = append(d.disks, model.VMDisk{Device: "cdrom", Target: dev.dev, Bus: dev.bus, Format: "raw"})
+	}
+	for _, m := range lay.nics {
+		d.nics = append(d.nics, model.VMNIC{Type: "network", Source: s.cfg.DefaultNetwork, MAC: simMAC(), Model: m})
+	}
+	s.domains[req.Name] = d
+	s.emit("domain", req.Name, "defined", "added")
+	p.SetProgress(100)
+	return nil
+}
+
+func (s *SimService) RenameVM(oldName, newName string) error {
+	release, err := s.ops.acquire("rename", oldName, newName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if !safeNameRe.MatchString(newName) {
+		return invalid(CodeInvalidName, "invalid vm name: %s", newName)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(oldName)
+	if err != nil {
+		return err
+	}
+	if d.state != "shutoff" {
+		return errNotShutoff
+	}
+	if _, ok := s.domains[newName]; ok {
+		return conflict(CodeAlreadyExists, "vm %s already exists", newName)
+	}
+	s.emit("domain", oldName, "undefined", "renamed")
+	delete(s.domains, oldName)
+	d.name = newName
+	s.domains[newName] = d
+	s.emit("domain", newName, "defined", "renamed")
+	return nil
+}
+
+func (s *SimService) GetAutostart(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(name)
+	if err != nil {
+		return false, err
+	}
+	return d.autostart, nil
+}
+
+func (s *SimService) SetAutostart(name string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(name)
+	if err != nil {
+		return err
+	}
+	d.autostart = enabled
+	return nil
+}
+
+func (s *SimService) SetVMOwner(name string, owner model.VMOwner) error {
+	release, err := s.ops.acquire("set_owner", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	d.owner, d.group = owner.Owner, owner.Group
+	return nil
+}
+
+func (s *SimService) AttachDisk(vmName string, req model.AttachDiskRequest) error {
+	cleanPath := filepath.Clean(req.Source)
+	if !s.cfg.PathAllowed(cleanPath) {
+		return invalid(CodeInvalidPath, "disk source must be under %s", strings.Join(s.cfg.AllowedRoots, ", "))
+	}
+	if req.Target == "" {
+		req.Target = "vdb"
+	}
+	if req.Bus == "" {
+		req.Bus = "virtio"
+	}
+	if !safeNameRe.MatchString(req.Target) {
+		return invalid(CodeInvalidArgument, "invalid target device: %s", req.Target)
+	}
+	validBus := map[string]bool{"virtio": true, "ide": true, "scsi": true, "sata": true}
+	if !validBus[req.Bus] {
+		return invalid(CodeInvalidArgument, "invalid bus type: %s", req.Bus)
+	}
+	format := "qcow2"
+	if strings.HasSuffix(req.Source, ".raw") || strings.HasSuffix(req.Source, ".img") {
+		format = "raw"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(vmName)
+	if err != nil {
+		return err
+	}
+	for _, disk := range d.disks {
+		if disk.Target == req.Target {
+			return conflict(CodeAlreadyExists, "Requested operation is not valid: target %s already exists", req.Target)
+		}
+	}
+	d.disks = append(d.disks, model.VMDisk{Device: "disk", Source: cleanPath, Target: req.Target, Bus: req.Bus, Format: format})
+	return nil
+}
+
+func (s *SimService) DetachDisk(vmName, target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(vmName)
+	if err != nil {
+		return err
+	}
+	for i, disk := range d.disks {
+		if disk.Target == target && disk.Device == "disk" {
+			d.disks = append(d.disks[:i], d.disks[i+1:]...)
+			return nil
+		}
+	}
+	return notFound(CodeDeviceNotFound, "disk %s not found", target)
+}
+
+func (s *SimService) AttachNIC(vmName string, req model.AttachNICRequest) error {
+	if req.Model == "" {
+		req.Model = "virtio"
+	}
+	validModel := map[string]bool{"virtio": true, "e1000": true, "rtl8139": true}
+	if !validModel[req.Model] {
+		return invalid(CodeInvalidArgument, "invalid nic model: %s", req.Model)
+	}
+	if req.Mode == "" {
+		req.Mode = "network"
+	}
+	nic := model.VMNIC{MAC: simMAC(), Model: req.Model}
+	switch req.Mode {
+	case "network":
+		if req.Network == "" {
+			req.Network = s.cfg.DefaultNetwork
+		}
+		nic.Type, nic.Source = "network", req.Network
+	case "bridge":
+		if req.Bridge == "" {
+			return invalid(CodeInvalidArgument, "bridge name required")
+		}
+		nic.Type, nic.Source = "bridge", req.Bridge
+	case "macvtap":
+		if req.Dev == "" {
+			return invalid(CodeInvalidArgument, "physical device required for macvtap")
+		}
+		nic.Type, nic.Source = "direct", req.Dev
+	default:
+		return invalid(CodeInvalidArgument, "unsupported mode: %s", req.Mode)
+	}
+	if !safeNameRe.MatchString(nic.Source) {
+		return invalid(CodeInvalidName, "invalid %s name: %s", req.Mode, nic.Source)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(vmName)
+	if err != nil {
+		return err
+	}
+	if nic.Type == "network" {
+		if _, ok := s.networks[nic.Source]; !ok {
+			return notFound(CodeNetworkNotFound, "Network not found: no network with matching name '%s'", nic.Source)
+		}
+	}
+	d.nics = append(d.nics, nic)
+	return nil
+}
+
+func (s *SimService) DetachNIC(vmName, mac string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(vmName)
+	if err != nil {
+		return err
+	}
+	for i, nic := range d.nics {
+		if nic.MAC == mac {
+			d.nics = append(d.nics[:i], d.nics[i+1:]...)
+			return nil
+		}
+	}
+	return notFound(CodeDeviceNotFound, "nic with mac %s not found", mac)
+}
+
+func (s *SimService) AttachISO(vmName string, isoPath string) error {
+	cleanPath := filepath.Clean(isoPath)
+	if !strings.HasPrefix(cleanPath, s.cfg.ISODir+"/") {
+		return invalid(CodeInvalidPath, "iso path must be under %s", s.cfg.ISODir)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(vmName)
+	if err != nil {
+		return err
+	}
+	attached := false
+	for i := range d.disks {
+		if d.disks[i].Device == "cdrom" {
+			d.disks[i].Source = cleanPath
+			attached = true
+			break
+		}
+	}
+	if !attached {
+		d.disks = append(d.disks, model.VMDisk{Device: "cdrom", Source: cleanPath, Target: "hda", Bus: "ide", Format: "raw"})
+	}
+	if !containsString(d.boot, "cdrom") {
+		d.boot = append([]string{"cdrom"}, d.boot...)
+	}
+	return nil
+}
+
+func (s *SimService) DetachISO(vmName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(vmName)
+	if err != nil {
+		return err
+	}
+	for i := range d.disks {
+		if d.disks[i].Device == "cdrom" {
+			d.disks[i].Source = ""
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *SimService) FinishInstall(vmName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(vmName)
+	if err != nil {
+		return err
+	}
+	for i := range d.disks {
+		if d.disks[i].Device == "cdrom" {
+			d.disks[i].Source = ""
+		}
+	}
+	d.boot = []string{"hd", "cdrom"}
+	return nil
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SimService) GetVNCPort(name string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	if d.vncPort == 0 {
+		return 0, conflict(CodeInvalidState, "vnc port not allocated (vm may not be running)")
+	}
+	return d.vncPort, nil
+}
+
+// OpenConsole returns a console that echoes what is typed and answers
+// every line with a login prompt.
+func (s *SimService) OpenConsole(name string) (io.ReadWriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if d.state != "running" {
+		return nil, conflict(CodeInvalidState, "no serial console (vm may not be running)")
+	}
+	return newSimConsole(name), nil
+}
+
+type simConsole struct {
+	prompt string
+	r      *io.PipeReader
+	w      *io.PipeWriter
+}
+
+func newSimConsole(name string) *simConsole {
+	r, w := io.Pipe()
+	c := &simConsole{prompt: "\r\n" + name + " login: ", r: r, w: w}
+	go w.Write([]byte("\r\nVirtPanel simulated console" + c.prompt))
+	return c
+}
+
+func (c *simConsole) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *simConsole) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		if b == '\r' || b == '\n' {
+			out = append(out, c.prompt...)
+		} else {
+			out = append(out, b)
+		}
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *simConsole) Close() error {
+	c.r.Close()
+	return c.w.Close()
+}
+
+func (s *SimService) ListSnapshots(vmName string) ([]model.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(vmName)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]model.Snapshot, 0, len(d.snapshots))
+	for _, snap := range d.snapshots {
+		result = append(result, model.Snapshot{
+			Name:        snap.name,
+			Description: snap.description,
+			State:       snap.state,
+			CreatedAt:   snap.createdAt,
+			IsCurrent:   snap.name == d.current,
+		})
+	}
+	return result, nil
+}
+
+// findSnapshot returns the named snapshot of d. Caller must hold s.mu.
+func (s *SimService) findSnapshot(d *simDomain, snapName string) (int, error) {
+	for i, snap := range d.snapshots {
+		if snap.name == snapName {
+			return i, nil
+		}
+	}
+	return -1, notFound(CodeSnapshotNotFound, "Domain snapshot not found: no domain snapshot with matching name '%s'", snapName)
+}
+
+func (s *SimService) CreateSnapshot(vmName string, req model.CreateSnapshotRequest) error {
+	release, err := s.ops.acquire("create_snapshot", vmName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if !safeNameRe.MatchString(req.Name) {
+		return invalid(CodeInvalidName, "invalid snapshot name: %s", req.Name)
+	}
+	s.mu.Lock()
+	d, err := s.lookupNotTemplate(vmName)
+	if err == nil {
+		if _, ferr := s.findSnapshot(d, req.Name); ferr == nil {
+			err = conflict(CodeAlreadyExists, "snapshot %s already exists", req.Name)
+		}
+	}
+	running := err == nil && d.state == "running"
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// Like libvirt, saving the memory of a running domain takes a while;
+	// only its op lock is held meanwhile
+	if running {
+		time.Sleep(simMemorySave)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, err = s.lookup(vmName); err != nil {
+		return err
+	}
+	d.snapshots = append(d.snapshots, &simSnapshot{
+		name:        req.Name,
+		description: req.Description,
+		state:       d.state,
+		createdAt:   time.Now().Unix(),
+		domain:      d.clone(),
+	})
+	d.current = req.Name
+	return nil
+}
+
+func (s *SimService) DeleteSnapshot(vmName, snapName string) error {
+	release, err := s.ops.acquire("delete_snapshot", vmName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(vmName)
+	if err != nil {
+		return err
+	}
+	i, err := s.findSnapshot(d, snapName)
+	if err != nil {
+		return err
+	}
+	d.snapshots = append(d.snapshots[:i], d.snapshots[i+1:]...)
+	if d.current == snapName {
+		d.current = ""
+	}
+	return nil
+}
+
+func (s *SimService) RevertSnapshot(vmName, snapName string) error {
+	release, err := s.ops.acquire("revert_snapshot", vmName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookupNotTemplate(vmName)
+	if err != nil {
+		return err
+	}
+	i, err := s.findSnapshot(d, snapName)
+	if err != nil {
+		return err
+	}
+	s.restoreLocked(d, d.snapshots[i])
+	return nil
+}
+
+// restoreLocked applies a snapshot's config and state to d. Caller must hold s.mu.
+func (s *SimService) restoreLocked(d *simDomain, snap *simSnapshot) {
+	saved := snap.domain.clone()
+	d.cpu, d.memory, d.boot, d.disks, d.nics = saved.cpu, saved.memory, saved.boot, saved.disks, saved.nics
+	switch {
+	case d.state != "running" && snap.state == "running":
+		s.emit("domain", d.name, "started", "from_snapshot")
+	case d.state != "shutoff" && snap.state == "shutoff":
+		s.emit("domain", d.name, "stopped", "from_snapshot")
+	}
+	d.state = snap.state
+	if d.state == "running" && d.vncPort == 0 {
+		d.vncPort = s.nextVNC
+		s.nextVNC++
+	} else if d.state == "shutoff" {
+		d.vncPort = 0
+	}
+	d.current = snap.name
+}
+
+func (s *SimService) RevertSnapshotToNew(ctx context.Context, vmName, snapName string, req model.RevertSnapshotToNewRequest, p Progress) error {
+	newName := req.NewName
+	release, err := s.ops.acquire("revert_to_new", vmName, newName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if !safeNameRe.MatchString(newName) {
+		return invalid(CodeInvalidName, "invalid vm name: %s", newName)
+	}
+	opts, err := fullCloneOptions(false, req.Pool, req.Format)
+	if err != nil {
+		return err
+	}
+	if _, err := s.GetVM(vmName); err != nil {
+		return err
+	}
+	if err := simCopy(ctx, p, "Cloning "+vmName+"@"+snapName, time.Second); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.lookup(vmName)
+	if err != nil {
+		return err
+	}
+	if d.state != "shutoff" {
+		return errNotShutoff
+	}
+	if err := s.checkNoLinkedClones(vmName); err != nil {
+		return err
+	}
+	i, err := s.findSnapshot(d, snapName)
+	if err != nil {
+		return err
+	}
+	src := d.snapshots[i].domain.clone()
+	src.state = "shutoff"
+	return s.cloneLocked(src, newName, opts)
+}
+
+func (s *SimService) ListNetworks() ([]model.Network, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.networks))
+	for n := range s.networks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	result := make([]model.Network, 0, len(names))
+	for _, n := range names {
+		result = append(result, s.networks[n].Network)
+	}
+	return result, nil
+}
+
+func (s *SimService) CreateNetwork(req model.CreateNetworkRequest) error {
+	if !safeNameRe.MatchString(req.Name) {
+		return invalid(CodeInvalidName, "invalid network name: %s", req.Name)
+	}
+	if req.Bridge == "" {
+		req.Bridge = "virbr-" + req.Name
+	}
+	if req.Subnet == "" {
+		req.Subnet = "192.168.100.1"
+	}
+	if req.DHCPStart == "" {
+		req.DHCPStart = "192.168.100.100"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.networks[req.Name]; ok {
+		return conflict(CodeAlreadyExists, "operation failed: network '%s' already exists", req.Name)
+	}
+	s.networks[req.Name] = &simNetwork{
+		Network: model.Network{
+			Name:    req.Name,
+			UUID:    simUUID(),
+			Active:  true,
+			Forward: "nat",
+			Bridge:  req.Bridge,
+			Subnet:  req.Subnet + "/24",
+		},
+		dhcpStart: req.DHCPStart,
+	}
+	s.emit("network", req.Name, "defined", "")
+	s.emit("network", req.Name, "started", "")
+	return nil
+}
+
+func (s *SimService) setNetworkActive(name string, active bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.networks[name]
+	if !ok {
+		return notFound(CodeNetworkNotFound, "Network not found: no network with matching name '%s'", name)
+	}
+	if n.Active == active {
+		if active {
+			return conflict(CodeInvalidState, "Requested operation is not valid: network is already active")
+		}
+		return conflict(CodeInvalidState, "Requested operation is not valid: network is not active")
+	}
+	n.Active = active
+	if active {
+		s.emit("network", name, "started", "")
+	} else {
+		s.emit("network", name, "stopped", "")
+	}
+	return nil
+}
+
+func (s *SimService) StartNetwork(name string) error { return s.setNetworkActive(name, true) }
+
+func (s *SimService) StopNetwork(name string) error { return s.setNetworkActive(name, false) }
+
+func (s *SimService) DeleteNetwork(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.networks[name]
+	if !ok {
+		return notFound(CodeNetworkNotFound, "Network not found: no network with matching name '%s'", name)
+	}
+	delete(s.networks, name)
+	if n.Active {
+		s.emit("network", name, "stopped", "")
+	}
+	s.emit("network", name, "undefined", "")
+	return nil
+}
+
+func (s *SimService) ListDHCPLeases(networkName string) ([]DHCPLease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.networks[networkName]
+	if !ok {
+		return nil, notFound(CodeNetworkNotFound, "Network not found: no network with matching name '%s'", networkName)
+	}
+	leases := []DHCPLease{}
+	if !n.Active {
+		return leases, nil
+	}
+	prefix := n.dhcpStart[:strings.LastIndex(n.dhcpStart, ".")+1]
+	var host int
+	fmt.Sscanf(n.dhcpStart[len(prefix):], "%d", &host)
+	for _, name := range s.domainNames() {
+		d := s.domains[name]
+		if d.state != "running" {
+			continue
+		}
+		for _, nic := range d.nics {
+			if nic.Type == "network" && nic.Source == networkName {
+				leases = append(leases, DHCPLease{IP: fmt.Sprintf("%s%d", prefix, host), MAC: nic.MAC, Hostname: d.name})
+				host++
+			}
+		}
+	}
+	return leases, nil
+}
+
+func (s *SimService) ListStoragePools() ([]model.StoragePool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.pools))
+	for n := range s.pools {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	result := make([]model.StoragePool, 0, len(names))
+	for _, n := range names {
+		p := s.pools[n]
+		sp := p.StoragePool
+		sp.Allocation, sp.Available = 0, 0
+		if sp.Active {
+			for _, v := range p.volumes {
+				sp.Allocation += v.Allocation
+			}
+			sp.Available = sp.Capacity - sp.Allocation
+		}
+		result = append(result, sp)
+	}
+	return result, nil
+}
+
+func (s *SimService) CreateStoragePool(req model.CreateStoragePoolRequest) error {
+	if !safeNameRe.MatchString(req.Name) {
+		return invalid(CodeInvalidName, "invalid pool name: %s", req.Name)
+	}
+	if req.Path == "" {
+		req.Path = filepath.Join(s.cfg.ImageDir, req.Name)
+	}
+	cleanPath := filepath.Clean(req.Path)
+	if !s.cfg.PathAllowed(cleanPath) {
+		return invalid(CodeInvalidPath, "pool path must be under %s", strings.Join(s.cfg.AllowedRoots, ", "))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pools[req.Name]; ok {
+		return conflict(CodeAlreadyExists, "operation failed: pool '%s' already exists", req.Name)
+	}
+	s.pools[req.Name] = &simPool{
+		StoragePool: model.StoragePool{Name: req.Name, UUID: simUUID(), Type: "dir", Path: cleanPath, Capacity: 500},
+		volumes:     make(map[string]*simVolume),
+	}
+	s.emit("pool", req.Name, "defined", "")
+	return nil
+}
+
+func (s *SimService) pool(name string) (*simPool, error) {
+	p, ok := s.pools[name]
+	if !ok {
+		return nil, notFound(CodePoolNotFound, "Storage pool not found: no storage pool with matching name '%s'", name)
+	}
+	return p, nil
+}
+
+func (s *SimService) StartStoragePool(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.pool(name)
+	if err != nil {
+		return err
+	}
+	if p.Active {
+		return conflict(CodeInvalidState, "Requested operation is not valid: storage pool '%s' is already active", name)
+	}
+	p.Active = true
+	s.emit("pool", name, "started", "")
+	return nil
+}
+
+func (s *SimService) StopStoragePool(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.pool(name)
+	if err != nil {
+		return err
+	}
+	if !p.Active {
+		return conflict(CodeInvalidState, "Requested operation is not valid: storage pool '%s' is not active", name)
+	}
+	p.Active = false
+	s.emit("pool", name, "stopped", "")
+	return nil
+}
+
+func (s *SimService) DeleteStoragePool(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.pool(name)
+	if err != nil {
+		return err
+	}
+	delete(s.pools, name)
+	if p.Active {
+		s.emit("pool", name, "stopped", "")
+	}
+	s.emit("pool", name, "undefined", "")
+	return nil
+}
+
+func (s *SimService) ListVolumes(poolName string) ([]model.StorageVolume, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.pool(poolName)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Active {
+		return nil, conflict(CodeInvalidState, "Requested operation is not valid: storage pool '%s' is not active", poolName)
+	}
+	names := make([]string, 0, len(p.volumes))
+	for n := range p.volumes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	result := make([]model.StorageVolume, 0, len(names))
+	for _, n := range names {
+		result = append(result, p.volumes[n].StorageVolume)
+	}
+	return result, nil
+}
+
+func (s *SimService) CreateVolume(req model.CreateVolumeRequest) error {
+	if !safeNameRe.MatchString(req.Name) {
+		return invalid(CodeInvalidName, "invalid volume name: %s", req.Name)
+	}
+	if req.Capacity <= 0 {
+		req.Capacity = 20
+	}
+	if req.Format == "" {
+		req.Format = "qcow2"
+	}
+	validFormat := map[string]bool{"qcow2": true, "raw": true, "vmdk": true, "vdi": true}
+	if !validFormat[req.Format] {
+		return invalid(CodeInvalidArgument, "invalid format: %s", req.Format)
+	}
+	ext := "." + req.Format
+	if !strings.HasSuffix(req.Name, ext) {
+		req.Name = req.Name + ext
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.pool(req.Pool)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.volumes[req.Name]; ok {
+		return conflict(CodeAlreadyExists, "storage volume name '%s' already in use.", req.Name)
+	}
+	alloc := uint64(1)
+	if req.Format == "raw" {
+		alloc = uint64(req.Capacity)
+	}
+	p.volumes[req.Name] = &simVolume{
+		StorageVolume: model.StorageVolume{
+			Name:       req.Name,
+			Path:       filepath.Join(p.Path, req.Name),
+			Type:       "file",
+			Capacity:   uint64(req.Capacity),
+			Allocation: alloc,
+		},
+		format: req.Format,
+	}
+	return nil
+}
+
+func (s *SimService) DeleteVolume(poolName, volName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.pool(poolName)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.volumes[volName]; !ok {
+		return notFound(CodeVolumeNotFound, "Storage volume not found: no storage vol with matching name '%s'", volName)
+	}
+	delete(p.volumes, volName)
+	return nil
+}
+
+func (s *SimService) ListISOs() ([]model.ISOFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.isos))
+	for n := range s.isos {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	result := make([]model.ISOFile, 0, len(names))
+	for _, n := range names {
+		result = append(result, model.ISOFile{Name: n, Path: filepath.Join(s.cfg.ISODir, n), Size: s.isos[n]})
+	}
+	return result, nil
+}
+
+func (s *SimService) UploadISO(ctx context.Context, filename string, reader io.Reader, size int64, p Progress) error {
+	filename = filepath.Base(filename)
+	if !strings.HasSuffix(strings.ToLower(filename), ".iso") {
+		return invalid(CodeInvalidName, "only .iso files allowed")
+	}
+	nameWithoutExt := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if !safeNameRe.MatchString(nameWithoutExt) {
+		return invalid(CodeInvalidName, "invalid filename: %s", filename)
+	}
+	s.mu.Lock()
+	_, exists := s.isos[filename]
+	s.mu.Unlock()
+	if exists {
+		return conflict(CodeAlreadyExists, "file already exists: %s", filename)
+	}
+	// Consume the upload but keep only its size
+	n, err := io.Copy(io.Discard, &progressReader{ctx: ctx, r: reader, p: p, total: size})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.isos[filename] = n
+	return nil
+}
+
+func (s *SimService) DeleteISO(filename string) error {
+	base := filepath.Base(filename)
+	if !strings.HasSuffix(strings.ToLower(base), ".iso") {
+		return invalid(CodeInvalidName, "not an iso file: %s", base)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.isos[base]; !ok {
+		return notFound(CodeFileNotFound, "iso not found: %s", base)
+	}
+	delete(s.isos, base)
+	return nil
+}
+
+func (s *SimService) ListBridges() ([]model.Bridge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.bridges))
+	for n := range s.bridges {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	result := make([]model.Bridge, 0, len(names))
+	for _, n := range names {
+		result = append(result, *s.bridges[n])
+	}
+	return result, nil
+}
+
+func (s *SimService) CreateBridge(req model.CreateBridgeRequest) error {
+	if !safeNameRe.MatchString(req.Name) {
+		return invalid(CodeInvalidName, "invalid bridge name: %s", req.Name)
+	}
+	name := s.cfg.BridgePrefix + req.Name
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.bridges[name]; ok {
+		return conflict(CodeAlreadyExists, "bridge %s already exists", name)
+	}
+	br := &model.Bridge{Name: name, Up: true}
+	if req.SlaveNIC != "" {
+		if !safeNameRe.MatchString(req.SlaveNIC) {
+			return invalid(CodeInvalidName, "invalid NIC name: %s", req.SlaveNIC)
+		}
+		br.Slaves = []string{req.SlaveNIC}
+	}
+	s.bridges[name] = br
+	return nil
+}
+
+func (s *SimService) DeleteBridge(name string) error {
+	full := s.cfg.BridgePrefix + name
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.bridges[full]; !ok {
+		return notFound(CodeBridgeNotFound, "bridge %s not found", full)
+	}
+	delete(s.bridges, full)
+	return nil
+}
+
+func (s *SimService) ListPortForwards() ([]PortForward, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PortForward{}, s.pfs...), nil
+}
+
+func (s *SimService) AddPortForward(pf PortForward) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end := pf.HostPort
+	if pf.HostPortEnd > 0 {
+		end = pf.HostPortEnd
+	}
+	for _, r := range s.pfs {
+		rEnd := r.HostPort
+		if r.HostPortEnd > 0 {
+			rEnd = r.HostPortEnd
+		}
+		if r.Protocol == pf.Protocol && pf.HostPort <= rEnd && end >= r.HostPort {
+			return conflict(CodePortInUse, "port range overlaps an existing rule")
+		}
+	}
+	pf.ID = fmt.Sprintf("%s-%d-%d-%s-%d", pf.Protocol, pf.HostPort, end, pf.VMIP, pf.VMPort)
+	s.pfs = append(s.pfs, pf)
+	return nil
+}
+
+func (s *SimService) DeletePortForward(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.pfs {
+		if r.ID == id {
+			s.pfs = append(s.pfs[:i], s.pfs[i+1:]...)
+			return nil
+		}
+	}
+	return notFound(CodePortForwardMissing, "port forward not found")
+}
+
+func (s *SimService) RestorePortForwards() {}
@@ -1,10 +1,10 @@
 package service
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"html"
-	"os/exec"
 
 	"virtpanel/internal/model"
 
@@ -20,25 +20,24 @@ type snapshotXML struct {
 }
 
 func (s *LibvirtService) ListSnapshots(vmName string) ([]model.Snapshot, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return nil, err
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	d, err := l.DomainLookupByName(vmName)
 	if err != nil {
 		return nil, err
 	}
-	snaps, _, err := s.l.DomainListAllSnapshots(d, -1, 0)
+	snaps, _, err := l.DomainListAllSnapshots(d, -1, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	current, currentErr := s.l.DomainSnapshotCurrent(d, 0)
+	current, currentErr := l.DomainSnapshotCurrent(d, 0)
 
 	result := make([]model.Snapshot, 0, len(snaps))
 	for _, snap := range snaps {
-		xmlStr, err := s.l.DomainSnapshotGetXMLDesc(snap, 0)
+		xmlStr, err := l.DomainSnapshotGetXMLDesc(snap, 0)
 		if err != nil {
 			continue
 		}
@@ -56,126 +55,137 @@ func (s *LibvirtService) ListSnapshots(vmName string) ([]model.Snapshot, error)
 }
 
 func (s *LibvirtService) CreateSnapshot(vmName string, req model.CreateSnapshotRequest) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("create_snapshot", vmName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
 	if !safeNameRe.MatchString(req.Name) {
-		return fmt.Errorf("invalid snapshot name: %s", req.Name)
+		return invalid(CodeInvalidName, "invalid snapshot name: %s", req.Name)
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	d, err := lookupNotTemplate(l, vmName)
 	if err != nil {
 		return err
 	}
+	if _, err := l.DomainSnapshotLookupByName(d, req.Name, 0); err == nil {
+		return conflict(CodeAlreadyExists, "snapshot %s already exists", req.Name)
+	}
 	xmlDef := fmt.Sprintf(`<domainsnapshot><name>%s</name><description>%s</description></domainsnapshot>`, req.Name, html.EscapeString(req.Description))
-	_, err = s.l.DomainSnapshotCreateXML(d, xmlDef, 0)
+	_, err = l.DomainSnapshotCreateXML(d, xmlDef, 0)
 	return err
 }
 
 func (s *LibvirtService) DeleteSnapshot(vmName, snapName string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("delete_snapshot", vmName)
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	defer release()
+	l, err := s.conn()
 	if err != nil {
 		return err
 	}
-	snap, err := s.l.DomainSnapshotLookupByName(d, snapName, 0)
+	d, err := lookupNotTemplate(l, vmName)
 	if err != nil {
 		return err
 	}
-	return s.l.DomainSnapshotDelete(snap, 0)
+	snap, err := l.DomainSnapshotLookupByName(d, snapName, 0)
+	if err != nil {
+		return err
+	}
+	return l.DomainSnapshotDelete(snap, 0)
 }
 
 func (s *LibvirtService) RevertSnapshot(vmName, snapName string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("revert_snapshot", vmName)
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	defer release()
+	l, err := s.conn()
 	if err != nil {
 		return err
 	}
-	snap, err := s.l.DomainSnapshotLookupByName(d, snapName, 0)
+	d, err := lookupNotTemplate(l, vmName)
 	if err != nil {
 		return err
 	}
-	return s.l.DomainRevertToSnapshot(snap, 0)
+	snap, err := l.DomainSnapshotLookupByName(d, snapName, 0)
+	if err != nil {
+		return err
+	}
+	return l.DomainRevertToSnapshot(snap, 0)
 }
 
 // RevertSnapshotToNew reverts a snapshot and clones the result to a new VM.
 // Steps: revert snapshot -> clone to newName -> revert back to current snapshot.
-func (s *LibvirtService) RevertSnapshotToNew(vmName, snapName, newName string) error {
+func (s *LibvirtService) RevertSnapshotToNew(ctx context.Context, vmName, snapName string, req model.RevertSnapshotToNewRequest, p Progress) error {
+	newName := req.NewName
 	if !safeNameRe.MatchString(newName) {
-		return fmt.Errorf("invalid vm name: %s", newName)
+		return invalid(CodeInvalidName, "invalid vm name: %s", newName)
+	}
+	opts, err := fullCloneOptions(false, req.Pool, req.Format)
+	if err != nil {
+		return err
+	}
+
+	release, err := s.ops.acquire("revert_to_new", vmName, newName)
+	if err != nil {
+		return err
 	}
+	defer release()
 
 	// 1. Check VM is shut off
-	s.mu.Lock()
-	if err := s.ensureConnected(); err != nil {
-		s.mu.Unlock()
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	d, err := l.DomainLookupByName(vmName)
 	if err != nil {
-		s.mu.Unlock()
 		return err
 	}
-	state, _, _, _, _, err := s.l.DomainGetInfo(d)
+	state, _, _, _, _, err := l.DomainGetInfo(d)
 	if err != nil {
-		s.mu.Unlock()
 		return err
 	}
 	if libvirt.DomainState(state) != libvirt.DomainShutoff {
-		s.mu.Unlock()
-		return fmt.Errorf("虚拟机必须处于关机状态才能执行此操作")
+		return errNotShutoff
 	}
-	currentSnap, currentErr := s.l.DomainSnapshotCurrent(d, 0)
+	if err := checkNameFree(l, newName); err != nil {
+		return err
+	}
+	// Reverting rewrites the disks linked clones read from
+	if err := checkNoLinkedClones(l, vmName); err != nil {
+		return err
+	}
+	currentSnap, currentErr := l.DomainSnapshotCurrent(d, 0)
 
 	// 2. Revert to target snapshot
-	snap, err := s.l.DomainSnapshotLookupByName(d, snapName, 0)
+	snap, err := l.DomainSnapshotLookupByName(d, snapName, 0)
 	if err != nil {
-		s.mu.Unlock()
 		return err
 	}
-	if err := s.l.DomainRevertToSnapshot(snap, 0); err != nil {
-		s.mu.Unlock()
+	if err := l.DomainRevertToSnapshot(snap, 0); err != nil {
 		return fmt.Errorf("revert failed: %w", err)
 	}
-	s.mu.Unlock()
-
-	// 3. Clone (runs outside lock, may be slow)
-	cmd := exec.Command("virt-clone", "--original", vmName, "--name", newName, "--auto-clone")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		// Try to restore original state before returning error
-		s.mu.Lock()
-		if currentErr == nil {
-			d2, _ := s.l.DomainLookupByName(vmName)
-			cs, _ := s.l.DomainSnapshotLookupByName(d2, currentSnap.Name, 0)
-			s.l.DomainRevertToSnapshot(cs, 0)
-		}
-		s.mu.Unlock()
-		return fmt.Errorf("clone failed: %s", string(output))
-	}
 
-	// 4. Restore original VM to its previous snapshot
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
-		return nil // clone succeeded, just can't restore — acceptable
-	}
+	// 3. Clone (may be slow; the op lock keeps other changes to vmName out)
+	cloneErr := s.cloneFrom(ctx, l, vmName, newName, opts, p)
+
+	// 4. Restore original VM to its previous snapshot, also after a failed
+	// clone. If that is impossible a successful clone is still kept.
 	if currentErr == nil {
-		d2, err := s.l.DomainLookupByName(vmName)
-		if err == nil {
-			cs, err := s.l.DomainSnapshotLookupByName(d2, currentSnap.Name, 0)
-			if err == nil {
-				s.l.DomainRevertToSnapshot(cs, 0)
+		if l, err := s.conn(); err == nil {
+			if d2, err := l.DomainLookupByName(vmName); err == nil {
+				if cs, err := l.DomainSnapshotLookupByName(d2, currentSnap.Name, 0); err == nil {
+					l.DomainRevertToSnapshot(cs, 0)
+				}
 			}
 		}
 	}
-	return nil
+	return cloneErr
 }
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"virtpanel/internal/model"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+const guestStatsTypes = libvirt.DomainStatsState | libvirt.DomainStatsCPUTotal | libvirt.DomainStatsBalloon |
+	libvirt.DomainStatsVCPU | libvirt.DomainStatsInterface | libvirt.DomainStatsBlock
+
+// GuestStats reads the counters of every domain with one
+// virConnectGetAllDomainStats call.
+func (s *LibvirtService) GuestStats() ([]model.GuestStats, error) {
+	return s.guestStats(nil)
+}
+
+// guestStats reads the counters of doms, or of every domain when doms is
+// empty.
+func (s *LibvirtService) guestStats(doms []libvirt.Domain) ([]model.GuestStats, error) {
+	l, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	recs, err := l.ConnectGetAllDomainStats(doms, uint32(guestStatsTypes), 0)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]model.GuestStats, 0, len(recs))
+	for _, rec := range recs {
+		stats = append(stats, parseGuestStats(rec))
+	}
+	return stats, nil
+}
+
+// parseGuestStats maps the typed parameters of one record, e.g.
+// "cpu.time", "balloon.rss", "block.0.rd.bytes" and "net.1.tx.pkts".
+func parseGuestStats(rec libvirt.DomainStatsRecord) model.GuestStats {
+	g := model.GuestStats{Name: rec.Dom.Name, UUID: fmt.Sprintf("%x", rec.Dom.UUID)}
+	blocks := map[int]*model.BlockStats{}
+	nics := map[int]*model.NICStats{}
+	var nBlocks, nNICs int
+	for _, p := range rec.Params {
+		v := paramUint(p.Value)
+		switch p.Field {
+		case "state.state":
+			g.State = stateName(libvirt.DomainState(v))
+			continue
+		case "cpu.time":
+			g.CPUTime = v
+			continue
+		case "vcpu.current":
+			g.VCPUs = int(v)
+			continue
+		case "block.count":
+			nBlocks = int(v)
+			continue
+		case "net.count":
+			nNICs = int(v)
+			continue
+		}
+		if field, ok := strings.CutPrefix(p.Field, "balloon."); ok {
+			b := &g.Balloon
+			dst := map[string]*uint64{
+				"current": &b.Current, "maximum": &b.Maximum, "available": &b.Available,
+				"unused": &b.Unused, "usable": &b.Usable, "rss": &b.RSS,
+				"swap_in": &b.SwapIn, "swap_out": &b.SwapOut,
+				"major_fault": &b.MajorFaults, "minor_fault": &b.MinorFaults,
+			}[field]
+			if dst != nil {
+				*dst = v
+			}
+			continue
+		}
+		kind, idx, field, ok := splitIndexed(p.Field)
+		if !ok {
+			continue
+		}
+		switch kind {
+		case "block":
+			b := blocks[idx]
+			if b == nil {
+				b = &model.BlockStats{}
+				blocks[idx] = b
+			}
+			switch field {
+			case "name":
+				b.Device = paramString(p.Value)
+			case "path":
+				b.Path = paramString(p.Value)
+			case "rd.bytes":
+				b.ReadBytes = v
+			case "rd.reqs":
+				b.ReadReqs = v
+			case "wr.bytes":
+				b.WriteBytes = v
+			case "wr.reqs":
+				b.WriteReqs = v
+			case "rd.times":
+				b.ReadTime = v
+			case "wr.times":
+				b.WriteTime = v
+			case "capacity":
+				b.Capacity = v
+			case "allocation":
+				b.Allocation = v
+			}
+		case "net":
+			n := nics[idx]
+			if n == nil {
+				n = &model.NICStats{}
+				nics[idx] = n
+			}
+			switch field {
+			case "name":
+				n.Device = paramString(p.Value)
+			case "rx.bytes":
+				n.RxBytes = v
+			case "rx.pkts":
+				n.RxPackets = v
+			case "rx.errs":
+				n.RxErrors = v
+			case "rx.drop":
+				n.RxDrops = v
+			case "tx.bytes":
+				n.TxBytes = v
+			case "tx.pkts":
+				n.TxPackets = v
+			case "tx.errs":
+				n.TxErrors = v
+			case "tx.drop":
+				n.TxDrops = v
+			}
+		}
+	}
+	for i := 0; i < nBlocks; i++ {
+		if b := blocks[i]; b != nil {
+			g.Blocks = append(g.Blocks, *b)
+		}
+	}
+	for i := 0; i < nNICs; i++ {
+		if n := nics[i]; n != nil {
+			g.NICs = append(g.NICs, *n)
+		}
+	}
+	return g
+}
+
+// splitIndexed splits "block.0.rd.bytes" into "block", 0, "rd.bytes".
+func splitIndexed(field string) (kind string, idx int, rest string, ok bool) {
+	parts := strings.SplitN(field, ".", 3)
+	if len(parts) != 3 {
+		return "", 0, "", false
+	}
+	idx, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, "", false
+	}
+	return parts[0], idx, parts[2], true
+}
+
+func paramUint(v libvirt.TypedParamValue) uint64 {
+	switch n := v.I.(type) {
+	case int32:
+		return uint64(n)
+	case uint32:
+		return uint64(n)
+	case int64:
+		return uint64(n)
+	case uint64:
+		return n
+	case float64:
+		return uint64(n)
+	}
+	return 0
+}
+
+func paramString(v libvirt.TypedParamValue) string {
+	s, _ := v.I.(string)
+	return s
+}
@@ -18,22 +18,21 @@ type poolXML struct {
 }
 
 func (s *LibvirtService) ListStoragePools() ([]model.StoragePool, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return nil, err
 	}
-	pools, _, err := s.l.ConnectListAllStoragePools(-1, 0)
+	pools, _, err := l.ConnectListAllStoragePools(-1, 0)
 	if err != nil {
 		return nil, err
 	}
 	result := make([]model.StoragePool, 0, len(pools))
 	for _, p := range pools {
-		active, err := s.l.StoragePoolIsActive(p)
+		active, err := l.StoragePoolIsActive(p)
 		if err != nil {
 			continue
 		}
-		xmlStr, err := s.l.StoragePoolGetXMLDesc(p, 0)
+		xmlStr, err := l.StoragePoolGetXMLDesc(p, 0)
 		if err != nil {
 			continue
 		}
@@ -49,7 +48,7 @@ func (s *LibvirtService) ListStoragePools() ([]model.StoragePool, error) {
 		}
 
 		if active == 1 {
-			_, capacity, allocation, available, err := s.l.StoragePoolGetInfo(p)
+			_, capacity, allocation, available, err := l.StoragePoolGetInfo(p)
 			if err == nil {
 				const gb = 1024 * 1024 * 1024
 				sp.Capacity = (capacity + gb - 1) / gb
@@ -63,60 +62,59 @@ func (s *LibvirtService) ListStoragePools() ([]model.StoragePool, error) {
 }
 
 func (s *LibvirtService) StartStoragePool(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	p, err := s.l.StoragePoolLookupByName(name)
+	p, err := l.StoragePoolLookupByName(name)
 	if err != nil {
 		return err
 	}
-	return s.l.StoragePoolCreate(p, 0)
+	return l.StoragePoolCreate(p, 0)
 }
 
 func (s *LibvirtService) StopStoragePool(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	p, err := s.l.StoragePoolLookupByName(name)
+	p, err := l.StoragePoolLookupByName(name)
 	if err != nil {
 		return err
 	}
-	return s.l.StoragePoolDestroy(p)
+	return l.StoragePoolDestroy(p)
 }
 
 func (s *LibvirtService) DeleteStoragePool(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	p, err := s.l.StoragePoolLookupByName(name)
+	p, err := l.StoragePoolLookupByName(name)
 	if err != nil {
 		return err
 	}
-	_ = s.l.StoragePoolDestroy(p)
-	return s.l.StoragePoolUndefine(p)
+	_ = l.StoragePoolDestroy(p)
+	return l.StoragePoolUndefine(p)
 }
 
 func (s *LibvirtService) CreateStoragePool(req model.CreateStoragePoolRequest) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
 	if !safeNameRe.MatchString(req.Name) {
-		return fmt.Errorf("invalid pool name: %s", req.Name)
+		return invalid(CodeInvalidName, "invalid pool name: %s", req.Name)
 	}
 	if req.Path == "" {
-		req.Path = "/var/lib/libvirt/images/" + req.Name
+		req.Path = filepath.Join(s.cfg.ImageDir, req.Name)
 	}
 	cleanPath := filepath.Clean(req.Path)
-	if !strings.HasPrefix(cleanPath, "/var/lib/libvirt/") {
-		return fmt.Errorf("pool path must be under /var/lib/libvirt/")
+	if !s.cfg.PathAllowed(cleanPath) {
+		return invalid(CodeInvalidPath, "pool path must be under %s", strings.Join(s.cfg.AllowedRoots, ", "))
+	}
+	if strings.ContainsAny(cleanPath, `<>&'"`) {
+		return invalid(CodeInvalidPath, "pool path contains invalid characters")
 	}
 
 	xmlDef := fmt.Sprintf(`<pool type='dir'>
@@ -126,6 +124,6 @@ func (s *LibvirtService) CreateStoragePool(req model.CreateStoragePoolRequest) e
   </target>
 </pool>`, req.Name, cleanPath)
 
-	_, err := s.l.StoragePoolDefineXML(xmlDef, 0)
+	_, err = l.StoragePoolDefineXML(xmlDef, 0)
 	return err
 }
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"virtpanel/internal/cloudinit"
+	"virtpanel/internal/domxml"
+	"virtpanel/internal/model"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// ErrIsTemplate rejects power actions and edits on a template; it must be
+// turned back into a normal VM first.
+var ErrIsTemplate = &Error{Kind: Conflict, Code: CodeIsTemplate, Msg: "vm is a template"}
+
+// SetTemplate marks a shut-off VM as a template or turns it back into a
+// normal VM. Templates never autostart, and stay templates while linked
+// clones use them.
+func (s *LibvirtService) SetTemplate(name string, template bool) error {
+	release, err := s.ops.acquire("set_template", name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	d, err := l.DomainLookupByName(name)
+	if err != nil {
+		return err
+	}
+	var elem libvirt.OptString
+	if !template {
+		if err := checkNoLinkedClones(l, name); err != nil {
+			return err
+		}
+	} else {
+		state, _, _, _, _, err := l.DomainGetInfo(d)
+		if err != nil {
+			return err
+		}
+		if libvirt.DomainState(state) != libvirt.DomainShutoff {
+			return errNotShutoff
+		}
+		if err := l.DomainSetAutostart(d, 0); err != nil {
+			return err
+		}
+		elem = libvirt.OptString{domxml.TemplateElement}
+	}
+	return setTemplateMark(l, d, elem)
+}
+
+// lookupNotTemplate looks up a domain for a power action or an edit and
+// refuses templates. Callers hold the domain's op lock, which SetTemplate
+// takes as well, so it cannot become a template before they are done.
+func lookupNotTemplate(l *libvirt.Libvirt, name string) (libvirt.Domain, error) {
+	d, err := l.DomainLookupByName(name)
+	if err != nil {
+		return d, err
+	}
+	xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	if err != nil {
+		return d, err
+	}
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return d, err
+	}
+	if dx.IsTemplate() {
+		return d, ErrIsTemplate
+	}
+	return d, nil
+}
+
+func setTemplateMark(l *libvirt.Libvirt, d libvirt.Domain, elem libvirt.OptString) error {
+	return l.DomainSetMetadata(d, int32(libvirt.DomainMetadataElement), elem,
+		libvirt.OptString{domxml.TemplatePrefix}, libvirt.OptString{domxml.TemplateNS}, libvirt.DomainAffectConfig)
+}
+
+// CreateFromTemplate copies a template's definition and disks into a new
+// VM, then applies the overrides of req. The copy is removed again if an
+// override fails.
+func (s *LibvirtService) CreateFromTemplate(ctx context.Context, template string, req model.CreateFromTemplateRequest, p Progress) error {
+	if !safeNameRe.MatchString(req.Name) {
+		return invalid(CodeInvalidName, "invalid vm name: %s", req.Name)
+	}
+	if req.CPU < 0 || req.Memory < 0 || req.Disk < 0 {
+		return invalid(CodeInvalidArgument, "cpu, memory and disk cannot be negative")
+	}
+	opts, err := fullCloneOptions(req.Linked, req.Pool, req.Format)
+	if err != nil {
+		return err
+	}
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	release, err := s.ops.acquire("provision", template, req.Name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := checkNameFree(l, req.Name); err != nil {
+		return err
+	}
+	d, err := l.DomainLookupByName(template)
+	if err != nil {
+		return err
+	}
+	xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	if err != nil {
+		return err
+	}
+	tx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return fmt.Errorf("parse domain xml: %w", err)
+	}
+	if !tx.IsTemplate() {
+		return precondition(CodeNotTemplate, "vm is not a template: %s", template)
+	}
+
+	// Check every override before copying any disk
+	var iface *domxml.Interface
+	if req.NetMode != "" {
+		netModel := req.NetModel
+		if netModel == "" {
+			netModel = "virtio"
+			if tx.Devices != nil && len(tx.Devices.Interfaces) > 0 && tx.Devices.Interfaces[0].ModelType() != "" {
+				netModel = tx.Devices.Interfaces[0].ModelType()
+			}
+		}
+		nic, err := s.newNIC(req.NetMode, req.BridgeName, req.MacvtapDev, netModel)
+		if err != nil {
+			return err
+		}
+		iface = &nic
+	} else if req.NetModel != "" {
+		if _, err := domxml.NewInterface("network", s.cfg.DefaultNetwork, req.NetModel); err != nil {
+			return &Error{Kind: Invalid, Code: CodeInvalidArgument, Err: err}
+		}
+	}
+	var seed []byte
+	if req.CloudInit != nil {
+		if seed, err = cloudinit.Seed(req.Name, req.CloudInit); err != nil {
+			return &Error{Kind: Invalid, Code: CodeInvalidArgument, Err: err}
+		}
+	}
+	var newSize uint64
+	if req.Disk > 0 {
+		disk := firstDisk(tx)
+		if disk == nil {
+			return invalid(CodeInvalidArgument, "template has no disk to resize")
+		}
+		size, err := s.diskCapacity(ctx, disk.SourceFile())
+		if err != nil {
+			return err
+		}
+		newSize = uint64(req.Disk) << 30
+		if newSize < size {
+			return invalid(CodeInvalidArgument, "disk cannot shrink below %d GB", size>>30)
+		}
+	}
+
+	if req.Linked {
+		err = s.linkedClone(ctx, l, xmlStr, req.Name, p)
+	} else {
+		err = s.cloneDomain(ctx, l, xmlStr, req.Name, opts, p)
+	}
+	if err != nil {
+		return err
+	}
+	p.SetProgress(90)
+	if err := s.customizeCopy(ctx, l, req, iface, seed, newSize, p); err != nil {
+		if derr := s.deleteVM(l, req.Name); derr != nil {
+			return fmt.Errorf("%w (cleanup failed: %v)", err, derr)
+		}
+		return err
+	}
+	return nil
+}
+
+// customizeCopy applies the overrides of a template copy and clears the
+// template mark it inherited.
+func (s *LibvirtService) customizeCopy(ctx context.Context, l *libvirt.Libvirt, req model.CreateFromTemplateRequest, iface *domxml.Interface, seed []byte, size uint64, p Progress) error {
+	d, err := l.DomainLookupByName(req.Name)
+	if err != nil {
+		return err
+	}
+	if err := setTemplateMark(l, d, libvirt.OptString{}); err != nil {
+		return err
+	}
+	xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	if err != nil {
+		return err
+	}
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return fmt.Errorf("parse domain xml: %w", err)
+	}
+	if req.CPU > 0 {
+		dx.SetVCPUs(req.CPU)
+	}
+	if req.Memory > 0 {
+		dx.SetMemoryMiB(req.Memory)
+	}
+	if dx.Devices == nil {
+		dx.Devices = &domxml.Devices{}
+	}
+	if iface != nil {
+		dx.Devices.Interfaces = []domxml.Interface{*iface}
+	} else if req.NetModel != "" {
+		for i := range dx.Devices.Interfaces {
+			dx.Devices.Interfaces[i].Model = &domxml.Model{Type: req.NetModel}
+		}
+	}
+
+	// The copy comes with the template's seed drive emptied; put the new
+	// seed in an empty drive
+	seedPath := filepath.Join(s.cfg.ImageDir, req.Name+seedSuffix)
+	if seed != nil {
+		if seedDrive := emptyCdrom(dx); seedDrive != nil {
+			seedDrive.Source = &domxml.DiskSource{File: seedPath}
+		} else {
+			dev, bus := freeCdromTarget(dx)
+			dx.Devices.Disks = append(dx.Devices.Disks, domxml.FileDisk("cdrom", seedPath, "raw", dev, bus))
+		}
+		if err := s.writeImage(seedPath, seed); err != nil {
+			return err
+		}
+		fmt.Fprintf(p, "cloud-init seed: %s\n", seedPath)
+	}
+
+	newXML, err := dx.Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := l.DomainDefineXML(newXML); err != nil {
+		if seed != nil {
+			s.removeDisk(seedPath)
+		}
+		return err
+	}
+	if size > 0 {
+		return s.resizeDisk(ctx, firstDisk(dx).SourceFile(), size)
+	}
+	return nil
+}
+
+// firstDisk returns the first file-backed disk, the one CreateVM makes.
+func firstDisk(dx *domxml.Domain) *domxml.Disk {
+	if dx.Devices == nil {
+		return nil
+	}
+	for i := range dx.Devices.Disks {
+		if disk := &dx.Devices.Disks[i]; disk.Device == "disk" && disk.SourceFile() != "" {
+			return disk
+		}
+	}
+	return nil
+}
+
+// emptyCdrom returns the first cdrom drive without media.
+func emptyCdrom(dx *domxml.Domain) *domxml.Disk {
+	for _, cd := range dx.Cdroms() {
+		if cd.Source == nil {
+			return cd
+		}
+	}
+	return nil
+}
+
+// freeCdromTarget picks an unused target for a new cdrom on the bus the
+// domain's cdroms already use, or the bus CreateVM would pick.
+func freeCdromTarget(dx *domxml.Domain) (dev, bus string) {
+	bus = "ide"
+	if dx.IsQ35() {
+		bus = "sata"
+	}
+	if cds := dx.Cdroms(); len(cds) > 0 && cds[0].Target != nil && cds[0].Target.Bus != "" {
+		bus = cds[0].Target.Bus
+	}
+	prefix := map[string]string{"ide": "hd", "sata": "sd", "scsi": "sd", "virtio": "vd"}[bus]
+	if prefix == "" {
+		prefix = "hd"
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		if dev = prefix + string(c); dx.FindDisk(dev) == nil {
+			return dev, bus
+		}
+	}
+	return dev, bus
+}
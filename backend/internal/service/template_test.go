@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"virtpanel/internal/model"
+)
+
+func TestTemplateReadOnly(t *testing.T) {
+	s := newTestSim(t, "base")
+	if err := s.SetTemplate("base", true); err != nil {
+		t.Fatal(err)
+	}
+	calls := map[string]error{
+		"start":    s.StartVM("base"),
+		"update":   s.UpdateVM("base", model.UpdateVMRequest{CPU: 2}),
+		"rename":   s.RenameVM("base", "other"),
+		"snapshot": s.CreateSnapshot("base", model.CreateSnapshotRequest{Name: "snap1"}),
+		"nic":      s.AttachNIC("base", model.AttachNICRequest{}),
+		"flatten":  s.FlattenVM(context.Background(), "base", nopProgress{io.Discard}),
+	}
+	for name, err := range calls {
+		if !errors.Is(err, ErrIsTemplate) {
+			t.Errorf("%s: got %v, want ErrIsTemplate", name, err)
+		}
+	}
+	if kind, code := Classify(ErrIsTemplate); kind != Conflict || code != CodeIsTemplate {
+		t.Errorf("classify: got %v %s", kind, code)
+	}
+
+	if err := s.SetTemplate("base", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StartVM("base"); err != nil {
+		t.Errorf("start after unmarking: %v", err)
+	}
+}
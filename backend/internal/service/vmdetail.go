@@ -1,172 +1,122 @@
 package service
 
 import (
-	"encoding/xml"
+	"context"
 	"fmt"
 	"net"
+	"virtpanel/internal/domxml"
 	"virtpanel/internal/model"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"time"
 
 	libvirt "github.com/digitalocean/go-libvirt"
 )
 
-// ensureBridge creates a bridge and slaves the physical NIC if it doesn't exist.
-func ensureBridge(brName string) error {
+// ensureBridge checks that the bridge exists, with or without the panel prefix.
+func (s *LibvirtService) ensureBridge(brName string) error {
+	if !s.local {
+		return nil // remote interfaces are not visible here; libvirt will complain
+	}
 	if _, err := net.InterfaceByName(brName); err == nil {
 		return nil // already exists
 	}
 	// Also check with vp- prefix
-	if _, err := net.InterfaceByName(bridgePrefix + brName); err == nil {
+	if _, err := net.InterfaceByName(s.cfg.BridgePrefix + brName); err == nil {
 		return nil
 	}
-	return fmt.Errorf("网桥 %s 不存在，请先在网桥管理中创建", brName)
-}
-
-// Full domain XML structs for detail parsing
-type detailDomainXML struct {
-	XMLName xml.Name `xml:"domain"`
-	Name    string   `xml:"name"`
-	VCPU    int      `xml:"vcpu"`
-	Memory  struct {
-		Value int    `xml:",chardata"`
-		Unit  string `xml:"unit,attr"`
-	} `xml:"memory"`
-	OS struct {
-		Type struct {
-			Arch string `xml:"arch,attr"`
-		} `xml:"type"`
-		Boot []struct {
-			Dev string `xml:"dev,attr"`
-		} `xml:"boot"`
-	} `xml:"os"`
-	Devices struct {
-		Disks      []detailDiskXML      `xml:"disk"`
-		Interfaces []detailInterfaceXML `xml:"interface"`
-	} `xml:"devices"`
-}
-
-type detailDiskXML struct {
-	Device string `xml:"device,attr"`
-	Driver struct {
-		Type string `xml:"type,attr"`
-	} `xml:"driver"`
-	Source struct {
-		File string `xml:"file,attr"`
-	} `xml:"source"`
-	Target struct {
-		Dev string `xml:"dev,attr"`
-		Bus string `xml:"bus,attr"`
-	} `xml:"target"`
-}
-
-type detailInterfaceXML struct {
-	Type   string `xml:"type,attr"`
-	Source struct {
-		Network string `xml:"network,attr"`
-		Bridge  string `xml:"bridge,attr"`
-		Dev     string `xml:"dev,attr"`
-	} `xml:"source"`
-	MAC struct {
-		Address string `xml:"address,attr"`
-	} `xml:"mac"`
-	Model struct {
-		Type string `xml:"type,attr"`
-	} `xml:"model"`
-}
-
-func bootOrder(boots []struct{ Dev string `xml:"dev,attr"` }) string {
-	devs := make([]string, 0, len(boots))
-	for _, b := range boots {
-		devs = append(devs, b.Dev)
-	}
-	return strings.Join(devs, ", ")
+	return precondition(CodeBridgeNotFound, "bridge %s not found, create it under bridges first", brName)
 }
 
 func (s *LibvirtService) GetVMDetail(name string) (*model.VMDetail, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return nil, err
 	}
-	d, err := s.l.DomainLookupByName(name)
+	d, err := l.DomainLookupByName(name)
 	if err != nil {
 		return nil, err
 	}
-	state, _, _, _, _, err := s.l.DomainGetInfo(d)
+	state, _, _, _, _, err := l.DomainGetInfo(d)
 	if err != nil {
 		return nil, err
 	}
-	xmlStr, err := s.l.DomainGetXMLDesc(d, 0)
+	xmlStr, err := l.DomainGetXMLDesc(d, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	var dx detailDomainXML
-	if err := xml.Unmarshal([]byte(xmlStr), &dx); err != nil {
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
 		return nil, err
 	}
 
-	cpu, memMB := parseDomainInfo(xmlStr)
-
 	detail := &model.VMDetail{
 		Name:   name,
 		UUID:   fmt.Sprintf("%x", d.UUID),
 		State:  stateName(libvirt.DomainState(state)),
-		CPU:    cpu,
-		Memory: memMB,
-		Boot:   bootOrder(dx.OS.Boot),
-		Arch:   dx.OS.Type.Arch,
+		CPU:    dx.VCPUs(),
+		Memory: dx.MemoryMiB(),
+		Boot:   strings.Join(dx.BootOrder(), ", "),
 		Disks:  []model.VMDisk{},
 		NICs:   []model.VMNIC{},
 	}
+	if dx.OS != nil {
+		detail.Arch = dx.OS.Type.Arch
+	}
+	if dx.Devices == nil {
+		return detail, nil
+	}
 
 	for _, disk := range dx.Devices.Disks {
-		detail.Disks = append(detail.Disks, model.VMDisk{
-			Device: disk.Device,
-			Source: disk.Source.File,
-			Target: disk.Target.Dev,
-			Bus:    disk.Target.Bus,
-			Format: disk.Driver.Type,
-		})
+		vd := model.VMDisk{Device: disk.Device, Source: disk.SourceFile()}
+		if disk.Target != nil {
+			vd.Target, vd.Bus = disk.Target.Dev, disk.Target.Bus
+		}
+		if disk.Driver != nil {
+			vd.Format = disk.Driver.Type
+		}
+		detail.Disks = append(detail.Disks, vd)
 	}
 
 	for _, iface := range dx.Devices.Interfaces {
-		src := iface.Source.Network
-		if src == "" {
-			src = iface.Source.Bridge
-		}
-		if src == "" {
-			src = iface.Source.Dev
-		}
 		detail.NICs = append(detail.NICs, model.VMNIC{
 			Type:   iface.Type,
-			Source: src,
-			MAC:    iface.MAC.Address,
-			Model:  iface.Model.Type,
+			Source: iface.SourceName(),
+			MAC:    iface.MACAddress(),
+			Model:  iface.ModelType(),
+			Target: iface.TargetDev(),
 		})
 	}
 
+	if detail.State == "running" {
+		if stats, err := s.guestStats([]libvirt.Domain{d}); err == nil && len(stats) == 1 {
+			s.ioCache.rates(stats[0], time.Now()).attach(detail)
+		}
+	}
+
 	return detail, nil
 }
 
 func (s *LibvirtService) AttachDisk(vmName string, req model.AttachDiskRequest) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("attach_disk", vmName)
+	if err != nil {
 		return err
 	}
-	// Validate disk path is under libvirt images
+	defer release()
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	// Validate disk path is under an allowed root
 	cleanPath := filepath.Clean(req.Source)
-	if !strings.HasPrefix(cleanPath, "/var/lib/libvirt/") {
-		return fmt.Errorf("disk source must be under /var/lib/libvirt/")
+	if !s.cfg.PathAllowed(cleanPath) {
+		return invalid(CodeInvalidPath, "disk source must be under %s", strings.Join(s.cfg.AllowedRoots, ", "))
 	}
 	if strings.ContainsAny(cleanPath, `<>&'"`) {
-		return fmt.Errorf("disk source contains invalid characters")
+		return invalid(CodeInvalidPath, "disk source contains invalid characters")
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	d, err := lookupNotTemplate(l, vmName)
 	if err != nil {
 		return err
 	}
@@ -177,11 +127,11 @@ func (s *LibvirtService) AttachDisk(vmName string, req model.AttachDiskRequest)
 		req.Bus = "virtio"
 	}
 	if !safeNameRe.MatchString(req.Target) {
-		return fmt.Errorf("invalid target device: %s", req.Target)
+		return invalid(CodeInvalidArgument, "invalid target device: %s", req.Target)
 	}
 	validBus := map[string]bool{"virtio": true, "ide": true, "scsi": true, "sata": true}
 	if !validBus[req.Bus] {
-		return fmt.Errorf("invalid bus type: %s", req.Bus)
+		return invalid(CodeInvalidArgument, "invalid bus type: %s", req.Bus)
 	}
 
 	// Detect format from extension
@@ -190,76 +140,76 @@ func (s *LibvirtService) AttachDisk(vmName string, req model.AttachDiskRequest)
 		format = "raw"
 	}
 
-	xmlDef := fmt.Sprintf(`<disk type='file' device='disk'>
-  <driver name='qemu' type='%s'/>
-  <source file='%s'/>
-  <target dev='%s' bus='%s'/>
-</disk>`, format, cleanPath, req.Target, req.Bus)
+	xmlDef, err := domxml.MarshalDevice(domxml.FileDisk("disk", cleanPath, format, req.Target, req.Bus))
+	if err != nil {
+		return err
+	}
 
 	// Try live attach first, fall back to config-only
-	state, _, _, _, _, _ := s.l.DomainGetInfo(d)
+	state, _, _, _, _, _ := l.DomainGetInfo(d)
 	var flags libvirt.DomainDeviceModifyFlags
 	if libvirt.DomainState(state) == libvirt.DomainRunning {
 		flags = libvirt.DomainDeviceModifyLive | libvirt.DomainDeviceModifyConfig
 	} else {
 		flags = libvirt.DomainDeviceModifyConfig
 	}
-	return s.l.DomainAttachDeviceFlags(d, xmlDef, uint32(flags))
+	return l.DomainAttachDeviceFlags(d, xmlDef, uint32(flags))
 }
 
 func (s *LibvirtService) DetachDisk(vmName, target string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("detach_disk", vmName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	d, err := lookupNotTemplate(l, vmName)
 	if err != nil {
 		return err
 	}
 
 	// Get current XML to find the disk
-	xmlStr, err := s.l.DomainGetXMLDesc(d, 0)
+	xmlStr, err := l.DomainGetXMLDesc(d, 0)
 	if err != nil {
 		return err
 	}
-	var dx detailDomainXML
-	if err := xml.Unmarshal([]byte(xmlStr), &dx); err != nil {
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
 		return fmt.Errorf("parse domain xml: %w", err)
 	}
+	disk := dx.FindDisk(target)
+	if disk == nil {
+		return notFound(CodeDeviceNotFound, "disk %s not found", target)
+	}
+	xmlDef, err := domxml.MarshalDevice(disk)
+	if err != nil {
+		return err
+	}
 
-	for _, disk := range dx.Devices.Disks {
-		if disk.Target.Dev == target {
-			format := disk.Driver.Type
-			if format == "" {
-				format = "qcow2"
-			}
-			xmlDef := fmt.Sprintf(`<disk type='file' device='disk'>
-  <driver name='qemu' type='%s'/>
-  <source file='%s'/>
-  <target dev='%s' bus='%s'/>
-</disk>`, format, disk.Source.File, disk.Target.Dev, disk.Target.Bus)
-
-			state, _, _, _, _, _ := s.l.DomainGetInfo(d)
-			var flags libvirt.DomainDeviceModifyFlags
-			if libvirt.DomainState(state) == libvirt.DomainRunning {
-				flags = libvirt.DomainDeviceModifyLive | libvirt.DomainDeviceModifyConfig
-			} else {
-				flags = libvirt.DomainDeviceModifyConfig
-			}
-			return s.l.DomainDetachDeviceFlags(d, xmlDef, uint32(flags))
-		}
+	state, _, _, _, _, _ := l.DomainGetInfo(d)
+	var flags libvirt.DomainDeviceModifyFlags
+	if libvirt.DomainState(state) == libvirt.DomainRunning {
+		flags = libvirt.DomainDeviceModifyLive | libvirt.DomainDeviceModifyConfig
+	} else {
+		flags = libvirt.DomainDeviceModifyConfig
 	}
-	return fmt.Errorf("disk %s not found", target)
+	return l.DomainDetachDeviceFlags(d, xmlDef, uint32(flags))
 }
 
 func (s *LibvirtService) AttachNIC(vmName string, req model.AttachNICRequest) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("attach_nic", vmName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	d, err := lookupNotTemplate(l, vmName)
 	if err != nil {
 		return err
 	}
@@ -268,152 +218,135 @@ func (s *LibvirtService) AttachNIC(vmName string, req model.AttachNICRequest) er
 	}
 	validModel := map[string]bool{"virtio": true, "e1000": true, "rtl8139": true}
 	if !validModel[req.Model] {
-		return fmt.Errorf("invalid nic model: %s", req.Model)
+		return invalid(CodeInvalidArgument, "invalid nic model: %s", req.Model)
 	}
 	if req.Mode == "" {
 		req.Mode = "network"
 	}
 
-	var xmlDef string
+	var source string
 	switch req.Mode {
 	case "network":
 		if req.Network == "" {
-			req.Network = "default"
+			req.Network = s.cfg.DefaultNetwork
 		}
 		if !safeNameRe.MatchString(req.Network) {
-			return fmt.Errorf("invalid network name: %s", req.Network)
+			return invalid(CodeInvalidName, "invalid network name: %s", req.Network)
 		}
-		xmlDef = fmt.Sprintf(`<interface type='network'>
-  <source network='%s'/>
-  <model type='%s'/>
-</interface>`, req.Network, req.Model)
+		source = req.Network
 	case "bridge":
 		if req.Bridge == "" {
-			return fmt.Errorf("bridge name required")
+			return invalid(CodeInvalidArgument, "bridge name required")
 		}
 		if !safeNameRe.MatchString(req.Bridge) {
-			return fmt.Errorf("invalid bridge name: %s", req.Bridge)
+			return invalid(CodeInvalidName, "invalid bridge name: %s", req.Bridge)
 		}
-		if err := ensureBridge(req.Bridge); err != nil {
-			return fmt.Errorf("创建网桥失败: %w", err)
+		if err := s.ensureBridge(req.Bridge); err != nil {
+			return err
 		}
-		xmlDef = fmt.Sprintf(`<interface type='bridge'>
-  <source bridge='%s'/>
-  <model type='%s'/>
-</interface>`, req.Bridge, req.Model)
+		source = req.Bridge
 	case "macvtap":
 		if req.Dev == "" {
-			return fmt.Errorf("physical device required for macvtap")
+			return invalid(CodeInvalidArgument, "physical device required for macvtap")
 		}
 		if !safeNameRe.MatchString(req.Dev) {
-			return fmt.Errorf("invalid device name: %s", req.Dev)
+			return invalid(CodeInvalidName, "invalid device name: %s", req.Dev)
 		}
-		xmlDef = fmt.Sprintf(`<interface type='direct'>
-  <source dev='%s' mode='bridge'/>
-  <model type='%s'/>
-</interface>`, req.Dev, req.Model)
+		source = req.Dev
 	default:
-		return fmt.Errorf("unsupported mode: %s", req.Mode)
+		return invalid(CodeInvalidArgument, "unsupported mode: %s", req.Mode)
+	}
+	iface, err := domxml.NewInterface(req.Mode, source, req.Model)
+	if err != nil {
+		return err
+	}
+	xmlDef, err := domxml.MarshalDevice(iface)
+	if err != nil {
+		return err
 	}
 
-	state, _, _, _, _, _ := s.l.DomainGetInfo(d)
+	state, _, _, _, _, _ := l.DomainGetInfo(d)
 	var flags libvirt.DomainDeviceModifyFlags
 	if libvirt.DomainState(state) == libvirt.DomainRunning {
 		flags = libvirt.DomainDeviceModifyLive | libvirt.DomainDeviceModifyConfig
 	} else {
 		flags = libvirt.DomainDeviceModifyConfig
 	}
-	return s.l.DomainAttachDeviceFlags(d, xmlDef, uint32(flags))
+	return l.DomainAttachDeviceFlags(d, xmlDef, uint32(flags))
 }
 
 func (s *LibvirtService) DetachNIC(vmName, mac string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("detach_nic", vmName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	d, err := lookupNotTemplate(l, vmName)
 	if err != nil {
 		return err
 	}
-	xmlStr, err := s.l.DomainGetXMLDesc(d, 0)
+	xmlStr, err := l.DomainGetXMLDesc(d, 0)
 	if err != nil {
 		return err
 	}
-	var dx detailDomainXML
-	if err := xml.Unmarshal([]byte(xmlStr), &dx); err != nil {
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
 		return fmt.Errorf("parse domain xml: %w", err)
 	}
+	iface := dx.FindInterface(mac)
+	if iface == nil {
+		return notFound(CodeDeviceNotFound, "nic with mac %s not found", mac)
+	}
+	xmlDef, err := domxml.MarshalDevice(iface)
+	if err != nil {
+		return err
+	}
 
-	for _, iface := range dx.Devices.Interfaces {
-		if iface.MAC.Address == mac {
-			src := iface.Source.Network
-			srcType := "network"
-			if src == "" {
-				src = iface.Source.Bridge
-				srcType = "bridge"
-			}
-			if src == "" {
-				src = iface.Source.Dev
-				srcType = "direct"
-			}
-			var srcAttr string
-			switch srcType {
-			case "network":
-				srcAttr = fmt.Sprintf(`network='%s'`, src)
-			case "bridge":
-				srcAttr = fmt.Sprintf(`bridge='%s'`, src)
-			case "direct":
-				srcAttr = fmt.Sprintf(`dev='%s' mode='bridge'`, src)
-			}
-			xmlDef := fmt.Sprintf(`<interface type='%s'>
-  <source %s/>
-  <mac address='%s'/>
-  <model type='%s'/>
-</interface>`, iface.Type, srcAttr, mac, iface.Model.Type)
-
-			state, _, _, _, _, _ := s.l.DomainGetInfo(d)
-			var flags libvirt.DomainDeviceModifyFlags
-			if libvirt.DomainState(state) == libvirt.DomainRunning {
-				flags = libvirt.DomainDeviceModifyLive | libvirt.DomainDeviceModifyConfig
-			} else {
-				flags = libvirt.DomainDeviceModifyConfig
-			}
-			return s.l.DomainDetachDeviceFlags(d, xmlDef, uint32(flags))
-		}
+	state, _, _, _, _, _ := l.DomainGetInfo(d)
+	var flags libvirt.DomainDeviceModifyFlags
+	if libvirt.DomainState(state) == libvirt.DomainRunning {
+		flags = libvirt.DomainDeviceModifyLive | libvirt.DomainDeviceModifyConfig
+	} else {
+		flags = libvirt.DomainDeviceModifyConfig
 	}
-	return fmt.Errorf("nic with mac %s not found", mac)
+	return l.DomainDetachDeviceFlags(d, xmlDef, uint32(flags))
 }
 
 func (s *LibvirtService) AttachISO(vmName string, isoPath string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("attach_iso", vmName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
 	cleanPath := filepath.Clean(isoPath)
-	if !strings.HasPrefix(cleanPath, isoDir+"/") {
-		return fmt.Errorf("iso path must be under %s", isoDir)
+	if !strings.HasPrefix(cleanPath, s.cfg.ISODir+"/") {
+		return invalid(CodeInvalidPath, "iso path must be under %s", s.cfg.ISODir)
 	}
 	if strings.ContainsAny(cleanPath, `<>&'"`) {
-		return fmt.Errorf("iso path contains invalid characters")
+		return invalid(CodeInvalidPath, "iso path contains invalid characters")
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	d, err := lookupNotTemplate(l, vmName)
 	if err != nil {
 		return err
 	}
 
 	// Detect existing cdrom bus from VM XML
-	cdromDev, cdromBus := s.findCdromBus(d)
+	cdromDev, cdromBus := findCdromBus(l, d)
 
-	xmlDef := fmt.Sprintf(`<disk type='file' device='cdrom'>
-  <driver name='qemu' type='raw'/>
-  <source file='%s'/>
-  <target dev='%s' bus='%s'/>
-  <readonly/>
-</disk>`, cleanPath, cdromDev, cdromBus)
+	xmlDef, err := domxml.MarshalDevice(domxml.FileDisk("cdrom", cleanPath, "raw", cdromDev, cdromBus))
+	if err != nil {
+		return err
+	}
 
-	state, _, _, _, _, _ := s.l.DomainGetInfo(d)
+	state, _, _, _, _, _ := l.DomainGetInfo(d)
 	var flags libvirt.DomainDeviceModifyFlags
 	if libvirt.DomainState(state) == libvirt.DomainRunning {
 		flags = libvirt.DomainDeviceModifyLive | libvirt.DomainDeviceModifyConfig
@@ -421,134 +354,163 @@ func (s *LibvirtService) AttachISO(vmName string, isoPath string) error {
 		flags = libvirt.DomainDeviceModifyConfig
 	}
 
-	err = s.l.DomainUpdateDeviceFlags(d, xmlDef, flags)
+	err = l.DomainUpdateDeviceFlags(d, xmlDef, flags)
 	if err != nil {
-		err = s.l.DomainAttachDeviceFlags(d, xmlDef, uint32(flags))
+		err = l.DomainAttachDeviceFlags(d, xmlDef, uint32(flags))
 		if err != nil {
 			return err
 		}
 	}
 
-	fullXML, err := s.l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	fullXML, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
 	if err != nil {
 		return nil
 	}
-	if !strings.Contains(fullXML, `<boot dev='cdrom'/>`) && !strings.Contains(fullXML, `<boot dev="cdrom"/>`) {
-		newXML := strings.Replace(fullXML, `<boot dev='hd'/>`, `<boot dev='cdrom'/><boot dev='hd'/>`, 1)
-		if newXML != fullXML {
-			s.l.DomainDefineXML(newXML)
-		}
+	// Boot from the ISO first if the VM only boots from disk
+	dx, err := domxml.Parse(fullXML)
+	if err != nil || dx.HasBoot("cdrom") || !dx.HasBoot("hd") {
+		return nil
+	}
+	dx.BootFirst("cdrom")
+	if newXML, err := dx.Marshal(); err == nil {
+		l.DomainDefineXML(newXML)
 	}
 	return nil
 }
 
-// findCdromBus detects the first cdrom's target dev and bus from VM XML. Caller must hold s.mu.
-func (s *LibvirtService) findCdromBus(d libvirt.Domain) (dev, bus string) {
+// findCdromBus detects the first cdrom's target dev and bus from VM XML.
+func findCdromBus(l *libvirt.Libvirt, d libvirt.Domain) (dev, bus string) {
 	dev, bus = "hda", "ide" // default for i440fx
-	xmlStr, err := s.l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
 	if err != nil {
 		return
 	}
-	var dx detailDomainXML
-	if xml.Unmarshal([]byte(xmlStr), &dx) == nil {
-		for _, disk := range dx.Devices.Disks {
-			if disk.Device == "cdrom" {
-				return disk.Target.Dev, disk.Target.Bus
-			}
-		}
-		// No cdrom found: check if q35 (no IDE support)
-		if strings.Contains(xmlStr, "q35") {
-			return "sdb", "sata"
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return
+	}
+	for _, cd := range dx.Cdroms() {
+		if cd.Target != nil {
+			return cd.Target.Dev, cd.Target.Bus
 		}
 	}
+	// No cdrom found: check if q35 (no IDE support)
+	if dx.IsQ35() {
+		return "sdb", "sata"
+	}
 	return
 }
 
 func (s *LibvirtService) DetachISO(vmName string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("detach_iso", vmName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	d, err := lookupNotTemplate(l, vmName)
 	if err != nil {
 		return err
 	}
 
-	cdromDev, cdromBus := s.findCdromBus(d)
+	cdromDev, cdromBus := findCdromBus(l, d)
 
-	xmlDef := fmt.Sprintf(`<disk type='file' device='cdrom'>
-  <driver name='qemu' type='raw'/>
-  <target dev='%s' bus='%s'/>
-  <readonly/>
-</disk>`, cdromDev, cdromBus)
+	xmlDef, err := domxml.MarshalDevice(domxml.FileDisk("cdrom", "", "raw", cdromDev, cdromBus))
+	if err != nil {
+		return err
+	}
 
-	state, _, _, _, _, _ := s.l.DomainGetInfo(d)
+	state, _, _, _, _, _ := l.DomainGetInfo(d)
 	var flags libvirt.DomainDeviceModifyFlags
 	if libvirt.DomainState(state) == libvirt.DomainRunning {
 		flags = libvirt.DomainDeviceModifyLive | libvirt.DomainDeviceModifyConfig
 	} else {
 		flags = libvirt.DomainDeviceModifyConfig
 	}
-	return s.l.DomainUpdateDeviceFlags(d, xmlDef, flags)
+	return l.DomainUpdateDeviceFlags(d, xmlDef, flags)
 }
 
-func (s *LibvirtService) CloneVM(srcName string, req model.CloneVMRequest) error {
-	s.mu.Lock()
-	if err := s.ensureConnected(); err != nil {
-		s.mu.Unlock()
-		return err
-	}
+func (s *LibvirtService) CloneVM(ctx context.Context, srcName string, req model.CloneVMRequest, p Progress) error {
 	if !safeNameRe.MatchString(req.NewName) {
-		s.mu.Unlock()
-		return fmt.Errorf("invalid vm name: %s", req.NewName)
+		return invalid(CodeInvalidName, "invalid vm name: %s", req.NewName)
 	}
 	if !safeNameRe.MatchString(srcName) {
-		s.mu.Unlock()
-		return fmt.Errorf("invalid source vm name: %s", srcName)
+		return invalid(CodeInvalidName, "invalid source vm name: %s", srcName)
 	}
-	s.mu.Unlock()
-	// virt-clone runs outside the lock — it may take minutes for large disks
-	cmd := exec.Command("virt-clone",
-		"--original", srcName,
-		"--name", req.NewName,
-		"--auto-clone",
-	)
-	output, err := cmd.CombinedOutput()
+	opts, err := fullCloneOptions(req.Linked, req.Pool, req.Format)
 	if err != nil {
-		return fmt.Errorf("clone failed: %s", string(output))
+		return err
 	}
-	return nil
+	l, err := s.conn()
+	if err != nil {
+		return err
+	}
+	// Both domains stay locked for the whole copy, which may take minutes
+	release, err := s.ops.acquire("clone", srcName, req.NewName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := checkNameFree(l, req.NewName); err != nil {
+		return err
+	}
+	if req.Linked {
+		d, err := l.DomainLookupByName(srcName)
+		if err != nil {
+			return err
+		}
+		xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+		if err != nil {
+			return err
+		}
+		return s.linkedClone(ctx, l, xmlStr, req.NewName, p)
+	}
+	return s.cloneFrom(ctx, l, srcName, req.NewName, opts, p)
 }
 
 func (s *LibvirtService) FinishInstall(vmName string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	release, err := s.ops.acquire("finish_install", vmName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	d, err := s.l.DomainLookupByName(vmName)
+	d, err := lookupNotTemplate(l, vmName)
 	if err != nil {
 		return err
 	}
-	xmlStr, err := s.l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	xmlStr, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
 	if err != nil {
 		return err
 	}
 
-	// Remove ISO from cdrom (handle both single and double quotes)
-	cdromRe := regexp.MustCompile(`(?s)(<disk type=['"]file['"] device=['"]cdrom['"]>.*?)([ \t]*<source file=['"][^'"]*['"]/>[ \t]*\n?)(.*?</disk>)`)
-	xmlStr = cdromRe.ReplaceAllString(xmlStr, "${1}${3}")
+	dx, err := domxml.Parse(xmlStr)
+	if err != nil {
+		return fmt.Errorf("parse domain xml: %w", err)
+	}
+
+	// Remove ISO from cdrom
+	for _, cd := range dx.Cdroms() {
+		if cd.Type == "file" {
+			cd.Source = nil
+		}
+	}
 
-	// Change boot order: hd first, cdrom second (handle both quote styles)
-	bootRe := regexp.MustCompile(`<boot dev=['"]cdrom['"]/>\s*<boot dev=['"]hd['"]/>`)
-	xmlStr = bootRe.ReplaceAllString(xmlStr, "<boot dev='hd'/><boot dev='cdrom'/>")
-	bootRe2 := regexp.MustCompile(`<boot dev=['"]hd['"]/>\s*<boot dev=['"]cdrom['"]/>`)
-	if !bootRe2.MatchString(xmlStr) {
-		singleBoot := regexp.MustCompile(`<boot dev=['"]cdrom['"]/>`)
-		xmlStr = singleBoot.ReplaceAllString(xmlStr, "<boot dev='hd'/><boot dev='cdrom'/>")
+	// Change boot order: hd first, cdrom second
+	if dx.HasBoot("cdrom") {
+		dx.BootFirst("hd")
 	}
 
-	_, err = s.l.DomainDefineXML(xmlStr)
+	newXML, err := dx.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = l.DomainDefineXML(newXML)
 	return err
 }
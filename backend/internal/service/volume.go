@@ -7,28 +7,27 @@ import (
 )
 
 func (s *LibvirtService) ListVolumes(poolName string) ([]model.StorageVolume, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return nil, err
 	}
-	pool, err := s.l.StoragePoolLookupByName(poolName)
+	pool, err := l.StoragePoolLookupByName(poolName)
 	if err != nil {
 		return nil, err
 	}
-	_ = s.l.StoragePoolRefresh(pool, 0)
+	_ = l.StoragePoolRefresh(pool, 0)
 
-	vols, _, err := s.l.StoragePoolListAllVolumes(pool, -1, 0)
+	vols, _, err := l.StoragePoolListAllVolumes(pool, -1, 0)
 	if err != nil {
 		return nil, err
 	}
 	result := make([]model.StorageVolume, 0, len(vols))
 	for _, v := range vols {
-		vType, capacity, allocation, err := s.l.StorageVolGetInfo(v)
+		vType, capacity, allocation, err := l.StorageVolGetInfo(v)
 		if err != nil {
 			continue
 		}
-		path, _ := s.l.StorageVolGetPath(v)
+		path, _ := l.StorageVolGetPath(v)
 		typeName := "unknown"
 		switch vType {
 		case 0:
@@ -52,18 +51,17 @@ func (s *LibvirtService) ListVolumes(poolName string) ([]model.StorageVolume, er
 }
 
 func (s *LibvirtService) CreateVolume(req model.CreateVolumeRequest) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
 	if !safeNameRe.MatchString(req.Name) {
-		return fmt.Errorf("invalid volume name: %s", req.Name)
+		return invalid(CodeInvalidName, "invalid volume name: %s", req.Name)
 	}
 	if !safeNameRe.MatchString(req.Pool) {
-		return fmt.Errorf("invalid pool name: %s", req.Pool)
+		return invalid(CodeInvalidName, "invalid pool name: %s", req.Pool)
 	}
-	pool, err := s.l.StoragePoolLookupByName(req.Pool)
+	pool, err := l.StoragePoolLookupByName(req.Pool)
 	if err != nil {
 		return err
 	}
@@ -75,7 +73,7 @@ func (s *LibvirtService) CreateVolume(req model.CreateVolumeRequest) error {
 	}
 	validFormat := map[string]bool{"qcow2": true, "raw": true, "vmdk": true, "vdi": true}
 	if !validFormat[req.Format] {
-		return fmt.Errorf("invalid format: %s", req.Format)
+		return invalid(CodeInvalidArgument, "invalid format: %s", req.Format)
 	}
 	// Auto-append extension if missing
 	ext := "." + req.Format
@@ -88,23 +86,22 @@ func (s *LibvirtService) CreateVolume(req model.CreateVolumeRequest) error {
   <target><format type='%s'/></target>
 </volume>`, req.Name, req.Capacity, req.Format)
 
-	_, err = s.l.StorageVolCreateXML(pool, xmlDef, 0)
+	_, err = l.StorageVolCreateXML(pool, xmlDef, 0)
 	return err
 }
 
 func (s *LibvirtService) DeleteVolume(poolName, volName string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.ensureConnected(); err != nil {
+	l, err := s.conn()
+	if err != nil {
 		return err
 	}
-	pool, err := s.l.StoragePoolLookupByName(poolName)
+	pool, err := l.StoragePoolLookupByName(poolName)
 	if err != nil {
 		return err
 	}
-	vol, err := s.l.StorageVolLookupByName(pool, volName)
+	vol, err := l.StorageVolLookupByName(pool, volName)
 	if err != nil {
 		return err
 	}
-	return s.l.StorageVolDelete(vol, 0)
+	return l.StorageVolDelete(vol, 0)
 }
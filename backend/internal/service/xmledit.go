@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"virtpanel/internal/domxml"
+	"virtpanel/internal/model"
+	"virtpanel/internal/textdiff"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// ErrInvalidXML is returned when an edited domain definition is malformed
+// or rejected by the libvirt schema.
+var ErrInvalidXML = &Error{Kind: Invalid, Code: CodeInvalidXML, Msg: "invalid domain xml"}
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// parseEditedXML checks that the edited definition parses and still
+// describes the same domain: defining a different name or UUID would
+// create a second VM instead of changing this one.
+func parseEditedXML(name, uuid, doc string) (*domxml.Domain, error) {
+	dx, err := domxml.Parse(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidXML, err)
+	}
+	if dx.Name != name {
+		return nil, fmt.Errorf("%w: name must stay %q, use rename instead", ErrInvalidXML, name)
+	}
+	if dx.UUID != "" && normalizeUUID(dx.UUID) != normalizeUUID(uuid) {
+		return nil, fmt.Errorf("%w: uuid must stay %s", ErrInvalidXML, uuid)
+	}
+	return dx, nil
+}
+
+func normalizeUUID(u string) string {
+	return strings.ToLower(strings.ReplaceAll(u, "-", ""))
+}
+
+func xmlDiff(name, cur, next string) string {
+	return textdiff.Unified(name+".xml", name+".xml (edited)", cur, next, diffContext)
+}
+
+// validateDomainSchema runs virt-xml-validate, the schema check libvirt
+// applies when defining with the validate flag. It reports false when the
+// tool is not installed.
+func validateDomainSchema(doc string) (bool, error) {
+	bin, err := exec.LookPath("virt-xml-validate")
+	if err != nil {
+		return false, nil
+	}
+	f, err := os.CreateTemp("", "virtpanel-*.xml")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(doc); err != nil {
+		f.Close()
+		return false, err
+	}
+	f.Close()
+	if out, err := exec.Command(bin, f.Name(), "domain").CombinedOutput(); err != nil {
+		return true, fmt.Errorf("%w: %s", ErrInvalidXML, strings.TrimSpace(string(out)))
+	}
+	return true, nil
+}
+
+// GetVMXML returns the domain definition: the live one of a running VM, or
+// the persistent one used on next boot when inactive is set.
+func (s *LibvirtService) GetVMXML(name string, inactive bool) (string, error) {
+	l, err := s.conn()
+	if err != nil {
+		return "", err
+	}
+	d, err := l.DomainLookupByName(name)
+	if err != nil {
+		return "", err
+	}
+	var flags libvirt.DomainXMLFlags
+	if inactive {
+		flags = libvirt.DomainXMLInactive
+	}
+	return l.DomainGetXMLDesc(d, flags)
+}
+
+// UpdateVMXML replaces the persistent definition of a VM with req.XML,
+// validated by libvirt. The diff in the result is against the definition
+// before the change; with DryRun nothing is defined. A running VM keeps
+// its live configuration until it is restarted.
+func (s *LibvirtService) UpdateVMXML(name string, req model.UpdateVMXMLRequest) (*model.VMXMLResult, error) {
+	release, err := s.ops.acquire("edit_xml", name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	l, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	d, err := lookupNotTemplate(l, name)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive)
+	if err != nil {
+		return nil, err
+	}
+	curDx, err := domxml.Parse(cur)
+	if err != nil {
+		return nil, fmt.Errorf("parse domain xml: %w", err)
+	}
+	if _, err := parseEditedXML(name, curDx.UUID, req.XML); err != nil {
+		return nil, err
+	}
+
+	res := &model.VMXMLResult{Diff: xmlDiff(name, cur, req.XML)}
+	res.Changed = res.Diff != ""
+	if req.DryRun {
+		// libvirt has no validate-only call; the schema check is the
+		// local tool when libvirt runs on this machine.
+		if s.local {
+			res.Validated, err = validateDomainSchema(req.XML)
+		}
+		return res, err
+	}
+	if !res.Changed {
+		return res, nil
+	}
+
+	if _, err := l.DomainDefineXMLFlags(req.XML, libvirt.DomainDefineValidate); err != nil {
+		var lerr libvirt.Error
+		if errors.As(err, &lerr) {
+			switch libvirt.ErrorNumber(lerr.Code) {
+			case libvirt.ErrXMLError, libvirt.ErrXMLDetail, libvirt.ErrXMLInvalidSchema:
+				return nil, fmt.Errorf("%w: %v", ErrInvalidXML, err)
+			}
+		}
+		return nil, err
+	}
+	res.Validated, res.Applied = true, true
+
+	// Report what libvirt actually stored: it fills in defaults and
+	// drops what it does not understand.
+	if next, err := l.DomainGetXMLDesc(d, libvirt.DomainXMLInactive); err == nil {
+		res.Diff = xmlDiff(name, cur, next)
+		res.Changed = res.Diff != ""
+	}
+	state, _, _, _, _, err := l.DomainGetInfo(d)
+	if err == nil && libvirt.DomainState(state) != libvirt.DomainShutoff {
+		res.RestartRequired = res.Changed
+	}
+	return res, nil
+}
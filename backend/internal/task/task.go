@@ -0,0 +1,260 @@
+// Package task runs long operations (clones, disk creation, uploads) in
+// the background and keeps their status, progress and log output.
+package task
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	Running   State = "running"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+	Canceled  State = "canceled"
+)
+
+const (
+	maxHistory = 200       // finished tasks kept on disk
+	maxLog     = 64 * 1024 // bytes of log output kept per task
+)
+
+type Task struct {
+	ID         string  `json:"id"`
+	Kind       string  `json:"kind"`            // create_vm, clone_vm, revert_snapshot_to_new, upload_iso
+	Host       string  `json:"host"`            // host the operation runs on
+	Target     string  `json:"target"`          // VM or file the task creates
+	Owner      string  `json:"owner,omitempty"` // user who started it
+	Group      string  `json:"group,omitempty"` // that user's default group
+	State      State   `json:"state"`
+	Progress   float64 `json:"progress"` // percent 0-100
+	Log        string  `json:"log"`
+	Error      string  `json:"error,omitempty"`
+	Code       string  `json:"code,omitempty"` // error code, as in API error replies
+	CreatedAt  int64   `json:"created_at"`
+	FinishedAt int64   `json:"finished_at,omitempty"`
+}
+
+// Done reports whether the task has finished, successfully or not.
+func (t *Task) Done() bool { return t.State != Running }
+
+type entry struct {
+	Task
+	cancel context.CancelFunc
+}
+
+// Manager runs tasks and persists their history to DataDir/tasks.json.
+type Manager struct {
+	file  string
+	mu    sync.Mutex
+	tasks map[string]*entry
+
+	// ErrorCode, if set, gives the Code of a failed task.
+	ErrorCode func(error) string
+}
+
+// NewManager loads the task history from dataDir. Tasks that were still
+// running when the panel stopped are marked failed.
+func NewManager(dataDir string) *Manager {
+	m := &Manager{file: filepath.Join(dataDir, "tasks.json"), tasks: make(map[string]*entry)}
+	data, err := os.ReadFile(m.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取任务记录失败: %v", err)
+		}
+		return m
+	}
+	var saved []Task
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("读取任务记录失败: %v", err)
+		return m
+	}
+	for _, t := range saved {
+		if !t.Done() {
+			t.State, t.Error = Failed, "interrupted by restart"
+			if t.FinishedAt == 0 {
+				t.FinishedAt = time.Now().Unix()
+			}
+		}
+		m.tasks[t.ID] = &entry{Task: t}
+	}
+	return m
+}
+
+// Func is the body of a task. It should stop early when ctx is canceled
+// and report through r.
+type Func func(ctx context.Context, r *Reporter) error
+
+// Run starts fn in the background for owner and group and returns the
+// new task.
+func (m *Manager) Run(kind, host, target, owner, group string, fn Func) Task {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &entry{
+		Task: Task{
+			ID:        newID(),
+			Kind:      kind,
+			Host:      host,
+			Target:    target,
+			Owner:     owner,
+			Group:     group,
+			State:     Running,
+			CreatedAt: time.Now().Unix(),
+		},
+		cancel: cancel,
+	}
+	m.mu.Lock()
+	m.tasks[e.ID] = e
+	m.saveLocked()
+	t := e.Task
+	m.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		err := fn(ctx, &Reporter{m: m, e: e})
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		switch {
+		case ctx.Err() != nil:
+			e.State, e.Error = Canceled, "canceled"
+		case err != nil:
+			e.State, e.Error = Failed, err.Error()
+			if m.ErrorCode != nil {
+				e.Code = m.ErrorCode(err)
+			}
+		default:
+			e.State, e.Progress = Succeeded, 100
+		}
+		e.FinishedAt = time.Now().Unix()
+		e.cancel = nil
+		m.saveLocked()
+	}()
+	return t
+}
+
+// Get returns a copy of the task with the given ID.
+func (m *Manager) Get(id string) (Task, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	return e.Task, true
+}
+
+// List returns all known tasks, newest first.
+func (m *Manager) List() []Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sortedLocked()
+}
+
+func (m *Manager) sortedLocked() []Task {
+	list := make([]Task, 0, len(m.tasks))
+	for _, e := range m.tasks {
+		list = append(list, e.Task)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt > list[j].CreatedAt
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list
+}
+
+var (
+	ErrNotFound = errors.New("task not found")
+	ErrFinished = errors.New("task already finished")
+)
+
+// Cancel asks a running task to stop. The task moves to Canceled once
+// its function returns.
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.tasks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if e.Done() || e.cancel == nil {
+		return ErrFinished
+	}
+	e.cancel()
+	return nil
+}
+
+// saveLocked writes the task list, dropping the oldest finished tasks
+// beyond maxHistory. Caller must hold m.mu.
+func (m *Manager) saveLocked() {
+	list := m.sortedLocked()
+	finished := 0
+	kept := list[:0]
+	for _, t := range list {
+		if t.Done() {
+			if finished++; finished > maxHistory {
+				delete(m.tasks, t.ID)
+				continue
+			}
+		}
+		kept = append(kept, t)
+	}
+	os.MkdirAll(filepath.Dir(m.file), 0755)
+	data, _ := json.MarshalIndent(kept, "", "  ")
+	if err := os.WriteFile(m.file, data, 0644); err != nil {
+		log.Printf("保存任务记录失败: %v", err)
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// Reporter lets a running task publish progress and log output. It is an
+// io.Writer so command output can be attached directly.
+type Reporter struct {
+	m *Manager
+	e *entry
+}
+
+// SetProgress records the completed percentage (0-100).
+func (r *Reporter) SetProgress(percent float64) {
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+	r.m.mu.Lock()
+	r.e.Progress = percent
+	r.m.mu.Unlock()
+}
+
+// Write appends to the task log, keeping only the most recent maxLog bytes.
+func (r *Reporter) Write(p []byte) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	l := r.e.Log + string(p)
+	if len(l) > maxLog {
+		l = l[len(l)-maxLog:]
+	}
+	r.e.Log = l
+	return len(p), nil
+}
+
+// Logf appends a formatted line to the task log.
+func (r *Reporter) Logf(format string, args ...any) {
+	fmt.Fprintf(r, format+"\n", args...)
+}
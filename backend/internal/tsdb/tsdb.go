@@ -0,0 +1,318 @@
+// Package tsdb keeps fixed-width samples in round-robin files, one file
+// per series and tier. Each tier has a step and a retention; a sample
+// goes into every tier, coarser tiers averaging the samples that fall
+// into the same step. Files have a fixed size, so disk use is bounded by
+// the number of series.
+package tsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tier is one resolution of every series.
+type Tier struct {
+	Step      time.Duration
+	Retention time.Duration
+}
+
+func (t Tier) slots() int64 { return int64(t.Retention / t.Step) }
+
+// file header: magic, step seconds, slot count, values per slot
+const (
+	magic      = "VPTS0001"
+	headerSize = 8 + 3*8
+)
+
+var ErrNoData = errors.New("no data")
+
+// DB is a set of series under one directory.
+type DB struct {
+	dir   string
+	tiers []Tier
+	mu    sync.Mutex
+	files map[string]*os.File // path -> open file
+}
+
+// Open uses dir for the series files, creating it if needed. Tiers go
+// from fine to coarse; every step must divide the next one.
+func Open(dir string, tiers []Tier) (*DB, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &DB{dir: dir, tiers: tiers, files: make(map[string]*os.File)}, nil
+}
+
+// Tiers returns the tiers the DB was opened with.
+func (db *DB) Tiers() []Tier { return db.tiers }
+
+// path maps a key such as "local/vm/web1" to a file per tier; key parts
+// are escaped so any domain name is a valid file name.
+func (db *DB) path(key string, t Tier) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	parts[len(parts)-1] += fmt.Sprintf(".%ds", int64(t.Step/time.Second))
+	return filepath.Join(append([]string{db.dir}, parts...)...)
+}
+
+// open returns the tier file and its slot width. With create, a missing
+// file or one with another layout (e.g. after the fields of a series
+// changed) is created anew for width values per slot.
+func (db *DB) open(path string, t Tier, width int, create bool) (*os.File, int, error) {
+	f, ok := db.files[path]
+	if !ok {
+		var err error
+		f, err = os.OpenFile(path, os.O_RDWR, 0)
+		switch {
+		case err == nil:
+			db.files[path] = f
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, 0, err
+		case !create:
+			return nil, 0, ErrNoData
+		}
+	}
+	if f != nil {
+		w, err := checkHeader(f, t)
+		if err == nil && (w == width || !create) {
+			return f, w, nil
+		}
+		if !create {
+			return nil, 0, ErrNoData
+		}
+		f.Close()
+		delete(db.files, path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return nil, 0, err
+	}
+	hdr := make([]byte, headerSize)
+	copy(hdr, magic)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(t.Step/time.Second))
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(t.slots()))
+	binary.LittleEndian.PutUint64(hdr[24:], uint64(width))
+	if _, err = f.WriteAt(hdr, 0); err == nil {
+		err = f.Truncate(headerSize + t.slots()*slotSize(width))
+	}
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	db.files[path] = f
+	return f, width, nil
+}
+
+// checkHeader returns the slot width of a file laid out for t.
+func checkHeader(f *os.File, t Tier) (int, error) {
+	hdr := make([]byte, headerSize)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		return 0, err
+	}
+	if string(hdr[:8]) != magic ||
+		binary.LittleEndian.Uint64(hdr[8:]) != uint64(t.Step/time.Second) ||
+		binary.LittleEndian.Uint64(hdr[16:]) != uint64(t.slots()) {
+		return 0, errors.New("layout changed")
+	}
+	return int(binary.LittleEndian.Uint64(hdr[24:])), nil
+}
+
+// A slot holds the bucket start (unix seconds, 0 when empty), the number
+// of samples averaged into it and the values.
+func slotSize(width int) int64 { return int64(8 * (2 + width)) }
+
+type slot struct {
+	ts     int64
+	n      int64
+	values []float64
+}
+
+func decodeSlot(b []byte, width int) slot {
+	s := slot{
+		ts:     int64(binary.LittleEndian.Uint64(b)),
+		n:      int64(binary.LittleEndian.Uint64(b[8:])),
+		values: make([]float64, width),
+	}
+	for i := range s.values {
+		s.values[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[16+8*i:]))
+	}
+	return s
+}
+
+func (s slot) encode() []byte {
+	b := make([]byte, 16+8*len(s.values))
+	binary.LittleEndian.PutUint64(b, uint64(s.ts))
+	binary.LittleEndian.PutUint64(b[8:], uint64(s.n))
+	for i, v := range s.values {
+		binary.LittleEndian.PutUint64(b[16+8*i:], math.Float64bits(v))
+	}
+	return b
+}
+
+// Write records values at t in every tier of key.
+func (db *DB) Write(key string, t time.Time, values []float64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	width := len(values)
+	for _, tier := range db.tiers {
+		f, _, err := db.open(db.path(key, tier), tier, width, true)
+		if err != nil {
+			return err
+		}
+		step := int64(tier.Step / time.Second)
+		bucket := t.Unix() / step * step
+		off := headerSize + (bucket/step)%tier.slots()*slotSize(width)
+		buf := make([]byte, slotSize(width))
+		if _, err := f.ReadAt(buf, off); err != nil {
+			return err
+		}
+		s := decodeSlot(buf, width)
+		if s.ts == bucket && s.n > 0 {
+			for i, v := range values {
+				s.values[i] = (s.values[i]*float64(s.n) + v) / float64(s.n+1)
+			}
+			s.n++
+		} else {
+			s = slot{ts: bucket, n: 1, values: values}
+		}
+		if _, err := f.WriteAt(s.encode(), off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Series is the result of a query: Time[i] is the start of the i-th step,
+// Values[f][i] the average of field f over it. Steps without samples are
+// left out.
+type Series struct {
+	Step   time.Duration
+	Time   []int64
+	Values [][]float64
+}
+
+// Query returns the samples of key between from and to, averaged over
+// step. It reads the finest tier that still holds from; step is rounded
+// up to a multiple of that tier's step.
+func (db *DB) Query(key string, from, to time.Time, step time.Duration) (*Series, error) {
+	tier := db.tiers[len(db.tiers)-1]
+	for _, t := range db.tiers {
+		if time.Since(from) <= t.Retention {
+			tier = t
+			break
+		}
+	}
+	if step < tier.Step {
+		step = tier.Step
+	}
+	step = (step + tier.Step - 1) / tier.Step * tier.Step
+
+	db.mu.Lock()
+	f, width, err := db.open(db.path(key, tier), tier, 0, false)
+	var data []byte
+	if err == nil {
+		data = make([]byte, tier.slots()*slotSize(width))
+		_, err = f.ReadAt(data, headerSize)
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	db.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	tierStep, outStep := int64(tier.Step/time.Second), int64(step/time.Second)
+	out := &Series{Step: step, Values: make([][]float64, width)}
+	// Older slots have been reused already, and newer ones hold nothing
+	// yet, so the walk below never covers more than the tier
+	now := time.Now()
+	if oldest := now.Add(-tier.Retention); from.Before(oldest) {
+		from = oldest
+	}
+	if to.After(now) {
+		to = now
+	}
+	first := from.Unix() / tierStep * tierStep
+	sums := make([]float64, width)
+	var curBucket, curN int64 = -1, 0
+	flush := func() {
+		if curN == 0 {
+			return
+		}
+		out.Time = append(out.Time, curBucket)
+		for i := range sums {
+			out.Values[i] = append(out.Values[i], sums[i]/float64(curN))
+			sums[i] = 0
+		}
+		curN = 0
+	}
+	for b := first; b <= to.Unix(); b += tierStep {
+		off := (b / tierStep) % tier.slots() * slotSize(width)
+		s := decodeSlot(data[off:off+slotSize(width)], width)
+		if s.ts != b || s.n == 0 {
+			continue
+		}
+		ob := b / outStep * outStep
+		if ob != curBucket {
+			flush()
+			curBucket = ob
+		}
+		for i, v := range s.values {
+			sums[i] += v * float64(s.n)
+		}
+		curN += s.n
+	}
+	flush()
+	return out, nil
+}
+
+// Expire removes series files that were not written for longer than the
+// retention of their tier.
+func (db *DB) Expire() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return filepath.WalkDir(db.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		for _, t := range db.tiers {
+			if !strings.HasSuffix(path, fmt.Sprintf(".%ds", int64(t.Step/time.Second))) {
+				continue
+			}
+			if info, err := d.Info(); err == nil && time.Since(info.ModTime()) > t.Retention {
+				if f, ok := db.files[path]; ok {
+					f.Close()
+					delete(db.files, path)
+				}
+				os.Remove(path)
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the open series files.
+func (db *DB) Close() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for path, f := range db.files {
+		f.Close()
+		delete(db.files, path)
+	}
+}
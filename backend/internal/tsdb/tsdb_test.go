@@ -0,0 +1,72 @@
+package tsdb
+
+import (
+	"testing"
+	"time"
+)
+
+func testDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(t.TempDir(), []Tier{
+		{Step: 10 * time.Second, Retention: time.Hour},
+		{Step: time.Minute, Retention: 24 * time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+func TestQuery(t *testing.T) {
+	db := testDB(t)
+	now := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := db.Write("local/host", now.Add(-time.Duration(i)*10*time.Second), []float64{float64(i), 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := db.Query("local/host", now.Add(-5*time.Minute), now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Step != 10*time.Second || len(s.Time) != 6 || len(s.Values) != 2 {
+		t.Fatalf("got step %v, %d points, %d fields", s.Step, len(s.Time), len(s.Values))
+	}
+	if got := s.Values[0][len(s.Time)-1]; got != 0 {
+		t.Errorf("newest value: got %v, want 0", got)
+	}
+}
+
+// Bounds far from now must not make Query walk slots that cannot hold
+// samples: the walk never goes past now or back beyond the retention.
+func TestQueryFarBounds(t *testing.T) {
+	db := testDB(t)
+	now := time.Now()
+	if err := db.Write("local/host", now, []float64{1}); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name     string
+		from, to time.Time
+		points   int
+	}{
+		{"future to", now.Add(-time.Minute), time.Unix(4000000000, 0), 1},
+		{"ancient from", time.Unix(0, 0), now, 1},
+		{"both", time.Unix(0, 0), time.Unix(1<<40, 0), 1},
+		{"all future", now.Add(time.Hour), now.Add(100 * 365 * 24 * time.Hour), 0},
+	}
+	for _, c := range cases {
+		start := time.Now()
+		s, err := db.Query("local/host", c.from, c.to, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(s.Time) != c.points {
+			t.Errorf("%s: got %d points, want %d", c.name, len(s.Time), c.points)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: query took %v", c.name, d)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	attemptTimeout = 10 * time.Second
+	maxAttempts    = 6
+	firstRetry     = 5 * time.Second // doubled after each failed attempt: 5s, 10s, 20s, 40s, 80s
+)
+
+// Sign returns the X-VirtPanel-Signature value of body: "sha256=" and the
+// hex HMAC-SHA256 of the body keyed with the secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver sends d until it succeeds or runs out of attempts. Each attempt
+// uses the webhook's current URL and secret; deleting the webhook ends
+// the retries.
+func (m *Manager) deliver(hook string, d Delivery) {
+	wait := firstRetry
+	for {
+		m.mu.Lock()
+		i := slices.IndexFunc(m.hooks, func(w Webhook) bool { return w.ID == hook })
+		var w Webhook
+		if i >= 0 {
+			w = m.hooks[i]
+		}
+		m.mu.Unlock()
+		if i < 0 {
+			return
+		}
+
+		err := m.attempt(w, &d)
+		done := err == nil || d.Attempts >= maxAttempts
+		switch {
+		case err == nil:
+			d.Status, d.NextRetry = "delivered", 0
+		case done:
+			d.Status, d.NextRetry = "failed", 0
+			log.Printf("Webhook %s 投递 %s 失败，已放弃: %v", w.Name, d.Event, err)
+		default:
+			d.NextRetry = time.Now().Add(wait).Unix()
+		}
+		m.mu.Lock()
+		ok := m.updateLocked(hook, d)
+		if ok {
+			m.save()
+		}
+		m.mu.Unlock()
+		if done || !ok {
+			return
+		}
+
+		select {
+		case <-time.After(wait):
+			wait *= 2
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
+// attempt posts the payload once and records the outcome in d.
+func (m *Manager) attempt(w Webhook, d *Delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), attemptTimeout)
+	defer cancel()
+	start := time.Now()
+	code, err := post(ctx, w, d)
+	d.Attempts++
+	d.StatusCode, d.DurationMS, d.Error = code, time.Since(start).Milliseconds(), ""
+	if err != nil {
+		d.Error = err.Error()
+	}
+	return err
+}
+
+func post(ctx context.Context, w Webhook, d *Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "virtpanel-webhooks")
+	req.Header.Set("X-VirtPanel-Event", d.Event)
+	req.Header.Set("X-VirtPanel-Delivery", d.ID)
+	req.Header.Set("X-VirtPanel-Signature", Sign(w.Secret, d.Payload))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Test sends a test event to one webhook, whatever its event filter or
+// disabled flag, and waits for a single attempt. The delivery is logged
+// like any other; a failed attempt is returned as the error.
+func (m *Manager) Test(id, actor string) (Delivery, error) {
+	m.mu.Lock()
+	i := slices.IndexFunc(m.hooks, func(w Webhook) bool { return w.ID == id })
+	if i < 0 {
+		m.mu.Unlock()
+		return Delivery{}, ErrNotFound
+	}
+	w := m.hooks[i]
+	e := Event{ID: randomID(), Type: Test, Time: time.Now().Unix(), Actor: actor,
+		Data: map[string]any{"message": "Test event from virtpanel"}}
+	payload, _ := json.Marshal(e)
+	d := m.queueLocked(id, e, payload)
+	m.mu.Unlock()
+
+	err := m.attempt(w, &d)
+	d.Status = "delivered"
+	if err != nil {
+		d.Status = "failed"
+	}
+	m.mu.Lock()
+	if m.updateLocked(id, d) {
+		m.save()
+	}
+	m.mu.Unlock()
+	return d, err
+}
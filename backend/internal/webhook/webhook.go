@@ -0,0 +1,325 @@
+// Package webhook posts VM lifecycle events done through the panel to
+// registered HTTP endpoints.
+//
+// Each payload is signed with the webhook's secret (HMAC-SHA256 of the
+// body in X-VirtPanel-Signature) and retried with exponential backoff
+// until the endpoint answers 2xx, at most six times. Webhooks and the last deliveries of
+// each are kept in DataDir/webhooks.json.
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const maxDeliveries = 50 // kept per webhook
+
+var (
+	ErrNotFound = errors.New("webhook not found")
+	ErrInvalid  = errors.New("invalid webhook")
+)
+
+// Event types. Test events only go to the webhook being tested.
+const (
+	VMCreated        = "vm.created" // also clones, imports and snapshots reverted to a new VM
+	VMDeleted        = "vm.deleted"
+	VMRenamed        = "vm.renamed"
+	VMStarted        = "vm.started"
+	VMStopped        = "vm.stopped" // shutdown requested or powered off
+	SnapshotCreated  = "vm.snapshot.created"
+	SnapshotDeleted  = "vm.snapshot.deleted"
+	SnapshotReverted = "vm.snapshot.reverted"
+	Test             = "test"
+)
+
+var eventTypes = []string{VMCreated, VMDeleted, VMRenamed, VMStarted, VMStopped, SnapshotCreated, SnapshotDeleted, SnapshotReverted}
+
+// Event is the JSON body posted to webhooks.
+type Event struct {
+	ID    string         `json:"id"`
+	Type  string         `json:"type"`
+	Time  int64          `json:"time"` // unix seconds
+	Host  string         `json:"host"`
+	VM    string         `json:"vm"`
+	Actor string         `json:"actor"` // user who made the call
+	Data  map[string]any `json:"data,omitempty"`
+}
+
+// Webhook is a registered endpoint.
+type Webhook struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Secret   string   `json:"secret,omitempty"` // only returned when created; generated when empty
+	Events   []string `json:"events"`           // event types, * patterns allowed; empty for all
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+// Delivery is one event sent to one webhook, with the result of its last
+// attempt.
+type Delivery struct {
+	ID         string          `json:"id"`
+	Event      string          `json:"event"` // event type
+	EventID    string          `json:"event_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Status     string          `json:"status"` // pending, delivered or failed
+	Attempts   int             `json:"attempts"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+	Created    int64           `json:"created"` // unix seconds
+	NextRetry  int64           `json:"next_retry,omitempty"`
+}
+
+type saved struct {
+	Webhooks   []Webhook             `json:"webhooks"`
+	Deliveries map[string][]Delivery `json:"deliveries"` // by webhook ID, oldest first
+}
+
+// Manager owns the webhooks and sends events to them.
+type Manager struct {
+	file       string
+	mu         sync.Mutex
+	hooks      []Webhook
+	deliveries map[string][]Delivery
+	stopCh     chan struct{}
+}
+
+// New loads the webhooks from dataDir. Deliveries that were still being
+// retried when the panel stopped are marked failed.
+func New(dataDir string) (*Manager, error) {
+	m := &Manager{
+		file:       filepath.Join(dataDir, "webhooks.json"),
+		deliveries: make(map[string][]Delivery),
+		stopCh:     make(chan struct{}),
+	}
+	data, err := os.ReadFile(m.file)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s saved
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	m.hooks = s.Webhooks
+	if s.Deliveries != nil {
+		m.deliveries = s.Deliveries
+	}
+	for _, list := range m.deliveries {
+		for i := range list {
+			if list[i].Status == "pending" {
+				list[i].Status, list[i].NextRetry, list[i].Error = "failed", 0, "interrupted by restart"
+			}
+		}
+	}
+	return m, nil
+}
+
+// Close stops the retries in progress.
+func (m *Manager) Close() { close(m.stopCh) }
+
+// saveLocked writes the webhooks and delivery logs. Caller must hold
+// m.mu.
+func (m *Manager) saveLocked() error {
+	data, _ := json.MarshalIndent(saved{Webhooks: m.hooks, Deliveries: m.deliveries}, "", "  ")
+	os.MkdirAll(filepath.Dir(m.file), 0755)
+	tmp := m.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.file)
+}
+
+func (m *Manager) save() {
+	if err := m.saveLocked(); err != nil {
+		log.Printf("保存 Webhook 配置失败: %v", err)
+	}
+}
+
+// List returns every webhook without its secret.
+func (m *Manager) List() []Webhook {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Webhook, len(m.hooks))
+	for i, w := range m.hooks {
+		w.Secret = ""
+		list[i] = w
+	}
+	return list
+}
+
+// Add validates w and stores it under a new ID. The result includes the
+// secret, which is not shown again.
+func (m *Manager) Add(w Webhook) (Webhook, error) {
+	w.ID = randomID()
+	if w.Secret == "" {
+		w.Secret = randomID() + randomID()
+	}
+	if err := checkWebhook(&w); err != nil {
+		return Webhook{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, w)
+	if err := m.saveLocked(); err != nil {
+		m.hooks = m.hooks[:len(m.hooks)-1]
+		return Webhook{}, err
+	}
+	return w, nil
+}
+
+// Update replaces the webhook with id. An empty secret keeps the current
+// one.
+func (m *Manager) Update(id string, w Webhook) (Webhook, error) {
+	w.ID = id
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.hooks, func(w Webhook) bool { return w.ID == id })
+	if i < 0 {
+		return Webhook{}, ErrNotFound
+	}
+	if w.Secret == "" {
+		w.Secret = m.hooks[i].Secret
+	}
+	if err := checkWebhook(&w); err != nil {
+		return Webhook{}, err
+	}
+	m.hooks[i] = w
+	m.save()
+	w.Secret = ""
+	return w, nil
+}
+
+// Delete removes a webhook and its delivery log. Retries in progress
+// stop after their current attempt.
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.hooks, func(w Webhook) bool { return w.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.hooks = slices.Delete(m.hooks, i, i+1)
+	delete(m.deliveries, id)
+	m.save()
+	return nil
+}
+
+// Deliveries returns the delivery log of a webhook, newest first.
+func (m *Manager) Deliveries(id string) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !slices.ContainsFunc(m.hooks, func(w Webhook) bool { return w.ID == id }) {
+		return nil, ErrNotFound
+	}
+	entries := m.deliveries[id]
+	list := make([]Delivery, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		list = append(list, entries[i])
+	}
+	return list, nil
+}
+
+func checkWebhook(w *Webhook) error {
+	w.Name = strings.TrimSpace(w.Name)
+	if w.Name == "" || utf8.RuneCountInString(w.Name) > 64 {
+		return fmt.Errorf("%w: name must be 1-64 characters", ErrInvalid)
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an http or https URL", ErrInvalid)
+	}
+	if len(w.Secret) < 16 {
+		return fmt.Errorf("%w: secret must be at least 16 characters", ErrInvalid)
+	}
+	if w.Events == nil {
+		w.Events = []string{}
+	}
+	for _, p := range w.Events {
+		if !slices.ContainsFunc(eventTypes, func(t string) bool { return typeMatch(p, t) }) {
+			return fmt.Errorf("%w: event %q matches none of %s", ErrInvalid, p, strings.Join(eventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// wants reports whether w subscribes to events of type t.
+func (w *Webhook) wants(t string) bool {
+	return !w.Disabled && (len(w.Events) == 0 || slices.ContainsFunc(w.Events, func(p string) bool { return typeMatch(p, t) }))
+}
+
+// typeMatch matches an event type against an exact type or a pattern
+// such as vm.snapshot.*.
+func typeMatch(pattern, t string) bool {
+	ok, _ := path.Match(pattern, t)
+	return ok
+}
+
+// Emit sends e to every enabled webhook subscribed to its type, in the
+// background.
+func (m *Manager) Emit(e Event) {
+	e.ID = randomID()
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	payload, _ := json.Marshal(e)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queued := false
+	for _, w := range m.hooks {
+		if !w.wants(e.Type) {
+			continue
+		}
+		d := m.queueLocked(w.ID, e, payload)
+		go m.deliver(w.ID, d)
+		queued = true
+	}
+	if queued {
+		m.save()
+	}
+}
+
+// queueLocked appends a pending delivery to the log of a webhook.
+func (m *Manager) queueLocked(hook string, e Event, payload []byte) Delivery {
+	d := Delivery{ID: randomID(), Event: e.Type, EventID: e.ID, Payload: payload, Status: "pending", Created: time.Now().Unix()}
+	entries := append(m.deliveries[hook], d)
+	if n := len(entries) - maxDeliveries; n > 0 {
+		entries = slices.Delete(entries, 0, n)
+	}
+	m.deliveries[hook] = entries
+	return d
+}
+
+// updateLocked replaces a delivery in the log of a webhook. It reports
+// false when the webhook was deleted or the entry has rotated out.
+func (m *Manager) updateLocked(hook string, d Delivery) bool {
+	entries := m.deliveries[hook]
+	i := slices.IndexFunc(entries, func(x Delivery) bool { return x.ID == d.ID })
+	if i < 0 {
+		return false
+	}
+	entries[i] = d
+	return true
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,16 @@
+import http from './http'
+
+export interface AuthStatus {
+  setup_required: boolean
+  user?: string
+  role?: string
+}
+
+export const authApi = {
+  status: () => http.get<any, AuthStatus>('/auth/status'),
+  login: (username: string, password: string) =>
+    http.post('/auth/login', { username, password }),
+  setup: (setupToken: string, username: string, password: string) =>
+    http.post('/auth/setup', { setup_token: setupToken, username, password }),
+  logout: () => http.post('/auth/logout'),
+}
@@ -6,6 +6,10 @@ http.interceptors.response.use(
   (res) => res.data,
   (err) => {
     console.error(err)
+    // Session expired or missing: back to the login page
+    if (err?.response?.status === 401 && !err.config?.url?.startsWith('/auth/') && location.pathname !== '/login') {
+      location.href = `/login?redirect=${encodeURIComponent(location.pathname + location.search)}`
+    }
     return Promise.reject(err)
   }
 )
@@ -1,4 +1,5 @@
 import http from './http'
+import { waitTask } from './task'
 import axios from 'axios'
 import type { CancelTokenSource } from 'axios'
 
@@ -21,7 +22,7 @@ export const isoApi = {
       onUploadProgress: (e) => {
         if (onProgress && e.total) onProgress(Math.round((e.loaded / e.total) * 100))
       },
-    })
+    }).then((res) => waitTask(res.data))
     return { source, promise }
   },
   delete: (name: string) => http.delete(`/isos/${name}`),
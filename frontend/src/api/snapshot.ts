@@ -1,4 +1,5 @@
 import http from './http'
+import { waitTask } from './task'
 
 export interface Snapshot {
   name: string
@@ -14,6 +15,6 @@ export const snapshotApi = {
     http.post(`/vms/${vm}/snapshots`, data),
   delete: (vm: string, snap: string) => http.delete(`/vms/${vm}/snapshots/${snap}`),
   revert: (vm: string, snap: string) => http.post(`/vms/${vm}/snapshots/${snap}/revert`),
-  revertToNew: (vm: string, snap: string, newName: string) =>
-    http.post(`/vms/${vm}/snapshots/${snap}/revert-to-new`, { new_name: newName }),
+  revertToNew: (vm: string, snap: string, newName: string, opts: { pool?: string; format?: string } = {}) =>
+    http.post<any, { task_id: string }>(`/vms/${vm}/snapshots/${snap}/revert-to-new`, { new_name: newName, ...opts }).then((res) => waitTask(res)),
 }
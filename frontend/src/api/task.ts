@@ -0,0 +1,32 @@
+import http from './http'
+
+export interface Task {
+  id: string
+  kind: string
+  host: string
+  target: string
+  state: 'running' | 'succeeded' | 'failed' | 'canceled'
+  progress: number
+  log: string
+  error?: string
+  created_at: number
+  finished_at?: number
+}
+
+export const taskApi = {
+  list: () => http.get<any, Task[]>('/tasks'),
+  get: (id: string) => http.get<any, Task>(`/tasks/${id}`),
+  cancel: (id: string) => http.post(`/tasks/${id}/cancel`),
+}
+
+// waitTask polls a task until it finishes; rejects with the task error so
+// callers can keep using errMsg(e).
+export async function waitTask(res: { task_id: string }, onProgress?: (percent: number) => void): Promise<Task> {
+  for (;;) {
+    const t = await taskApi.get(res.task_id)
+    onProgress?.(t.progress)
+    if (t.state === 'succeeded') return t
+    if (t.state !== 'running') throw new Error(t.error || t.state)
+    await new Promise((r) => setTimeout(r, 1000))
+  }
+}
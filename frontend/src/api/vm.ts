@@ -1,4 +1,6 @@
 import http from './http'
+import { waitTask } from './task'
+import axios from 'axios'
 
 export interface VM {
   name: string
@@ -8,6 +10,13 @@ export interface VM {
   memory: number
   cpu_usage: number
   mem_used: number
+  disk_read: number
+  disk_write: number
+  disk_iops: number
+  net_rx: number
+  net_tx: number
+  template?: boolean
+  linked_base?: string
 }
 
 export interface VMDetail {
@@ -28,6 +37,7 @@ export interface VMDisk {
   target: string
   bus: string
   format: string
+  io?: DiskIO
 }
 
 export interface VMNIC {
@@ -35,6 +45,77 @@ export interface VMNIC {
   source: string
   mac: string
   model: string
+  target?: string
+  io?: NICIO
+}
+
+// Rates per second since the previous sample; latencies in ms
+export interface DiskIO {
+  read_bytes: number
+  write_bytes: number
+  read_iops: number
+  write_iops: number
+  read_latency: number
+  write_latency: number
+}
+
+export interface NICIO {
+  rx_bytes: number
+  rx_packets: number
+  rx_errors: number
+  rx_drops: number
+  tx_bytes: number
+  tx_packets: number
+  tx_errors: number
+  tx_drops: number
+}
+
+// fmtRate formats bytes per second, e.g. 1.5 MB/s
+export const fmtRate = (v: number) => {
+  const units = ['B/s', 'KB/s', 'MB/s', 'GB/s']
+  let i = 0
+  while (v >= 1024 && i < units.length - 1) { v /= 1024; i++ }
+  return (i === 0 ? Math.round(v) : v.toFixed(1)) + ' ' + units[i]
+}
+
+export interface CloudInitUser {
+  name: string
+  password_hash?: string
+  ssh_keys?: string[]
+  sudo?: boolean
+  groups?: string[]
+  shell?: string
+}
+
+export interface CloudInit {
+  hostname?: string
+  users?: CloudInitUser[]
+  ssh_keys?: string[]
+  packages?: string[]
+  user_data?: string
+  network_config?: string
+}
+
+export interface CloneOptions {
+  linked?: boolean
+  pool?: string // default: the pool of each source disk
+  format?: string // qcow2 or raw; default: that of each source disk
+}
+
+export interface ImportOVAOptions {
+  name: string
+  pool?: string // default: the pool of image_dir
+  format?: string // qcow2 (default) or raw
+  disk_bus?: string // default: ide stays ide, other controllers become sata
+  net_model?: string // default: virtio stays virtio, other adapters become e1000
+}
+
+export interface VMXMLResult {
+  diff: string
+  changed: boolean
+  validated: boolean
+  applied: boolean
+  restart_required: boolean
 }
 
 export const vmApi = {
@@ -48,20 +129,45 @@ export const vmApi = {
   suspend: (name: string) => http.post(`/vms/${name}/suspend`),
   resume: (name: string) => http.post(`/vms/${name}/resume`),
   delete: (name: string) => http.delete(`/vms/${name}`),
-  create: (data: { name: string; cpu: number; memory: number; disk: number; os_type?: string; iso?: string; disk_bus?: string; net_model?: string; machine?: string; cpu_model?: string; clock?: string; virtio_iso?: string; net_mode?: string; bridge_name?: string; macvtap_dev?: string }) =>
-    http.post('/vms', data),
+  create: (data: { name: string; cpu: number; memory: number; disk: number; os_type?: string; iso?: string; disk_bus?: string; net_model?: string; machine?: string; cpu_model?: string; clock?: string; virtio_iso?: string; net_mode?: string; bridge_name?: string; macvtap_dev?: string; cloud_init?: CloudInit }) =>
+    http.post<any, { task_id: string }>('/vms', data).then((res) => waitTask(res)),
   update: (name: string, data: { cpu?: number; memory?: number }) =>
     http.put(`/vms/${name}`, data),
-  clone: (name: string, newName: string) =>
-    http.post(`/vms/${name}/clone`, { new_name: newName }),
+  getXML: (name: string, inactive = false) =>
+    http.get<any, { xml: string }>(`/vms/${name}/xml`, { params: { inactive } }),
+  updateXML: (name: string, xml: string, dryRun = false) =>
+    http.put<any, VMXMLResult>(`/vms/${name}/xml`, { xml, dry_run: dryRun }),
+  clone: (name: string, newName: string, opts: CloneOptions = {}) =>
+    http.post<any, { task_id: string }>(`/vms/${name}/clone`, { new_name: newName, ...opts }).then((res) => waitTask(res)),
+  flatten: (name: string) =>
+    http.post<any, { task_id: string }>(`/vms/${name}/flatten`).then((res) => waitTask(res)),
   getAutostart: (name: string) =>
     http.get<any, { autostart: boolean }>(`/vms/${name}/autostart`),
   setAutostart: (name: string, autostart: boolean) =>
     http.put(`/vms/${name}/autostart`, { autostart }),
+  setTemplate: (name: string, template: boolean) =>
+    http.put(`/vms/${name}/template`, { template }),
+  templates: () => http.get<any, VM[]>('/templates'),
+  createFromTemplate: (template: string, data: { name: string; cpu?: number; memory?: number; disk?: number; net_mode?: string; net_model?: string; bridge_name?: string; macvtap_dev?: string; cloud_init?: CloudInit } & CloneOptions) =>
+    http.post<any, { task_id: string }>(`/templates/${template}/vms`, data).then((res) => waitTask(res)),
   rename: (name: string, newName: string) =>
     http.post(`/vms/${name}/rename`, { new_name: newName }),
   import: (data: { name: string; disk_path: string; cpu?: number; memory?: number; disk_bus?: string }) =>
     http.post('/vms/import', data),
+  // The browser downloads the OVA itself, streaming it to disk
+  exportURL: (name: string) => `/api/vms/${encodeURIComponent(name)}/export?format=ova`,
+  importOVA: (file: File, params: ImportOVAOptions, onProgress?: (percent: number) => void) => {
+    const form = new FormData()
+    form.append('file', file)
+    return axios.post('/api/vms/import-ova', form, {
+      baseURL: '',
+      params,
+      timeout: 0,
+      onUploadProgress: (e) => {
+        if (onProgress && e.total) onProgress(Math.round((e.loaded / e.total) * 100))
+      },
+    }).then((res) => waitTask(res.data))
+  },
   batch: (names: string[], action: string) =>
     http.post('/vms/batch', { names, action }),
   attachDisk: (name: string, data: { source: string; target?: string; bus?: string }) =>
@@ -1,5 +1,6 @@
 import { createRouter, createWebHistory } from 'vue-router'
 import DefaultLayout from '../layout/DefaultLayout.vue'
+import { authApi } from '../api/auth'
 
 const router = createRouter({
   history: createWebHistory(),
@@ -20,6 +21,12 @@ const router = createRouter({
         { path: 'portforward', name: 'portforward', component: () => import('../views/portforward/index.vue') },
       ],
     },
+    {
+      path: '/login',
+      name: 'login',
+      component: () => import('../views/login/index.vue'),
+      meta: { public: true },
+    },
     {
       path: '/vnc/:name',
       name: 'vnc',
@@ -28,4 +35,13 @@ const router = createRouter({
   ],
 })
 
+router.beforeEach(async (to) => {
+  if (to.meta.public) return true
+  try {
+    const st = await authApi.status()
+    if (st.user) return true
+  } catch {}
+  return { name: 'login', query: { redirect: to.fullPath } }
+})
+
 export default router